@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	flag "github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// configFileNames are the names of the config files searched for, from the
+// directory of the input or else the working directory upwards.
+var configFileNames = []string{"agrows.yaml", "agrows.yml", "agrows.toml"}
+
+// config is the content of an agrows config file. Besides the keys below, it
+// may contain any flag by its long name, e.g. "build-tags" or "compress".
+type config struct {
+	// Inputs are generated one after another when no --input is given.
+	Inputs []configInput `yaml:"inputs" toml:"inputs"`
+	// Output is the output pattern of inputs without their own, see
+	// configInput.Output.
+	Output string `yaml:"output" toml:"output"`
+
+	// flags are the values of the remaining keys by flag name.
+	flags map[string]any
+	// dir is the directory of the file, which relative paths are based on.
+	dir string
+}
+
+// configInput is an input file of a config.
+type configInput struct {
+	Input string `yaml:"input" toml:"input"`
+	// Output is the output file, with "{name}" replaced by the base name of
+	// the input without ".go" and "{command}" by the subcommand.
+	Output string `yaml:"output" toml:"output"`
+	// Command is the subcommand generating the input, e.g. "server".
+	Command string `yaml:"command" toml:"command"`
+}
+
+// configKeys are the keys of config that are no flags.
+var configKeys = map[string]bool{"inputs": true, "output": true}
+
+// discoverConfig returns the path of the config file of inputs, the files or
+// directories given with --input. It is searched for from the directory of the
+// first input upwards and, if there is none, from the working directory
+// upwards, which is also where it is searched for without inputs. It returns ""
+// if there is no config file.
+func discoverConfig(inputs []string) (string, error) {
+	if len(inputs) > 0 && inputs[0] != "-" {
+		dir := inputs[0]
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			dir = filepath.Dir(dir)
+		}
+		dir, err := filepath.Abs(dir)
+		if err != nil {
+			return "", err
+		}
+		path, err := findConfig(dir)
+		if path != "" || err != nil {
+			return path, err
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	return findConfig(wd)
+}
+
+// findConfig returns the path of the config file in dir or the closest of its
+// parents, or "" if there is none.
+func findConfig(dir string) (string, error) {
+	for {
+		var found []string
+		for _, name := range configFileNames {
+			path := filepath.Join(dir, name)
+			if _, err := os.Stat(path); err == nil {
+				found = append(found, path)
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return "", err
+			}
+		}
+		switch len(found) {
+		case 0:
+		case 1:
+			return found[0], nil
+		default:
+			return "", fmt.Errorf("found several config files in %s: %s, pass one with --config", dir, strings.Join(found, ", "))
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// loadConfig reads the config file at path, in YAML unless it ends with
+// ".toml".
+func loadConfig(path string) (config, error) {
+	cfg := config{dir: filepath.Dir(path)}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read config: %w", err)
+	}
+	var values map[string]any
+	if filepath.Ext(path) == ".toml" {
+		err = toml.Unmarshal(data, &cfg)
+		if err == nil {
+			err = toml.Unmarshal(data, &values)
+		}
+	} else {
+		err = yaml.Unmarshal(data, &cfg)
+		if err == nil {
+			err = yaml.Unmarshal(data, &values)
+		}
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+
+	cfg.flags = make(map[string]any, len(values))
+	for key, value := range values {
+		if configKeys[key] {
+			continue
+		}
+		if key == "input" || key == "config" || flag.Lookup(key) == nil {
+			return cfg, fmt.Errorf("unknown key '%s' in config %s", key, path)
+		}
+		cfg.flags[key] = value
+	}
+	for i, input := range cfg.Inputs {
+		if input.Input == "" {
+			return cfg, fmt.Errorf("input %d in config %s has no input file", i+1, path)
+		}
+	}
+	return cfg, nil
+}
+
+// applyFlags sets the flags of the config which were not given on the command
+// line, so the command line takes precedence.
+func (cfg config) applyFlags() error {
+	for name, value := range cfg.flags {
+		if flag.Lookup(name).Changed {
+			continue
+		}
+		text, err := flagValue(value)
+		if err != nil {
+			return fmt.Errorf("invalid value of '%s' in config: %w", name, err)
+		}
+		if err := flag.Set(name, text); err != nil {
+			return fmt.Errorf("invalid value of '%s' in config: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// flagValue formats a value of a config file as the argument of a flag. Lists
+// become comma-separated, like slice flags take them.
+func flagValue(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int, int64, float64:
+		return fmt.Sprint(v), nil
+	case []any:
+		texts := make([]string, 0, len(v))
+		for _, element := range v {
+			text, err := flagValue(element)
+			if err != nil {
+				return "", err
+			}
+			texts = append(texts, text)
+		}
+		return strings.Join(texts, ","), nil
+	}
+	return "", fmt.Errorf("unsupported value %v", value)
+}
+
+// path resolves a path of the config relative to its directory.
+func (cfg config) path(path string) string {
+	if path == "" || path == "-" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(cfg.dir, path)
+}
+
+// outputPath expands the output pattern of input, falling back to the one of
+// the config. It returns "" if neither has one.
+func (cfg config) outputPath(input configInput, command string) string {
+	pattern := input.Output
+	if pattern == "" {
+		pattern = cfg.Output
+	}
+	if pattern == "" {
+		return ""
+	}
+	name := strings.TrimSuffix(filepath.Base(input.Input), ".go")
+	return cfg.path(strings.NewReplacer("{name}", name, "{command}", command).Replace(pattern))
+}
@@ -1,5 +1,9 @@
 //go:build exclude
 
+// The constraint keeps this example out of the build of agrows, so generating
+// from it needs --allow-ignored-build, e.g.
+//
+//	agrows --input example.go --allow-ignored-build server
 package agrows
 
 import (
@@ -35,6 +39,86 @@ func NoParamsFunc() error {
 	return nil
 }
 
-func NamedReturns(eyjo complex128) (text string, err error) {
+// Delete only returns an error, the server replies with an empty string when
+// it is nil.
+func Delete(id int) error {
+	if id < 0 {
+		return fmt.Errorf("invalid id %d", id)
+	}
+	return nil
+}
+
+func NamedReturns(eyjo float64) (text string, err error) {
 	return
 }
+
+func Explode(reason string) {
+	panic(reason)
+}
+
+type User struct {
+	Name string
+	Age  int
+}
+
+// Import receives every user as a User, e.g. Import([{Name: "Ada", Age: 36},
+// {Name: "Alan", Age: 41}]) from JavaScript.
+func Import(users []User) (string, error) {
+	if len(users) != 2 {
+		return "", fmt.Errorf("expected 2 users, got %d", len(users))
+	}
+	return fmt.Sprintf("imported %s and %s", users[0].Name, users[1].Name), nil
+}
+
+// Tags returns a slice, which the client receives as an array of strings.
+func Tags() []string {
+	return []string{"go", "wasm"}
+}
+
+// List returns a slice of structs, which the client receives as an array of
+// objects, e.g. [{Name: "Ada", Age: 36}, {Name: "Alan", Age: 41}].
+func List() ([]User, error) {
+	return []User{{Name: "Ada", Age: 36}, {Name: "Alan", Age: 41}}, nil
+}
+
+// Find returns a pointer, which the client receives as an object for a known
+// id, e.g. Find(1) gives {Name: "Ada", Age: 36}, and as null otherwise.
+func Find(id int) (*User, error) {
+	if id != 1 {
+		return nil, nil
+	}
+	return &User{Name: "Ada", Age: 36}, nil
+}
+
+// Counts returns a map, which the client receives as an object, e.g.
+// {users: 2, tags: 2}.
+func Counts() (map[string]int, error) {
+	return map[string]int{"users": 2, "tags": 2}, nil
+}
+
+type Limits struct {
+	Max   int
+	Ratio float64
+}
+
+type Settings struct {
+	Enabled bool
+	Limits  Limits
+}
+
+// Configure receives every value as a Settings including its nested Limits,
+// e.g. Configure({search: {Enabled: true, Limits: {Max: 10, Ratio: 0.5}}}).
+func Configure(settings map[string]Settings) error {
+	for name, s := range settings {
+		if s.Enabled && s.Limits.Max <= 0 {
+			return fmt.Errorf("%s: enabled without a limit", name)
+		}
+	}
+	return nil
+}
+
+// Echo has parameters named like the variables and packages of the generated
+// code, which renames them there while keeping their names on the wire.
+func Echo(data string, args int, ok bool, err string, functionName string, fmt string) string {
+	return functionName + ": " + data + err + fmt
+}
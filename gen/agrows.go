@@ -0,0 +1,2462 @@
+package gen
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"github.com/dave/jennifer/jen"
+	log "github.com/dikkadev/dnutlogger"
+	"github.com/samber/lo"
+)
+
+type ParamReflectInfo struct {
+	DstField *dst.Field
+	IsStruct bool
+	// Position is the "file:line" of the type in the source.
+	Position string
+}
+
+func (p *ParamReflectInfo) String() string {
+	if len(p.DstField.Names) > 0 && p.DstField.Names[0] != nil {
+		return fmt.Sprintf("%s isStruct: %t", p.DstField.Names[0].Name, p.IsStruct)
+	}
+
+	return fmt.Sprintf("%s isStruct: %t", typeString(p.DstField.Type), p.IsStruct)
+}
+
+// IsPrimitive reports whether the type of p is a builtin scalar type like int,
+// float64, bool or string.
+func (p *ParamReflectInfo) IsPrimitive() bool {
+	ident, ok := p.DstField.Type.(*dst.Ident)
+	if !ok {
+		return false
+	}
+	_, ok = jsonSchemaScalarTypes[ident.Name]
+	return ok
+}
+
+type FuncInfo struct {
+	OriginalIdentifier *dst.Ident
+	Params             []*ParamReflectInfo
+	Results            []*ParamReflectInfo
+	// Doc holds the comment lines directly preceding the source function,
+	// including their comment markers.
+	Doc []string
+	// Position is the "file:line" of the function declaration in the source.
+	Position string
+	// TakesContext is set for functions whose first parameter is a
+	// context.Context. It is passed by the server and not part of Params.
+	TakesContext bool
+	// Timeout bounds a call of the function, as set by an //agrows:timeout
+	// directive. Zero means the default timeout applies.
+	Timeout time.Duration
+	// ServerToClient is set by an //agrows:direction server-to-client directive.
+	// The function then runs in the client and is called by the server.
+	ServerToClient bool
+	// Emit is the trailing emit func(T) parameter of functions streaming events
+	// to the client, with T as its type. It is not part of Params.
+	Emit *ParamReflectInfo
+}
+
+func (f *FuncInfo) String() string {
+	var params []string
+	var results []string
+
+	for _, param := range f.Params {
+		params = append(params, param.String())
+	}
+
+	for _, result := range f.Results {
+		results = append(results, result.String())
+	}
+
+	return fmt.Sprintf("func %s(%s) (%s)",
+		f.ToIdentifierString(),
+		strings.Join(params, ", "),
+		strings.Join(results, ", "))
+}
+
+// Signature returns the RPC name followed by its parameter types, e.g.
+// "CreateUser(string, int)".
+func (f *FuncInfo) Signature() string {
+	params := lo.Reduce(f.Params, func(agg string, item *ParamReflectInfo, i int) string {
+		agg += typeString(item.DstField.Type)
+		if i < len(f.Params)-1 {
+			agg += ", "
+		}
+		return agg
+	}, "")
+	if f.Emit != nil {
+		if params != "" {
+			params += ", "
+		}
+		params += "func(" + typeString(f.Emit.DstField.Type) + ")"
+	}
+	return fmt.Sprintf("%s(%s)", f.ToIdentifierString(), params)
+}
+
+func (f *FuncInfo) ToIdentifierString() string {
+	if f.OriginalIdentifier != nil {
+		return f.OriginalIdentifier.Name
+	}
+	return ""
+}
+
+type Input struct {
+	FileName  string
+	Functions []FuncInfo
+	TypeMap   map[string]dst.Node
+}
+
+type paramDump struct {
+	Name     string `json:"name,omitempty"`
+	Type     string `json:"type"`
+	IsStruct bool   `json:"isStruct"`
+}
+
+type funcDump struct {
+	Name           string      `json:"name"`
+	Params         []paramDump `json:"params"`
+	Results        []paramDump `json:"results"`
+	TakesContext   bool        `json:"takesContext"`
+	Timeout        string      `json:"timeout,omitempty"`
+	ServerToClient bool        `json:"serverToClient,omitempty"`
+	Emit           *paramDump  `json:"emit,omitempty"`
+}
+
+type inputDump struct {
+	FileName  string     `json:"fileName"`
+	Functions []funcDump `json:"functions"`
+	Types     []string   `json:"types"`
+	// Skipped and Config are only filled by WriteList.
+	Skipped []skippedDump `json:"skipped,omitempty"`
+	Config  string        `json:"config,omitempty"`
+}
+
+// DumpFuncs writes what agrows discovered in the input as indented JSON, so
+// tooling can inspect it without parsing generated code.
+func DumpFuncs(input Input, w io.Writer) error {
+	return encodeDump(w, newInputDump(input))
+}
+
+// newInputDump converts input to the JSON written by DumpFuncs.
+func newInputDump(input Input) inputDump {
+	toParamDumps := func(infos []*ParamReflectInfo) []paramDump {
+		dumps := make([]paramDump, 0, len(infos))
+		for _, info := range infos {
+			dump := paramDump{
+				Type:     typeString(info.DstField.Type),
+				IsStruct: info.IsStruct,
+			}
+			if len(info.DstField.Names) > 0 && info.DstField.Names[0] != nil {
+				dump.Name = info.DstField.Names[0].Name
+			}
+			dumps = append(dumps, dump)
+		}
+		return dumps
+	}
+
+	dump := inputDump{
+		FileName:  input.FileName,
+		Functions: make([]funcDump, 0, len(input.Functions)),
+		Types:     lo.Keys(input.TypeMap),
+	}
+	sort.Strings(dump.Types)
+	for _, info := range input.Functions {
+		funcDump := funcDump{
+			Name:           info.ToIdentifierString(),
+			Params:         toParamDumps(info.Params),
+			Results:        toParamDumps(info.Results),
+			TakesContext:   info.TakesContext,
+			ServerToClient: info.ServerToClient,
+		}
+		if info.Timeout > 0 {
+			funcDump.Timeout = info.Timeout.String()
+		}
+		if info.Emit != nil {
+			funcDump.Emit = &toParamDumps([]*ParamReflectInfo{info.Emit})[0]
+		}
+		dump.Functions = append(dump.Functions, funcDump)
+	}
+	return dump
+}
+
+// encodeDump writes dump as indented JSON.
+func encodeDump(w io.Writer, dump inputDump) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(dump)
+}
+
+// typeString renders a type expression the way it is written in Go source.
+func typeString(expr dst.Expr) string {
+	switch t := expr.(type) {
+	case *dst.Ident:
+		if t.Path != "" {
+			return t.Path + "." + t.Name
+		}
+		return t.Name
+	case *dst.SelectorExpr:
+		return typeString(t.X) + "." + t.Sel.Name
+	case *dst.StarExpr:
+		return "*" + typeString(t.X)
+	case *dst.ArrayType:
+		if t.Len == nil {
+			return "[]" + typeString(t.Elt)
+		}
+		if lit, ok := t.Len.(*dst.BasicLit); ok {
+			return "[" + lit.Value + "]" + typeString(t.Elt)
+		}
+		return "[...]" + typeString(t.Elt)
+	case *dst.MapType:
+		return "map[" + typeString(t.Key) + "]" + typeString(t.Value)
+	case *dst.ChanType:
+		switch t.Dir {
+		case dst.SEND:
+			return "chan<- " + typeString(t.Value)
+		case dst.RECV:
+			return "<-chan " + typeString(t.Value)
+		}
+		return "chan " + typeString(t.Value)
+	case *dst.Ellipsis:
+		return "..." + typeString(t.Elt)
+	case *dst.FuncType:
+		return "func(...)"
+	case *dst.InterfaceType:
+		if t.Methods == nil || len(t.Methods.List) == 0 {
+			return "interface{}"
+		}
+		return "interface{...}"
+	case *dst.StructType:
+		return "struct{...}"
+	case *dst.IndexExpr:
+		return typeString(t.X) + "[" + typeString(t.Index) + "]"
+	default:
+		return fmt.Sprintf("%T", expr)
+	}
+}
+
+// typeCode emits expr as a type, including slices, arrays, maps, pointers and
+// funcs. For files that do not share the imports of the source, the packages it
+// uses are qualified with their paths in imports. With nil imports, as for the
+// code generated into the file of the source, they are written as in the
+// source.
+func typeCode(expr dst.Expr, imports map[string]string) jen.Code {
+	switch t := expr.(type) {
+	case *dst.SelectorExpr:
+		if pkg, ok := t.X.(*dst.Ident); ok {
+			if path, ok := imports[pkg.Name]; ok {
+				return jen.Qual(path, t.Sel.Name)
+			}
+		}
+	case *dst.StarExpr:
+		return jen.Op("*").Add(typeCode(t.X, imports))
+	case *dst.ArrayType:
+		if t.Len == nil {
+			return jen.Index().Add(typeCode(t.Elt, imports))
+		}
+		length := typeString(t.Len)
+		if lit, ok := t.Len.(*dst.BasicLit); ok {
+			length = lit.Value
+		}
+		return jen.Index(jen.Id(length)).Add(typeCode(t.Elt, imports))
+	case *dst.MapType:
+		return jen.Map(typeCode(t.Key, imports)).Add(typeCode(t.Value, imports))
+	case *dst.Ellipsis:
+		return jen.Op("...").Add(typeCode(t.Elt, imports))
+	case *dst.FuncType:
+		fieldCodes := func(fields *dst.FieldList) []jen.Code {
+			var codes []jen.Code
+			if fields == nil {
+				return codes
+			}
+			for _, field := range fields.List {
+				for range max(len(field.Names), 1) {
+					codes = append(codes, typeCode(field.Type, imports))
+				}
+			}
+			return codes
+		}
+		return jen.Func().Params(fieldCodes(t.Params)...).Params(fieldCodes(t.Results)...)
+	}
+	return jen.Id(typeString(expr))
+}
+
+// parseFileToTree parses the source read from r. fileName is only used for
+// positions in errors. The returned decorator maps the dst nodes back to their
+// source positions.
+func parseFileToTree(r io.Reader, fileName string) (*dst.File, *decorator.Decorator, error) {
+	dec := decorator.NewDecorator(token.NewFileSet())
+	file, err := dec.ParseFile(fileName, r, parser.ParseComments)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, dec, nil
+}
+
+// cloneTree returns a deep copy of tree, which keeps the resolved objects of its
+// identifiers and the positions of its nodes in dec.
+func cloneTree(tree *dst.File, dec *decorator.Decorator) *dst.File {
+	clone := dst.Clone(tree).(*dst.File)
+	var originals []dst.Node
+	dst.Inspect(tree, func(n dst.Node) bool {
+		if n != nil {
+			originals = append(originals, n)
+		}
+		return true
+	})
+	i := 0
+	clone.Imports = nil
+	dst.Inspect(clone, func(n dst.Node) bool {
+		if n == nil {
+			return true
+		}
+		original := originals[i]
+		i++
+		if astNode, ok := dec.Ast.Nodes[original]; ok {
+			dec.Ast.Nodes[n] = astNode
+		}
+		if ident, ok := n.(*dst.Ident); ok {
+			// Clone drops the objects, which references are matched by
+			ident.Obj = original.(*dst.Ident).Obj
+		}
+		if spec, ok := n.(*dst.ImportSpec); ok {
+			clone.Imports = append(clone.Imports, spec)
+		}
+		return true
+	})
+	return clone
+}
+
+// nodePosition returns the "file:line" position of node in the parsed source.
+func nodePosition(dec *decorator.Decorator, node dst.Node) string {
+	astNode, ok := dec.Ast.Nodes[node]
+	if !ok {
+		return "unknown position"
+	}
+	position := dec.Fset.Position(astNode.Pos())
+	return fmt.Sprintf("%s:%d", position.Filename, position.Line)
+}
+
+// isStruct reports whether expr is a struct type or a pointer to one. With
+// opts.Types, named types and aliases are followed to their underlying
+// type, otherwise only types declared as struct literals in the input are
+// found.
+func (opts *Options) isStruct(typeMap map[string]dst.Node, expr dst.Expr) bool {
+	switch t := expr.(type) {
+	case *dst.StructType:
+		return true
+	case *dst.StarExpr:
+		return opts.isStruct(typeMap, t.X)
+	case *dst.Ident:
+		if opts.Types != nil {
+			if typeName, ok := opts.Types.Scope().Lookup(t.Name).(*types.TypeName); ok {
+				_, ok := typeName.Type().Underlying().(*types.Struct)
+				return ok
+			}
+		}
+		if node, exists := typeMap[t.Name]; exists {
+			if _, ok := node.(*dst.StructType); ok {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+// unsupportedType classifies expr as the type of a parameter, result or event,
+// named by kind, and explains why the generators can not handle it yet, or
+// returns "" if they can. Besides named types these may be slices or maps with
+// string keys, e.g. []User or map[string]Settings, and results may be pointers.
+// Named types of typeMap are classified by their declaration if it is no
+// struct, slice or map.
+func unsupportedType(expr dst.Expr, kind string, typeMap map[string]dst.Node) string {
+	switch t := expr.(type) {
+	case *dst.Ident:
+		if t.Name == "complex64" || t.Name == "complex128" {
+			return "complex numbers can not be encoded as JSON"
+		}
+		underlying, _ := typeMap[t.Name].(dst.Expr)
+		switch underlying.(type) {
+		case *dst.Ident, *dst.ChanType, *dst.FuncType:
+			if reason := unsupportedType(underlying, kind, nil); reason != "" {
+				return fmt.Sprintf("it is declared as %s, %s", typeString(underlying), reason)
+			}
+		}
+		return ""
+	case *dst.ArrayType:
+		if t.Len != nil {
+			return "arrays are not supported, use a slice"
+		}
+		return unsupportedType(t.Elt, "element", typeMap)
+	case *dst.MapType:
+		if !isStringKeyMap(t) {
+			return "maps need string keys to be sent as JSON objects"
+		}
+		return unsupportedType(t.Value, "element", typeMap)
+	case *dst.StarExpr:
+		if kind != "result" {
+			return "only results can be pointers, not parameters or elements"
+		}
+		if _, ok := t.X.(*dst.Ident); !ok {
+			return "results can only point to named types"
+		}
+		return ""
+	case *dst.ChanType:
+		return "channels can not be sent to or from the client"
+	case *dst.FuncType:
+		return "functions can not be sent, except for an emit func(T) as the last parameter"
+	case *dst.InterfaceType:
+		return "interfaces can not be decoded into a concrete type"
+	case *dst.SelectorExpr:
+		return "types of other packages are not supported yet"
+	case *dst.StructType:
+		return "anonymous structs are not supported, declare a named type"
+	case *dst.Ellipsis:
+		return "variadic parameters are not supported yet"
+	case *dst.IndexExpr, *dst.IndexListExpr:
+		return "generic types are not supported yet"
+	}
+	return "not supported yet"
+}
+
+// isStringKeyMap reports whether the keys of t are strings, which JSON objects
+// and the objects of JavaScript require.
+func isStringKeyMap(t *dst.MapType) bool {
+	key, ok := t.Key.(*dst.Ident)
+	return ok && key.Name == "string"
+}
+
+// jsonResult returns the index of the slice, map or pointer result of info,
+// which is encoded as JSON in the reply instead of being formatted, or -1 if
+// info has none. A nil pointer is encoded as null.
+func jsonResult(info FuncInfo) int {
+	for i, resultInfo := range info.Results {
+		switch resultInfo.DstField.Type.(type) {
+		case *dst.ArrayType, *dst.MapType, *dst.StarExpr:
+			return i
+		}
+	}
+	return -1
+}
+
+func extractTypeMap(node *dst.File) map[string]dst.Node {
+	typeMap := make(map[string]dst.Node)
+	dst.Inspect(node, func(n dst.Node) bool {
+		switch decl := n.(type) {
+		case *dst.GenDecl:
+			for _, spec := range decl.Specs {
+				if ts, ok := spec.(*dst.TypeSpec); ok {
+					typeMap[ts.Name.Name] = ts.Type
+				}
+			}
+		}
+		return true
+	})
+	return typeMap
+}
+
+// extractFuncInfo collects the RPC functions of node, as selected by --only and
+// --exclude. Types the generators can not handle yet are reported with their
+// source position.
+func (opts *Options) extractFuncInfo(node *dst.File, typeMap map[string]dst.Node, dec *decorator.Decorator) ([]FuncInfo, error) {
+	var funcs []FuncInfo
+	var err error
+
+	toParamInfo := func(fnName, kind string, name *dst.Ident, typ dst.Expr) *ParamReflectInfo {
+		if reason := unsupportedType(typ, kind, typeMap); reason != "" && err == nil {
+			what := kind
+			if name != nil {
+				what += " " + name.Name
+			}
+			err = fmt.Errorf("function %s %s at %s has unsupported type %s: %s", fnName, what, nodePosition(dec, typ), typeString(typ), reason)
+		}
+		return &ParamReflectInfo{
+			DstField: &dst.Field{
+				Names: []*dst.Ident{name},
+				Type:  typ,
+			},
+			IsStruct: opts.isStruct(typeMap, typ),
+			Position: nodePosition(dec, typ),
+		}
+	}
+
+	if err := checkExposeDirectives(node, dec); err != nil {
+		return nil, err
+	}
+
+	dst.Inspect(node, func(n dst.Node) bool {
+		if fn, ok := n.(*dst.FuncDecl); ok && opts.isRPCFunction(fn) {
+			// an exposed unexported function is called by an exported name
+			originalIdentifier := *fn.Name
+			originalIdentifier.Name = rpcName(fn)
+
+			funcInfo := FuncInfo{
+				OriginalIdentifier: &originalIdentifier,
+				Params:             []*ParamReflectInfo{},
+				Results:            []*ParamReflectInfo{},
+				Doc:                extractDocComment(fn),
+				Position:           nodePosition(dec, fn),
+			}
+
+			if fn.Type.Params != nil {
+				params := fn.Type.Params.List
+				if len(params) > 0 && isContextType(params[0].Type) && len(params[0].Names) <= 1 {
+					funcInfo.TakesContext = true
+					params = params[1:]
+				}
+				for i, param := range params {
+					if eventType, ok := emitEventType(param.Type); ok && i == len(params)-1 && len(param.Names) == 1 {
+						funcInfo.Emit = toParamInfo(fn.Name.Name, "event", param.Names[0], eventType)
+						continue
+					}
+					if len(param.Names) == 0 && err == nil {
+						err = fmt.Errorf("unnamed parameter at %s: parameters of %s need names to be sent as arguments", nodePosition(dec, param), fn.Name.Name)
+					}
+					for _, name := range param.Names {
+						funcInfo.Params = append(funcInfo.Params, toParamInfo(fn.Name.Name, "parameter", name, param.Type))
+					}
+				}
+			}
+
+			if fn.Type.Results != nil {
+				for _, result := range fn.Type.Results.List {
+					if len(result.Names) == 0 {
+						funcInfo.Results = append(funcInfo.Results, toParamInfo(fn.Name.Name, "result", nil, result.Type))
+					}
+					for _, name := range result.Names {
+						funcInfo.Results = append(funcInfo.Results, toParamInfo(fn.Name.Name, "result", name, result.Type))
+					}
+				}
+			}
+
+			// the reply of a slice, map or pointer result is its JSON encoding,
+			// which leaves no room for further values
+			if i := jsonResult(funcInfo); i >= 0 && err == nil {
+				for _, resultInfo := range funcInfo.Results {
+					if resultInfo != funcInfo.Results[i] && typeString(resultInfo.DstField.Type) != "error" {
+						err = fmt.Errorf("%s result of %s at %s can only be combined with an error", typeString(funcInfo.Results[i].DstField.Type), fn.Name.Name, funcInfo.Results[i].Position)
+						break
+					}
+				}
+			}
+
+			timeout, timeoutErr := extractTimeoutDirective(funcInfo.Doc)
+			if timeoutErr != nil && err == nil {
+				err = fmt.Errorf("invalid %s directive at %s: %w", timeoutDirective, funcInfo.Position, timeoutErr)
+			}
+			funcInfo.Timeout = timeout
+
+			serverToClient, directionErr := extractDirectionDirective(funcInfo.Doc)
+			if directionErr != nil && err == nil {
+				err = fmt.Errorf("invalid %s directive at %s: %w", directionDirective, funcInfo.Position, directionErr)
+			}
+			if serverToClient && !fn.Name.IsExported() && err == nil {
+				err = fmt.Errorf("server-to-client function %s at %s can not be exposed by %s, the client calls it by its own name", fn.Name.Name, funcInfo.Position, exposeDirective)
+			}
+			if serverToClient && funcInfo.Emit != nil && err == nil {
+				err = fmt.Errorf("server-to-client function %s at %s can not take an emit parameter", fn.Name.Name, funcInfo.Position)
+			}
+			if serverToClient && (len(funcInfo.Results) > 0 || funcInfo.TakesContext) && err == nil {
+				err = fmt.Errorf("server-to-client function %s at %s can not take a context or return results, calls to the client are one-way", fn.Name.Name, funcInfo.Position)
+			}
+			funcInfo.ServerToClient = serverToClient
+
+			funcs = append(funcs, funcInfo)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := opts.checkOnlyMatches(node); err != nil {
+		return nil, err
+	}
+	return funcs, nil
+}
+
+// extractDocComment returns the comment lines attached to the start of fn.
+// Blank-line decorations are dropped, only the comments themselves are kept.
+func extractDocComment(fn *dst.FuncDecl) []string {
+	var doc []string
+	for _, line := range fn.Decs.Start {
+		if strings.HasPrefix(line, "//") || strings.HasPrefix(line, "/*") {
+			doc = append(doc, line)
+		}
+	}
+	return doc
+}
+
+// isContextType reports whether expr is context.Context.
+func isContextType(expr dst.Expr) bool {
+	selector, ok := expr.(*dst.SelectorExpr)
+	if !ok {
+		return false
+	}
+	pkg, ok := selector.X.(*dst.Ident)
+	return ok && pkg.Name == "context" && selector.Sel.Name == "Context"
+}
+
+// timeoutDirective sets the timeout of a function in its doc comment, e.g.
+// "//agrows:timeout 5s" or "//agrows:timeout=5s".
+const timeoutDirective = "//agrows:timeout"
+
+// extractTimeoutDirective returns the duration of the timeout directive in doc,
+// or zero if there is none.
+func extractTimeoutDirective(doc []string) (time.Duration, error) {
+	for _, line := range doc {
+		value, ok := strings.CutPrefix(strings.TrimSpace(line), timeoutDirective)
+		if !ok || value == "" || (value[0] != ' ' && value[0] != '=') {
+			continue
+		}
+		value = value[1:]
+		timeout, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			return 0, err
+		}
+		if timeout <= 0 {
+			return 0, fmt.Errorf("timeout must be positive, got %s", timeout)
+		}
+		return timeout, nil
+	}
+	return 0, nil
+}
+
+// isRPCFunction reports whether fn is exposed as an RPC endpoint. Only plain
+// functions are, methods are left alone: the exported ones selected by --only
+// and --exclude and the unexported ones with an expose directive, unless an
+// ignore directive leaves them out. Invalid directives are reported by
+// extractFuncInfo.
+func (opts *Options) isRPCFunction(fn *dst.FuncDecl) bool {
+	if fn.Recv != nil {
+		return false
+	}
+	ignored, exposed, err := exposeDirectives(fn)
+	if err != nil || ignored {
+		return false
+	}
+	if !fn.Name.IsExported() {
+		return exposed != ""
+	}
+	return exposed != "" || opts.wraps(fn.Name.Name)
+}
+
+// modifyOriginalFunctions renames the RPC functions and every reference to them
+// within the file, so calls and function values in other functions and methods
+// keep compiling. References are matched through their resolved object, which
+// leaves selectors into other packages and shadowing locals untouched.
+func (opts *Options) modifyOriginalFunctions(tree *dst.File) {
+	renamed := make(map[*dst.Object]string)
+	for _, decl := range tree.Decls {
+		if fn, ok := decl.(*dst.FuncDecl); ok && opts.isRPCFunction(fn) {
+			newName := fmt.Sprintf(opts.FunctionFormat, rpcName(fn))
+			if fn.Name.Obj != nil {
+				renamed[fn.Name.Obj] = newName
+			}
+			fn.Name.Name = newName
+		}
+	}
+
+	dst.Inspect(tree, func(n dst.Node) bool {
+		if ident, ok := n.(*dst.Ident); ok && ident.Obj != nil && ident.Path == "" {
+			if newName, ok := renamed[ident.Obj]; ok {
+				ident.Name = newName
+			}
+		}
+		return true
+	})
+}
+
+// removeOriginalAndUnexportedFunctions drops the functions the client does not
+// need. Methods on types declared in the file are kept, since the types stay in
+// the output and may rely on them (e.g. json.Unmarshaler), as are plain
+// functions reachable from any retained declaration. With keepServerToClient,
+// the server-to-client functions are kept too, since they run in the client.
+func (opts *Options) removeOriginalAndUnexportedFunctions(tree *dst.File, keepServerToClient bool) {
+	retainedTypes := make(map[string]bool)
+	for _, decl := range tree.Decls {
+		if genDecl, ok := decl.(*dst.GenDecl); ok && genDecl.Tok == token.TYPE {
+			for _, spec := range genDecl.Specs {
+				retainedTypes[spec.(*dst.TypeSpec).Name.Name] = true
+			}
+		}
+	}
+
+	rpcFuncs := make(map[*dst.Object]bool)
+	helpers := make(map[*dst.Object]*dst.FuncDecl)
+	for _, decl := range tree.Decls {
+		if fn, ok := decl.(*dst.FuncDecl); ok && fn.Recv == nil && fn.Name.Obj != nil {
+			if opts.isRPCFunction(fn) && !(keepServerToClient && isServerToClient(fn)) {
+				rpcFuncs[fn.Name.Obj] = true
+			} else {
+				helpers[fn.Name.Obj] = fn
+			}
+		}
+	}
+
+	keep := make(map[dst.Decl]bool)
+	var pending []dst.Decl
+	for _, decl := range tree.Decls {
+		switch d := decl.(type) {
+		case *dst.FuncDecl:
+			if d.Recv == nil && keepServerToClient && opts.isRPCFunction(d) && isServerToClient(d) {
+				keep[d] = true
+				pending = append(pending, d)
+				continue
+			}
+			if d.Recv == nil || !retainedTypes[receiverTypeName(d)] {
+				continue
+			}
+			// the client versions of RPC functions have different signatures,
+			// so methods calling them can not be kept as they are
+			if referencesAny(d, rpcFuncs) {
+				log.Warnf("Dropping method %s.%s from client output because it calls an RPC function", receiverTypeName(d), d.Name.Name)
+				continue
+			}
+			keep[d] = true
+			pending = append(pending, d)
+		default:
+			keep[d] = true
+			pending = append(pending, d)
+		}
+	}
+
+	for len(pending) > 0 {
+		decl := pending[0]
+		pending = pending[1:]
+		dst.Inspect(decl, func(n dst.Node) bool {
+			if ident, ok := n.(*dst.Ident); ok && ident.Obj != nil {
+				if helper, ok := helpers[ident.Obj]; ok && !keep[helper] {
+					keep[helper] = true
+					pending = append(pending, helper)
+				}
+			}
+			return true
+		})
+	}
+
+	var decls []dst.Decl
+	for _, decl := range tree.Decls {
+		if keep[decl] {
+			decls = append(decls, decl)
+		}
+	}
+	tree.Decls = decls
+}
+
+// removeUnusedTypes drops the type declarations of tree used neither by the
+// parameters and results of infos nor by the other declarations left in tree,
+// directly or through other used types. It runs after the functions the
+// output does not need were removed, which may leave their types unused.
+func removeUnusedTypes(tree *dst.File, infos []FuncInfo) {
+	typeMap := make(map[string]dst.Node)
+	roots := funcTypes(infos)
+	for _, decl := range tree.Decls {
+		genDecl, ok := decl.(*dst.GenDecl)
+		if !ok || genDecl.Tok != token.TYPE {
+			roots = append(roots, decl)
+			continue
+		}
+		for _, spec := range genDecl.Specs {
+			typeMap[spec.(*dst.TypeSpec).Name.Name] = spec
+		}
+	}
+	used := referencedTypes(roots, typeMap)
+
+	var decls []dst.Decl
+	for _, decl := range tree.Decls {
+		genDecl, ok := decl.(*dst.GenDecl)
+		if !ok || genDecl.Tok != token.TYPE {
+			decls = append(decls, decl)
+			continue
+		}
+		genDecl.Specs = slices.DeleteFunc(genDecl.Specs, func(spec dst.Spec) bool {
+			return !used[spec.(*dst.TypeSpec).Name.Name]
+		})
+		if len(genDecl.Specs) > 0 {
+			decls = append(decls, decl)
+		}
+	}
+	tree.Decls = decls
+}
+
+// funcTypes returns the types of the parameters, results and emit callbacks of
+// infos.
+func funcTypes(infos []FuncInfo) []dst.Node {
+	var types []dst.Node
+	for _, info := range infos {
+		for _, param := range info.Params {
+			types = append(types, param.DstField.Type)
+		}
+		for _, result := range info.Results {
+			types = append(types, result.DstField.Type)
+		}
+		if info.Emit != nil {
+			types = append(types, info.Emit.DstField.Type)
+		}
+	}
+	return types
+}
+
+// referencedTypes returns the names of typeMap referenced by roots, directly
+// or through the declarations of other referenced types.
+func referencedTypes(roots []dst.Node, typeMap map[string]dst.Node) map[string]bool {
+	names := make(map[string]bool)
+	var visit func(node dst.Node)
+	visit = func(node dst.Node) {
+		dst.Inspect(node, func(n dst.Node) bool {
+			ident, ok := n.(*dst.Ident)
+			if !ok || names[ident.Name] {
+				return true
+			}
+			if typ, ok := typeMap[ident.Name]; ok {
+				names[ident.Name] = true
+				visit(typ)
+			}
+			return true
+		})
+	}
+	for _, root := range roots {
+		visit(root)
+	}
+	return names
+}
+
+// referencesAny reports whether node references one of the given objects.
+func referencesAny(node dst.Node, objects map[*dst.Object]bool) bool {
+	found := false
+	dst.Inspect(node, func(n dst.Node) bool {
+		if ident, ok := n.(*dst.Ident); ok && ident.Obj != nil && objects[ident.Obj] {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// receiverTypeName returns the name of the type a method is declared on,
+// looking through pointer receivers and type parameters.
+func receiverTypeName(fn *dst.FuncDecl) string {
+	if fn.Recv == nil || len(fn.Recv.List) == 0 {
+		return ""
+	}
+
+	expr := fn.Recv.List[0].Type
+	if star, ok := expr.(*dst.StarExpr); ok {
+		expr = star.X
+	}
+	switch t := expr.(type) {
+	case *dst.IndexExpr:
+		expr = t.X
+	case *dst.IndexListExpr:
+		expr = t.X
+	}
+	if ident, ok := expr.(*dst.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
+
+func (opts *Options) generateProtocolOptions() *jen.Statement {
+	if opts.Compress {
+		return jen.Qual(opts.ProtocolPath, "Options").Call(jen.Qual(opts.ProtocolPath, "Compression").Call(jen.True()))
+	} else {
+		return jen.Qual(opts.ProtocolPath, "Options").Call()
+	}
+}
+
+func (opts *Options) generateNewClientFunc(info FuncInfo) *jen.Statement {
+	doc := jen.Null()
+	for _, line := range info.Doc {
+		doc.Comment(line).Line()
+	}
+	if len(info.Doc) == 0 {
+		doc.Commentf("%s calls the RPC '%s' on the server.", info.OriginalIdentifier.Name, info.Signature()).Line()
+	}
+
+	fn := doc.Func().Id(info.OriginalIdentifier.Name).
+		ParamsFunc(func(g *jen.Group) {
+			for _, paramInfo := range info.Params {
+				param := paramInfo.DstField
+				if len(param.Names) > 0 {
+					g.Id(paramIdent(info, param.Names[0].Name)).Add(typeCode(param.Type, nil))
+				} else {
+					g.Add(typeCode(param.Type, nil))
+				}
+			}
+			if info.Emit != nil {
+				g.Id(paramIdent(info, info.Emit.DstField.Names[0].Name)).Func().Params(typeCode(info.Emit.DstField.Type, nil))
+			}
+		}).
+		ParamsFunc(func(g *jen.Group) {
+			g.Any()
+		}).
+		BlockFunc(func(g *jen.Group) {
+			var args jen.Code = jen.Map(jen.String()).Any().ValuesFunc(func(g *jen.Group) {
+				for _, paramInfo := range info.Params {
+					param := paramInfo.DstField
+					g.Line().Lit(param.Names[0].Name).Op(":").Id(paramIdent(info, param.Names[0].Name))
+				}
+				if info.Emit != nil {
+					g.Line().Lit(subscriptionArg).Op(":").Id("subscription")
+				}
+				g.Line().Lit(callArg).Op(":").Id("call")
+				g.Line()
+			},
+			)
+			if info.Emit != nil {
+				g.Add(generateClientSubscribe(info))
+			}
+			g.Id("start").Op(":=").Qual("time", "Now").Call()
+			g.List(jen.Id("call"), jen.Id("promise")).Op(":=").Id("agrowsBeginCall").Call(jen.Lit(info.OriginalIdentifier.Name), jen.Id("start"), jen.Lit(jsonResult(info) >= 0))
+			if opts.Trace == "otel" {
+				g.Id("args").Op(":=").Add(args)
+				g.If(jen.Id("traceContext").Op(":=").Id("agrowsTraceContext").Call(), jen.Id("traceContext").Op("!=").Nil()).Block(
+					jen.Id("args").Index(jen.Id("agrowsTraceContextArg")).Op("=").Id("traceContext"),
+				)
+				args = jen.Id("args")
+			}
+			g.Id("data").Op(",").Err().Op(":=").Add(opts.generateEncodeCall(jen.Lit(info.OriginalIdentifier.Name), args))
+			g.If(jen.Err().Op("!=").Nil()).BlockFunc(func(g *jen.Group) {
+				g.Id("agrowsEndCall").Call(jen.Id("call"))
+				if info.Emit != nil {
+					g.Id("agrowsUnsubscribe").Call(jen.Id("subscription"))
+				}
+				g.Id("agrowsRecordMetrics").Call(jen.Lit(info.OriginalIdentifier.Name), jen.Id("start"), jen.Err())
+				g.Return(jen.Err())
+			})
+			g.If(jen.Id("result").Op(":=").Id("sendMessage").Call(jen.Id("data")), jen.Id("result").Op("!=").Nil()).BlockFunc(func(g *jen.Group) {
+				g.Id("agrowsEndCall").Call(jen.Id("call"))
+				if info.Emit != nil {
+					g.Id("agrowsUnsubscribe").Call(jen.Id("subscription"))
+				}
+				g.Id("agrowsRecordMetrics").Call(jen.Lit(info.OriginalIdentifier.Name), jen.Id("start"), jen.Id("result"))
+				g.Return(jen.Id("result"))
+			})
+			g.Comment("the response settles the promise, see agrowsSettleCall")
+			g.Return(jen.Id("promise"))
+		})
+	fn.Line()
+
+	wrapperName := fmt.Sprintf(opts.WrapperFormat, info.OriginalIdentifier.Name)
+	exposedFn := jen.Commentf("%s exposes the RPC '%s' to JavaScript.", wrapperName, info.Signature()).Line().
+		Func().Id(wrapperName).
+		Params(
+			jen.Id("this").Qual("syscall/js", "Value"),
+			jen.Id("p").Index().Qual("syscall/js", "Value"),
+		).Params(jen.Any()).
+		BlockFunc(func(g *jen.Group) {
+
+			paramCount := len(info.Params)
+			if info.Emit != nil {
+				paramCount++
+			}
+			g.If(jen.Len(jen.Id("p")).Op("!=").Lit(paramCount)).Block(
+				jen.Return(generateJsGlobalError(jen.Qual("fmt", "Sprintf").Call(jen.Lit(fmt.Sprintf("expected %d arguments, got %%d", paramCount)), jen.Len(jen.Id("p"))))),
+			)
+			for i, paramInfo := range info.Params {
+				param := paramInfo.DstField
+				if len(param.Names) > 0 {
+					paramName := param.Names[0].Name
+					ident := paramIdent(info, paramName)
+					paramNameAsAny := ident + "AsAny"
+					g.Id(paramNameAsAny).Op(",").Err().Op(":=").Id("jsValueToAny").Call(jen.Id("p").Index(jen.Lit(i)), jen.Qual("reflect", "TypeOf").Call(jen.Parens(jen.Op("*").Add(typeCode(param.Type, nil))).Call(jen.Nil())).Dot("Elem").Call())
+					g.If(jen.Err().Op("!=").Nil()).Block(
+						jen.Return(generateJsGlobalError(jen.Qual("fmt", "Sprintf").Call(jen.Lit(fmt.Sprintf("failed to make go type '%s' from js value: %%+v", typeString(param.Type))), jen.Err()))),
+					)
+					g.Id(ident).Op(",").Id("ok").Op(":=").Id(paramNameAsAny).Assert(typeCode(param.Type, nil))
+					g.If(jen.Op("!").Id("ok")).Block(
+						jen.Return(generateJsGlobalError(jen.Qual("fmt", "Sprintf").Call(jen.Lit(fmt.Sprintf("parameter '%s' is not in the received arguments", paramName))))),
+					)
+				}
+			}
+			if info.Emit != nil {
+				generateWrapperEmit(g, info, len(info.Params))
+			}
+			g.Return(
+				jen.Id(info.OriginalIdentifier.Name).
+					ParamsFunc(func(g *jen.Group) {
+						for _, paramInfo := range info.Params {
+							param := paramInfo.DstField
+							if len(param.Names) > 0 {
+								g.Id(paramIdent(info, param.Names[0].Name))
+							}
+						}
+						if info.Emit != nil {
+							g.Id(paramIdent(info, info.Emit.DstField.Names[0].Name))
+						}
+					}),
+			)
+		})
+	exposedFn.Line()
+
+	return jen.Add(fn, exposedFn)
+}
+
+// generateClientMain emits the main function of the client, registering the
+// wrappers as JavaScript functions and keeping the module alive. Runtimes
+// recycling the module may run main more than once, so the registrations are
+// guarded by agrowsInitOnce.
+func (opts *Options) generateClientMain(funcInfos []FuncInfo, receive bool) *jen.Statement {
+	register := jen.Func().Params().BlockFunc(func(g *jen.Group) {
+		g.Id("global").Op(":=").Qual("syscall/js", "Global").Call()
+		for _, fnInfo := range funcInfos {
+			g.Id("global").Dot("Set").Call(jen.Lit(fnInfo.OriginalIdentifier.Name), jen.Qual("syscall/js", "FuncOf").Call(jen.Id(fmt.Sprintf(opts.WrapperFormat, fnInfo.OriginalIdentifier.Name))))
+			if !opts.Quiet {
+				g.Id("agrowsLog").Call(jen.Lit("info"), jen.Lit(fmt.Sprintf("AGROWS: '%s' function registered", fnInfo.Signature())))
+			}
+		}
+		if opts.Batch {
+			for _, name := range batchedClientFuncs {
+				g.Id("global").Dot("Set").Call(jen.Lit(name), jen.Qual("syscall/js", "FuncOf").Call(jen.Id(fmt.Sprintf(opts.WrapperFormat, name))))
+			}
+		}
+		if receive {
+			g.Id("global").Dot("Set").Call(jen.Lit("receiveMessage"), jen.Qual("syscall/js", "FuncOf").Call(jen.Id("receiveMessageWrapper")))
+		}
+		g.Id("global").Dot("Set").Call(jen.Lit("agrowsHandleMessage"), jen.Qual("syscall/js", "FuncOf").Call(jen.Id("handleMessageWrapper")))
+	})
+
+	return jen.Comment("agrowsInitOnce registers the functions only once, even if main runs again.").Line().
+		Var().Id("agrowsInitOnce").Qual("sync", "Once").Line().Line().
+		Func().Id("main").Params().Block(
+		jen.Id("agrowsInitOnce").Dot("Do").Call(register),
+		jen.Line(),
+		jen.Select().Block(),
+	)
+}
+
+func (opts *Options) generateJSSendMessageFunction() *jen.Statement {
+	return jen.Func().Id("sendMessage").Params(jen.Id("data").Index().Byte()).Any().Block(
+		opts.generateClientBatchCheck(),
+		jen.Id("jsGlobal").Op(":=").Qual("syscall/js", "Global").Call(),
+		jen.Id("sendMessageFunc").Op(":=").Id("jsGlobal").Dot("Get").Call(jen.Lit("sendMessage")),
+		jen.If(jen.Id("sendMessageFunc").Dot("Type").Call().Op("!=").Qual("syscall/js", "TypeFunction")).Block(
+			jen.Return(generateJsGlobalError(jen.Lit("sendMessage is not a JS function"))),
+		),
+		jen.Id("uint8Array").Op(":=").Qual("syscall/js", "Global").Call().Dot("Get").Call(jen.Lit("Uint8Array")).Dot("New").Call(jen.Len(jen.Id("data"))),
+		jen.Qual("syscall/js", "CopyBytesToJS").Call(jen.Id("uint8Array"), jen.Id("data")),
+		jen.Id("sendMessageFunc").Dot("Invoke").Call(jen.Id("uint8Array")),
+		jen.Return(jen.Nil()),
+	).Line()
+}
+
+func generateJsGlobalError(errMsg jen.Code) jen.Code {
+	return jen.Qual("syscall/js", "Global").Call().Dot("Get").Call(jen.Lit("Error")).Dot("New").Call(errMsg)
+}
+
+func (opts *Options) generateJsValueToAny() *jen.Statement {
+	return jen.Func().Id("jsValueToAny").Params(
+		jen.Id("v").Qual("syscall/js", "Value"),
+		jen.Id("targetType").Qual("reflect", "Type"),
+	).Params(
+		jen.Any(), jen.Any(),
+	).BlockFunc(func(g *jen.Group) {
+		g.Switch(jen.Id("v").Dot("Type").Call()).BlockFunc(func(f *jen.Group) {
+			f.Case(jen.Qual("syscall/js", "TypeBoolean")).BlockFunc(func(g *jen.Group) {
+				g.Return(jen.Id("v").Dot("Bool").Call(), jen.Nil())
+			})
+			f.Case(jen.Qual("syscall/js", "TypeNumber")).BlockFunc(func(h *jen.Group) {
+				// JS numbers are float64, convert them to the exact parameter type so the
+				// assertion of the wrapper holds for int64, float32, named types etc.
+				notFitting := jen.Return(jen.Nil(), generateJsGlobalError(jen.Qual("fmt", "Sprintf").Call(jen.Lit("%v does not fit into %s"), jen.Id("number"), jen.Id("targetType"))))
+				h.Id("number").Op(":=").Id("v").Dot("Float").Call()
+				h.Id("target").Op(":=").Qual("reflect", "New").Call(jen.Id("targetType")).Dot("Elem").Call()
+				h.Switch(jen.Id("targetType").Dot("Kind").Call()).Block(
+					jen.Case(jen.Qual("reflect", "Int"), jen.Qual("reflect", "Int8"), jen.Qual("reflect", "Int16"), jen.Qual("reflect", "Int32"), jen.Qual("reflect", "Int64")).Block(
+						jen.If(jen.Float64().Call(jen.Int64().Call(jen.Id("number"))).Op("!=").Id("number").Op("||").Id("target").Dot("OverflowInt").Call(jen.Int64().Call(jen.Id("number")))).Block(notFitting.Clone()),
+						jen.Id("target").Dot("SetInt").Call(jen.Int64().Call(jen.Id("number"))),
+					),
+					jen.Case(jen.Qual("reflect", "Uint"), jen.Qual("reflect", "Uint8"), jen.Qual("reflect", "Uint16"), jen.Qual("reflect", "Uint32"), jen.Qual("reflect", "Uint64")).Block(
+						jen.If(jen.Id("number").Op("<").Lit(0).Op("||").Float64().Call(jen.Uint64().Call(jen.Id("number"))).Op("!=").Id("number").Op("||").Id("target").Dot("OverflowUint").Call(jen.Uint64().Call(jen.Id("number")))).Block(notFitting.Clone()),
+						jen.Id("target").Dot("SetUint").Call(jen.Uint64().Call(jen.Id("number"))),
+					),
+					jen.Case(jen.Qual("reflect", "Float32"), jen.Qual("reflect", "Float64")).Block(
+						jen.If(jen.Id("target").Dot("OverflowFloat").Call(jen.Id("number"))).Block(notFitting.Clone()),
+						jen.Id("target").Dot("SetFloat").Call(jen.Id("number")),
+					),
+					jen.Default().Block(
+						jen.Return(jen.Id("number"), jen.Nil()),
+					),
+				)
+				h.Return(jen.Id("target").Dot("Interface").Call(), jen.Nil())
+			})
+			f.Case(jen.Qual("syscall/js", "TypeString")).BlockFunc(func(g *jen.Group) {
+				g.Return(jen.Id("v").Dot("String").Call(), jen.Nil())
+			})
+			f.Case(jen.Qual("syscall/js", "TypeObject")).BlockFunc(func(h *jen.Group) {
+				if opts.Serialize == "cbor" {
+					// a Uint8Array holds the bytes of a []byte parameter or a value
+					// encoded with CBOR by the page
+					h.If(jen.Id("v").Dot("InstanceOf").Call(jen.Qual("syscall/js", "Global").Call().Dot("Get").Call(jen.Lit("Uint8Array")))).Block(
+						jen.Id("data").Op(":=").Make(jen.Index().Byte(), jen.Id("v").Dot("Length").Call()),
+						jen.Qual("syscall/js", "CopyBytesToGo").Call(jen.Id("data"), jen.Id("v")),
+						jen.If(jen.Id("targetType").Op("==").Qual("reflect", "TypeOf").Call(jen.Id("data"))).Block(
+							jen.Return(jen.Id("data"), jen.Nil()),
+						),
+						jen.Id("targetValue").Op(":=").Qual("reflect", "New").Call(jen.Id("targetType")).Dot("Interface").Call(),
+						jen.If(jen.Err().Op(":=").Id("agrowsUnmarshal").Call(jen.Id("data"), jen.Id("targetValue")), jen.Err().Op("!=").Nil()).Block(
+							jen.Return(jen.Nil(), generateJsGlobalError(jen.Qual("fmt", "Sprintf").Call(jen.Lit("failed to unmarshal cbor to target type: %v"), jen.Err()))),
+						),
+						jen.Return(jen.Qual("reflect", "ValueOf").Call(jen.Id("targetValue")).Dot("Elem").Call().Dot("Interface").Call(), jen.Nil()),
+					)
+				}
+				// Arrays for slice parameters and objects for map parameters are converted
+				// element by element, so every element gets the exact type, e.g. a User
+				// for []User.
+				// Nested values are converted to any, arrays among them become []any.
+				h.If(jen.Id("targetType").Dot("Kind").Call().Op("==").Qual("reflect", "Interface").Op("&&").Qual("syscall/js", "Global").Call().Dot("Get").Call(jen.Lit("Array")).Dot("Call").Call(jen.Lit("isArray"), jen.Id("v")).Dot("Bool").Call()).Block(
+					jen.Id("targetType").Op("=").Qual("reflect", "TypeOf").Call(jen.Index().Any().Values()),
+				)
+				h.If(jen.Id("targetType").Dot("Kind").Call().Op("==").Qual("reflect", "Slice").Op("&&").Qual("syscall/js", "Global").Call().Dot("Get").Call(jen.Lit("Array")).Dot("Call").Call(jen.Lit("isArray"), jen.Id("v")).Dot("Bool").Call()).Block(
+					jen.Id("slice").Op(":=").Qual("reflect", "MakeSlice").Call(jen.Id("targetType"), jen.Id("v").Dot("Length").Call(), jen.Id("v").Dot("Length").Call()),
+					jen.For(jen.Id("i").Op(":=").Lit(0), jen.Id("i").Op("<").Id("v").Dot("Length").Call(), jen.Id("i").Op("++")).Block(
+						jen.List(jen.Id("elem"), jen.Err()).Op(":=").Id("jsValueToElem").Call(jen.Id("v").Dot("Index").Call(jen.Id("i")), jen.Id("targetType").Dot("Elem").Call(), jen.Qual("fmt", "Sprintf").Call(jen.Lit("element %d"), jen.Id("i"))),
+						jen.If(jen.Err().Op("!=").Nil()).Block(jen.Return(jen.Nil(), jen.Err())),
+						jen.Id("slice").Dot("Index").Call(jen.Id("i")).Dot("Set").Call(jen.Id("elem")),
+					),
+					jen.Return(jen.Id("slice").Dot("Interface").Call(), jen.Nil()),
+				)
+				h.If(jen.Id("targetType").Dot("Kind").Call().Op("==").Qual("reflect", "Map").Op("&&").Id("targetType").Dot("Key").Call().Dot("Kind").Call().Op("==").Qual("reflect", "String")).Block(
+					jen.Id("mapValue").Op(":=").Qual("reflect", "MakeMap").Call(jen.Id("targetType")),
+					jen.Id("keys").Op(":=").Qual("syscall/js", "Global").Call().Dot("Get").Call(jen.Lit("Object")).Dot("Call").Call(jen.Lit("keys"), jen.Id("v")),
+					jen.For(jen.Id("i").Op(":=").Lit(0), jen.Id("i").Op("<").Id("keys").Dot("Length").Call(), jen.Id("i").Op("++")).Block(
+						jen.Id("key").Op(":=").Id("keys").Dot("Index").Call(jen.Id("i")).Dot("String").Call(),
+						jen.List(jen.Id("elem"), jen.Err()).Op(":=").Id("jsValueToElem").Call(jen.Id("v").Dot("Get").Call(jen.Id("key")), jen.Id("targetType").Dot("Elem").Call(), jen.Qual("fmt", "Sprintf").Call(jen.Lit("value '%s'"), jen.Id("key"))),
+						jen.If(jen.Err().Op("!=").Nil()).Block(jen.Return(jen.Nil(), jen.Err())),
+						jen.Id("mapValue").Dot("SetMapIndex").Call(jen.Qual("reflect", "ValueOf").Call(jen.Id("key")).Dot("Convert").Call(jen.Id("targetType").Dot("Key").Call()), jen.Id("elem")),
+					),
+					jen.Return(jen.Id("mapValue").Dot("Interface").Call(), jen.Nil()),
+				)
+				h.Id("result").Op(":=").Make(jen.Map(jen.String()).Any())
+				h.Id("keys").Op(":=").Qual("syscall/js", "Global").Call().Dot("Get").Call(jen.Lit("Object")).Dot("Call").Call(jen.Lit("keys"), jen.Id("v"))
+				h.For(jen.Id("i").Op(":=").Lit(0), jen.Id("i").Op("<").Id("keys").Dot("Length").Call(), jen.Id("i").Op("++")).BlockFunc(func(i *jen.Group) {
+					i.Id("key").Op(":=").Id("keys").Dot("Index").Call(jen.Id("i")).Dot("String").Call()
+					i.Id("value").Op(",").Err().Op(":=").Id("jsValueToAny").Call(jen.Id("v").Dot("Get").Call(jen.Id("key")), jen.Qual("reflect", "TypeOf").Call(jen.Parens(jen.Op("*").Any()).Call(jen.Nil())).Dot("Elem").Call())
+					i.If(jen.Err().Op("!=").Nil()).Block(jen.Return(jen.Nil(), jen.Err()))
+					i.Id("result").Index(jen.Id("key")).Op("=").Id("value")
+				})
+				marshal, unmarshal, encoding := jen.Qual("encoding/json", "Marshal"), jen.Qual("encoding/json", "Unmarshal"), "json"
+				// CBOR keeps the numbers of JavaScript objects floats, which do not
+				// decode into integer fields, so only MessagePack replaces JSON here
+				if opts.Serialize == "msgpack" {
+					marshal, unmarshal, encoding = jen.Id("agrowsMarshal"), jen.Id("agrowsUnmarshal"), "msgpack"
+				}
+				h.List(jen.Id(encoding+"Data"), jen.Err()).Op(":=").Add(marshal).Call(jen.Id("result"))
+				h.If(jen.Err().Op("!=").Nil()).Block(jen.Return(jen.Nil(), generateJsGlobalError(jen.Qual("fmt", "Sprintf").Call(jen.Lit("failed to marshal js object to "+encoding+": %v"), jen.Err()))))
+				h.Id("targetValue").Op(":=").Qual("reflect", "New").Call(jen.Id("targetType")).Dot("Interface").Call()
+				h.Err().Op("=").Add(unmarshal).Call(jen.Id(encoding+"Data"), jen.Id("targetValue"))
+				h.If(jen.Err().Op("!=").Nil()).Block(jen.Return(jen.Nil(), generateJsGlobalError(jen.Qual("fmt", "Sprintf").Call(jen.Lit("failed to unmarshal "+encoding+" to target type: %v"), jen.Err()))))
+				h.Return(jen.Qual("reflect", "ValueOf").Call(jen.Id("targetValue")).Dot("Elem").Call().Dot("Interface").Call(), jen.Nil())
+			})
+			f.Case(jen.Qual("syscall/js", "TypeFunction")).BlockFunc(func(g *jen.Group) {
+				g.Return(jen.Id("v"), jen.Nil())
+			})
+			f.Case(jen.Qual("syscall/js", "TypeUndefined"), jen.Qual("syscall/js", "TypeNull")).BlockFunc(func(g *jen.Group) {
+				g.Return(jen.Nil(), jen.Nil())
+			})
+			f.Default().BlockFunc(func(g *jen.Group) {
+				g.Return(jen.Nil(), generateJsGlobalError(jen.Qual("fmt", "Sprintf").Call(jen.Lit("unsupported js value type: %s"), jen.Id("v").Dot("Type").Call())))
+			})
+		})
+	}).Line().Line().
+		Comment("jsValueToElem converts an element of a JS array or object to elemType. what").Line().
+		Comment("names the element in errors. null and undefined become the zero value.").Line().
+		Func().Id("jsValueToElem").Params(
+		jen.Id("v").Qual("syscall/js", "Value"),
+		jen.Id("elemType").Qual("reflect", "Type"),
+		jen.Id("what").String(),
+	).Params(jen.Qual("reflect", "Value"), jen.Any()).Block(
+		jen.List(jen.Id("elem"), jen.Err()).Op(":=").Id("jsValueToAny").Call(jen.Id("v"), jen.Id("elemType")),
+		jen.If(jen.Err().Op("!=").Nil()).Block(jen.Return(jen.Qual("reflect", "Value").Values(), jen.Err())),
+		jen.If(jen.Id("elem").Op("==").Nil()).Block(jen.Return(jen.Qual("reflect", "Zero").Call(jen.Id("elemType")), jen.Nil())),
+		jen.Id("elemValue").Op(":=").Qual("reflect", "ValueOf").Call(jen.Id("elem")),
+		jen.If(jen.Op("!").Id("elemValue").Dot("Type").Call().Dot("ConvertibleTo").Call(jen.Id("elemType"))).Block(
+			jen.Return(jen.Qual("reflect", "Value").Values(), generateJsGlobalError(jen.Qual("fmt", "Sprintf").Call(jen.Lit("%s of type %s does not fit into %s"), jen.Id("what"), jen.Id("elemValue").Dot("Type").Call(), jen.Id("elemType")))),
+		),
+		jen.Return(jen.Id("elemValue").Dot("Convert").Call(jen.Id("elemType")), jen.Nil()),
+	).Line()
+}
+
+// defaultReceiverName is the name of the receiver of the server unless
+// --receiver-name sets another one.
+const defaultReceiverName = "AgrowsReceive"
+
+func (opts *Options) generateServerReceiver(infos []FuncInfo) *jen.Statement {
+	header := jen.Comment(opts.ReceiverName + " decodes a function call from data and dispatches it to the matching function.").Line().
+		Func().
+		Id(opts.ReceiverName)
+	receive := jen.Id(opts.ReceiverName)
+	if opts.ServerStruct {
+		receive = jen.Id("s").Dot("Receive")
+		header = jen.Comment("Receive decodes a function call from data and dispatches it to the matching method of s.Handler.").Line().
+			Func().
+			Params(jen.Id("s").Op("*").Id("AgrowsServer")).
+			Id("Receive")
+	}
+
+	return header.
+		ParamsFunc(func(g *jen.Group) {
+			if opts.Trace == "otel" {
+				g.Id("ctx").Qual("context", "Context")
+			}
+			g.Id("data").Qual("", "[]byte")
+			for _, name := range opts.receiveParams() {
+				g.Id(name).String()
+			}
+		}).
+		Params(jen.Id("result").String(), jen.Err().Error()).
+		Block(
+			opts.generateBatchDetection(receive),
+
+			// unauthenticated calls do not use up the rate limit
+			opts.generateJWTCheck(),
+
+			opts.generateRateLimitCheck(),
+
+			opts.generateShutdownCheck(jen.Return(jen.Lit(""), shutdownError())),
+
+			jen.List(jen.Id("functionName"), jen.Id("args"), jen.Err()).
+				Op(":=").
+				Add(opts.generateDecodeCall(jen.Id("data"))),
+
+			jen.If(jen.Err().Op("!=").Nil()).Block(
+				jen.Err().Op("=").Qual("fmt", "Errorf").Call(jen.Lit("failed to decode function call: %w"), jen.Err()),
+				jen.Id("agrowsLog").Call(jen.Lit("error"), jen.Err().Dot("Error").Call()),
+				jen.Return(jen.Lit(""), jen.Err()),
+			),
+
+			jen.Comment("the ID of the call only matters to AgrowsResponses"),
+			jen.Delete(jen.Id("args"), jen.Lit(callArg)),
+
+			opts.generateAuditRecording(),
+
+			opts.generateAuthorizeCheck(),
+
+			generateAllowCheck(),
+
+			opts.generateDispatchContext(infos),
+
+			opts.generateServerCallLogging(),
+
+			jen.Comment("deferred before the recover, so errors produced from panics are logged too"),
+			jen.Defer().Func().Params().Block(
+				jen.If(jen.Err().Op("!=").Nil()).Block(
+					jen.Id("agrowsLog").Call(jen.Lit("error"), jen.Id("functionName").Op("+").Lit(": ").Op("+").Err().Dot("Error").Call()),
+				),
+			).Call(),
+
+			opts.generateServerMetricsRecording(),
+
+			generateMetricsHookCall(),
+
+			opts.generateStatsRecording(),
+
+			opts.generateServerTracing(),
+
+			jen.Defer().Id("agrowsRecover").Call(jen.Id("functionName"), jen.Op("&").Err()),
+
+			jen.Switch(jen.Id("functionName")).BlockFunc(func(generator *jen.Group) {
+				opts.generateHealthCase(generator)
+				for _, fnInfo := range infos {
+					generator.Empty()
+					target := fmt.Sprintf(opts.FunctionFormat, fnInfo.OriginalIdentifier.Name)
+					if opts.ServerStruct {
+						target = "Handler." + fnInfo.OriginalIdentifier.Name
+					}
+					generator.Commentf("%s -> %s", fnInfo.Signature(), target)
+					generator.Case(jen.Id(funcNameConstant(fnInfo))).
+						BlockFunc(func(caseGenerator *jen.Group) {
+							generateRequestDecoding(caseGenerator, fnInfo, jen.Lit(""))
+							if fnInfo.Emit != nil {
+								caseGenerator.Id("subscription").Op(":=").Id("args").Index(jen.Lit(subscriptionArg)).Dot("Value")
+								caseGenerator.Defer().Id("agrowsEmit").Call(jen.Id("subscription"), jen.Nil(), jen.True())
+							}
+							call := opts.generateDispatchCall(fnInfo, func(callGenerator *jen.Group) {
+								for i := range fnInfo.Params {
+									callGenerator.Id("request").Dot(requestFieldName(fnInfo, i))
+								}
+								if fnInfo.Emit != nil {
+									callGenerator.Add(generateEmitFunc(fnInfo))
+								}
+							})
+
+							if opts.isCached(fnInfo) {
+								generateCacheLookup(caseGenerator)
+							}
+
+							timeout := opts.generateCallTimeout(fnInfo)
+							if timeout != nil {
+								caseGenerator.List(jen.Id("ctx"), jen.Id("cancel")).Op(":=").Qual("context", "WithTimeout").Call(jen.Id("ctx"), timeout)
+								caseGenerator.Defer().Id("cancel").Call()
+							}
+
+							firstReturnedError := ""
+							firstReturnedString := ""
+							errorCount := 0
+							varNames := make([]string, len(fnInfo.Results))
+							for i := range fnInfo.Results {
+								if typeString(fnInfo.Results[i].DstField.Type) == "error" {
+									varNames[i] = "err" + fmt.Sprint(i)
+									firstReturnedError = varNames[i]
+									errorCount++
+									continue
+								}
+								if typeString(fnInfo.Results[i].DstField.Type) == "string" {
+									varNames[i] = "str" + fmt.Sprint(i)
+									firstReturnedString = varNames[i]
+									continue
+								}
+								varNames[i] = "ret" + fmt.Sprint(i)
+							}
+
+							if timeout != nil && !fnInfo.TakesContext {
+								generateAbandonableCall(caseGenerator, fnInfo, varNames, call)
+							} else if len(varNames) == 0 {
+								caseGenerator.Add(call)
+							} else {
+								caseGenerator.ListFunc(func(retGenerator *jen.Group) {
+									for _, varName := range varNames {
+										retGenerator.Id(varName)
+									}
+								}).Op(":=").Add(call)
+							}
+
+							if len(fnInfo.Results) == 0 {
+								caseGenerator.Return(jen.Lit(""), jen.Nil())
+								return
+							}
+
+							if firstReturnedError != "" {
+								caseGenerator.If(jen.Id(firstReturnedError).Op("!=").Nil()).Block(
+									jen.Return(jen.Lit(""), opts.generateMapErrorCall(jen.Id(firstReturnedError))),
+								)
+							}
+
+							if errorCount == len(fnInfo.Results) {
+								// nothing to report on success, like functions without results
+								caseGenerator.Return(jen.Lit(""), jen.Nil())
+								return
+							}
+
+							var strReturn *jen.Statement
+
+							if i := jsonResult(fnInfo); i >= 0 {
+								strReturn = generateEncodeResult(caseGenerator, varNames[i])
+							} else if firstReturnedString != "" {
+								strReturn = jen.Id(firstReturnedString)
+							} else {
+								strReturn = jen.Qual("fmt", "Sprintf").Call(
+									jen.Lit(fmt.Sprintf("'%%+v'%s", strings.Repeat(", '%+v'", len(varNames)-1))),
+									jen.ListFunc(func(paramGenerator *jen.Group) {
+										for _, varName := range varNames {
+											paramGenerator.Id(varName)
+										}
+									}),
+								)
+							}
+
+							if opts.isCached(fnInfo) {
+								caseGenerator.Id("result").Op("=").Add(strReturn)
+								caseGenerator.Id("agrowsCacheStore").Call(jen.Id("cacheKey"), jen.Id("result"))
+								caseGenerator.Return(jen.Id("result"), jen.Nil())
+								return
+							}
+							caseGenerator.Return(strReturn, jen.Nil())
+						})
+				}
+				generator.Empty()
+				generator.Default().Block(
+					jen.Return(jen.Lit(""), jen.Qual("fmt", "Errorf").Call(jen.Lit("unknown function '%s'"), jen.Id("functionName"))),
+				)
+			}),
+		)
+}
+
+// generateEncodeResult emits the JSON encoding of the slice, map or pointer
+// result varName into encoded, returning the error of the dispatch if it fails, and
+// returns the reply containing it.
+func generateEncodeResult(g *jen.Group, varName string) *jen.Statement {
+	g.List(jen.Id("encoded"), jen.Id("encodeErr")).Op(":=").Qual("encoding/json", "Marshal").Call(jen.Id(varName))
+	g.If(jen.Id("encodeErr").Op("!=").Nil()).Block(
+		jen.Return(jen.Lit(""), jen.Qual("fmt", "Errorf").Call(jen.Lit("%s: failed to encode result: %w"), jen.Id("functionName"), jen.Id("encodeErr"))),
+	)
+	return jen.String().Call(jen.Id("encoded"))
+}
+
+// generateDispatchTarget emits the function a dispatch case calls: the renamed
+// original, or the handler method when generating AgrowsServer.
+func (opts *Options) generateDispatchTarget(info FuncInfo) *jen.Statement {
+	if opts.ServerStruct {
+		return jen.Id("s").Dot("Handler").Dot(info.OriginalIdentifier.Name)
+	}
+	return jen.Id(fmt.Sprintf(opts.FunctionFormat, info.OriginalIdentifier.Name))
+}
+
+// generateDispatchCall emits the call of the dispatch target of info, passing
+// ctx to functions taking a context before the arguments added by args.
+func (opts *Options) generateDispatchCall(info FuncInfo, args func(*jen.Group)) *jen.Statement {
+	return opts.generateDispatchTarget(info).CallFunc(func(g *jen.Group) {
+		if info.TakesContext {
+			g.Id("ctx")
+		}
+		args(g)
+	})
+}
+
+// needsDispatchContext reports whether dispatching any of infos uses a ctx.
+func (opts *Options) needsDispatchContext(infos []FuncInfo) bool {
+	for _, info := range infos {
+		if info.TakesContext || opts.generateCallTimeout(info) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// generateDispatchContext emits the ctx the dispatch derives its contexts
+// from, unless AgrowsReceive already takes one.
+func (opts *Options) generateDispatchContext(infos []FuncInfo) jen.Code {
+	if opts.Trace == "otel" || !opts.needsDispatchContext(infos) {
+		return jen.Null()
+	}
+	return jen.Id("ctx").Op(":=").Qual("context", "Background").Call()
+}
+
+// generateCallTimeout emits the timeout bounding a call of info, or nil if
+// calls are not bounded.
+func (opts *Options) generateCallTimeout(info FuncInfo) *jen.Statement {
+	if info.Timeout > 0 {
+		return generateDuration(info.Timeout)
+	}
+	if opts.Timeout > 0 {
+		return jen.Id("AgrowsCallTimeout")
+	}
+	return nil
+}
+
+// generateDuration emits d in the largest unit it is a whole multiple of.
+func generateDuration(d time.Duration) *jen.Statement {
+	units := []struct {
+		unit time.Duration
+		name string
+	}{
+		{time.Hour, "Hour"},
+		{time.Minute, "Minute"},
+		{time.Second, "Second"},
+		{time.Millisecond, "Millisecond"},
+		{time.Microsecond, "Microsecond"},
+	}
+	for _, u := range units {
+		if d%u.unit == 0 {
+			return jen.Lit(int(d/u.unit)).Op("*").Qual("time", u.name)
+		}
+	}
+	return jen.Lit(int(d)).Op("*").Qual("time", "Nanosecond")
+}
+
+// generateAbandonableCall emits call running on its own goroutine, so the
+// dispatch can return a timeout error once ctx is done. Results are assigned
+// to varNames, which are only read after the call completed.
+func generateAbandonableCall(g *jen.Group, info FuncInfo, varNames []string, call *jen.Statement) {
+	for i, varName := range varNames {
+		g.Var().Id(varName).Add(typeCode(info.Results[i].DstField.Type, nil))
+	}
+	g.Var().Id("panicErr").Error()
+	g.Id("done").Op(":=").Make(jen.Chan().Struct())
+	g.Commentf("WARNING: %s does not take a context. On timeout it is abandoned, and its", info.OriginalIdentifier.Name)
+	g.Comment("goroutine keeps running (and may leak) until the function returns.")
+	g.Go().Func().Params().BlockFunc(func(goroutine *jen.Group) {
+		goroutine.Defer().Close(jen.Id("done"))
+		goroutine.Defer().Id("agrowsRecover").Call(jen.Id("functionName"), jen.Op("&").Id("panicErr"))
+		if len(varNames) == 0 {
+			goroutine.Add(call)
+			return
+		}
+		goroutine.ListFunc(func(retGenerator *jen.Group) {
+			for _, varName := range varNames {
+				retGenerator.Id(varName)
+			}
+		}).Op("=").Add(call)
+	}).Call()
+	g.Select().Block(
+		jen.Case(jen.Op("<-").Id("done")),
+		jen.Case(jen.Op("<-").Id("ctx").Dot("Done").Call()).Block(
+			jen.Return(jen.Lit(""), jen.Qual("fmt", "Errorf").Call(jen.Lit("%s: %w"), jen.Id("functionName"), jen.Id("ctx").Dot("Err").Call())),
+		),
+	)
+	g.If(jen.Id("panicErr").Op("!=").Nil()).Block(
+		jen.Return(jen.Lit(""), jen.Id("panicErr")),
+	)
+}
+
+// generateCallTimeoutVar emits AgrowsCallTimeout, the timeout of calls to
+// functions without an //agrows:timeout directive.
+func (opts *Options) generateCallTimeoutVar() *jen.Statement {
+	return jen.Comment("AgrowsCallTimeout bounds calls of functions without an //agrows:timeout directive.").Line().
+		Comment("It must be positive.").Line().
+		Var().Id("AgrowsCallTimeout").Op("=").Add(generateDuration(opts.Timeout)).Line()
+}
+
+// generateServerStruct emits AgrowsServer together with the AgrowsHandler
+// interface it dispatches to, and an adapter implementing the interface with
+// the functions from the source file.
+func (opts *Options) generateServerStruct(infos []FuncInfo) *jen.Statement {
+	signature := func(info FuncInfo) *jen.Statement {
+		return jen.ParamsFunc(func(g *jen.Group) {
+			if info.TakesContext {
+				g.Id("ctx").Qual("context", "Context")
+			}
+			for _, paramInfo := range info.Params {
+				g.Id(paramIdent(info, paramInfo.DstField.Names[0].Name)).Add(typeCode(paramInfo.DstField.Type, nil))
+			}
+		}).ParamsFunc(func(g *jen.Group) {
+			for _, resultInfo := range info.Results {
+				g.Add(typeCode(resultInfo.DstField.Type, nil))
+			}
+		})
+	}
+
+	code := jen.Comment("AgrowsHandler is implemented by the value AgrowsServer dispatches calls to.").Line().
+		Comment("It has one method per function of the source file.").Line().
+		Type().Id("AgrowsHandler").InterfaceFunc(func(g *jen.Group) {
+		for _, info := range infos {
+			g.Id(info.OriginalIdentifier.Name).Add(signature(info))
+		}
+	}).Line().Line()
+
+	code.Comment("AgrowsServer dispatches received calls to Handler, which can carry whatever").Line().
+		Comment("dependencies the functions need.").Line().
+		Type().Id("AgrowsServer").Struct(
+		jen.Id("Handler").Id("AgrowsHandler"),
+	).Line().Line()
+
+	code.Comment("NewAgrowsServer returns a server dispatching to handler. A nil handler").Line().
+		Comment("dispatches to the functions of the source file.").Line().
+		Func().Id("NewAgrowsServer").Params(jen.Id("handler").Id("AgrowsHandler")).Op("*").Id("AgrowsServer").Block(
+		jen.If(jen.Id("handler").Op("==").Nil()).Block(
+			jen.Id("handler").Op("=").Id("agrowsFunctions").Values(),
+		),
+		jen.Return(jen.Op("&").Id("AgrowsServer").Values(jen.Dict{jen.Id("Handler"): jen.Id("handler")})),
+	).Line().Line()
+
+	code.Type().Id("agrowsFunctions").Struct().Line().Line()
+	for _, info := range infos {
+		code.Func().Params(jen.Id("agrowsFunctions")).Id(info.OriginalIdentifier.Name).Add(signature(info)).BlockFunc(func(g *jen.Group) {
+			call := jen.Id(fmt.Sprintf(opts.FunctionFormat, info.OriginalIdentifier.Name)).CallFunc(func(g *jen.Group) {
+				if info.TakesContext {
+					g.Id("ctx")
+				}
+				for _, paramInfo := range info.Params {
+					g.Id(paramIdent(info, paramInfo.DstField.Names[0].Name))
+				}
+			})
+			if len(info.Results) == 0 {
+				g.Add(call)
+			} else {
+				g.Return(call)
+			}
+		}).Line().Line()
+	}
+
+	return code
+}
+
+// requestTypeName returns the name of the struct the arguments of info are
+// decoded into, e.g. "createUserRequest" for CreateUser.
+func requestTypeName(info FuncInfo) string {
+	name := info.OriginalIdentifier.Name
+	return strings.ToLower(name[:1]) + name[1:] + "Request"
+}
+
+// requestFieldName returns the field of the request struct holding the
+// parameter at index. Parameters are exported by capitalizing them, clashes
+// between names differing only in their first letter get the index appended.
+func requestFieldName(info FuncInfo, index int) string {
+	fieldName := func(i int) string {
+		name := info.Params[i].DstField.Names[0].Name
+		return strings.ToUpper(name[:1]) + name[1:]
+	}
+
+	name := fieldName(index)
+	for i := range info.Params {
+		if i != index && fieldName(i) == name {
+			return fmt.Sprintf("%s%d", name, index)
+		}
+	}
+	return name
+}
+
+// reservedIdentifiers are the names generated code declares or refers to next
+// to the parameters of a function, i.e. its own variables and the packages it
+// uses.
+var reservedIdentifiers = map[string]bool{
+	"args": true, "conn": true, "ctx": true, "data": true, "decoded": true,
+	"encoded": true, "err": true, "event": true, "functionName": true,
+	"ok": true, "p": true, "reply": true, "request": true, "result": true,
+	"start": true, "subscription": true, "t": true, "this": true, "traceContext": true,
+	"sendMessage": true, "jsValueToAny": true, "jsValueToElem": true,
+	"context": true, "errors": true, "fmt": true, "js": true, "json": true,
+	"protocol": true, "reflect": true, "strings": true, "testing": true,
+	"time": true, "websocket": true,
+}
+
+// paramIdent returns the Go identifier generated code uses for the parameter
+// name of info. Names clashing with reservedIdentifiers, the agrows helpers or
+// the function itself get "Arg" appended. The name on the wire stays the same.
+func paramIdent(info FuncInfo, name string) string {
+	if !reservedIdentifiers[name] && !strings.HasPrefix(name, "agrows") && name != info.OriginalIdentifier.Name {
+		return name
+	}
+	taken := func(ident string) bool {
+		for _, paramInfo := range info.Params {
+			if paramInfo.DstField.Names[0].Name == ident {
+				return true
+			}
+		}
+		return info.Emit != nil && info.Emit.DstField.Names[0].Name == ident
+	}
+	ident := name + "Arg"
+	for taken(ident) {
+		ident += "Arg"
+	}
+	return ident
+}
+
+func funcNameConstant(info FuncInfo) string {
+	return "AgrowsFunc_" + info.OriginalIdentifier.Name
+}
+
+// generateFuncNameConstants emits a constant per function holding the name its
+// calls are dispatched by, for hooks receiving function names.
+func generateFuncNameConstants(infos []FuncInfo) *jen.Statement {
+	return jen.Comment("Names of the functions as passed to hooks like AgrowsAllow and AgrowsAuthorize.").Line().
+		Const().DefsFunc(func(g *jen.Group) {
+		for _, info := range infos {
+			g.Id(funcNameConstant(info)).Op("=").Lit(info.OriginalIdentifier.Name)
+		}
+	}).Line()
+}
+
+// generateRequestTypes emits one request struct per function with parameters.
+// The json tags match the argument names used on the wire. Requests of
+// primitive parameters also get agrowsAssign, and agrowsConvertNumber if one of
+// them is a number.
+func (opts *Options) generateRequestTypes(infos []FuncInfo) *jen.Statement {
+	types := jen.Null()
+	numbers := false
+	for _, info := range infos {
+		if len(info.Params) == 0 {
+			continue
+		}
+		types.Type().Id(requestTypeName(info)).StructFunc(func(g *jen.Group) {
+			for i, paramInfo := range info.Params {
+				param := paramInfo.DstField
+				g.Id(requestFieldName(info, i)).Add(typeCode(param.Type, nil)).Tag(map[string]string{"json": param.Names[0].Name})
+			}
+		}).Line().Line()
+		if primitiveParams(info) {
+			types.Add(opts.generateRequestAssign(info))
+			for _, paramInfo := range info.Params {
+				numbers = numbers || isNumber(paramInfo)
+			}
+		}
+	}
+	if numbers {
+		types.Add(generateConvertNumber())
+	}
+	return types
+}
+
+// isNumber reports whether p is of a builtin numeric type, which arguments may
+// have been decoded as another one of, e.g. float64 for all JSON numbers.
+func isNumber(p *ParamReflectInfo) bool {
+	ident, ok := p.DstField.Type.(*dst.Ident)
+	return ok && p.IsPrimitive() && ident.Name != "string" && ident.Name != "bool"
+}
+
+// generateConvertNumber emits agrowsConvertNumber, which converts a number of
+// any numeric kind to the numeric type target points to with reflection. It
+// rejects values which do not fit, such as fractions for integers.
+func generateConvertNumber() *jen.Statement {
+	kinds := func(prefix string, sizes ...string) []jen.Code {
+		var codes []jen.Code
+		for _, size := range sizes {
+			codes = append(codes, jen.Qual("reflect", prefix+size))
+		}
+		return codes
+	}
+	v := func(method string) *jen.Statement { return jen.Id("v").Dot(method).Call() }
+	whole := v("Float").Op("==").Qual("math", "Trunc").Call(v("Float"))
+	return jen.Comment("agrowsConvertNumber sets the number target points to to value, a number of any").Line().
+		Comment("numeric type, e.g. the float64 JSON numbers are decoded as. It reports false if").Line().
+		Comment("value is no number or does not fit, e.g. a fraction for an integer.").Line().
+		Func().Id("agrowsConvertNumber").Params(jen.Id("value").Any(), jen.Id("target").Any()).Bool().Block(
+		jen.Id("v").Op(":=").Qual("reflect", "ValueOf").Call(jen.Id("value")),
+		jen.Id("t").Op(":=").Qual("reflect", "ValueOf").Call(jen.Id("target")).Dot("Elem").Call(),
+		jen.Switch(jen.Id("t").Dot("Kind").Call()).Block(
+			jen.Case(kinds("Int", "", "8", "16", "32", "64")...).Block(
+				jen.Var().Id("n").Int64(),
+				jen.Switch().Block(
+					jen.Case(v("CanInt")).Block(jen.Id("n").Op("=").Add(v("Int"))),
+					jen.Case(v("CanUint").Op("&&").Add(v("Uint")).Op("<=").Qual("math", "MaxInt64")).Block(jen.Id("n").Op("=").Int64().Call(v("Uint"))),
+					jen.Case(v("CanFloat").Op("&&").Add(whole).Op("&&").Add(v("Float")).Op(">=").Qual("math", "MinInt64").Op("&&").Add(v("Float")).Op("<").Qual("math", "MaxInt64")).Block(jen.Id("n").Op("=").Int64().Call(v("Float"))),
+					jen.Default().Block(jen.Return(jen.False())),
+				),
+				jen.If(jen.Id("t").Dot("OverflowInt").Call(jen.Id("n"))).Block(jen.Return(jen.False())),
+				jen.Id("t").Dot("SetInt").Call(jen.Id("n")),
+			),
+			jen.Case(kinds("Uint", "", "8", "16", "32", "64", "ptr")...).Block(
+				jen.Var().Id("n").Uint64(),
+				jen.Switch().Block(
+					jen.Case(v("CanUint")).Block(jen.Id("n").Op("=").Add(v("Uint"))),
+					jen.Case(v("CanInt").Op("&&").Add(v("Int")).Op(">=").Lit(0)).Block(jen.Id("n").Op("=").Uint64().Call(v("Int"))),
+					jen.Case(v("CanFloat").Op("&&").Add(whole).Op("&&").Add(v("Float")).Op(">=").Lit(0).Op("&&").Add(v("Float")).Op("<").Qual("math", "MaxUint64")).Block(jen.Id("n").Op("=").Uint64().Call(v("Float"))),
+					jen.Default().Block(jen.Return(jen.False())),
+				),
+				jen.If(jen.Id("t").Dot("OverflowUint").Call(jen.Id("n"))).Block(jen.Return(jen.False())),
+				jen.Id("t").Dot("SetUint").Call(jen.Id("n")),
+			),
+			jen.Case(kinds("Float", "32", "64")...).Block(
+				jen.Var().Id("f").Float64(),
+				jen.Switch().Block(
+					jen.Case(v("CanFloat")).Block(jen.Id("f").Op("=").Add(v("Float"))),
+					jen.Case(v("CanInt")).Block(jen.Id("f").Op("=").Float64().Call(v("Int"))),
+					jen.Case(v("CanUint")).Block(jen.Id("f").Op("=").Float64().Call(v("Uint"))),
+					jen.Default().Block(jen.Return(jen.False())),
+				),
+				jen.If(jen.Id("t").Dot("OverflowFloat").Call(jen.Id("f"))).Block(jen.Return(jen.False())),
+				jen.Id("t").Dot("SetFloat").Call(jen.Id("f")),
+			),
+			jen.Default().Block(jen.Return(jen.False())),
+		),
+		jen.Return(jen.True()),
+	).Line().Line()
+}
+
+// primitiveParams reports whether info has parameters, all of primitive types,
+// whose request gets the agrowsAssign fast path.
+func primitiveParams(info FuncInfo) bool {
+	if len(info.Params) == 0 {
+		return false
+	}
+	for _, paramInfo := range info.Params {
+		if !paramInfo.IsPrimitive() {
+			return false
+		}
+	}
+	return true
+}
+
+// generateRequestAssign emits the agrowsAssign method of the request of info,
+// setting its fields from the arguments with type assertions, or with
+// agrowsConvertNumber for numbers, which may have been decoded as another
+// numeric type. It reports false if an argument does not fit, which
+// agrowsDecodeRequest then reports.
+func (opts *Options) generateRequestAssign(info FuncInfo) *jen.Statement {
+	return jen.Func().Params(jen.Id("r").Op("*").Id(requestTypeName(info))).Id("agrowsAssign").Params(
+		jen.Id("args").Map(jen.String()).Qual(opts.ProtocolPath, "Argument"),
+	).Bool().BlockFunc(func(g *jen.Group) {
+		if slices.ContainsFunc(info.Params, func(p *ParamReflectInfo) bool { return !isNumber(p) }) {
+			g.Var().Id("ok").Bool()
+		}
+		for i, paramInfo := range info.Params {
+			if isNumber(paramInfo) {
+				g.If(jen.Op("!").Id("agrowsConvertNumber").Call(jen.Id("args").Index(jen.Lit(paramInfo.DstField.Names[0].Name)).Dot("Value"), jen.Op("&").Id("r").Dot(requestFieldName(info, i)))).Block(
+					jen.Return(jen.False()),
+				)
+				continue
+			}
+			g.If(
+				jen.List(jen.Id("r").Dot(requestFieldName(info, i)), jen.Id("ok")).Op("=").Id("args").Index(jen.Lit(paramInfo.DstField.Names[0].Name)).Dot("Value").Assert(typeCode(paramInfo.DstField.Type, nil)),
+				jen.Op("!").Id("ok"),
+			).Block(
+				jen.Return(jen.False()),
+			)
+		}
+		g.Return(jen.True())
+	}).Line().Line()
+}
+
+// generateRequestDecoding declares the request of info and decodes the
+// received args into it, returning results followed by the error if that
+// fails. Requests of primitive parameters are assigned directly when the
+// arguments fit their types, sparing the JSON round trip.
+func generateRequestDecoding(g *jen.Group, info FuncInfo, results ...jen.Code) {
+	if len(info.Params) == 0 {
+		return
+	}
+	g.Var().Id("request").Id(requestTypeName(info))
+	decode := jen.If(
+		jen.Err().Op(":=").Id("agrowsDecodeRequest").CallFunc(func(call *jen.Group) {
+			call.Id("functionName")
+			call.Id("args")
+			call.Op("&").Id("request")
+			for _, paramInfo := range info.Params {
+				call.Lit(paramInfo.DstField.Names[0].Name)
+			}
+		}),
+		jen.Err().Op("!=").Nil(),
+	).Block(
+		jen.Return(append(results, jen.Err())...),
+	)
+	if primitiveParams(info) {
+		g.If(jen.Op("!").Id("request").Dot("agrowsAssign").Call(jen.Id("args"))).Block(decode)
+		return
+	}
+	g.Add(decode)
+}
+
+// generateDecodeRequest emits the helper decoding the received arguments into
+// a request struct. Going through JSON converts numbers to the parameter types
+// and rebuilds struct parameters from the decoded objects. With binary, the
+// arguments decoded by agrowsDecodeCall go through their encoding instead.
+func (opts *Options) generateDecodeRequest(binary bool) *jen.Statement {
+	marshal, unmarshal := jen.Qual("encoding/json", "Marshal"), jen.Qual("encoding/json", "Unmarshal")
+	if binary {
+		marshal, unmarshal = jen.Id("agrowsMarshal"), jen.Id("agrowsUnmarshal")
+	}
+	return jen.Func().Id("agrowsDecodeRequest").Params(
+		jen.Id("functionName").String(),
+		jen.Id("args").Map(jen.String()).Qual(opts.ProtocolPath, "Argument"),
+		jen.Id("request").Any(),
+		jen.Id("required").Op("...").String(),
+	).Error().Block(
+		jen.Id("values").Op(":=").Make(jen.Map(jen.String()).Any(), jen.Len(jen.Id("args"))),
+		jen.For(jen.List(jen.Id("key"), jen.Id("arg")).Op(":=").Range().Id("args")).Block(
+			jen.Id("values").Index(jen.Id("key")).Op("=").Id("arg").Dot("Value"),
+		),
+		jen.For(jen.List(jen.Id("_"), jen.Id("key")).Op(":=").Range().Id("required")).Block(
+			jen.If(jen.List(jen.Id("_"), jen.Id("ok")).Op(":=").Id("values").Index(jen.Id("key")), jen.Op("!").Id("ok")).Block(
+				jen.Return(jen.Qual("fmt", "Errorf").Call(jen.Lit("%s: parameter '%s' is not in the received arguments"), jen.Id("functionName"), jen.Id("key"))),
+			),
+		),
+		jen.List(jen.Id("data"), jen.Err()).Op(":=").Add(marshal).Call(jen.Id("values")),
+		jen.If(jen.Err().Op("!=").Nil()).Block(
+			jen.Return(jen.Qual("fmt", "Errorf").Call(jen.Lit("%s: failed to encode arguments: %w"), jen.Id("functionName"), jen.Err())),
+		),
+		jen.If(jen.Err().Op(":=").Add(unmarshal).Call(jen.Id("data"), jen.Id("request")), jen.Err().Op("!=").Nil()).Block(
+			jen.Return(jen.Qual("fmt", "Errorf").Call(jen.Lit("%s: failed to decode arguments: %w"), jen.Id("functionName"), jen.Err())),
+		),
+		jen.Return(jen.Nil()),
+	).Line()
+}
+
+// needsAgrowsError reports whether any enabled feature uses AgrowsError.
+func (opts *Options) needsAgrowsError() bool {
+	return opts.HTTPErrors == "codes" || opts.rateLimit > 0 || opts.Auth != "" || len(opts.errorCodes) > 0
+}
+
+// generateAgrowsError emits AgrowsError, an error with a status code that user
+// functions can return and that features map to their transport.
+func generateAgrowsError() *jen.Statement {
+	return jen.Comment("AgrowsError is an error carrying a status code. Return it (or wrap it) from a").Line().
+		Comment("function to control the status reported to the caller.").Line().
+		Type().Id("AgrowsError").Struct(
+		jen.Id("Code").Int(),
+		jen.Id("Message").String(),
+	).Line().Line().
+		Func().Params(jen.Id("e").Op("*").Id("AgrowsError")).Id("Error").Params().String().Block(
+		jen.Return(jen.Id("e").Dot("Message")),
+	).Line()
+}
+
+// generateReceiverNameConstant emits AgrowsReceiverName, the name of the
+// receiver set by --receiver-name, for code referring to it by name.
+func (opts *Options) generateReceiverNameConstant() *jen.Statement {
+	return jen.Comment("AgrowsReceiverName is the name of the function decoding and dispatching the calls.").Line().
+		Const().Id("AgrowsReceiverName").Op("=").Lit(opts.ReceiverName).Line()
+}
+
+// generateReceiveCall emits a call of AgrowsReceive, or the receiver named by
+// --receiver-name, with the given data, passing whatever additional arguments
+// the enabled features require.
+func (opts *Options) generateReceiveCall(data jen.Code) *jen.Statement {
+	return jen.Id(opts.ReceiverName).CallFunc(func(g *jen.Group) {
+		if opts.Trace == "otel" {
+			g.Id("ctx")
+		}
+		g.Add(data)
+		for _, name := range opts.receiveParams() {
+			g.Id(name)
+		}
+	})
+}
+
+// receiveParams returns the names of the string parameters AgrowsReceive takes
+// after data, which every caller passes on.
+func (opts *Options) receiveParams() []string {
+	var params []string
+	if opts.rateLimit > 0 {
+		params = append(params, "clientID")
+	}
+	if opts.Auth == "jwt" {
+		params = append(params, "token")
+	}
+	return params
+}
+
+// generateServerRecover emits the helper deferred by AgrowsReceive that turns a
+// panic in a dispatched function into an error carrying a truncated stack.
+func generateServerRecover() *jen.Statement {
+	return jen.Const().Id("agrowsPanicStackLimit").Op("=").Lit(4096).Line().Line().
+		Func().Id("agrowsRecover").Params(
+		jen.Id("functionName").String(),
+		jen.Err().Op("*").Error(),
+	).Block(
+		jen.If(jen.Id("r").Op(":=").Recover(), jen.Id("r").Op("!=").Nil()).Block(
+			jen.Id("stack").Op(":=").Qual("runtime/debug", "Stack").Call(),
+			jen.If(jen.Len(jen.Id("stack")).Op(">").Id("agrowsPanicStackLimit")).Block(
+				jen.Id("stack").Op("=").Id("stack").Index(jen.Empty(), jen.Id("agrowsPanicStackLimit")),
+			),
+			jen.Op("*").Err().Op("=").Qual("fmt", "Errorf").Call(jen.Lit("panic in %s: %v\n%s"), jen.Id("functionName"), jen.Id("r"), jen.Id("stack")),
+		),
+	).Line()
+}
+
+// generateServerCallLogging emits the entry log and the deferred result log for
+// AgrowsReceive. It must run before the recover is deferred so the result log
+// sees errors produced from panics.
+func (opts *Options) generateServerCallLogging() jen.Code {
+	if !opts.LogCalls {
+		return jen.Null()
+	}
+
+	return jen.Add(
+		jen.Id("agrowsLogger").Dot("Info").CallFunc(func(g *jen.Group) {
+			g.Lit("agrows.call")
+			g.Lit("function")
+			g.Id("functionName")
+			if opts.LogArgs {
+				g.Lit("args")
+				g.Id("args")
+			}
+		}).Line(),
+		jen.Defer().Func().Params().Block(
+			jen.Id("agrowsLogger").Dot("Info").Call(
+				jen.Lit("agrows.result"),
+				jen.Lit("function"), jen.Id("functionName"),
+				jen.Lit("result"), jen.Id("result"),
+				jen.Lit("error"), jen.Err(),
+			),
+		).Call(),
+	)
+}
+
+// generateServerLogger emits the slog logger used by the call logging together
+// with the exported level var that controls its minimum level.
+func (opts *Options) generateServerLogger() *jen.Statement {
+	return jen.Var().Id("AgrowsLogLevel").Op("=").New(jen.Qual("log/slog", "LevelVar")).Line().Line().
+		Var().Id("agrowsLogger").Op("=").Qual("log/slog", "New").Call(
+		jen.Qual("log/slog", "NewTextHandler").Call(
+			jen.Qual("os", "Stderr"),
+			jen.Op("&").Qual("log/slog", "HandlerOptions").Values(jen.Dict{
+				jen.Id("Level"): jen.Id("AgrowsLogLevel"),
+			}),
+		),
+	).Line().Line().
+		Func().Id("init").Params().Block(
+		jen.Id("AgrowsLogLevel").Dot("Set").Call(jen.Qual("log/slog", logLevels[opts.LogLevel])),
+	).Line()
+}
+
+const prometheusPackage = "github.com/prometheus/client_golang/prometheus"
+
+// generateServerMetricsRecording emits the per call metric updates for
+// AgrowsReceive. Nothing is recorded until InitAgrowsMetrics was called.
+func (opts *Options) generateServerMetricsRecording() jen.Code {
+	if opts.Metrics != "prometheus" {
+		return jen.Null()
+	}
+
+	return jen.If(jen.Id("agrowsMetrics").Op("!=").Nil()).Block(
+		jen.Id("start").Op(":=").Qual("time", "Now").Call(),
+		jen.Id("agrowsMetrics").Dot("callsTotal").Dot("WithLabelValues").Call(jen.Id("functionName")).Dot("Inc").Call(),
+		jen.Defer().Func().Params().Block(
+			jen.Id("agrowsMetrics").Dot("callDuration").Dot("WithLabelValues").Call(jen.Id("functionName")).Dot("Observe").Call(
+				jen.Qual("time", "Since").Call(jen.Id("start")).Dot("Seconds").Call(),
+			),
+			jen.If(jen.Err().Op("!=").Nil()).Block(
+				jen.Id("agrowsMetrics").Dot("errorsTotal").Dot("WithLabelValues").Call(jen.Id("functionName")).Dot("Inc").Call(),
+			),
+		).Call(),
+	)
+}
+
+// generateMetricsHookCall emits the deferred call of the AgrowsMetrics hook in
+// AgrowsReceive. Like the call logging it must be deferred before the recover.
+func generateMetricsHookCall() jen.Code {
+	return jen.If(jen.Id("AgrowsMetrics").Op("!=").Nil()).Block(
+		jen.Id("start").Op(":=").Qual("time", "Now").Call(),
+		jen.Defer().Func().Params().Block(
+			jen.Id("AgrowsMetrics").Call(jen.Id("functionName"), jen.Qual("time", "Since").Call(jen.Id("start")), jen.Err()),
+		).Call(),
+	)
+}
+
+// generateMetricsHook emits the AgrowsMetrics hook. In the server it measures
+// dispatched calls, in the client encoding and sending them.
+func generateMetricsHook(genType byte) *jen.Statement {
+	measured := "dispatched call"
+	if genType == CLIENT {
+		measured = "sent call"
+	}
+	return jen.Commentf("AgrowsMetrics, if set, is called after every %s with the name of the function,", measured).Line().
+		Comment("the time the call took and its error. It is called concurrently when calls are.").Line().
+		Var().Id("AgrowsMetrics").Func().Params(
+		jen.Id("fn").String(),
+		jen.Id("duration").Qual("time", "Duration"),
+		jen.Err().Error(),
+	).Line()
+}
+
+// generateLogHook emits the AgrowsLog hook and the agrowsLog helper the
+// generated code logs through.
+func generateLogHook(genType byte) *jen.Statement {
+	logged := "failed calls"
+	if genType == CLIENT {
+		logged = "the registered functions"
+	}
+	return jen.Comment("AgrowsLog, if set, is called with the level (debug, info, warn or error) and text of").Line().
+		Commentf("every message of agrows, e.g. about %s. Nothing is logged while it is nil.", logged).Line().
+		Var().Id("AgrowsLog").Func().Params(jen.Id("level").String(), jen.Id("msg").String()).Line().Line().
+		Func().Id("agrowsLog").Params(jen.Id("level").String(), jen.Id("msg").String()).Block(
+		jen.If(jen.Id("AgrowsLog").Op("!=").Nil()).Block(
+			jen.Id("AgrowsLog").Call(jen.Id("level"), jen.Id("msg")),
+		),
+	).Line()
+}
+
+// generateClientMetricsRecording emits the helper the client functions report
+// their calls to AgrowsMetrics with. result is what sendMessage returned.
+func generateClientMetricsRecording() *jen.Statement {
+	return jen.Func().Id("agrowsRecordMetrics").Params(
+		jen.Id("functionName").String(),
+		jen.Id("start").Qual("time", "Time"),
+		jen.Id("result").Any(),
+	).Block(
+		jen.If(jen.Id("AgrowsMetrics").Op("==").Nil()).Block(jen.Return()),
+		jen.Var().Err().Error(),
+		jen.Switch(jen.Id("r").Op(":=").Id("result").Assert(jen.Type())).Block(
+			jen.Case(jen.Nil()),
+			jen.Case(jen.Error()).Block(jen.Err().Op("=").Id("r")),
+			jen.Case(jen.Qual("syscall/js", "Value")).Block(
+				jen.Err().Op("=").Qual("errors", "New").Call(jen.Id("r").Dot("Get").Call(jen.Lit("message")).Dot("String").Call()),
+			),
+			jen.Default().Block(jen.Err().Op("=").Qual("fmt", "Errorf").Call(jen.Lit("%v"), jen.Id("r"))),
+		),
+		jen.Id("AgrowsMetrics").Call(jen.Id("functionName"), jen.Qual("time", "Since").Call(jen.Id("start")), jen.Err()),
+	).Line()
+}
+
+// generateServerMetrics emits the prometheus collectors and
+// InitAgrowsMetrics, which registers them and enables recording. The struct
+// holding them is unexported so AgrowsMetrics stays free for the hook.
+func generateServerMetrics() *jen.Statement {
+	labels := jen.Index().String().Values(jen.Lit("function"))
+
+	return jen.Type().Id("agrowsPrometheusMetrics").Struct(
+		jen.Id("callsTotal").Op("*").Qual(prometheusPackage, "CounterVec"),
+		jen.Id("callDuration").Op("*").Qual(prometheusPackage, "HistogramVec"),
+		jen.Id("errorsTotal").Op("*").Qual(prometheusPackage, "CounterVec"),
+	).Line().Line().
+		Var().Id("agrowsMetrics").Op("*").Id("agrowsPrometheusMetrics").Line().Line().
+		Comment("InitAgrowsMetrics creates the agrows collectors, registers them with reg and").Line().
+		Comment("starts recording calls in AgrowsReceive.").Line().
+		Func().Id("InitAgrowsMetrics").Params(jen.Id("reg").Qual(prometheusPackage, "Registerer")).Error().Block(
+		jen.Id("metrics").Op(":=").Op("&").Id("agrowsPrometheusMetrics").Values(jen.Dict{
+			jen.Id("callsTotal"): jen.Qual(prometheusPackage, "NewCounterVec").Call(jen.Qual(prometheusPackage, "CounterOpts").Values(jen.Dict{
+				jen.Id("Name"): jen.Lit("agrows_calls_total"),
+				jen.Id("Help"): jen.Lit("Number of calls received per function."),
+			}), labels.Clone()),
+			jen.Id("callDuration"): jen.Qual(prometheusPackage, "NewHistogramVec").Call(jen.Qual(prometheusPackage, "HistogramOpts").Values(jen.Dict{
+				jen.Id("Name"):    jen.Lit("agrows_call_duration_seconds"),
+				jen.Id("Help"):    jen.Lit("Duration of calls per function."),
+				jen.Id("Buckets"): jen.Qual(prometheusPackage, "DefBuckets"),
+			}), labels.Clone()),
+			jen.Id("errorsTotal"): jen.Qual(prometheusPackage, "NewCounterVec").Call(jen.Qual(prometheusPackage, "CounterOpts").Values(jen.Dict{
+				jen.Id("Name"): jen.Lit("agrows_errors_total"),
+				jen.Id("Help"): jen.Lit("Number of calls per function that returned an error."),
+			}), labels.Clone()),
+		}),
+		jen.For(jen.List(jen.Id("_"), jen.Id("collector")).Op(":=").Range().Index().Qual(prometheusPackage, "Collector").Values(
+			jen.Id("metrics").Dot("callsTotal"),
+			jen.Id("metrics").Dot("callDuration"),
+			jen.Id("metrics").Dot("errorsTotal"),
+		)).Block(
+			jen.If(jen.Err().Op(":=").Id("reg").Dot("Register").Call(jen.Id("collector")), jen.Err().Op("!=").Nil()).Block(
+				jen.Return(jen.Err()),
+			),
+		),
+		jen.Id("agrowsMetrics").Op("=").Id("metrics"),
+		jen.Return(jen.Nil()),
+	).Line()
+}
+
+const (
+	otelPackage            = "go.opentelemetry.io/otel"
+	otelAttributePackage   = "go.opentelemetry.io/otel/attribute"
+	otelCodesPackage       = "go.opentelemetry.io/otel/codes"
+	otelPropagationPackage = "go.opentelemetry.io/otel/propagation"
+	otelTracePackage       = "go.opentelemetry.io/otel/trace"
+)
+
+// generateTraceContextArg emits the name of the argument that carries the
+// client's trace context. Server and client have to agree on it.
+func generateTraceContextArg() *jen.Statement {
+	return jen.Const().Id("agrowsTraceContextArg").Op("=").Lit("agrowsTraceContext").Line()
+}
+
+// generateServerTracing emits the span wrapping the dispatch in AgrowsReceive.
+// The span continues the trace sent by the client, if there is one.
+func (opts *Options) generateServerTracing() jen.Code {
+	if opts.Trace != "otel" {
+		return jen.Null()
+	}
+
+	return jen.Add(
+		jen.Id("ctx").Op("=").Id("agrowsExtractTraceContext").Call(jen.Id("ctx"), jen.Id("args")).Line(),
+		jen.List(jen.Id("ctx"), jen.Id("span")).Op(":=").Qual(otelPackage, "Tracer").Call(jen.Lit("agrows")).Dot("Start").Call(
+			jen.Id("ctx"),
+			jen.Id("functionName"),
+			jen.Qual(otelTracePackage, "WithAttributes").Call(jen.Qual(otelAttributePackage, "String").Call(jen.Lit("agrows.function"), jen.Id("functionName"))),
+		).Line(),
+		jen.Defer().Func().Params().Block(
+			jen.If(jen.Err().Op("!=").Nil()).Block(
+				jen.Id("span").Dot("RecordError").Call(jen.Err()),
+				jen.Id("span").Dot("SetStatus").Call(jen.Qual(otelCodesPackage, "Error"), jen.Err().Dot("Error").Call()),
+			),
+			jen.Id("span").Dot("End").Call(),
+		).Call(),
+	)
+}
+
+// generateServerTraceExtraction emits the helper that removes the trace context
+// from the received arguments and extracts it into the context.
+func (opts *Options) generateServerTraceExtraction() *jen.Statement {
+	return jen.Func().Id("agrowsExtractTraceContext").Params(
+		jen.Id("ctx").Qual("context", "Context"),
+		jen.Id("args").Map(jen.String()).Qual(opts.ProtocolPath, "Argument"),
+	).Qual("context", "Context").Block(
+		jen.List(jen.Id("arg"), jen.Id("ok")).Op(":=").Id("args").Index(jen.Id("agrowsTraceContextArg")),
+		jen.If(jen.Op("!").Id("ok")).Block(jen.Return(jen.Id("ctx"))),
+		jen.Delete(jen.Id("args"), jen.Id("agrowsTraceContextArg")),
+		jen.List(jen.Id("values"), jen.Id("ok")).Op(":=").Id("arg").Dot("Value").Assert(jen.Map(jen.String()).Any()),
+		jen.If(jen.Op("!").Id("ok")).Block(jen.Return(jen.Id("ctx"))),
+		jen.Id("carrier").Op(":=").Qual(otelPropagationPackage, "MapCarrier").Values(),
+		jen.For(jen.List(jen.Id("key"), jen.Id("value")).Op(":=").Range().Id("values")).Block(
+			jen.If(jen.List(jen.Id("str"), jen.Id("ok")).Op(":=").Id("value").Assert(jen.String()), jen.Id("ok")).Block(
+				jen.Id("carrier").Index(jen.Id("key")).Op("=").Id("str"),
+			),
+		),
+		jen.Return(jen.Qual(otelPackage, "GetTextMapPropagator").Call().Dot("Extract").Call(jen.Id("ctx"), jen.Id("carrier"))),
+	).Line()
+}
+
+// generateClientTraceContext emits the helper reading the trace context the
+// page exposes as the global 'agrowsTraceContext' object.
+func generateClientTraceContext() *jen.Statement {
+	return jen.Func().Id("agrowsTraceContext").Params().Map(jen.String()).String().Block(
+		jen.Id("value").Op(":=").Qual("syscall/js", "Global").Call().Dot("Get").Call(jen.Lit("agrowsTraceContext")),
+		jen.If(jen.Id("value").Dot("Type").Call().Op("!=").Qual("syscall/js", "TypeObject")).Block(
+			jen.Return(jen.Nil()),
+		),
+		jen.Id("keys").Op(":=").Qual("syscall/js", "Global").Call().Dot("Get").Call(jen.Lit("Object")).Dot("Call").Call(jen.Lit("keys"), jen.Id("value")),
+		jen.Id("carrier").Op(":=").Make(jen.Map(jen.String()).String(), jen.Id("keys").Dot("Length").Call()),
+		jen.For(jen.Id("i").Op(":=").Lit(0), jen.Id("i").Op("<").Id("keys").Dot("Length").Call(), jen.Id("i").Op("++")).Block(
+			jen.Id("key").Op(":=").Id("keys").Dot("Index").Call(jen.Id("i")).Dot("String").Call(),
+			jen.Id("carrier").Index(jen.Id("key")).Op("=").Id("value").Dot("Get").Call(jen.Id("key")).Dot("String").Call(),
+		),
+		jen.Return(jen.Id("carrier")),
+	).Line()
+}
+
+func (opts *Options) writeCombinedTreeAndGenerated(tree *dst.File, generated *jen.File, writer io.Writer, genType byte) (int, error) {
+	var filePrefix string
+	if genType == CLIENT {
+		tree.Name.Name = "main"
+	}
+	if opts.PackageName != "" {
+		tree.Name.Name = opts.PackageName
+	}
+	if opts.BuildTags != "" && genType != TESTHELPERS {
+		// the toolchain only takes the constraint from lines at column zero
+		// followed by a blank line, otherwise they are part of the header
+		// comment. The // +build lines repeat it for Go before 1.17.
+		filePrefix += "//go:build " + opts.BuildTags + "\n"
+		for _, line := range opts.plusBuild {
+			filePrefix += line + "\n"
+		}
+		filePrefix += "\n"
+	}
+
+	filePrefix += opts.generateHeader()
+	if opts.noLintAll() {
+		filePrefix += "//nolint:all\n"
+	}
+
+	fset := token.NewFileSet()
+	var builder strings.Builder
+	err := generated.Render(&builder)
+	if err != nil {
+		return 0, fmt.Errorf("failed to render generated code: %v", err)
+	}
+
+	src := builder.String()
+	parsedFile, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse rendered code: %v", err)
+	}
+
+	genDst, err := decorator.DecorateFile(fset, parsedFile)
+	if err != nil {
+		return 0, fmt.Errorf("failed to convert parsed file to dst.File: %v", err)
+	}
+	if len(opts.NoLint) > 0 {
+		opts.addNoLint(genDst)
+	}
+
+	sourceImportSpecs := make([]dst.Spec, 0)
+	lo.ForEach(tree.Decls, func(item dst.Decl, _ int) {
+		if genDecl, ok := item.(*dst.GenDecl); ok && genDecl.Tok == token.IMPORT {
+			sourceImportSpecs = append(sourceImportSpecs, genDecl.Specs...)
+		}
+	})
+
+	genImportSpecs := make([]dst.Spec, 0)
+	lo.ForEach(genDst.Decls, func(item dst.Decl, _ int) {
+		if genDecl, ok := item.(*dst.GenDecl); ok && genDecl.Tok == token.IMPORT {
+			genImportSpecs = append(genImportSpecs, genDecl.Specs...)
+		}
+	})
+
+	if err := opts.checkDeclarationCollisions(tree, genDst); err != nil {
+		return 0, err
+	}
+
+	declsWithoutImports := lo.Filter(append(tree.Decls, genDst.Decls...), func(x dst.Decl, _ int) bool {
+		if genDecl, ok := x.(*dst.GenDecl); ok {
+			return genDecl.Tok != token.IMPORT
+		}
+		return true
+	})
+
+	// the source imports only used by removed declarations would not compile,
+	// the others are needed by the types and helpers kept
+	rebuildImportSpec := dst.GenDecl{
+		Tok:    token.IMPORT,
+		Specs:  mergeImportSpecs(filterUnusedImports(tree.Decls, sourceImportSpecs), genImportSpecs),
+		Lparen: true,
+		Rparen: true,
+	}
+
+	rebuildCombinedDecls := declsWithoutImports
+	if len(rebuildImportSpec.Specs) > 0 {
+		rebuildCombinedDecls = append([]dst.Decl{&rebuildImportSpec}, declsWithoutImports...)
+	}
+
+	combined := &dst.File{
+		Name:  tree.Name,
+		Decls: rebuildCombinedDecls,
+	}
+
+	fset, file, err := decorator.RestoreFile(combined)
+	if err != nil {
+		return 0, fmt.Errorf("failed to restore dst.File: %v", err)
+	}
+
+	var buffer strings.Builder
+
+	buffer.WriteString(filePrefix)
+
+	if err := printer.Fprint(&buffer, fset, file); err != nil {
+		return 0, fmt.Errorf("failed to write to buffer: %v", err)
+	}
+
+	formattedFile, err := format.Source([]byte(buffer.String()))
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := writer.Write(formattedFile)
+	return n, err
+}
+
+// topLevelNames returns the names file declares at package level, with methods
+// as "Type.Method".
+func topLevelNames(file *dst.File) []string {
+	var names []string
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *dst.FuncDecl:
+			if d.Recv != nil {
+				names = append(names, receiverTypeName(d)+"."+d.Name.Name)
+			} else if d.Name.Name != "init" {
+				names = append(names, d.Name.Name)
+			}
+		case *dst.GenDecl:
+			for _, spec := range d.Specs {
+				switch spec := spec.(type) {
+				case *dst.TypeSpec:
+					names = append(names, spec.Name.Name)
+				case *dst.ValueSpec:
+					for _, name := range spec.Names {
+						names = append(names, name.Name)
+					}
+				}
+			}
+		}
+	}
+	return lo.Filter(names, func(name string, _ int) bool { return name != "_" })
+}
+
+// checkDeclarationCollisions returns an error listing the generated
+// declarations that the source already declares, which would not compile.
+// This includes source functions clashing with the renamed RPC functions, and
+// RPC functions named like the generated code of another one, e.g. the client
+// of a LoginWrapper next to the wrapper of Login.
+func (opts *Options) checkDeclarationCollisions(source, generated *dst.File) error {
+	declared := make(map[string]bool)
+	var collisions []string
+	renamed := false
+	renamedPrefix, renamedSuffix, _ := strings.Cut(opts.FunctionFormat, "%s")
+	for _, name := range topLevelNames(source) {
+		if declared[name] {
+			collisions = append(collisions, name)
+			if strings.HasPrefix(name, renamedPrefix) && strings.HasSuffix(name, renamedSuffix) {
+				renamed = true
+			}
+		}
+		declared[name] = true
+	}
+	wrappers := false
+	prefix, suffix, _ := strings.Cut(opts.WrapperFormat, "%s")
+	for _, name := range topLevelNames(generated) {
+		if !declared[name] {
+			declared[name] = true
+			continue
+		}
+		collisions = append(collisions, name)
+		if strings.HasPrefix(name, prefix) && strings.HasSuffix(name, suffix) {
+			wrappers = true
+		}
+	}
+	if len(collisions) == 0 {
+		return nil
+	}
+	hint := "rename them in the input"
+	if renamed {
+		hint += " or choose other names for the original functions with --function-format"
+	}
+	if wrappers {
+		hint += " or choose other wrapper names with --wrapper-format"
+	}
+	return fmt.Errorf("the input already declares %s, which the generated code declares too; %s", strings.Join(collisions, ", "), hint)
+}
+
+// mergeImportSpecs appends the generated imports to the source imports,
+// leaving out those the source already has under the same name.
+func mergeImportSpecs(source, generated []dst.Spec) []dst.Spec {
+	key := func(spec dst.Spec) string {
+		importSpec := spec.(*dst.ImportSpec)
+		return importName(importSpec) + " " + importSpec.Path.Value
+	}
+
+	seen := make(map[string]bool)
+	for _, spec := range source {
+		seen[key(spec)] = true
+	}
+	merged := source
+	for _, spec := range generated {
+		if !seen[key(spec)] {
+			merged = append(merged, spec)
+		}
+	}
+	return merged
+}
+
+const (
+	SERVER byte = iota + 1
+	CLIENT
+	GOCLIENT
+	MOCK
+	TESTHELPERS
+)
+
+var logLevels = map[string]string{
+	"debug": "LevelDebug",
+	"info":  "LevelInfo",
+	"warn":  "LevelWarn",
+	"error": "LevelError",
+}
+
+// generatedMarker is part of the header of every file agrows generates.
+const generatedMarker = "Code generated by agrows"
+
+// CreateOutputFile creates path for writing. An existing file is only replaced
+// if it was generated by agrows or force is set, so pointing the output at
+// the wrong file does not destroy it.
+func CreateOutputFile(path string, force bool) (*os.File, error) {
+	if !force {
+		if err := CheckOverwritable(path); err != nil {
+			return nil, err
+		}
+	}
+	return os.Create(path)
+}
+
+// CheckOverwritable returns an error if path exists and does not start with
+// the header of a generated file.
+func CheckOverwritable(path string) error {
+	generated, err := IsGenerated(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !generated {
+		return fmt.Errorf("%s exists and was not generated by agrows, use --force to overwrite it", path)
+	}
+	return nil
+}
+
+// IsGenerated reports whether the file at path starts with the header of a
+// file generated by agrows. The header may follow a long license text of
+// --header, so everything before the package clause is searched.
+func IsGenerated(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	head := make([]byte, 64*1024)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false, err
+	}
+	// the newline also finds a package clause on the first line, as written
+	// with --no-header
+	text := "\n" + string(head[:n])
+	if i := strings.Index(text, "\npackage "); i >= 0 {
+		text = text[:i]
+	}
+	return strings.Contains(text, generatedMarker), nil
+}
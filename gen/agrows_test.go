@@ -0,0 +1,1412 @@
+package gen
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dave/dst"
+	"github.com/dave/jennifer/jen"
+)
+
+// recoverSource has a function panicking with its reason.
+const recoverSource = `package api
+
+func Explode(reason string) {
+	panic(reason)
+}
+
+func Echo(text string) string {
+	return text
+}
+`
+
+// recoverTest checks that a panicking function fails its call with the panic
+// and a stack, and the receiver keeps serving the calls after it.
+const recoverTest = `package api
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/codeupdateandmodificationsystem/protocol"
+)
+
+func receive(t *testing.T, functionName string, args map[string]any) (string, error) {
+	t.Helper()
+	data, err := protocol.EncodeFunctionCall(functionName, protocol.Options(), args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return AgrowsReceive(data)
+}
+
+func TestRecover(t *testing.T) {
+	var logged []string
+	AgrowsLog = func(level string, msg string) {
+		logged = append(logged, level+": "+msg)
+	}
+
+	for i := 0; i < 2; i++ {
+		result, err := receive(t, "Explode", map[string]any{"reason": "boom"})
+		if result != "" || err == nil {
+			t.Fatalf("Explode returned %q, %v, want the panic as error", result, err)
+		}
+		message, stack, _ := strings.Cut(err.Error(), "\n")
+		if message != "panic in Explode: boom" {
+			t.Errorf("got error %q, want the panic of Explode", message)
+		}
+		if !strings.HasPrefix(stack, "goroutine ") || len(stack) > agrowsPanicStackLimit {
+			t.Errorf("got stack of %d bytes, want at most %d:\n%s", len(stack), agrowsPanicStackLimit, stack)
+		}
+
+		if result, err := receive(t, "Echo", map[string]any{"text": "alive"}); result != "alive" || err != nil {
+			t.Fatalf("Echo after the panic returned %q, %v", result, err)
+		}
+	}
+
+	if len(logged) != 2 || !strings.HasPrefix(logged[0], "error: Explode: panic in Explode: boom") {
+		t.Errorf("got log %q, want the panics as errors", logged)
+	}
+}
+`
+
+func TestRecover(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{"inline", Options{}},
+		// the function runs on its own goroutine, a panic there is not
+		// recovered by AgrowsReceive
+		{"timeout", Options{Timeout: time.Second}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := newTestModule(t)
+			writeFile(t, filepath.Join(dir, "server", "agrows_server_api.go"), generate(t, recoverSource, test.opts))
+			writeFile(t, filepath.Join(dir, "server", "agrows_server_api_test.go"), recoverTest)
+			mustRunGo(t, dir, nil, "test", "./server")
+		})
+	}
+}
+
+// tracingSource has a function failing for an empty name and one panicking.
+const tracingSource = `package api
+
+type checkError string
+
+func (e checkError) Error() string { return string(e) }
+
+func Check(name string) error {
+	if name == "" {
+		return checkError("empty name")
+	}
+	return nil
+}
+
+func Explode(reason string) {
+	panic(reason)
+}
+`
+
+// tracingTest records the spans of the calls with the in-memory exporter of the
+// SDK, continuing the trace context sent along with the first call.
+const tracingTest = `package api
+
+import (
+	"context"
+	"testing"
+
+	"github.com/codeupdateandmodificationsystem/protocol"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+const (
+	traceID = "0af7651916cd43dd8448eb211c80319c"
+	spanID  = "b7ad6b7169203331"
+)
+
+func receive(t *testing.T, functionName string, args map[string]any) (string, error) {
+	t.Helper()
+	data, err := protocol.EncodeFunctionCall(functionName, protocol.Options(), args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return AgrowsReceive(context.Background(), data)
+}
+
+func TestTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	traceContext := map[string]any{"traceparent": "00-" + traceID + "-" + spanID + "-01"}
+	if _, err := receive(t, "Check", map[string]any{"name": "ada", "agrowsTraceContext": traceContext}); err != nil {
+		t.Fatalf("Check(ada) failed: %v", err)
+	}
+	if _, err := receive(t, "Check", map[string]any{"name": ""}); err == nil {
+		t.Fatal("Check() did not fail")
+	}
+	if _, err := receive(t, "Explode", map[string]any{"reason": "boom"}); err == nil {
+		t.Fatal("Explode did not fail")
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans, want 3", len(spans))
+	}
+	for i, want := range []string{"Check", "Check", "Explode"} {
+		span := spans[i]
+		if span.Name != want {
+			t.Errorf("span %d is named %q, want %q", i, span.Name, want)
+		}
+		if !hasAttribute(span.Attributes, attribute.String("agrows.function", want)) {
+			t.Errorf("span %d has attributes %v, want agrows.function=%s", i, span.Attributes, want)
+		}
+	}
+
+	if got := spans[0].Parent; !got.IsRemote() || got.TraceID().String() != traceID || got.SpanID().String() != spanID {
+		t.Errorf("span 0 has parent %v, want the remote span %s of trace %s", got, spanID, traceID)
+	}
+	if spans[0].SpanContext.TraceID().String() != traceID {
+		t.Errorf("span 0 is in trace %s, want %s", spans[0].SpanContext.TraceID(), traceID)
+	}
+	if spans[0].Status.Code != codes.Unset || len(spans[0].Events) != 0 {
+		t.Errorf("span 0 has status %v and events %v, want neither", spans[0].Status, spans[0].Events)
+	}
+	if spans[1].Parent.IsValid() {
+		t.Errorf("span 1 has parent %v, want none without a trace context", spans[1].Parent)
+	}
+
+	if got := spans[1].Status; got.Code != codes.Error || got.Description != "empty name" {
+		t.Errorf("span 1 has status %v, want the error of Check()", got)
+	}
+	if got := spans[2].Status; got.Code != codes.Error || len(spans[2].Events) != 1 || spans[2].Events[0].Name != "exception" {
+		t.Errorf("span 2 has status %v and events %v, want the recorded panic", got, spans[2].Events)
+	}
+}
+
+func hasAttribute(attributes []attribute.KeyValue, want attribute.KeyValue) bool {
+	for _, kv := range attributes {
+		if kv == want {
+			return true
+		}
+	}
+	return false
+}
+`
+
+func TestTracing(t *testing.T) {
+	dir := newTestModule(t, "go.opentelemetry.io/otel", "go.opentelemetry.io/otel/sdk", "go.opentelemetry.io/otel/trace")
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api.go"), generate(t, tracingSource, Options{Trace: "otel"}))
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api_test.go"), tracingTest)
+	mustRunGo(t, dir, nil, "test", "./server")
+}
+
+// renameSource refers to its RPC functions from functions, methods and
+// variables, next to a method and a local of the same name.
+const renameSource = `package api
+
+import "strings"
+
+type Store struct{}
+
+// Save is a method, it keeps its name while calling the RPC function.
+func (s Store) Save(name string) string { return Save(name) + "!" }
+
+func (s Store) Load() string { return s.Save("stored") }
+
+var handler = Save
+
+func Save(name string) string { return strings.ToUpper(name) }
+
+func Count(text string) int {
+	Save := func(s string) string { return s + s }
+	return len(Save(text))
+}
+
+func twice(name string) string { return Save(Save(name)) }
+
+func Apply(name string) string {
+	fns := map[string]func(string) string{"save": Save, "twice": twice}
+	return fns["save"](name) + fns["twice"](name)
+}
+`
+
+// renameTest runs in the generated server of renameSource.
+const renameTest = `package api
+
+import "testing"
+
+func TestRename(t *testing.T) {
+	if got := (Store{}).Load(); got != "STORED!" {
+		t.Errorf("Store.Load returned %q", got)
+	}
+	if got := handler("a"); got != "A" {
+		t.Errorf("handler returned %q", got)
+	}
+	if got := agrows_Count("ab"); got != 4 {
+		t.Errorf("Count returned %d, the local Save was renamed", got)
+	}
+	if got := agrows_Apply("b"); got != "BB" {
+		t.Errorf("Apply returned %q", got)
+	}
+}
+`
+
+func TestRenameReferences(t *testing.T) {
+	server := string(generate(t, renameSource, Options{}))
+	for _, want := range []string{
+		"func (s Store) Save(name string) string { return agrows_Save(name) + \"!\" }",
+		"return s.Save(\"stored\")",
+		"var handler = agrows_Save",
+		"func agrows_Save(name string) string { return strings.ToUpper(name) }",
+		"Save := func(s string) string { return s + s }",
+		"return len(Save(text))",
+		"return agrows_Save(agrows_Save(name))",
+		"\"save\": agrows_Save, \"twice\": twice",
+	} {
+		if !strings.Contains(server, want) {
+			t.Errorf("the server does not contain %q:\n%s", want, server)
+		}
+	}
+
+	dir := newTestModule(t)
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api.go"), server)
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api_test.go"), renameTest)
+	mustRunGo(t, dir, nil, "test", "./server")
+}
+
+// unusedTypesSource has types used by the functions, through another type
+// and by a helper only, and one used by nothing.
+const unusedTypesSource = `package api
+
+type Address struct {
+	City string
+}
+
+type User struct {
+	Name string
+	Home Address
+}
+
+type Record struct {
+	ID int
+}
+
+type Unused struct{}
+
+func Save(user User) int {
+	return store(Record{ID: len(user.Name)})
+}
+
+func store(record Record) int {
+	return record.ID
+}
+`
+
+func TestClientUnusedTypes(t *testing.T) {
+	client := string(generate(t, unusedTypesSource, Options{Mode: CLIENT}))
+	for _, want := range []string{"type User struct", "type Address struct"} {
+		if !strings.Contains(client, want) {
+			t.Errorf("the client has no %s:\n%s", want, client)
+		}
+	}
+	for _, unwanted := range []string{"type Record", "type Unused"} {
+		if strings.Contains(client, unwanted) {
+			t.Errorf("the client still declares %s:\n%s", unwanted, client)
+		}
+	}
+	// the server keeps the types of the input
+	if server := string(generate(t, unusedTypesSource, Options{})); !strings.Contains(server, "type Unused struct{}") {
+		t.Errorf("the server dropped Unused:\n%s", server)
+	}
+}
+
+// errorOnlySource has a function only returning an error.
+const errorOnlySource = `package api
+
+type idError int
+
+func (e idError) Error() string { return "invalid id" }
+
+func Delete(id int) error {
+	if id < 0 {
+		return idError(id)
+	}
+	return nil
+}
+`
+
+// errorOnlyTest checks that Delete replies with an empty string on success.
+const errorOnlyTest = `package api
+
+import (
+	"testing"
+
+	"github.com/codeupdateandmodificationsystem/protocol"
+)
+
+func TestDelete(t *testing.T) {
+	data, err := protocol.EncodeFunctionCall("Delete", protocol.Options(), map[string]any{"id": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := AgrowsReceive(data); result != "" || err != nil {
+		t.Fatalf("Delete(1) returned %q, %v, want an empty result", result, err)
+	}
+
+	data, err = protocol.EncodeFunctionCall("Delete", protocol.Options(), map[string]any{"id": -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := AgrowsReceive(data); result != "" || err == nil || err.Error() != "invalid id" {
+		t.Errorf("Delete(-1) returned %q, %v", result, err)
+	}
+}
+`
+
+func TestErrorOnlyResult(t *testing.T) {
+	dir := newTestModule(t)
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api.go"), generate(t, errorOnlySource, Options{}))
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api_test.go"), errorOnlyTest)
+	mustRunGo(t, dir, nil, "test", "./server")
+}
+
+// numbersSource has parameters of numeric types other than float64, which
+// JavaScript numbers are.
+const numbersSource = `package api
+
+type Level int
+
+func Double(n int64) int64 {
+	return 2 * n
+}
+
+func Half(x float32) float32 {
+	return x / 2
+}
+
+func Label(n uint8, name string) string {
+	return name
+}
+
+func SetLevel(level Level) Level {
+	return level
+}
+`
+
+// numbersTest runs in the generated client of numbersSource, converting
+// JavaScript numbers to the parameter types.
+const numbersTest = `//go:build js && wasm && client
+
+package main
+
+import (
+	"reflect"
+	"syscall/js"
+	"testing"
+)
+
+func TestNumbers(t *testing.T) {
+	tests := []struct {
+		value  any
+		target any
+	}{
+		{3, int64(3)},
+		{0.5, float32(0.5)},
+		{7, uint8(7)},
+		{2, Level(2)},
+		{1 << 40, int64(1 << 40)},
+	}
+	for _, test := range tests {
+		got, err := jsValueToAny(js.ValueOf(test.value), reflect.TypeOf(test.target))
+		if err != nil || got != test.target {
+			t.Errorf("%v as %T: got %#v, %v, want %#v", test.value, test.target, got, err, test.target)
+		}
+	}
+
+	failing := []struct {
+		value  any
+		target any
+	}{
+		{1.5, int64(0)},
+		{256, uint8(0)},
+		{-1, uint(0)},
+		{1e300, float32(0)},
+	}
+	for _, test := range failing {
+		if got, err := jsValueToAny(js.ValueOf(test.value), reflect.TypeOf(test.target)); err == nil {
+			t.Errorf("%v as %T: got %#v, want an error", test.value, test.target, got)
+		}
+	}
+}
+`
+
+func TestNumericArguments(t *testing.T) {
+	dir := newTestModule(t)
+	writeFile(t, filepath.Join(dir, "client", "agrows_client_api.go"), generate(t, numbersSource, Options{Mode: CLIENT}))
+	writeFile(t, filepath.Join(dir, "client", "agrows_client_api_test.go"), numbersTest)
+	mustRunGo(t, dir, wasmEnv(t, dir), "test", "-tags", "client", "./client")
+}
+
+// sliceSource takes a slice of structs.
+const sliceSource = `package api
+
+type User struct {
+	Name string
+	Age  int
+}
+
+type importError string
+
+func (e importError) Error() string { return string(e) }
+
+func Import(users []User) (string, error) {
+	if len(users) != 2 {
+		return "", importError("expected 2 users")
+	}
+	return "imported " + users[0].Name + " and " + users[1].Name, nil
+}
+`
+
+// sliceServerTest calls Import in the generated server of sliceSource with
+// the two users it expects.
+const sliceServerTest = `package api
+
+import (
+	"testing"
+
+	"github.com/codeupdateandmodificationsystem/protocol"
+)
+
+func TestImport(t *testing.T) {
+	users := []map[string]any{{"Name": "Ada", "Age": 36}, {"Name": "Alan", "Age": 41}}
+	data, err := protocol.EncodeFunctionCall("Import", protocol.Options(), map[string]any{"users": users})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := AgrowsReceive(data)
+	if err != nil || result != "imported Ada and Alan" {
+		t.Errorf("Import returned %s, %v, want both users imported", result, err)
+	}
+}
+`
+
+// sliceClientTest runs in the generated client of sliceSource, converting a
+// JavaScript array to the slice.
+const sliceClientTest = `//go:build js && wasm && client
+
+package main
+
+import (
+	"reflect"
+	"syscall/js"
+	"testing"
+)
+
+func TestImport(t *testing.T) {
+	value := js.Global().Get("JSON").Call("parse", ` + "`" + `[{"Name": "Ada", "Age": 36}, {"Name": "Alan", "Age": 41}]` + "`" + `)
+	users, err := jsValueToAny(value, reflect.TypeOf([]User(nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []User{{Name: "Ada", Age: 36}, {Name: "Alan", Age: 41}}; !reflect.DeepEqual(users, want) {
+		t.Errorf("got %#v, want %#v", users, want)
+	}
+}
+`
+
+func TestSliceParameter(t *testing.T) {
+	dir := newTestModule(t)
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api.go"), generate(t, sliceSource, Options{}))
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api_test.go"), sliceServerTest)
+	writeFile(t, filepath.Join(dir, "client", "agrows_client_api.go"), generate(t, sliceSource, Options{Mode: CLIENT}))
+	writeFile(t, filepath.Join(dir, "client", "agrows_client_api_test.go"), sliceClientTest)
+	mustRunGo(t, dir, nil, "test", "./server")
+	mustRunGo(t, dir, wasmEnv(t, dir), "test", "-tags", "client", "./client")
+}
+
+// mapsSource takes a map of structs with nested structs, maps and slices.
+const mapsSource = `package api
+
+type Limits struct {
+	Max   int
+	Ratio float64
+}
+
+type Settings struct {
+	Enabled   bool
+	Limits    Limits
+	Overrides map[string]Limits
+	Tags      []string
+}
+
+var configured map[string]Settings
+
+func Configure(settings map[string]Settings) error {
+	configured = settings
+	return nil
+}
+`
+
+// mapsValue is the JSON of a map[string]Settings of mapsSource, as a client
+// sends it, and mapsWant the value it decodes into.
+const (
+	mapsValue = `{"db": {"Enabled": true, "Limits": {"Max": 3, "Ratio": 0.5}, "Overrides": {"night": {"Max": 1, "Ratio": 0.25}}, "Tags": ["a", "b"]}, "cache": {"Limits": {"Max": 10}}}`
+	mapsWant  = `map[string]Settings{
+		"db":    {Enabled: true, Limits: Limits{Max: 3, Ratio: 0.5}, Overrides: map[string]Limits{"night": {Max: 1, Ratio: 0.25}}, Tags: []string{"a", "b"}},
+		"cache": {Limits: Limits{Max: 10}},
+	}`
+)
+
+// mapsServerTest runs in the generated server of mapsSource.
+const mapsServerTest = `package api
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/codeupdateandmodificationsystem/protocol"
+)
+
+func TestConfigure(t *testing.T) {
+	var settings any
+	if err := json.Unmarshal([]byte(` + "`" + mapsValue + "`" + `), &settings); err != nil {
+		t.Fatal(err)
+	}
+	data, err := protocol.EncodeFunctionCall("Configure", protocol.Options(), map[string]any{"settings": settings})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AgrowsReceive(data); err != nil {
+		t.Fatal(err)
+	}
+	if want := ` + mapsWant + `; !reflect.DeepEqual(configured, want) {
+		t.Errorf("got %#v, want %#v", configured, want)
+	}
+
+	data, err = protocol.EncodeFunctionCall("Configure", protocol.Options(), map[string]any{"settings": map[string]any{"db": "on"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AgrowsReceive(data); err == nil {
+		t.Error("a string was accepted as Settings")
+	}
+}
+`
+
+// mapsClientTest runs in the generated client of mapsSource, converting a
+// JavaScript object to the map.
+const mapsClientTest = `//go:build js && wasm && client
+
+package main
+
+import (
+	"reflect"
+	"syscall/js"
+	"testing"
+)
+
+func TestConfigure(t *testing.T) {
+	value := js.Global().Get("JSON").Call("parse", ` + "`" + mapsValue + "`" + `)
+	settings, err := jsValueToAny(value, reflect.TypeOf(map[string]Settings(nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := ` + mapsWant + `; !reflect.DeepEqual(settings, want) {
+		t.Errorf("got %#v, want %#v", settings, want)
+	}
+}
+`
+
+func TestMapOfStructs(t *testing.T) {
+	dir := newTestModule(t)
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api.go"), generate(t, mapsSource, Options{}))
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api_test.go"), mapsServerTest)
+	writeFile(t, filepath.Join(dir, "client", "agrows_client_api.go"), generate(t, mapsSource, Options{Mode: CLIENT}))
+	writeFile(t, filepath.Join(dir, "client", "agrows_client_api_test.go"), mapsClientTest)
+	mustRunGo(t, dir, nil, "test", "./server")
+	mustRunGo(t, dir, wasmEnv(t, dir), "test", "-tags", "client", "./client")
+}
+
+// reservedSource returns an input whose function Echo has a parameter for each
+// of reservedIdentifiers and one named like the function, all of them strings
+// but ok. Echo returns the strings joined with "|", along with the names.
+func reservedSource() (string, []string) {
+	names := []string{"Echo"}
+	for name := range reservedIdentifiers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var params, values []string
+	for _, name := range names {
+		if name == "ok" {
+			params = append(params, "ok bool")
+			continue
+		}
+		params = append(params, name+" string")
+		values = append(values, name)
+	}
+	src := fmt.Sprintf("package api\n\nfunc Echo(%s) string {\n\tif !ok {\n\t\treturn \"\"\n\t}\n\treturn %s\n}\n",
+		strings.Join(params, ", "), strings.Join(values, ` + "|" + `))
+	return src, names
+}
+
+// reservedServerTest runs in the generated server of reservedSource, calling
+// Echo with every argument set to its name.
+const reservedServerTest = `package api
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/codeupdateandmodificationsystem/protocol"
+)
+
+var names = strings.Fields(%q)
+
+func TestReserved(t *testing.T) {
+	args := map[string]any{}
+	var want []string
+	for _, name := range names {
+		if name == "ok" {
+			args[name] = true
+			continue
+		}
+		args[name] = name
+		want = append(want, name)
+	}
+	data, err := protocol.EncodeFunctionCall("Echo", protocol.Options(), args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := AgrowsReceive(data); err != nil || result != strings.Join(want, "|") {
+		t.Errorf("Echo returned %%q, %%v", result, err)
+	}
+}
+`
+
+// reservedClientTest runs in the generated client of reservedSource, checking
+// that the wrapper sends every argument under its own name.
+const reservedClientTest = `//go:build js && wasm && client
+
+package main
+
+import (
+	"strings"
+	"syscall/js"
+	"testing"
+
+	"github.com/codeupdateandmodificationsystem/protocol"
+)
+
+var names = strings.Fields(%q)
+
+func TestReserved(t *testing.T) {
+	var sent []byte
+	js.Global().Set("sendMessage", js.FuncOf(func(this js.Value, p []js.Value) any {
+		sent = make([]byte, p[0].Length())
+		js.CopyBytesToGo(sent, p[0])
+		return nil
+	}))
+	var values []js.Value
+	for _, name := range names {
+		if name == "ok" {
+			values = append(values, js.ValueOf(true))
+			continue
+		}
+		values = append(values, js.ValueOf(name))
+	}
+	EchoWrapper(js.Null(), values)
+
+	functionName, args, err := protocol.DecodeFunctionCall(sent, protocol.Options())
+	if err != nil || functionName != "Echo" {
+		t.Fatalf("sent %%q, %%v", functionName, err)
+	}
+	for _, name := range names {
+		want := any(name)
+		if name == "ok" {
+			want = true
+		}
+		if args[name].Value != want {
+			t.Errorf("argument %%s is %%#v, want %%#v", name, args[name].Value, want)
+		}
+	}
+}
+`
+
+func TestReservedIdentifiers(t *testing.T) {
+	src, names := reservedSource()
+	dir := newTestModule(t, "github.com/gorilla/websocket")
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api.go"), generate(t, src, Options{}))
+	writeFile(t, filepath.Join(dir, "server", "agrows_test_helpers_test.go"), generate(t, src, Options{Mode: TESTHELPERS}))
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api_test.go"), fmt.Sprintf(reservedServerTest, strings.Join(names, " ")))
+	writeFile(t, filepath.Join(dir, "client", "agrows_client_api.go"), generate(t, src, Options{Mode: CLIENT}))
+	writeFile(t, filepath.Join(dir, "client", "agrows_client_api_test.go"), fmt.Sprintf(reservedClientTest, strings.Join(names, " ")))
+	for mode, opts := range map[string]Options{
+		"goclient":    {Mode: GOCLIENT},
+		"mock":        {Mode: MOCK},
+		"server-http": {HTTP: true},
+		"server-ws":   {WebSocket: true},
+	} {
+		writeFile(t, filepath.Join(dir, mode, "agrows_api.go"), generate(t, src, opts))
+	}
+	mustRunGo(t, dir, nil, "vet", "./server", "./goclient", "./mock", "./server-http", "./server-ws")
+	mustRunGo(t, dir, nil, "test", "./server")
+	mustRunGo(t, dir, wasmEnv(t, dir), "test", "-tags", "client", "./client")
+}
+
+func TestExtractTimeoutDirective(t *testing.T) {
+	tests := []struct {
+		doc     []string
+		timeout time.Duration
+		err     bool
+	}{
+		{nil, 0, false},
+		{[]string{"// Slow takes a while.", "//agrows:timeout 5s"}, 5 * time.Second, false},
+		{[]string{"//agrows:timeout=250ms"}, 250 * time.Millisecond, false},
+		{[]string{"//agrows:timeout= 1m"}, time.Minute, false},
+		{[]string{"//agrows:timeouts 5s"}, 0, false},
+		{[]string{"//agrows:timeout=soon"}, 0, true},
+	}
+	for _, test := range tests {
+		timeout, err := extractTimeoutDirective(test.doc)
+		if (err != nil) != test.err || timeout != test.timeout {
+			t.Errorf("%q: got %v, %v, want %v", test.doc, timeout, err, test.timeout)
+		}
+	}
+}
+
+// collisionSource declares a helper of the generated server and an RPC
+// function named like a helper of the generated client.
+const collisionSource = `package api
+
+func Login(name string) string {
+	return name
+}
+
+func AgrowsReceive(data []byte) {}
+
+func agrowsRecover() {}
+`
+
+func TestDeclarationCollisions(t *testing.T) {
+	tests := []struct {
+		mode byte
+		want string
+	}{
+		{SERVER, "the input already declares agrowsRecover, which the generated code declares too; rename them in the input"},
+		{CLIENT, "the input already declares AgrowsReceive, which the generated code declares too; rename them in the input"},
+	}
+	for _, test := range tests {
+		err := Generate(strings.NewReader(collisionSource), Options{Mode: test.mode, FileName: "api.go"}, &bytes.Buffer{})
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("mode %d: got error %v, want one containing %q", test.mode, err, test.want)
+		}
+	}
+}
+
+func TestNameCollisions(t *testing.T) {
+	login := "package api\n\nfunc Login(name string) string {\n\treturn name\n}\n"
+	tests := []struct {
+		doc  string
+		src  string
+		opts Options
+		want string
+	}{
+		{"server function", login + "\nfunc agrows_Login() {}\n", Options{}, "duplicate name agrows_Login: function agrows_Login at api.go:7 and server function agrows_Login generated for Login at api.go:3"},
+		{"wrapper", login + "\nfunc LoginWrapper() {}\n", Options{}, "duplicate name LoginWrapper: function LoginWrapper at api.go:7 and wrapper LoginWrapper generated for Login at api.go:3"},
+		{"request type", login + "\ntype loginRequest struct{}\n", Options{}, "duplicate name loginRequest: type loginRequest at api.go:7 and request type loginRequest generated for Login at api.go:3"},
+		{"function format", login + "\nfunc rpcLogin() {}\n", Options{FunctionFormat: "rpc%s"}, "duplicate name rpcLogin: function rpcLogin at api.go:7"},
+		{"receiver name", login + "\ntype ReceiveAPI struct{}\n", Options{ReceiverName: "ReceiveAPI"}, "duplicate name ReceiveAPI: type ReceiveAPI at api.go:7 and receiver ReceiveAPI at the generated server"},
+		{"receiver name constant", login + "\nconst AgrowsReceiverName = \"\"\n", Options{}, "duplicate name AgrowsReceiverName"},
+	}
+	for _, test := range tests {
+		test.opts.FileName = "api.go"
+		err := Generate(strings.NewReader(test.src), test.opts, &bytes.Buffer{})
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want one containing %q", test.doc, err, test.want)
+		}
+	}
+
+	// the server renames Agrows_Login too, which leaves its name to Login
+	generate(t, login+"\nfunc Agrows_Login() {}\n", Options{FunctionFormat: "Agrows_%s"})
+
+	// other wrapper names resolve the collision
+	generate(t, login+"\nfunc LoginWrapper() {}\n", Options{Mode: CLIENT, WrapperFormat: "%sJS"})
+}
+
+func TestReceiverName(t *testing.T) {
+	dir := newTestModule(t)
+	server := filepath.Join(dir, "server")
+	if err := os.Mkdir(server, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{FileName: "calls.go", ReceiverName: "ReceiveAPI", Harness: true, Benchmarks: true, TestStubs: true, OutputDir: server}
+	out := generate(t, callsSource, opts)
+	for _, want := range []string{"func ReceiveAPI(", `const AgrowsReceiverName = "ReceiveAPI"`} {
+		if !bytes.Contains(out, []byte(want)) {
+			t.Errorf("the server has no %s:\n%s", want, out)
+		}
+	}
+	if bytes.Contains(out, []byte("AgrowsReceive(")) {
+		t.Errorf("the server still calls AgrowsReceive:\n%s", out)
+	}
+	writeFile(t, filepath.Join(server, "agrows_server_calls.go"), out)
+	mustRunGo(t, dir, nil, "vet", "./server")
+	mustRunGo(t, dir, nil, "test", "-bench", ".", "-benchtime", "1x", "./server")
+}
+
+// sliceResultSource returns a nil, an empty and a filled slice depending on kind.
+const sliceResultSource = `package api
+
+type User struct {
+	Name string
+	Age  int
+}
+
+func Tags(kind string) []string {
+	switch kind {
+	case "nil":
+		return nil
+	case "empty":
+		return []string{}
+	}
+	return []string{"go", "wasm"}
+}
+
+func List() ([]User, error) {
+	return []User{{Name: "Ada", Age: 36}, {Name: "Alan", Age: 41}}, nil
+}
+`
+
+// sliceResultTest checks the JSON arrays the generated server of
+// sliceResultSource replies with.
+const sliceResultTest = `package api
+
+import (
+	"testing"
+
+	"github.com/codeupdateandmodificationsystem/protocol"
+)
+
+func receive(t *testing.T, functionName string, args map[string]any) string {
+	t.Helper()
+	data, err := protocol.EncodeFunctionCall(functionName, protocol.Options(), args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := AgrowsReceive(data)
+	if err != nil {
+		t.Fatalf("%s failed: %v", functionName, err)
+	}
+	return result
+}
+
+func TestSlices(t *testing.T) {
+	for kind, want := range map[string]string{"nil": "null", "empty": "[]", "filled": ` + "`" + `["go","wasm"]` + "`" + `} {
+		if got := receive(t, "Tags", map[string]any{"kind": kind}); got != want {
+			t.Errorf("Tags(%q) returned %s, want %s", kind, got, want)
+		}
+	}
+	want := ` + "`" + `[{"Name":"Ada","Age":36},{"Name":"Alan","Age":41}]` + "`" + `
+	if got := receive(t, "List", nil); got != want {
+		t.Errorf("List returned %s, want %s", got, want)
+	}
+}
+`
+
+// sliceResultClientTest checks that the generated client of sliceResultSource
+// resolves the Promise of Tags with an array.
+const sliceResultClientTest = `//go:build js && wasm && client
+
+package main
+
+import (
+	"syscall/js"
+	"testing"
+
+	"github.com/codeupdateandmodificationsystem/protocol"
+)
+
+func TestSlices(t *testing.T) {
+	var call string
+	js.Global().Set("sendMessage", js.FuncOf(func(this js.Value, p []js.Value) any {
+		data := make([]byte, p[0].Length())
+		js.CopyBytesToGo(data, p[0])
+		_, args, _ := protocol.DecodeFunctionCall(data, protocol.Options())
+		call = args["agrowsCall"].Value.(string)
+		return nil
+	}))
+	promise := TagsWrapper(js.Null(), []js.Value{js.ValueOf("filled")}).(js.Value)
+	data, err := protocol.EncodeFunctionCall("agrows_response", protocol.Options(), map[string]any{"call": call, "result": ` + "`" + `["go","wasm"]` + "`" + `})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if handled, err := AgrowsHandleMessage(data); !handled || err != nil {
+		t.Fatalf("got %t, %v for the response", handled, err)
+	}
+	done := make(chan js.Value)
+	resolve := js.FuncOf(func(this js.Value, p []js.Value) any {
+		done <- p[0]
+		return nil
+	})
+	defer resolve.Release()
+	promise.Call("then", resolve)
+	tags := <-done
+	if !js.Global().Get("Array").Call("isArray", tags).Bool() || tags.Length() != 2 || tags.Index(1).String() != "wasm" {
+		t.Errorf("Tags resolved to %v, want the array [go wasm]", tags)
+	}
+}
+`
+
+func TestSliceResults(t *testing.T) {
+	dir := newTestModule(t)
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api.go"), generate(t, sliceResultSource, Options{}))
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api_test.go"), sliceResultTest)
+	writeFile(t, filepath.Join(dir, "client", "agrows_client_api.go"), generate(t, sliceResultSource, Options{Mode: CLIENT}))
+	writeFile(t, filepath.Join(dir, "client", "agrows_client_api_test.go"), sliceResultClientTest)
+	mustRunGo(t, dir, nil, "test", "./server")
+	mustRunGo(t, dir, wasmEnv(t, dir), "test", "-tags", "client", "./client")
+}
+
+// importsSource has a type using time, kept by every output, and a function
+// using fmt, which only the server keeps.
+const importsSource = `package api
+
+import (
+	"fmt"
+	"time"
+)
+
+type Options struct {
+	Timeout time.Duration
+}
+
+func Describe(opts Options) string {
+	return fmt.Sprint(opts.Timeout)
+}
+`
+
+func TestSourceImports(t *testing.T) {
+	tests := []struct {
+		mode     byte
+		imported []string
+	}{
+		{SERVER, []string{`"fmt"`, `"time"`}},
+		{CLIENT, []string{`"time"`}},
+		{GOCLIENT, []string{`"time"`}},
+		{MOCK, []string{`"time"`}},
+	}
+	for _, test := range tests {
+		output := string(generate(t, importsSource, Options{Mode: test.mode}))
+		for _, want := range test.imported {
+			if strings.Count(output, want) != 1 {
+				t.Errorf("mode %d: want %s imported once:\n%s", test.mode, want, output)
+			}
+		}
+	}
+}
+
+// mapResultSource returns a nil, an empty and a filled map depending on kind.
+const mapResultSource = `package api
+
+type Limits struct {
+	Max   int
+	Ratio float64
+}
+
+func Counts(kind string) (map[string]int, error) {
+	switch kind {
+	case "nil":
+		return nil, nil
+	case "empty":
+		return map[string]int{}, nil
+	}
+	return map[string]int{"users": 2, "tags": 3}, nil
+}
+
+func AllLimits() map[string]Limits {
+	return map[string]Limits{"api": {Max: 10, Ratio: 0.5}}
+}
+`
+
+// mapResultTest decodes the JSON objects the generated server of
+// mapResultSource replies with.
+const mapResultTest = `package api
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/codeupdateandmodificationsystem/protocol"
+)
+
+func receive(t *testing.T, functionName string, args map[string]any) string {
+	t.Helper()
+	data, err := protocol.EncodeFunctionCall(functionName, protocol.Options(), args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := AgrowsReceive(data)
+	if err != nil {
+		t.Fatalf("%s failed: %v", functionName, err)
+	}
+	return result
+}
+
+func TestMaps(t *testing.T) {
+	var counts map[string]int
+	if err := json.Unmarshal([]byte(receive(t, "Counts", map[string]any{"kind": "filled"})), &counts); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int{"users": 2, "tags": 3}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("Counts returned %v, want %v", counts, want)
+	}
+	if got := receive(t, "Counts", map[string]any{"kind": "empty"}); got != "{}" {
+		t.Errorf("Counts returned %s for an empty map, want {}", got)
+	}
+	if got := receive(t, "Counts", map[string]any{"kind": "nil"}); got != "null" {
+		t.Errorf("Counts returned %s for a nil map, want null", got)
+	}
+
+	var limits map[string]Limits
+	if err := json.Unmarshal([]byte(receive(t, "AllLimits", nil)), &limits); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]Limits{"api": {Max: 10, Ratio: 0.5}}; !reflect.DeepEqual(limits, want) {
+		t.Errorf("AllLimits returned %v, want %v", limits, want)
+	}
+}
+`
+
+func TestMapResults(t *testing.T) {
+	dir := newTestModule(t)
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api.go"), generate(t, mapResultSource, Options{}))
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api_test.go"), mapResultTest)
+	mustRunGo(t, dir, nil, "test", "./server")
+}
+
+// mixSource has a function with primitive parameters only, assigned by the
+// fast path of agrowsAssign.
+const mixSource = `package api
+
+func Mix(name string, count int, ok bool) string {
+	return name
+}
+`
+
+// mixTest runs in the generated server of mixSource. It compares the fast
+// path with agrowsDecodeRequest, the reflection path it falls back to, and
+// benchmarks both.
+const mixTest = `package api
+
+import (
+	"testing"
+
+	"github.com/codeupdateandmodificationsystem/protocol"
+)
+
+var mixArgs = map[string]protocol.Argument{"name": {Value: "ada"}, "count": {Value: float64(3)}, "ok": {Value: true}}
+
+func TestPaths(t *testing.T) {
+	var fast, slow mixRequest
+	if !fast.agrowsAssign(mixArgs) {
+		t.Fatal("the fast path rejected the arguments")
+	}
+	if err := agrowsDecodeRequest(AgrowsFunc_Mix, mixArgs, &slow, "name", "count", "ok"); err != nil {
+		t.Fatal(err)
+	}
+	if fast != slow || fast != (mixRequest{Name: "ada", Count: 3, Ok: true}) {
+		t.Errorf("the fast path assigned %+v, the reflection path %+v", fast, slow)
+	}
+	for _, count := range []any{"3", 3.5, nil} {
+		args := map[string]protocol.Argument{"name": {Value: "ada"}, "count": {Value: count}, "ok": {Value: true}}
+		if new(mixRequest).agrowsAssign(args) {
+			t.Errorf("the fast path accepted count %#v", count)
+		}
+	}
+}
+
+func BenchmarkFastPath(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var request mixRequest
+		if !request.agrowsAssign(mixArgs) {
+			b.Fatal("the fast path rejected the arguments")
+		}
+	}
+}
+
+func BenchmarkReflectionPath(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var request mixRequest
+		if err := agrowsDecodeRequest(AgrowsFunc_Mix, mixArgs, &request, "name", "count", "ok"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+`
+
+func TestPrimitiveFastPath(t *testing.T) {
+	dir := newTestModule(t)
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api.go"), generate(t, mixSource, Options{}))
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api_test.go"), mixTest)
+	out := mustRunGo(t, dir, nil, "test", "-bench", ".", "-benchtime", "100x", "./server")
+	for _, benchmark := range []string{"BenchmarkFastPath", "BenchmarkReflectionPath"} {
+		if !strings.Contains(out, benchmark) {
+			t.Errorf("%s did not run:\n%s", benchmark, out)
+		}
+	}
+}
+
+// serverNumbersTest runs in the generated server of numbersSource, whose
+// arguments arrive as whatever numeric type the protocol decoded them as.
+const serverNumbersTest = `package api
+
+import (
+	"testing"
+
+	"github.com/codeupdateandmodificationsystem/protocol"
+)
+
+func TestNumbers(t *testing.T) {
+	tests := []struct {
+		fn      string
+		args    map[string]any
+		wantErr bool
+	}{
+		{"Double", map[string]any{"n": 1 << 40}, false},
+		{"Half", map[string]any{"x": 0.5}, false},
+		{"Label", map[string]any{"n": 7, "name": "x"}, false},
+		{"Double", map[string]any{"n": 1.5}, true},
+		{"Label", map[string]any{"n": 256, "name": "x"}, true},
+		{"Label", map[string]any{"n": -1, "name": "x"}, true},
+	}
+	for _, test := range tests {
+		data, err := protocol.EncodeFunctionCall(test.fn, protocol.Options(), test.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := AgrowsReceive(data); (err != nil) != test.wantErr {
+			t.Errorf("%s(%v): got error %v, want one: %t", test.fn, test.args, err, test.wantErr)
+		}
+	}
+}
+
+func TestConvertNumber(t *testing.T) {
+	var double doubleRequest
+	if !double.agrowsAssign(map[string]protocol.Argument{"n": {Value: float64(1 << 53)}}) || double.N != 1<<53 {
+		t.Errorf("a float64 argument was not assigned to an int64 parameter: %+v", double)
+	}
+	var half halfRequest
+	if !half.agrowsAssign(map[string]protocol.Argument{"x": {Value: int64(2)}}) || half.X != 2 {
+		t.Errorf("an int64 argument was not assigned to a float32 parameter: %+v", half)
+	}
+	if half.agrowsAssign(map[string]protocol.Argument{"x": {Value: 1e300}}) {
+		t.Errorf("a float64 overflowing float32 was assigned")
+	}
+	var label labelRequest
+	if label.agrowsAssign(map[string]protocol.Argument{"n": {Value: "7"}, "name": {Value: "x"}}) {
+		t.Errorf("a string argument was assigned to a uint8 parameter")
+	}
+}
+`
+
+func TestServerNumericArguments(t *testing.T) {
+	dir := newTestModule(t)
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api.go"), generate(t, numbersSource, Options{}))
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api_test.go"), serverNumbersTest)
+	mustRunGo(t, dir, nil, "test", "./server")
+}
+
+// pointerSource returns a nil pointer for unknown IDs.
+const pointerSource = `package api
+
+type User struct {
+	Name string
+	Age  int
+}
+
+func Find(id int) (*User, error) {
+	if id != 1 {
+		return nil, nil
+	}
+	return &User{Name: "Ada", Age: 36}, nil
+}
+`
+
+// pointerTest runs in the generated server of pointerSource.
+const pointerTest = `package api
+
+import (
+	"testing"
+
+	"github.com/codeupdateandmodificationsystem/protocol"
+)
+
+func TestFind(t *testing.T) {
+	for id, want := range map[int]string{1: ` + "`" + `{"Name":"Ada","Age":36}` + "`" + `, 2: "null"} {
+		data, err := protocol.EncodeFunctionCall("Find", protocol.Options(), map[string]any{"id": id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result, err := AgrowsReceive(data); result != want || err != nil {
+			t.Errorf("Find(%d) returned %s, %v, want %s", id, result, err, want)
+		}
+	}
+}
+`
+
+func TestPointerResult(t *testing.T) {
+	dir := newTestModule(t)
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api.go"), generate(t, pointerSource, Options{}))
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api_test.go"), pointerTest)
+	mustRunGo(t, dir, nil, "test", "./server")
+}
+
+// clientMainTest runs main of the generated client twice, as environments
+// recycling the Go runtime do.
+const clientMainTest = `//go:build js && wasm && client
+
+package main
+
+import (
+	"strings"
+	"sync"
+	"syscall/js"
+	"testing"
+	"time"
+)
+
+func TestMainTwice(t *testing.T) {
+	var mu sync.Mutex
+	registered := 0
+	AgrowsLog = func(level string, msg string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if strings.HasSuffix(msg, "function registered") {
+			registered++
+		}
+	}
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return registered
+	}
+
+	go main()
+	for deadline := time.Now().Add(5 * time.Second); count() < 3; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("main registered %d functions, want 3", count())
+		}
+	}
+	greet := js.Global().Get("Greet")
+	if greet.Type() != js.TypeFunction {
+		t.Fatalf("Greet is %s, want a function", greet.Type())
+	}
+
+	go main()
+	time.Sleep(50 * time.Millisecond)
+	if got := count(); got != 3 {
+		t.Errorf("the second main registered the functions again, %d registrations", got)
+	}
+	if !js.Global().Get("Greet").Equal(greet) {
+		t.Error("the second main replaced Greet")
+	}
+}
+`
+
+func TestClientMainTwice(t *testing.T) {
+	dir := newTestModule(t)
+	writeFile(t, filepath.Join(dir, "client", "agrows_client_api.go"), generate(t, apiSource, Options{Mode: CLIENT}))
+	writeFile(t, filepath.Join(dir, "client", "agrows_client_api_test.go"), clientMainTest)
+	mustRunGo(t, dir, wasmEnv(t, dir), "test", "-tags", "client", "./client")
+}
+
+func TestUnsupportedTypes(t *testing.T) {
+	tests := []struct {
+		name string
+		decl string
+		want string
+	}{
+		{"complex128 parameter", "func F(z complex128) {}", "parameter z at api.go:3 has unsupported type complex128: complex numbers can not be encoded as JSON"},
+		{"complex64 result", "func F() (complex64, error) { return 0, nil }", "result at api.go:3 has unsupported type complex64: complex numbers"},
+		{"complex element", "func F(zs []complex128) {}", "has unsupported type []complex128: complex numbers"},
+		{"channel", "func F(ch chan int) {}", "has unsupported type chan int: channels can not be sent"},
+		{"channel element", "func F(chs map[string]chan int) {}", "has unsupported type map[string]chan int: channels can not be sent"},
+		{"function", "func F(fn func() int, x int) {}", "has unsupported type func(...): functions can not be sent"},
+		{"named complex", "type Phase complex128\n\nfunc F(p Phase) {}", "has unsupported type Phase: it is declared as complex128, complex numbers"},
+		{"named function", "type Handler func(string)\n\nfunc F() (Handler, error) { return nil, nil }", "has unsupported type Handler: it is declared as func(...), functions can not be sent"},
+		{"named channel", "type Events chan string\n\nfunc F(events []Events) {}", "has unsupported type []Events: it is declared as chan string, channels"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			src := "package api\n\n" + test.decl + "\n"
+			err := Generate(strings.NewReader(src), Options{FileName: "api.go"}, &bytes.Buffer{})
+			if err == nil || !strings.Contains(err.Error(), "function F ") || !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("got error %v, want one containing %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestSupportedNamedTypes(t *testing.T) {
+	src := `package api
+
+type ID int
+
+type Tags []string
+
+type User struct {
+	Name string
+}
+
+func F(id ID, tags Tags, user User, ratio float64) (string, error) {
+	return "", nil
+}
+`
+	generate(t, src, Options{})
+}
+
+func TestTypeCode(t *testing.T) {
+	tests := []struct {
+		typ     string
+		imports map[string]string
+		want    string
+	}{
+		{"int", nil, "int"},
+		{"*User", nil, "*User"},
+		{"[]map[string][4]byte", nil, "[]map[string][4]byte"},
+		{"[Size]byte", nil, "[Size]byte"},
+		{"func(a, b int, rest ...string) (User, error)", nil, "func(int, int, ...string) (User, error)"},
+		{"t.Duration", nil, "t.Duration"},
+		{"map[string]t.Duration", map[string]string{"t": "time"}, "map[string]time.Duration"},
+	}
+	for _, test := range tests {
+		src := "package api\n\nvar x " + test.typ + "\n"
+		tree, _, err := parseFileToTree(strings.NewReader(src), "api.go")
+		if err != nil {
+			t.Fatal(err)
+		}
+		typ := tree.Decls[0].(*dst.GenDecl).Specs[0].(*dst.ValueSpec).Type
+		if got := jen.Var().Id("x").Add(typeCode(typ, test.imports)).GoString(); got != "var x "+test.want {
+			t.Errorf("%s: got %q, want %q", test.typ, got, "var x "+test.want)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package gen
+
+import (
+	"github.com/dave/jennifer/jen"
+)
+
+// generateAsyncReceiver emits AgrowsReceiveAsync and AgrowsReceiveAsyncOrdered,
+// which hand calls to a lazily started pool of AgrowsWorkers goroutines, and
+// AgrowsShutdown, which drains the pool. With --shutdown, AgrowsShutdown is
+// generated by generateShutdownFunc and the pool is drained by
+// agrowsShutdownPool instead.
+//
+// Every worker reads the shared queue and its own queue. Ordered calls are
+// routed to a worker by their key, so calls with the same key run one after
+// another in the order they were submitted.
+func (opts *Options) generateAsyncReceiver() *jen.Statement {
+	contextParam := func(g *jen.Group) {
+		if opts.Trace == "otel" {
+			g.Id("ctx").Qual("context", "Context")
+		}
+	}
+	extraParams := func(g *jen.Group) {
+		for _, name := range opts.receiveParams() {
+			g.Id(name).String()
+		}
+	}
+	replyType := jen.Func().Params(jen.Index().Byte(), jen.Error())
+
+	code := jen.Comment("AgrowsWorkers is the number of goroutines AgrowsReceiveAsync dispatches calls on.").Line().
+		Const().Id("AgrowsWorkers").Op("=").Lit(opts.AsyncWorkers).Line().Line()
+
+	code.Type().Id("agrowsJob").StructFunc(func(g *jen.Group) {
+		if opts.Trace == "otel" {
+			g.Id("ctx").Qual("context", "Context")
+		}
+		g.Id("data").Index().Byte()
+		extraParams(g)
+		g.Id("reply").Add(replyType.Clone())
+	}).Line().Line()
+
+	code.Var().Defs(
+		jen.Id("agrowsPoolOnce").Qual("sync", "Once"),
+		jen.Id("agrowsPoolMutex").Qual("sync", "RWMutex"),
+		jen.Id("agrowsPoolClosed").Bool(),
+		jen.Id("agrowsPoolWait").Qual("sync", "WaitGroup"),
+		jen.Id("agrowsQueue").Chan().Id("agrowsJob"),
+		jen.Id("agrowsWorkerQueues").Index().Chan().Id("agrowsJob"),
+	).Line().Line()
+
+	code.Commentf("AgrowsReceiveAsync dispatches data like %s on the next free worker and", opts.ReceiverName).Line().
+		Comment("passes the result to reply. Calls may complete in any order, use").Line().
+		Comment("AgrowsReceiveAsyncOrdered if they must not. It blocks while all workers are busy.").Line().
+		Func().Id("AgrowsReceiveAsync").ParamsFunc(func(g *jen.Group) {
+		contextParam(g)
+		g.Id("data").Index().Byte()
+		extraParams(g)
+		g.Id("reply").Add(replyType.Clone())
+	}).Block(
+		jen.Id("agrowsSubmit").Call(jen.Nil(), opts.generateAsyncJob()),
+	).Line().Line()
+
+	code.Comment("AgrowsReceiveAsyncOrdered is like AgrowsReceiveAsync, but calls sharing a key are").Line().
+		Comment("dispatched one at a time in the order they were submitted.").Line().
+		Func().Id("AgrowsReceiveAsyncOrdered").ParamsFunc(func(g *jen.Group) {
+		contextParam(g)
+		g.Id("key").String()
+		g.Id("data").Index().Byte()
+		extraParams(g)
+		g.Id("reply").Add(replyType.Clone())
+	}).Block(
+		jen.Id("hash").Op(":=").Qual("hash/fnv", "New32a").Call(),
+		jen.Id("hash").Dot("Write").Call(jen.Index().Byte().Call(jen.Id("key"))),
+		jen.Id("worker").Op(":=").Int().Call(jen.Id("hash").Dot("Sum32").Call().Op("%").Id("AgrowsWorkers")),
+		jen.Id("agrowsSubmit").Call(jen.Op("&").Id("worker"), opts.generateAsyncJob()),
+	).Line().Line()
+
+	code.Func().Id("agrowsSubmit").Params(jen.Id("worker").Op("*").Int(), jen.Id("job").Id("agrowsJob")).Block(
+		jen.Id("agrowsPoolMutex").Dot("RLock").Call(),
+		jen.Defer().Id("agrowsPoolMutex").Dot("RUnlock").Call(),
+		jen.If(jen.Id("agrowsPoolClosed")).Block(
+			jen.Id("agrowsReply").Call(jen.Id("job"), jen.Lit(""), jen.Qual("fmt", "Errorf").Call(jen.Lit("agrows: worker pool is shut down"))),
+			jen.Return(),
+		),
+		jen.Id("agrowsPoolOnce").Dot("Do").Call(jen.Id("agrowsStartPool")),
+		jen.If(jen.Id("worker").Op("==").Nil()).Block(
+			jen.Id("agrowsQueue").Op("<-").Id("job"),
+		).Else().Block(
+			jen.Id("agrowsWorkerQueues").Index(jen.Op("*").Id("worker")).Op("<-").Id("job"),
+		),
+	).Line().Line()
+
+	code.Func().Id("agrowsStartPool").Params().Block(
+		jen.Id("agrowsQueue").Op("=").Make(jen.Chan().Id("agrowsJob")),
+		jen.Id("agrowsWorkerQueues").Op("=").Make(jen.Index().Chan().Id("agrowsJob"), jen.Id("AgrowsWorkers")),
+		jen.For(jen.Id("i").Op(":=").Range().Id("agrowsWorkerQueues")).Block(
+			jen.Id("agrowsWorkerQueues").Index(jen.Id("i")).Op("=").Make(jen.Chan().Id("agrowsJob")),
+			jen.Id("agrowsPoolWait").Dot("Add").Call(jen.Lit(1)),
+			jen.Go().Id("agrowsWorker").Call(jen.Id("agrowsWorkerQueues").Index(jen.Id("i"))),
+		),
+	).Line().Line()
+
+	code.Func().Id("agrowsWorker").Params(jen.Id("own").Chan().Id("agrowsJob")).Block(
+		jen.Defer().Id("agrowsPoolWait").Dot("Done").Call(),
+		jen.Id("shared").Op(":=").Id("agrowsQueue"),
+		jen.For(jen.Id("shared").Op("!=").Nil().Op("||").Id("own").Op("!=").Nil()).Block(
+			jen.Select().Block(
+				jen.Case(jen.List(jen.Id("job"), jen.Id("ok")).Op(":=").Op("<-").Id("shared")).Block(
+					jen.If(jen.Op("!").Id("ok")).Block(
+						jen.Id("shared").Op("=").Nil(),
+						jen.Continue(),
+					),
+					jen.Id("agrowsRunJob").Call(jen.Id("job")),
+				),
+				jen.Case(jen.List(jen.Id("job"), jen.Id("ok")).Op(":=").Op("<-").Id("own")).Block(
+					jen.If(jen.Op("!").Id("ok")).Block(
+						jen.Id("own").Op("=").Nil(),
+						jen.Continue(),
+					),
+					jen.Id("agrowsRunJob").Call(jen.Id("job")),
+				),
+			),
+		),
+	).Line().Line()
+
+	code.Func().Id("agrowsRunJob").Params(jen.Id("job").Id("agrowsJob")).BlockFunc(func(g *jen.Group) {
+		if opts.Trace == "otel" {
+			g.Id("ctx").Op(":=").Id("job").Dot("ctx")
+		}
+		for _, name := range opts.receiveParams() {
+			g.Id(name).Op(":=").Id("job").Dot(name)
+		}
+		g.List(jen.Id("result"), jen.Err()).Op(":=").Add(opts.generateReceiveCall(jen.Id("job").Dot("data")))
+		g.Id("agrowsReply").Call(jen.Id("job"), jen.Id("result"), jen.Err())
+	}).Line().Line()
+
+	code.Comment("agrowsReply passes a result to the reply of job. A panicking reply is reported on").Line().
+		Comment("stderr instead of taking down the worker running it.").Line().
+		Func().Id("agrowsReply").Params(jen.Id("job").Id("agrowsJob"), jen.Id("result").String(), jen.Err().Error()).Block(
+		jen.If(jen.Id("job").Dot("reply").Op("==").Nil()).Block(
+			jen.Return(),
+		),
+		jen.Defer().Func().Params().Block(
+			jen.If(jen.Id("r").Op(":=").Recover(), jen.Id("r").Op("!=").Nil()).Block(
+				jen.Qual("fmt", "Fprintf").Call(jen.Qual("os", "Stderr"), jen.Lit("agrows: panic in reply: %v\n"), jen.Id("r")),
+			),
+		).Call(),
+		jen.Id("job").Dot("reply").Call(jen.Index().Byte().Call(jen.Id("result")), jen.Err()),
+	).Line().Line()
+
+	shutdown := jen.Comment("AgrowsShutdown waits for all submitted calls to complete and stops the workers.").Line().
+		Comment("Calls submitted afterwards are answered with an error.").Line().
+		Func().Id("AgrowsShutdown")
+	if opts.Shutdown {
+		shutdown = jen.Func().Id("agrowsShutdownPool")
+	}
+	code.Add(shutdown).Params().Block(
+		jen.Id("agrowsPoolMutex").Dot("Lock").Call(),
+		jen.If(jen.Id("agrowsPoolClosed")).Block(
+			jen.Id("agrowsPoolMutex").Dot("Unlock").Call(),
+			jen.Return(),
+		),
+		jen.Id("agrowsPoolClosed").Op("=").True(),
+		jen.If(jen.Id("agrowsQueue").Op("!=").Nil()).Block(
+			jen.Close(jen.Id("agrowsQueue")),
+			jen.For(jen.List(jen.Id("_"), jen.Id("queue")).Op(":=").Range().Id("agrowsWorkerQueues")).Block(
+				jen.Close(jen.Id("queue")),
+			),
+		),
+		jen.Id("agrowsPoolMutex").Dot("Unlock").Call(),
+		jen.Id("agrowsPoolWait").Dot("Wait").Call(),
+	).Line()
+
+	return code
+}
+
+func (opts *Options) generateAsyncJob() *jen.Statement {
+	return jen.Id("agrowsJob").ValuesFunc(func(g *jen.Group) {
+		if opts.Trace == "otel" {
+			g.Id("ctx")
+		}
+		g.Id("data")
+		for _, name := range opts.receiveParams() {
+			g.Id(name)
+		}
+		g.Id("reply")
+	})
+}
@@ -0,0 +1,126 @@
+package gen
+
+import (
+	"github.com/dave/jennifer/jen"
+)
+
+// generateAuditRecording emits the deferred audit record of a call in
+// AgrowsReceive. Like the call logging, it must be deferred before the recover.
+func (opts *Options) generateAuditRecording() jen.Code {
+	if opts.AuditLog == "" {
+		return jen.Null()
+	}
+	var clientID jen.Code = jen.Lit("")
+	if opts.rateLimit > 0 {
+		clientID = jen.Id("clientID")
+	}
+	return jen.Add(
+		jen.Id("auditStart").Op(":=").Qual("time", "Now").Call(),
+		jen.Line(),
+		jen.Defer().Func().Params().Block(
+			jen.Id("agrowsAudit").Call(jen.Id("functionName"), jen.Id("args"), clientID, jen.Id("auditStart"), jen.Err()),
+		).Call(),
+	)
+}
+
+// generateAuditLog emits the audit log written by AgrowsReceive, one JSON line
+// per call, rotated once it exceeds AgrowsAuditLogMaxSize.
+func (opts *Options) generateAuditLog() *jen.Statement {
+	reportFailure := func(what string, err jen.Code) jen.Code {
+		return jen.Qual("fmt", "Fprintf").Call(jen.Qual("os", "Stderr"), jen.Lit("agrows: failed to "+what+" audit log: %v\n"), err)
+	}
+
+	code := jen.Var().Defs(
+		jen.Comment("AgrowsAuditLogPath is the file every call is recorded in."),
+		jen.Id("AgrowsAuditLogPath").Op("=").Lit(opts.AuditLog),
+		jen.Comment("AgrowsAuditLogMaxSize is the size in bytes at which the audit log is renamed to"),
+		jen.Comment("AgrowsAuditLogPath + \".1\" and a new one is started."),
+		jen.Id("AgrowsAuditLogMaxSize").Int64().Op("=").Lit(100).Op("*").Lit(1024).Op("*").Lit(1024),
+	).Line().Line()
+
+	code.Var().Id("agrowsAuditLog").Struct(
+		jen.Qual("sync", "Mutex"),
+		jen.Id("file").Op("*").Qual("os", "File"),
+		jen.Id("size").Int64(),
+	).Line().Line()
+
+	code.Func().Id("init").Params().Block(
+		jen.If(jen.Err().Op(":=").Id("agrowsOpenAuditLog").Call(), jen.Err().Op("!=").Nil()).Block(
+			reportFailure("open", jen.Err()),
+		),
+	).Line().Line()
+
+	code.Func().Id("agrowsOpenAuditLog").Params().Error().Block(
+		jen.List(jen.Id("file"), jen.Err()).Op(":=").Qual("os", "OpenFile").Call(
+			jen.Id("AgrowsAuditLogPath"),
+			jen.Qual("os", "O_APPEND").Op("|").Qual("os", "O_CREATE").Op("|").Qual("os", "O_WRONLY"),
+			jen.Lit(0o600),
+		),
+		jen.If(jen.Err().Op("!=").Nil()).Block(jen.Return(jen.Err())),
+		jen.List(jen.Id("info"), jen.Err()).Op(":=").Id("file").Dot("Stat").Call(),
+		jen.If(jen.Err().Op("!=").Nil()).Block(
+			jen.Id("file").Dot("Close").Call(),
+			jen.Return(jen.Err()),
+		),
+		jen.Id("agrowsAuditLog").Dot("file").Op("=").Id("file"),
+		jen.Id("agrowsAuditLog").Dot("size").Op("=").Id("info").Dot("Size").Call(),
+		jen.Return(jen.Nil()),
+	).Line().Line()
+
+	code.Comment("agrowsAudit appends the record of a call to the audit log. Arguments are only").Line().
+		Comment("recorded as the SHA-256 of their JSON encoding.").Line().
+		Func().Id("agrowsAudit").Params(
+		jen.Id("functionName").String(),
+		jen.Id("args").Map(jen.String()).Qual(opts.ProtocolPath, "Argument"),
+		jen.Id("clientID").String(),
+		jen.Id("start").Qual("time", "Time"),
+		jen.Err().Error(),
+	).Block(
+		jen.Id("values").Op(":=").Make(jen.Map(jen.String()).Any(), jen.Len(jen.Id("args"))),
+		jen.For(jen.List(jen.Id("key"), jen.Id("arg")).Op(":=").Range().Id("args")).Block(
+			jen.Id("values").Index(jen.Id("key")).Op("=").Id("arg").Dot("Value"),
+		),
+		jen.List(jen.Id("encodedArgs"), jen.Id("_")).Op(":=").Qual("encoding/json", "Marshal").Call(jen.Id("values")),
+		jen.Id("argsHash").Op(":=").Qual("crypto/sha256", "Sum256").Call(jen.Id("encodedArgs")),
+		jen.List(jen.Id("line"), jen.Id("_")).Op(":=").Qual("encoding/json", "Marshal").Call(jen.Struct(
+			jen.Id("TS").String().Tag(map[string]string{"json": "ts"}),
+			jen.Id("Function").String().Tag(map[string]string{"json": "function"}),
+			jen.Id("ClientID").String().Tag(map[string]string{"json": "clientID"}),
+			jen.Id("ArgsHash").String().Tag(map[string]string{"json": "argsHash"}),
+			jen.Id("Success").Bool().Tag(map[string]string{"json": "success"}),
+			jen.Id("DurationMs").Int64().Tag(map[string]string{"json": "durationMs"}),
+		).Values(jen.Dict{
+			jen.Id("TS"):         jen.Id("start").Dot("UTC").Call().Dot("Format").Call(jen.Qual("time", "RFC3339Nano")),
+			jen.Id("Function"):   jen.Id("functionName"),
+			jen.Id("ClientID"):   jen.Id("clientID"),
+			jen.Id("ArgsHash"):   jen.Qual("encoding/hex", "EncodeToString").Call(jen.Id("argsHash").Index(jen.Empty(), jen.Empty())),
+			jen.Id("Success"):    jen.Err().Op("==").Nil(),
+			jen.Id("DurationMs"): jen.Qual("time", "Since").Call(jen.Id("start")).Dot("Milliseconds").Call(),
+		})),
+		jen.Id("line").Op("=").Append(jen.Id("line"), jen.LitRune('\n')),
+
+		jen.Id("agrowsAuditLog").Dot("Lock").Call(),
+		jen.Defer().Id("agrowsAuditLog").Dot("Unlock").Call(),
+		jen.If(jen.Id("agrowsAuditLog").Dot("file").Op("==").Nil()).Block(
+			jen.Return(),
+		),
+		jen.List(jen.Id("n"), jen.Id("writeErr")).Op(":=").Id("agrowsAuditLog").Dot("file").Dot("Write").Call(jen.Id("line")),
+		jen.Id("agrowsAuditLog").Dot("size").Op("+=").Int64().Call(jen.Id("n")),
+		jen.If(jen.Id("writeErr").Op("!=").Nil()).Block(
+			reportFailure("write", jen.Id("writeErr")),
+			jen.Return(),
+		),
+		jen.If(jen.Id("agrowsAuditLog").Dot("size").Op(">").Id("AgrowsAuditLogMaxSize")).Block(
+			jen.Id("agrowsAuditLog").Dot("file").Dot("Close").Call(),
+			jen.Id("agrowsAuditLog").Dot("file").Op("=").Nil(),
+			jen.If(jen.Err().Op(":=").Qual("os", "Rename").Call(jen.Id("AgrowsAuditLogPath"), jen.Id("AgrowsAuditLogPath").Op("+").Lit(".1")), jen.Err().Op("!=").Nil()).Block(
+				reportFailure("rotate", jen.Err()),
+			),
+			jen.If(jen.Err().Op(":=").Id("agrowsOpenAuditLog").Call(), jen.Err().Op("!=").Nil()).Block(
+				reportFailure("reopen", jen.Err()),
+			),
+		),
+	).Line()
+
+	return code
+}
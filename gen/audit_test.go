@@ -0,0 +1,174 @@
+package gen
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// auditSource has functions succeeding and failing, Greet for a user without
+// name.
+const auditSource = `package api
+
+type User struct {
+	Name string
+	Age  int
+}
+
+type greetError string
+
+func (e greetError) Error() string { return string(e) }
+
+func Greet(user User) (string, error) {
+	if user.Name == "" {
+		return "", greetError("user without name")
+	}
+	return "hello " + user.Name, nil
+}
+
+func Add(a, b int) int {
+	return a + b
+}
+
+func Reset() error {
+	return nil
+}
+
+func Ping() {}
+`
+
+// auditTest runs in the generated server of auditSource with
+// --audit-log=audit.log, calling it through receive of the variant, see
+// auditReceive.
+const auditTest = `package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codeupdateandmodificationsystem/protocol"
+)
+
+type record struct {
+	TS         string
+	Function   string
+	ClientID   string
+	ArgsHash   string
+	Success    bool
+	DurationMs int64
+}
+
+func call(t *testing.T, functionName string, args map[string]any) {
+	t.Helper()
+	data, err := protocol.EncodeFunctionCall(functionName, protocol.Options(), args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receive(data)
+}
+
+func records(t *testing.T, path string) []record {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []record
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		var r record
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("invalid line %q: %v", line, err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func hash(args map[string]any) string {
+	data, _ := json.Marshal(args)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestAuditLog(t *testing.T) {
+	before := time.Now().UTC()
+	call(t, "Add", map[string]any{"a": 1, "b": 2})
+	call(t, "Greet", map[string]any{"user": map[string]any{"Name": ""}})
+
+	got := records(t, AgrowsAuditLogPath)
+	want := []record{
+		{Function: "Add", ClientID: wantClientID, ArgsHash: hash(map[string]any{"a": 1, "b": 2}), Success: true},
+		{Function: "Greet", ClientID: wantClientID, ArgsHash: hash(map[string]any{"user": map[string]any{"Name": ""}}), Success: false},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d records, want %d", len(got), len(want))
+	}
+	for i, r := range got {
+		ts, err := time.Parse(time.RFC3339Nano, r.TS)
+		if err != nil || ts.Before(before) || r.DurationMs < 0 {
+			t.Errorf("record %d has time %s and duration %d", i, r.TS, r.DurationMs)
+		}
+		r.TS, r.DurationMs = "", 0
+		if r != want[i] {
+			t.Errorf("got record %+v, want %+v", r, want[i])
+		}
+	}
+
+	// the third record exceeds the size, the fourth starts the new log
+	AgrowsAuditLogMaxSize = int64(len(mustRead(t, AgrowsAuditLogPath))) + 1
+	call(t, "Ping", nil)
+	call(t, "Reset", nil)
+	rotated := records(t, AgrowsAuditLogPath+".1")
+	if len(rotated) != 3 || rotated[2].Function != "Ping" {
+		t.Errorf("the rotated log has %+v", rotated)
+	}
+	if current := records(t, AgrowsAuditLogPath); len(current) != 1 || current[0].Function != "Reset" {
+		t.Errorf("the new log has %+v", current)
+	}
+}
+
+func mustRead(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+`
+
+// auditReceive are the variants of receive and the client ID auditTest
+// expects, without and with --rate-limit.
+var auditReceive = map[string]string{
+	"plain": `package api
+
+const wantClientID = ""
+
+func receive(data []byte) (string, error) { return AgrowsReceive(data) }
+`,
+	"limited": `package api
+
+const wantClientID = "client-1"
+
+func receive(data []byte) (string, error) { return AgrowsReceive(data, wantClientID) }
+`,
+}
+
+func TestAuditLog(t *testing.T) {
+	dir := newTestModule(t, "golang.org/x/time")
+	for name, receive := range auditReceive {
+		opts := Options{AuditLog: "audit.log"}
+		if name == "limited" {
+			opts.RateLimit = "100/s"
+		}
+		writeFile(t, filepath.Join(dir, name, "agrows_server_api.go"), generate(t, auditSource, opts))
+		writeFile(t, filepath.Join(dir, name, "receive_test.go"), receive)
+		writeFile(t, filepath.Join(dir, name, "agrows_server_api_test.go"), auditTest)
+	}
+	// the log is written next to the test of each package
+	mustRunGo(t, dir, nil, "test", "./plain", "./limited")
+}
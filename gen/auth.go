@@ -0,0 +1,77 @@
+package gen
+
+import (
+	"github.com/dave/jennifer/jen"
+)
+
+// generateAuthorizeCheck emits the call of AgrowsAuthorize in AgrowsReceive,
+// right after the call was decoded. With --auth=jwt, the claims of the token
+// are passed first.
+func (opts *Options) generateAuthorizeCheck() jen.Code {
+	return jen.If(jen.Id("AgrowsAuthorize").Op("!=").Nil()).Block(
+		jen.If(jen.Err().Op(":=").Id("AgrowsAuthorize").CallFunc(func(g *jen.Group) {
+			if opts.Auth == "jwt" {
+				g.Id("claims")
+			}
+			g.Id("functionName")
+			g.Id("args")
+		}), jen.Err().Op("!=").Nil()).Block(
+			jen.Return(jen.Lit(""), generateUnauthorizedError(jen.Err())),
+		),
+	)
+}
+
+func generateUnauthorizedError(err jen.Code) *jen.Statement {
+	return jen.Qual("fmt", "Errorf").Call(jen.Lit("%w: %w"), jen.Id("ErrAgrowsUnauthorized"), err)
+}
+
+// generateAuthorize emits AgrowsAuthorize and the error calls rejected by it are
+// wrapped in.
+func (opts *Options) generateAuthorize() *jen.Statement {
+	code := jen.Comment("ErrAgrowsUnauthorized wraps the errors of calls rejected by AgrowsAuthorize.").Line().
+		Var().Id("ErrAgrowsUnauthorized").Op("=").Qual("errors", "New").Call(jen.Lit("unauthorized")).Line().Line()
+	var claims jen.Code = jen.Null()
+	if opts.Auth == "jwt" {
+		code.Comment("AgrowsAuthorize, if set, is called with the claims of the verified token and every").Line().
+			Comment("decoded call before it is dispatched.").Line()
+		claims = jen.Id("claims").Map(jen.String()).Any()
+	} else {
+		code.Comment("AgrowsAuthorize, if set, is called with every decoded call before it is dispatched.").Line()
+	}
+	return code.Comment("A returned error rejects the call, wrapped in ErrAgrowsUnauthorized.").Line().
+		Var().Id("AgrowsAuthorize").Func().Params(
+		claims,
+		jen.Id("fn").String(),
+		jen.Id("args").Map(jen.String()).Qual(opts.ProtocolPath, "Argument"),
+	).Error().Line()
+}
+
+// generateConnectionAuthorize emits the hooks server-ws authorizes calls per
+// connection with.
+func (opts *Options) generateConnectionAuthorize() *jen.Statement {
+	return jen.Comment("AgrowsConnectionValue, if set, is called by AgrowsServeHTTP before upgrading a").Line().
+		Comment("connection, e.g. to check a token of the request. An error rejects the upgrade").Line().
+		Comment("with 401 Unauthorized, the value is passed to AgrowsAuthorizeConnection.").Line().
+		Var().Id("AgrowsConnectionValue").Func().Params(jen.Id("r").Op("*").Qual("net/http", "Request")).Params(jen.Any(), jen.Error()).Line().Line().
+		Comment("AgrowsAuthorizeConnection, if set, is called with the value of the connection and").Line().
+		Comment("every call received on it, like AgrowsAuthorize. Messages it can not decode,").Line().
+		Comment("including batches, are rejected while it is set.").Line().
+		Var().Id("AgrowsAuthorizeConnection").Func().Params(
+		jen.Id("value").Any(),
+		jen.Id("fn").String(),
+		jen.Id("args").Map(jen.String()).Qual(opts.ProtocolPath, "Argument"),
+	).Error().Line().Line().
+		Func().Id("agrowsAuthorizeConnection").Params(jen.Id("value").Any(), jen.Id("data").Index().Byte()).Error().Block(
+		jen.If(jen.Id("AgrowsAuthorizeConnection").Op("==").Nil()).Block(
+			jen.Return(jen.Nil()),
+		),
+		jen.List(jen.Id("functionName"), jen.Id("args"), jen.Err()).Op(":=").Add(opts.generateDecodeCall(jen.Id("data"))),
+		jen.If(jen.Err().Op("!=").Nil()).Block(
+			jen.Return(generateUnauthorizedError(jen.Qual("fmt", "Errorf").Call(jen.Lit("failed to decode function call: %w"), jen.Err()))),
+		),
+		jen.If(jen.Err().Op(":=").Id("AgrowsAuthorizeConnection").Call(jen.Id("value"), jen.Id("functionName"), jen.Id("args")), jen.Err().Op("!=").Nil()).Block(
+			jen.Return(generateUnauthorizedError(jen.Err())),
+		),
+		jen.Return(jen.Nil()),
+	).Line()
+}
@@ -0,0 +1,183 @@
+package gen
+
+import (
+	"fmt"
+
+	"github.com/dave/jennifer/jen"
+)
+
+// batchMagic starts a payload carrying several calls. It is followed by every
+// encoded call, prefixed with its length as uvarint.
+const batchMagic = "AGROWS-BATCH\x00"
+
+var batchedClientFuncs = []string{"AgrowsBeginBatch", "AgrowsFlush"}
+
+// generateBatchDetection emits the check at the start of the receiver handing
+// batch payloads to agrowsReceiveBatch, which calls receive for each of them.
+func (opts *Options) generateBatchDetection(receive jen.Code) jen.Code {
+	if !opts.Batch {
+		return jen.Null()
+	}
+	return jen.If(jen.Qual("bytes", "HasPrefix").Call(jen.Id("data"), jen.Index().Byte().Call(jen.Id("agrowsBatchMagic")))).Block(
+		jen.Return(jen.Id("agrowsReceiveBatch").CallFunc(func(g *jen.Group) {
+			if opts.Trace == "otel" {
+				g.Id("ctx")
+			}
+			g.Id("data")
+			for _, name := range opts.receiveParams() {
+				g.Id(name)
+			}
+			g.Add(receive)
+		})),
+	)
+}
+
+// generateServerBatch emits agrowsReceiveBatch, which dispatches the calls of a
+// batch in order and replies with a JSON array of their results.
+func (opts *Options) generateServerBatch() *jen.Statement {
+	receiveType := jen.Func().ParamsFunc(func(g *jen.Group) {
+		if opts.Trace == "otel" {
+			g.Qual("context", "Context")
+		}
+		g.Index().Byte()
+		for range opts.receiveParams() {
+			g.String()
+		}
+	}).Params(jen.String(), jen.Error())
+
+	code := jen.Comment("agrowsBatchMagic starts a payload carrying several calls, each prefixed with its").Line().
+		Comment("length as uvarint.").Line().
+		Const().Id("agrowsBatchMagic").Op("=").Lit(batchMagic).Line().Line()
+
+	code.Comment("agrowsBatchResult is the reply to one call of a batch.").Line().
+		Type().Id("agrowsBatchResult").Struct(
+		jen.Id("Result").String().Tag(map[string]string{"json": "result"}),
+		jen.Id("Error").String().Tag(map[string]string{"json": "error,omitempty"}),
+	).Line().Line()
+
+	code.Comment("agrowsReceiveBatch passes the calls of a batch to receive one after another. A").Line().
+		Comment("failed call does not stop the following ones, the reply lists the outcome of").Line().
+		Comment("every call at its position in the batch.").Line().
+		Func().Id("agrowsReceiveBatch").ParamsFunc(func(g *jen.Group) {
+		if opts.Trace == "otel" {
+			g.Id("ctx").Qual("context", "Context")
+		}
+		g.Id("data").Index().Byte()
+		for _, name := range opts.receiveParams() {
+			g.Id(name).String()
+		}
+		g.Id("receive").Add(receiveType)
+	}).Params(jen.String(), jen.Error()).Block(
+		jen.Id("data").Op("=").Id("data").Index(jen.Len(jen.Id("agrowsBatchMagic")), jen.Empty()),
+		jen.Var().Id("results").Index().Id("agrowsBatchResult"),
+		jen.For(jen.Len(jen.Id("data")).Op(">").Lit(0)).Block(
+			jen.List(jen.Id("size"), jen.Id("n")).Op(":=").Qual("encoding/binary", "Uvarint").Call(jen.Id("data")),
+			jen.If(jen.Id("n").Op("<=").Lit(0).Op("||").Id("size").Op(">").Uint64().Call(jen.Len(jen.Id("data")).Op("-").Id("n"))).Block(
+				jen.Return(jen.Lit(""), jen.Qual("fmt", "Errorf").Call(jen.Lit("malformed batch at call %d"), jen.Len(jen.Id("results")))),
+			),
+			jen.Id("call").Op(":=").Id("data").Index(jen.Id("n"), jen.Id("n").Op("+").Int().Call(jen.Id("size"))),
+			jen.Id("data").Op("=").Id("data").Index(jen.Id("n").Op("+").Int().Call(jen.Id("size")), jen.Empty()),
+			jen.Var().Id("batchResult").Id("agrowsBatchResult"),
+			jen.If(jen.Qual("bytes", "HasPrefix").Call(jen.Id("call"), jen.Index().Byte().Call(jen.Id("agrowsBatchMagic")))).Block(
+				jen.Id("batchResult").Dot("Error").Op("=").Lit("batches can not be nested"),
+			).Else().If(
+				jen.List(jen.Id("result"), jen.Err()).Op(":=").Id("receive").CallFunc(func(g *jen.Group) {
+					if opts.Trace == "otel" {
+						g.Id("ctx")
+					}
+					g.Id("call")
+					for _, name := range opts.receiveParams() {
+						g.Id(name)
+					}
+				}),
+				jen.Err().Op("!=").Nil(),
+			).Block(
+				jen.Id("batchResult").Dot("Error").Op("=").Err().Dot("Error").Call(),
+			).Else().Block(
+				jen.Id("batchResult").Dot("Result").Op("=").Id("result"),
+			),
+			jen.Id("results").Op("=").Append(jen.Id("results"), jen.Id("batchResult")),
+		),
+		jen.List(jen.Id("reply"), jen.Err()).Op(":=").Qual("encoding/json", "Marshal").Call(jen.Id("results")),
+		jen.If(jen.Err().Op("!=").Nil()).Block(
+			jen.Return(jen.Lit(""), jen.Qual("fmt", "Errorf").Call(jen.Lit("failed to encode batch results: %w"), jen.Err())),
+		),
+		jen.Return(jen.String().Call(jen.Id("reply")), jen.Nil()),
+	).Line()
+
+	return code
+}
+
+// generateClientBatchCheck emits the start of sendMessage collecting the calls
+// made between AgrowsBeginBatch and AgrowsFlush.
+func (opts *Options) generateClientBatchCheck() jen.Code {
+	if !opts.Batch {
+		return jen.Null()
+	}
+	return jen.Add(
+		jen.Id("agrowsBatchMutex").Dot("Lock").Call(),
+		jen.Line(),
+		jen.If(jen.Id("agrowsBatching")).Block(
+			jen.Id("agrowsBatchCalls").Op("=").Append(jen.Id("agrowsBatchCalls"), jen.Id("data")),
+			jen.Id("agrowsBatchMutex").Dot("Unlock").Call(),
+			jen.Return(jen.Nil()),
+		),
+		jen.Line(),
+		jen.Id("agrowsBatchMutex").Dot("Unlock").Call(),
+	)
+}
+
+// generateClientBatch emits AgrowsBeginBatch and AgrowsFlush together with the
+// wrappers exposing them to JavaScript.
+func (opts *Options) generateClientBatch() *jen.Statement {
+	code := jen.Const().Id("agrowsBatchMagic").Op("=").Lit(batchMagic).Line().Line()
+
+	code.Var().Defs(
+		jen.Id("agrowsBatchMutex").Qual("sync", "Mutex"),
+		jen.Id("agrowsBatching").Bool(),
+		jen.Id("agrowsBatchCalls").Index().Index().Byte(),
+	).Line().Line()
+
+	code.Comment("AgrowsBeginBatch collects all following calls until AgrowsFlush sends them as one").Line().
+		Comment("message.").Line().
+		Func().Id("AgrowsBeginBatch").Params().Block(
+		jen.Id("agrowsBatchMutex").Dot("Lock").Call(),
+		jen.Defer().Id("agrowsBatchMutex").Dot("Unlock").Call(),
+		jen.Id("agrowsBatching").Op("=").True(),
+	).Line().Line()
+
+	code.Comment("AgrowsFlush sends the calls collected since AgrowsBeginBatch. The server replies").Line().
+		Comment("with a JSON array holding the result or error of every call in order.").Line().
+		Func().Id("AgrowsFlush").Params().Any().Block(
+		jen.Id("agrowsBatchMutex").Dot("Lock").Call(),
+		jen.Id("calls").Op(":=").Id("agrowsBatchCalls"),
+		jen.Id("agrowsBatchCalls").Op("=").Nil(),
+		jen.Id("agrowsBatching").Op("=").False(),
+		jen.Id("agrowsBatchMutex").Dot("Unlock").Call(),
+		jen.If(jen.Len(jen.Id("calls")).Op("==").Lit(0)).Block(
+			jen.Return(jen.Nil()),
+		),
+		jen.Id("data").Op(":=").Index().Byte().Call(jen.Id("agrowsBatchMagic")),
+		jen.For(jen.List(jen.Id("_"), jen.Id("call")).Op(":=").Range().Id("calls")).Block(
+			jen.Id("data").Op("=").Qual("encoding/binary", "AppendUvarint").Call(jen.Id("data"), jen.Uint64().Call(jen.Len(jen.Id("call")))),
+			jen.Id("data").Op("=").Append(jen.Id("data"), jen.Id("call").Op("...")),
+		),
+		jen.Return(jen.Id("sendMessage").Call(jen.Id("data"))),
+	).Line().Line()
+
+	for _, name := range batchedClientFuncs {
+		wrapperName := fmt.Sprintf(opts.WrapperFormat, name)
+		call := jen.Id(name).Call()
+		body := []jen.Code{jen.Return(call)}
+		if name == "AgrowsBeginBatch" {
+			body = []jen.Code{call, jen.Return(jen.Nil())}
+		}
+		code.Commentf("%s exposes %s to JavaScript.", wrapperName, name).Line().
+			Func().Id(wrapperName).Params(
+			jen.Id("this").Qual("syscall/js", "Value"),
+			jen.Id("p").Index().Qual("syscall/js", "Value"),
+		).Any().Block(body...).Line().Line()
+	}
+
+	return code
+}
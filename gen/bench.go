@@ -0,0 +1,140 @@
+package gen
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/dave/dst"
+	"github.com/dave/jennifer/jen"
+)
+
+// benchmarksFileName is the file --benchmarks writes next to the server output.
+const benchmarksFileName = "agrows_bench_test.go"
+
+// generateBenchmarks emits a BenchmarkAgrowsReceive_<Func> per function calling
+// it through AgrowsReceive with zero values, plus benchmarks of an unknown
+// function and of the cheapest call as the dispatch overhead. Functions with
+// primitive parameters also get a BenchmarkAgrowsDecode_<Func> comparing
+// agrowsAssign with agrowsDecodeRequest.
+func (opts *Options) generateBenchmarks(infos []FuncInfo) *jen.Statement {
+	code := jen.Comment("agrowsEncodeBenchmarkCall encodes a call of funcName with params, failing the").Line().
+		Comment("benchmark if the call can not be encoded.").Line().
+		Func().Id("agrowsEncodeBenchmarkCall").Params(
+		jen.Id("b").Op("*").Qual("testing", "B"),
+		jen.Id("funcName").String(),
+		jen.Id("params").Map(jen.String()).Any(),
+	).Index().Byte().Block(
+		jen.Id("b").Dot("Helper").Call(),
+		jen.List(jen.Id("data"), jen.Err()).Op(":=").Add(opts.generateEncodeCall(jen.Id("funcName"), jen.Id("params"))),
+		jen.If(jen.Err().Op("!=").Nil()).Block(
+			jen.Id("b").Dot("Fatalf").Call(jen.Lit("failed to encode call of %s: %v"), jen.Id("funcName"), jen.Err()),
+		),
+		jen.Return(jen.Id("data")),
+	).Line().Line()
+
+	benchmark := func(name string, funcName jen.Code, params jen.Code) {
+		code.Func().Id("BenchmarkAgrowsReceive_" + name).Params(jen.Id("b").Op("*").Qual("testing", "B")).BlockFunc(func(g *jen.Group) {
+			g.Id("data").Op(":=").Id("agrowsEncodeBenchmarkCall").Call(jen.Id("b"), funcName, params)
+			opts.generateReceiveArgs(g, "b")
+			g.Id("b").Dot("SetBytes").Call(jen.Int64().Call(jen.Len(jen.Id("data"))))
+			g.Id("b").Dot("ResetTimer").Call()
+			g.For(jen.Id("i").Op(":=").Lit(0), jen.Id("i").Op("<").Id("b").Dot("N"), jen.Id("i").Op("++")).Block(
+				opts.generateReceiveCall(jen.Id("data")),
+			)
+		}).Line().Line()
+	}
+
+	for _, info := range infos {
+		benchmark(info.OriginalIdentifier.Name, jen.Id(funcNameConstant(info)), opts.zeroArguments(info))
+	}
+	benchmark("Unknown", jen.Lit("agrows_unknown_function"), jen.Map(jen.String()).Any().Values())
+
+	// the call with the fewest parameters spends the least time decoding, so
+	// it comes closest to the cost of dispatching alone
+	var cheapest *FuncInfo
+	for i := range infos {
+		if cheapest == nil || len(infos[i].Params) < len(cheapest.Params) {
+			cheapest = &infos[i]
+		}
+	}
+	if cheapest != nil {
+		code.Comment(fmt.Sprintf("BenchmarkAgrowsReceive_Dispatch measures the overhead of %s with %s,", opts.ReceiverName, cheapest.OriginalIdentifier.Name)).Line().
+			Comment("the function taking the fewest parameters.").Line()
+		benchmark("Dispatch", jen.Id(funcNameConstant(*cheapest)), opts.zeroArguments(*cheapest))
+	}
+
+	// the fast path of primitive parameters against the round trip it spares,
+	// both without the rest of the dispatch
+	for _, info := range infos {
+		if !primitiveParams(info) {
+			continue
+		}
+		name := info.OriginalIdentifier.Name
+		decode := func(b *jen.Group, call jen.Code) {
+			b.Id("b").Dot("ReportAllocs").Call()
+			b.For(jen.Id("i").Op(":=").Lit(0), jen.Id("i").Op("<").Id("b").Dot("N"), jen.Id("i").Op("++")).Block(
+				jen.Var().Id("request").Id(requestTypeName(info)),
+				call,
+			)
+		}
+		code.Comment(fmt.Sprintf("BenchmarkAgrowsDecode_%s compares assigning the arguments of %s directly", name, name)).Line().
+			Comment(fmt.Sprintf("with decoding them through %s.", serializeNames[opts.Serialize])).Line().
+			Func().Id("BenchmarkAgrowsDecode_"+name).Params(jen.Id("b").Op("*").Qual("testing", "B")).Block(
+			jen.Id("args").Op(":=").Map(jen.String()).Qual(opts.ProtocolPath, "Argument").ValuesFunc(func(g *jen.Group) {
+				for _, paramInfo := range info.Params {
+					g.Line().Lit(paramInfo.DstField.Names[0].Name).Op(":").Values(jen.Id("Value").Op(":").Add(opts.zeroArgument(paramInfo)))
+				}
+				g.Line()
+			}),
+			jen.Id("b").Dot("Run").Call(jen.Lit("assign"), jen.Func().Params(jen.Id("b").Op("*").Qual("testing", "B")).BlockFunc(func(g *jen.Group) {
+				decode(g, jen.If(jen.Op("!").Id("request").Dot("agrowsAssign").Call(jen.Id("args"))).Block(
+					jen.Id("b").Dot("Fatal").Call(jen.Lit("the arguments do not have the types of the parameters")),
+				))
+			})),
+			jen.Id("b").Dot("Run").Call(jen.Lit(opts.Serialize), jen.Func().Params(jen.Id("b").Op("*").Qual("testing", "B")).BlockFunc(func(g *jen.Group) {
+				decode(g, jen.If(
+					jen.Err().Op(":=").Id("agrowsDecodeRequest").CallFunc(func(call *jen.Group) {
+						call.Id(funcNameConstant(info))
+						call.Id("args")
+						call.Op("&").Id("request")
+						for _, paramInfo := range info.Params {
+							call.Lit(paramInfo.DstField.Names[0].Name)
+						}
+					}),
+					jen.Err().Op("!=").Nil(),
+				).Block(
+					jen.Id("b").Dot("Fatal").Call(jen.Err()),
+				))
+			})),
+		).Line().Line()
+	}
+
+	return code
+}
+
+// writeTestFile writes code as a test file of the package packageName to
+// path, which runs in the package of the server output and uses its types.
+func (opts *Options) writeTestFile(path string, packageName string, code jen.Code, force bool) error {
+	return opts.writeSideFile(path, packageName, code, TESTHELPERS, force)
+}
+
+// writeSideFile writes code to path as a file of the package packageName next
+// to the output generated in mode, which decides about its build tags.
+func (opts *Options) writeSideFile(path string, packageName string, code jen.Code, mode byte, force bool) error {
+	if !force {
+		if err := CheckOverwritable(path); err != nil {
+			return err
+		}
+	}
+	newFile := jen.NewFile(packageName)
+	newFile.Add(code)
+	var buffer bytes.Buffer
+	if _, err := opts.writeCombinedTreeAndGenerated(&dst.File{Name: dst.NewIdent(packageName)}, newFile, &buffer, mode); err != nil {
+		return fmt.Errorf("failed to generate %s: %w", path, err)
+	}
+	if err := os.WriteFile(path, buffer.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
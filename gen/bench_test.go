@@ -0,0 +1,28 @@
+package gen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBenchmarks(t *testing.T) {
+	dir := newTestModule(t)
+	server := filepath.Join(dir, "server")
+	if err := os.Mkdir(server, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(server, "agrows_server_api.go"), generate(t, apiSource, Options{Benchmarks: true, OutputDir: server}))
+	out := mustRunGo(t, dir, nil, "test", "-run", "^$", "-bench", ".", "-benchtime", "1x", "./server")
+	for _, name := range []string{"Greet", "Add", "Reset", "Unknown", "Dispatch"} {
+		if !strings.Contains(out, "BenchmarkAgrowsReceive_"+name) {
+			t.Errorf("BenchmarkAgrowsReceive_%s did not run:\n%s", name, out)
+		}
+	}
+	for _, name := range []string{"Add/assign", "Add/json"} {
+		if !strings.Contains(out, "BenchmarkAgrowsDecode_"+name) {
+			t.Errorf("BenchmarkAgrowsDecode_%s did not run:\n%s", name, out)
+		}
+	}
+}
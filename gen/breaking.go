@@ -0,0 +1,130 @@
+package gen
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/dave/dst"
+)
+
+// Change is a change of an RPC function breaking the clients generated before
+// it, as found by BreakingChanges.
+type Change struct {
+	Function    string
+	Description string
+}
+
+func (c Change) String() string {
+	return c.Function + ": " + c.Description
+}
+
+// BreakingChanges compares the RPC functions of a server generated before,
+// read from previous, with the functions of input. It returns the changes
+// breaking the clients of the previous server: removed functions, added or
+// removed parameters and changed parameter, result or event types. Added
+// functions and contexts are no such changes. opts.FunctionFormat must be the
+// one previous was generated with.
+func BreakingChanges(previous io.Reader, previousName string, input Input, opts Options) ([]Change, error) {
+	if err := opts.check(); err != nil {
+		return nil, err
+	}
+	current := make(map[string]FuncInfo, len(input.Functions))
+	for _, info := range input.Functions {
+		if !info.ServerToClient && opts.wraps(info.OriginalIdentifier.Name) {
+			current[info.OriginalIdentifier.Name] = info
+		}
+	}
+
+	// every function of the previous output was an RPC function, those left
+	// out by --only and --exclude now are removed for its clients
+	opts.Only, opts.Exclude, opts.only, opts.exclude = nil, nil, nil, nil
+
+	tree, dec, err := parseFileToTree(previous, previousName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse previous output: %w", err)
+	}
+	// the renamed functions get their original names back, so they are
+	// described like the functions of the source
+	renamed := &dst.File{Name: tree.Name}
+	for _, decl := range tree.Decls {
+		if fn, ok := decl.(*dst.FuncDecl); ok && fn.Recv == nil {
+			if name, ok := originalName(fn.Name.Name, opts.FunctionFormat); ok {
+				fn.Name.Name = name
+				renamed.Decls = append(renamed.Decls, fn)
+			}
+		}
+	}
+	previousInfos, err := opts.extractFuncInfo(renamed, extractTypeMap(tree), dec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read functions of previous output: %w", err)
+	}
+
+	var changes []Change
+	for _, before := range previousInfos {
+		name := before.OriginalIdentifier.Name
+		after, ok := current[name]
+		if !ok {
+			changes = append(changes, Change{Function: name, Description: "removed"})
+			continue
+		}
+		changes = append(changes, paramChanges(name, before.Params, after.Params)...)
+		if beforeResults, afterResults := resultTypes(before), resultTypes(after); beforeResults != afterResults {
+			changes = append(changes, Change{Function: name, Description: fmt.Sprintf("results changed from (%s) to (%s)", beforeResults, afterResults)})
+		}
+		if beforeEvent, afterEvent := eventType(before), eventType(after); beforeEvent != afterEvent {
+			changes = append(changes, Change{Function: name, Description: fmt.Sprintf("events changed from %s to %s", beforeEvent, afterEvent)})
+		}
+	}
+	return changes, nil
+}
+
+// paramChanges compares the parameters of a function by name, since the
+// arguments are sent by name.
+func paramChanges(function string, before, after []*ParamReflectInfo) []Change {
+	types := func(params []*ParamReflectInfo) map[string]string {
+		byName := make(map[string]string, len(params))
+		for _, param := range params {
+			byName[param.DstField.Names[0].Name] = typeString(param.DstField.Type)
+		}
+		return byName
+	}
+	beforeTypes, afterTypes := types(before), types(after)
+
+	var changes []Change
+	for _, param := range before {
+		name := param.DstField.Names[0].Name
+		afterType, ok := afterTypes[name]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Function: function, Description: fmt.Sprintf("removed parameter %s", name)})
+		case afterType != beforeTypes[name]:
+			changes = append(changes, Change{Function: function, Description: fmt.Sprintf("parameter %s changed from %s to %s", name, beforeTypes[name], afterType)})
+		}
+	}
+	for _, param := range after {
+		name := param.DstField.Names[0].Name
+		if _, ok := beforeTypes[name]; !ok {
+			changes = append(changes, Change{Function: function, Description: fmt.Sprintf("added parameter %s %s", name, afterTypes[name])})
+		}
+	}
+	return changes
+}
+
+// resultTypes lists the result types of info, separated by commas.
+func resultTypes(info FuncInfo) string {
+	types := make([]string, 0, len(info.Results))
+	for _, result := range info.Results {
+		types = append(types, typeString(result.DstField.Type))
+	}
+	return strings.Join(types, ", ")
+}
+
+// eventType returns the type of the events of info, "none" if it has no emit
+// parameter.
+func eventType(info FuncInfo) string {
+	if info.Emit == nil {
+		return "none"
+	}
+	return typeString(info.Emit.DstField.Type)
+}
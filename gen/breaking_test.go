@@ -0,0 +1,41 @@
+package gen
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBreakingChanges(t *testing.T) {
+	opts := Options{FileName: "api.go"}
+	previous := generate(t, apiSource, opts)
+
+	changed := strings.NewReplacer(
+		"func Greet(user User) (string, error)", "func Greet(user User, loud bool) (string, error)",
+		"func Add(a, b int) int", "func Add(a int, b int64) string",
+		"func Reset() error {\n\treturn nil\n}\n", "func Ping() {}\n",
+	).Replace(apiSource)
+	input, err := Parse(strings.NewReader(changed), "api.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err := BreakingChanges(bytes.NewReader(previous), "agrows_server_api.go", input, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, change := range changes {
+		got = append(got, change.String())
+	}
+	// the added Ping breaks no client
+	want := []string{
+		"Greet: added parameter loud bool",
+		"Add: parameter b changed from int to int64",
+		"Add: results changed from (int) to (string)",
+		"Reset: removed",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got changes %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,110 @@
+package gen
+
+import (
+	"strings"
+
+	"github.com/dave/jennifer/jen"
+)
+
+// noCacheDirective excludes a function from --cache-ttl, e.g. because its
+// result depends on more than its arguments.
+const noCacheDirective = "//agrows:nocache"
+
+// isCached reports whether results of info are cached. Functions without a
+// value result are called for their effect and never are, nor are those
+// emitting events.
+func (opts *Options) isCached(info FuncInfo) bool {
+	if opts.CacheTTL <= 0 || info.Emit != nil {
+		return false
+	}
+	for _, line := range info.Doc {
+		if strings.TrimSpace(line) == noCacheDirective {
+			return false
+		}
+	}
+	for _, resultInfo := range info.Results {
+		if typeString(resultInfo.DstField.Type) != "error" {
+			return true
+		}
+	}
+	return false
+}
+
+// generateCacheLookup emits the lookup of a cached result at the start of a
+// dispatch case, returning it on a hit.
+func generateCacheLookup(g *jen.Group) {
+	g.Id("cacheKey").Op(":=").Id("agrowsCacheKey").Call(jen.Id("functionName"), jen.Id("args"))
+	g.If(jen.List(jen.Id("cached"), jen.Id("ok")).Op(":=").Id("agrowsCacheLoad").Call(jen.Id("cacheKey")), jen.Id("ok")).Block(
+		jen.Return(jen.Id("cached"), jen.Nil()),
+	)
+}
+
+// generateCache emits the result cache used by dispatch cases of cached
+// functions and AgrowsCacheInvalidate.
+func (opts *Options) generateCache() *jen.Statement {
+	code := jen.Const().Id("agrowsCacheTTL").Op("=").Add(generateDuration(opts.CacheTTL)).Line().Line()
+
+	code.Type().Id("agrowsCacheEntry").Struct(
+		jen.Id("value").String(),
+		jen.Id("expiry").Qual("time", "Time"),
+	).Line().Line()
+
+	code.Var().Defs(
+		jen.Id("agrowsCache").Qual("sync", "Map"),
+		jen.Comment("agrowsNow is the clock of the cache, replaceable in tests."),
+		jen.Id("agrowsNow").Op("=").Qual("time", "Now"),
+	).Line().Line()
+
+	code.Comment("agrowsCacheKey identifies a call by its function and arguments. Calls whose").Line().
+		Comment("arguments can not be encoded get an empty key and are not cached.").Line().
+		Func().Id("agrowsCacheKey").Params(
+		jen.Id("functionName").String(),
+		jen.Id("args").Map(jen.String()).Qual(opts.ProtocolPath, "Argument"),
+	).String().BlockFunc(func(g *jen.Group) {
+		g.Id("values").Op(":=").Make(jen.Map(jen.String()).Any(), jen.Len(jen.Id("args")))
+		g.For(jen.List(jen.Id("key"), jen.Id("arg")).Op(":=").Range().Id("args")).BlockFunc(func(loop *jen.Group) {
+			if opts.Trace == "otel" {
+				loop.If(jen.Id("key").Op("==").Id("agrowsTraceContextArg")).Block(jen.Continue())
+			}
+			loop.Id("values").Index(jen.Id("key")).Op("=").Id("arg").Dot("Value")
+		})
+		g.Comment("maps are encoded with sorted keys, so equal arguments give equal keys")
+		g.List(jen.Id("data"), jen.Err()).Op(":=").Qual("encoding/json", "Marshal").Call(jen.Id("values"))
+		g.If(jen.Err().Op("!=").Nil()).Block(jen.Return(jen.Lit("")))
+		g.Return(jen.Id("functionName").Op("+").Lit(":").Op("+").String().Call(jen.Id("data")))
+	}).Line().Line()
+
+	code.Func().Id("agrowsCacheLoad").Params(jen.Id("key").String()).Params(jen.String(), jen.Bool()).Block(
+		jen.If(jen.Id("key").Op("==").Lit("")).Block(jen.Return(jen.Lit(""), jen.False())),
+		jen.List(jen.Id("value"), jen.Id("ok")).Op(":=").Id("agrowsCache").Dot("Load").Call(jen.Id("key")),
+		jen.If(jen.Op("!").Id("ok")).Block(jen.Return(jen.Lit(""), jen.False())),
+		jen.Id("entry").Op(":=").Id("value").Assert(jen.Id("agrowsCacheEntry")),
+		jen.If(jen.Op("!").Id("agrowsNow").Call().Dot("Before").Call(jen.Id("entry").Dot("expiry"))).Block(
+			jen.Id("agrowsCache").Dot("CompareAndDelete").Call(jen.Id("key"), jen.Id("value")),
+			jen.Return(jen.Lit(""), jen.False()),
+		),
+		jen.Return(jen.Id("entry").Dot("value"), jen.True()),
+	).Line().Line()
+
+	code.Func().Id("agrowsCacheStore").Params(jen.Id("key").String(), jen.Id("value").String()).Block(
+		jen.If(jen.Id("key").Op("==").Lit("")).Block(jen.Return()),
+		jen.Id("agrowsCache").Dot("Store").Call(jen.Id("key"), jen.Id("agrowsCacheEntry").Values(jen.Dict{
+			jen.Id("value"):  jen.Id("value"),
+			jen.Id("expiry"): jen.Id("agrowsNow").Call().Dot("Add").Call(jen.Id("agrowsCacheTTL")),
+		})),
+	).Line().Line()
+
+	code.Comment("AgrowsCacheInvalidate drops the cached results of the function functionName,").Line().
+		Comment("e.g. after the data it returns changed.").Line().
+		Func().Id("AgrowsCacheInvalidate").Params(jen.Id("functionName").String()).Block(
+		jen.Id("prefix").Op(":=").Id("functionName").Op("+").Lit(":"),
+		jen.Id("agrowsCache").Dot("Range").Call(jen.Func().Params(jen.Id("key"), jen.Id("_").Any()).Bool().Block(
+			jen.If(jen.Qual("strings", "HasPrefix").Call(jen.Id("key").Assert(jen.String()), jen.Id("prefix"))).Block(
+				jen.Id("agrowsCache").Dot("Delete").Call(jen.Id("key")),
+			),
+			jen.Return(jen.True()),
+		)),
+	).Line()
+
+	return code
+}
@@ -0,0 +1,105 @@
+package gen
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// cacheSource counts the calls of its functions, which the cache saves.
+const cacheSource = `package api
+
+type failure string
+
+func (f failure) Error() string { return string(f) }
+
+var calls = map[string]int{}
+
+func Count(name string) int {
+	calls["Count"]++
+	return len(name)
+}
+
+func Fail(name string) (string, error) {
+	calls["Fail"]++
+	return "", failure("failed")
+}
+
+//agrows:nocache
+func Fresh(name string) string {
+	calls["Fresh"]++
+	return name
+}
+
+func Other(x int) int {
+	calls["Other"]++
+	return x
+}
+`
+
+// cacheTest runs in the generated server of cacheSource with --cache-ttl=1m,
+// replacing the clock of the cache.
+const cacheTest = `package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/codeupdateandmodificationsystem/protocol"
+)
+
+func call(t *testing.T, functionName string, args map[string]any) {
+	t.Helper()
+	data, err := protocol.EncodeFunctionCall(functionName, protocol.Options(), args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AgrowsReceive(data)
+}
+
+func TestCache(t *testing.T) {
+	now := time.Unix(0, 0)
+	agrowsNow = func() time.Time { return now }
+	check := func(functionName string, want int) {
+		t.Helper()
+		if calls[functionName] != want {
+			t.Errorf("%s ran %d times, want %d", functionName, calls[functionName], want)
+		}
+	}
+
+	call(t, "Count", map[string]any{"name": "a"})
+	call(t, "Count", map[string]any{"name": "a"})
+	check("Count", 1)
+	call(t, "Count", map[string]any{"name": "b"})
+	check("Count", 2)
+
+	now = now.Add(time.Minute - time.Nanosecond)
+	call(t, "Count", map[string]any{"name": "a"})
+	check("Count", 2)
+	now = now.Add(time.Nanosecond)
+	call(t, "Count", map[string]any{"name": "a"})
+	check("Count", 3)
+
+	// errors and functions marked with //agrows:nocache are not cached
+	call(t, "Fail", map[string]any{"name": "a"})
+	call(t, "Fail", map[string]any{"name": "a"})
+	check("Fail", 2)
+	call(t, "Fresh", map[string]any{"name": "a"})
+	call(t, "Fresh", map[string]any{"name": "a"})
+	check("Fresh", 2)
+
+	call(t, "Other", map[string]any{"x": 1})
+	AgrowsCacheInvalidate("Count")
+	call(t, "Count", map[string]any{"name": "a"})
+	check("Count", 4)
+	call(t, "Other", map[string]any{"x": 1})
+	check("Other", 1)
+}
+`
+
+func TestCacheTTL(t *testing.T) {
+	dir := newTestModule(t)
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api.go"), generate(t, cacheSource, Options{CacheTTL: time.Minute}))
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api_test.go"), cacheTest)
+	mustRunGo(t, dir, nil, "test", "./server")
+}
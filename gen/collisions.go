@@ -0,0 +1,120 @@
+package gen
+
+import (
+	"fmt"
+	"go/token"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+)
+
+// declaredName is the owner of a name in the generated output, for reporting
+// collisions.
+type declaredName struct {
+	what     string
+	position string
+	// renamed is set for RPC functions renamed by FunctionFormat in the server,
+	// which leaves their name free there.
+	renamed bool
+}
+
+// checkNameCollisions fails for RPC functions sharing a name, which their calls
+// are dispatched by, and for identifiers generated for the functions which are
+// taken by another declaration or generated identifier. The error names the
+// positions of both, instead of leaving the output to fail compiling.
+func (opts *Options) checkNameCollisions(tree *dst.File, infos []FuncInfo, dec *decorator.Decorator) error {
+	taken := make(map[string]declaredName)
+	take := func(name string, owner declaredName) error {
+		if first, ok := taken[name]; ok {
+			return fmt.Errorf("duplicate name %s: %s at %s and %s at %s", name, first.what, first.position, owner.what, owner.position)
+		}
+		taken[name] = owner
+		return nil
+	}
+
+	for _, decl := range tree.Decls {
+		switch decl := decl.(type) {
+		case *dst.FuncDecl:
+			if decl.Recv != nil || opts.isRPCFunction(decl) || decl.Name.Name == "init" || decl.Name.Name == "_" {
+				continue
+			}
+			if err := take(decl.Name.Name, declaredName{what: "function " + decl.Name.Name, position: nodePosition(dec, decl)}); err != nil {
+				return err
+			}
+		case *dst.GenDecl:
+			if decl.Tok == token.IMPORT {
+				continue
+			}
+			for _, spec := range decl.Specs {
+				var names []*dst.Ident
+				switch spec := spec.(type) {
+				case *dst.TypeSpec:
+					names = []*dst.Ident{spec.Name}
+				case *dst.ValueSpec:
+					names = spec.Names
+				}
+				for _, name := range names {
+					if name.Name == "_" {
+						continue
+					}
+					if err := take(name.Name, declaredName{what: decl.Tok.String() + " " + name.Name, position: nodePosition(dec, spec)}); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+
+	for _, info := range infos {
+		name := info.OriginalIdentifier.Name
+		owner := declaredName{what: "function " + name, position: info.Position, renamed: !info.ServerToClient}
+		if err := take(name, owner); err != nil {
+			return err
+		}
+	}
+
+	// the receiver of --server-struct is a method of AgrowsServer
+	if opts.Mode == SERVER && !opts.ServerStruct {
+		receiver := []struct{ what, name string }{
+			{"receiver", opts.ReceiverName},
+			{"constant", "AgrowsReceiverName"},
+		}
+		for _, generated := range receiver {
+			// the name of a renamed function is free in the server
+			if first, ok := taken[generated.name]; ok && first.renamed {
+				continue
+			}
+			if err := take(generated.name, declaredName{what: generated.what + " " + generated.name, position: "the generated server"}); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, info := range infos {
+		name := info.OriginalIdentifier.Name
+		generated := func(what string, generatedName string) error {
+			return take(generatedName, declaredName{what: fmt.Sprintf("%s %s generated for %s", what, generatedName, name), position: info.Position})
+		}
+		if !info.ServerToClient {
+			serverName := fmt.Sprintf(opts.FunctionFormat, name)
+			// the name of another renamed function is free in the server
+			if first, ok := taken[serverName]; !ok || !first.renamed {
+				if err := generated("server function", serverName); err != nil {
+					return err
+				}
+			}
+			if err := generated("wrapper", fmt.Sprintf(opts.WrapperFormat, name)); err != nil {
+				return err
+			}
+		}
+		if err := generated("constant", funcNameConstant(info)); err != nil {
+			return err
+		}
+		if len(info.Params) > 0 {
+			if err := generated("request type", requestTypeName(info)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package gen
+
+import (
+	"fmt"
+	"go/build"
+	"go/build/constraint"
+	"slices"
+	"strings"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+)
+
+// parseTags returns the build tags satisfied when evaluating the //go:build
+// lines of declarations: opts.ParseTags if set, otherwise js and wasm for
+// CLIENT and the GOOS and GOARCH of the running toolchain for the other modes.
+// The compiler and the release tags like go1.21 are always satisfied.
+func parseTags(opts Options) map[string]bool {
+	tags := map[string]bool{build.Default.Compiler: true}
+	for _, tag := range build.Default.ReleaseTags {
+		tags[tag] = true
+	}
+	switch {
+	case opts.ParseTags != nil:
+		for _, tag := range opts.ParseTags {
+			tags[tag] = true
+		}
+	case opts.Mode == CLIENT:
+		tags["js"], tags["wasm"] = true, true
+	default:
+		tags[build.Default.GOOS], tags[build.Default.GOARCH] = true, true
+	}
+	return tags
+}
+
+// checkFileConstraint fails if the build constraint at the top of tree is not
+// satisfied by the tags of the server, e.g. for generator sources marked with
+// //go:build ignore. Their functions are never compiled, so code calling them
+// would not build.
+func checkFileConstraint(tree *dst.File, opts Options) error {
+	if opts.AllowIgnoredBuild {
+		return nil
+	}
+	// the input is compiled like the server, also when generating a client
+	opts.Mode = SERVER
+	tags := parseTags(opts)
+	for _, line := range tree.Decs.Start {
+		if !constraint.IsGoBuild(line) && !constraint.IsPlusBuild(line) {
+			continue
+		}
+		expr, err := constraint.Parse(line)
+		if err != nil {
+			return fmt.Errorf("invalid build constraint in input file %s: %w", opts.FileName, err)
+		}
+		if !expr.Eval(func(tag string) bool { return tags[tag] }) {
+			return fmt.Errorf("input file %s has build constraint '%s' and will not be compiled in the target build; use --allow-ignored-build to override", opts.FileName, line)
+		}
+	}
+	return nil
+}
+
+// checkTestPackage fails if tree belongs to an external test package, whose
+// functions can not be called from the generated code, unless test files are
+// allowed.
+func checkTestPackage(tree *dst.File, opts Options) error {
+	if opts.AllowTestFiles || !strings.HasSuffix(tree.Name.Name, "_test") {
+		return nil
+	}
+	return fmt.Errorf("input file %s is in test package %s, whose functions can not be called from non-test code; use --allow-test-files to override", opts.FileName, tree.Name.Name)
+}
+
+// filterConstrainedDecls removes the declarations of tree whose doc comment has
+// a //go:build line not satisfied by tags, so neither their functions nor their
+// types are extracted or kept in the output. The line is removed from the
+// remaining declarations, since it only constrains whole files for the go
+// tool.
+func filterConstrainedDecls(tree *dst.File, dec *decorator.Decorator, tags map[string]bool) error {
+	keep, err := constrainedDecls(tree, dec, tags)
+	if err != nil {
+		return err
+	}
+	applyConstrainedDecls(tree, keep)
+	return nil
+}
+
+// constrainedDecls reports for every declaration of tree whether its //go:build
+// line, if any, is satisfied by tags.
+func constrainedDecls(tree *dst.File, dec *decorator.Decorator, tags map[string]bool) ([]bool, error) {
+	keep := make([]bool, len(tree.Decls))
+	for i, decl := range tree.Decls {
+		keep[i] = true
+		for _, line := range decl.Decorations().Start {
+			if !constraint.IsGoBuild(line) {
+				continue
+			}
+			expr, err := constraint.Parse(line)
+			if err != nil {
+				return nil, fmt.Errorf("invalid build constraint at %s: %w", nodePosition(dec, decl), err)
+			}
+			keep[i] = keep[i] && expr.Eval(func(tag string) bool { return tags[tag] })
+		}
+	}
+	return keep, nil
+}
+
+// applyConstrainedDecls removes the declarations of tree not kept, as reported
+// by constrainedDecls, and the //go:build lines of the others.
+func applyConstrainedDecls(tree *dst.File, keep []bool) {
+	removed := false
+	decls := tree.Decls[:0]
+	for i, decl := range tree.Decls {
+		if !keep[i] {
+			removed = true
+			continue
+		}
+		decs := decl.Decorations()
+		decs.Start = slices.DeleteFunc(decs.Start, constraint.IsGoBuild)
+		decls = append(decls, decl)
+	}
+	tree.Decls = decls
+	if removed {
+		pruneUnusedImports(tree)
+	}
+}
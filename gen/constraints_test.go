@@ -0,0 +1,148 @@
+package gen
+
+import (
+	"bytes"
+	"go/build"
+	"strings"
+	"testing"
+)
+
+// constrainedSource has a function for the browser only and one for the
+// server only.
+const constrainedSource = `package api
+
+//go:build wasm
+func Browser() string {
+	return "browser"
+}
+
+//go:build !wasm
+func Export(path string) bool {
+	return path != ""
+}
+
+func Add(a, b int) int {
+	return a + b
+}
+`
+
+func TestBuildConstraints(t *testing.T) {
+	tests := []struct {
+		doc      string
+		opts     Options
+		included []string
+		excluded []string
+	}{
+		{"server", Options{}, []string{"func agrows_Export(", "func agrows_Add("}, []string{"Browser"}},
+		{"client", Options{Mode: CLIENT}, []string{"func BrowserWrapper(", "func AddWrapper("}, []string{"Export"}},
+		{"parse tags", Options{ParseTags: []string{"wasm"}}, []string{"func agrows_Browser(", "func agrows_Add("}, []string{"Export"}},
+	}
+	for _, test := range tests {
+		output := string(generate(t, constrainedSource, test.opts))
+		if strings.Contains(output, "//go:build wasm") || strings.Contains(output, "//go:build !wasm") {
+			t.Errorf("%s: the output still has the build constraint of a declaration:\n%s", test.doc, output)
+		}
+		for _, want := range test.included {
+			if !strings.Contains(output, want) {
+				t.Errorf("%s: the output has no %s:\n%s", test.doc, want, output)
+			}
+		}
+		for _, name := range test.excluded {
+			if strings.Contains(output, name) {
+				t.Errorf("%s: the output contains the excluded %s:\n%s", test.doc, name, output)
+			}
+		}
+	}
+
+	broken := strings.Replace(constrainedSource, "//go:build wasm", "//go:build (wasm", 1)
+	err := Generate(strings.NewReader(broken), Options{FileName: "api.go"}, &bytes.Buffer{})
+	if want := "invalid build constraint at api.go:4"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("got error %v, want one containing %q", err, want)
+	}
+}
+
+func TestVerifyBuildConstraints(t *testing.T) {
+	opts := Options{FileName: "api.go"}
+	server := generate(t, constrainedSource, opts)
+	mismatches, err := Verify(strings.NewReader(constrainedSource), bytes.NewReader(server), "agrows_server_api.go", opts)
+	if err != nil || len(mismatches) != 0 {
+		t.Errorf("got %v, %v, want the excluded Browser to be ignored", mismatches, err)
+	}
+}
+
+func TestFileConstraint(t *testing.T) {
+	tests := []struct {
+		name       string
+		constraint string
+		opts       Options
+		want       string
+	}{
+		{"ignore", "//go:build ignore", Options{}, "input file api.go has build constraint '//go:build ignore' and will not be compiled in the target build; use --allow-ignored-build to override"},
+		{"plus build", "// +build ignore", Options{}, "has build constraint '// +build ignore'"},
+		{"allowed", "//go:build ignore", Options{AllowIgnoredBuild: true}, ""},
+		{"satisfied", "//go:build " + build.Default.GOOS + " && !ignore", Options{}, ""},
+		// the input is compiled like the server, so js and wasm do not apply
+		{"client", "//go:build js && wasm", Options{Mode: CLIENT}, "has build constraint '//go:build js && wasm'"},
+		{"client not wasm", "//go:build !wasm", Options{Mode: CLIENT}, ""},
+		{"parse tags", "//go:build linux && arm", Options{ParseTags: []string{"linux", "arm"}}, ""},
+		{"invalid", "//go:build (", Options{}, "invalid build constraint in input file api.go"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			src := test.constraint + "\n\n" + apiSource
+			test.opts.FileName = "api.go"
+			err := Generate(strings.NewReader(src), test.opts, &bytes.Buffer{})
+			if test.want == "" {
+				if err != nil {
+					t.Fatalf("Generate failed: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("got error %v, want one containing %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestTestPackage(t *testing.T) {
+	src := strings.Replace(apiSource, "package api", "package api_test", 1)
+	err := Generate(strings.NewReader(src), Options{FileName: "api.go"}, &bytes.Buffer{})
+	if want := "input file api.go is in test package api_test"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("got error %v, want one containing %q", err, want)
+	}
+	var server, client bytes.Buffer
+	err = GenerateServerAndClient(strings.NewReader(src), Options{FileName: "api.go"}, &server, &client)
+	if err == nil || !strings.Contains(err.Error(), "use --allow-test-files") {
+		t.Errorf("got error %v from GenerateServerAndClient, want the test package refused", err)
+	}
+	generate(t, src, Options{AllowTestFiles: true})
+}
+
+func TestConstrainedDecls(t *testing.T) {
+	names := func(opts Options) string {
+		t.Helper()
+		opts.FileName = "api.go"
+		input, skipped, err := ListFiles([]SourceFile{{Name: "api.go", Src: strings.NewReader(constrainedSource)}}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, info := range input.Functions {
+			names = append(names, info.OriginalIdentifier.Name)
+		}
+		for _, fn := range skipped {
+			if fn.Reason != "left out by its //go:build line" {
+				t.Errorf("%s is skipped because it is %s", fn.Name, fn.Reason)
+			}
+			names = append(names, "-"+fn.Name)
+		}
+		return strings.Join(names, " ")
+	}
+	if got, want := names(Options{}), "Export Add -Browser"; got != want {
+		t.Errorf("server: got %s, want %s", got, want)
+	}
+	if got, want := names(Options{Mode: CLIENT}), "Browser Add -Export"; got != want {
+		t.Errorf("client: got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,143 @@
+package gen
+
+import (
+	"fmt"
+	"go/token"
+	"strconv"
+	"strings"
+
+	"github.com/dave/dst"
+	"github.com/dave/jennifer/jen"
+)
+
+// errorCode is an error of the input named by --error-unwrap and the code of
+// the AgrowsError it is mapped to.
+type errorCode struct {
+	name string
+	code int
+}
+
+// errorTarget is an errorCode resolved in the input. Types are matched with
+// errors.As, through a pointer if their Error method has a pointer receiver,
+// and variables such as sentinel errors with errors.Is.
+type errorTarget struct {
+	errorCode
+	isType  bool
+	pointer bool
+}
+
+// parseErrorCodes parses the Name:code entries of --error-unwrap, e.g.
+// "ErrNotFound:404".
+func parseErrorCodes(entries []string) ([]errorCode, error) {
+	var codes []errorCode
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		name, codeText, ok := strings.Cut(entry, ":")
+		if !ok || !token.IsIdentifier(name) {
+			return nil, fmt.Errorf("error mapping '%s' is not of the form Name:code, e.g. ErrNotFound:404", entry)
+		}
+		code, err := strconv.Atoi(codeText)
+		if err != nil || code <= 0 {
+			return nil, fmt.Errorf("error mapping '%s' needs a positive code", entry)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("error %s is mapped more than once", name)
+		}
+		seen[name] = true
+		codes = append(codes, errorCode{name: name, code: code})
+	}
+	return codes, nil
+}
+
+// resolveErrorCodes looks up the errors of --error-unwrap among the types and
+// variables declared in tree.
+func (opts *Options) resolveErrorCodes(tree *dst.File) ([]errorTarget, error) {
+	types := make(map[string]*dst.TypeSpec)
+	vars := make(map[string]bool)
+	for _, decl := range tree.Decls {
+		genDecl, ok := decl.(*dst.GenDecl)
+		if !ok {
+			continue
+		}
+		for _, spec := range genDecl.Specs {
+			switch spec := spec.(type) {
+			case *dst.TypeSpec:
+				types[spec.Name.Name] = spec
+			case *dst.ValueSpec:
+				if genDecl.Tok == token.VAR {
+					for _, name := range spec.Names {
+						vars[name.Name] = true
+					}
+				}
+			}
+		}
+	}
+
+	targets := make([]errorTarget, 0, len(opts.errorCodes))
+	for _, errorCode := range opts.errorCodes {
+		target := errorTarget{errorCode: errorCode}
+		if spec, ok := types[errorCode.name]; ok {
+			target.isType = true
+			if _, isInterface := spec.Type.(*dst.InterfaceType); !isInterface {
+				pointer, found := errorMethodReceiver(tree, errorCode.name)
+				if !found {
+					return nil, fmt.Errorf("type %s named by --error-unwrap has no Error method", errorCode.name)
+				}
+				target.pointer = pointer
+			}
+		} else if !vars[errorCode.name] {
+			return nil, fmt.Errorf("--error-unwrap names %s, which is neither a type nor a variable of the input", errorCode.name)
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// errorMethodReceiver reports whether typeName declares an Error method in
+// tree and whether its receiver is a pointer.
+func errorMethodReceiver(tree *dst.File, typeName string) (pointer bool, found bool) {
+	for _, decl := range tree.Decls {
+		if fn, ok := decl.(*dst.FuncDecl); ok && fn.Name.Name == "Error" && receiverTypeName(fn) == typeName {
+			_, pointer = fn.Recv.List[0].Type.(*dst.StarExpr)
+			return pointer, true
+		}
+	}
+	return false, false
+}
+
+// generateMapErrorCall emits err as returned by a dispatched function, mapped
+// by agrowsMapError with --error-unwrap.
+func (opts *Options) generateMapErrorCall(err jen.Code) jen.Code {
+	if len(opts.errorCodes) == 0 {
+		return err
+	}
+	return jen.Id("agrowsMapError").Call(err)
+}
+
+// generateErrorMapper emits agrowsMapError, replacing the errors of targets by
+// an AgrowsError with their code. The first matching target wins.
+func generateErrorMapper(targets []errorTarget) *jen.Statement {
+	return jen.Comment("agrowsMapError replaces the errors returned by the functions which match an").Line().
+		Comment("error of --error-unwrap by an AgrowsError with its code, so callers can tell them").Line().
+		Comment("apart without parsing the message. Other errors are returned as they are.").Line().
+		Func().Id("agrowsMapError").Params(jen.Err().Error()).Error().BlockFunc(func(g *jen.Group) {
+		for i, target := range targets {
+			mapped := jen.Return(jen.Op("&").Id("AgrowsError").Values(jen.Dict{
+				jen.Id("Code"):    jen.Lit(target.code),
+				jen.Id("Message"): jen.Err().Dot("Error").Call(),
+			}))
+			if !target.isType {
+				g.If(jen.Qual("errors", "Is").Call(jen.Err(), jen.Id(target.name))).Block(mapped)
+				continue
+			}
+			targetName := fmt.Sprintf("target%d", i)
+			targetType := jen.Id(target.name)
+			if target.pointer {
+				targetType = jen.Op("*").Id(target.name)
+			}
+			g.Var().Id(targetName).Add(targetType)
+			g.If(jen.Qual("errors", "As").Call(jen.Err(), jen.Op("&").Id(targetName))).Block(mapped)
+		}
+		g.Return(jen.Err())
+	}).Line()
+}
@@ -0,0 +1,145 @@
+package gen
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// errorsSource has functions returning sentinel errors and errors of types
+// with pointer and value receivers, plain or wrapped.
+const errorsSource = `package api
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrGone         = errors.New("gone")
+)
+
+type NotFoundError struct{ ID int }
+
+func (e *NotFoundError) Error() string { return fmt.Sprintf("%d not found", e.ID) }
+
+type ValueError struct{ Field string }
+
+func (e ValueError) Error() string { return "invalid " + e.Field }
+
+type Plain struct{}
+
+func Find(kind string) (string, error) {
+	switch kind {
+	case "sentinel":
+		return "", ErrUnauthorized
+	case "wrapped":
+		return "", fmt.Errorf("loading: %w", ErrUnauthorized)
+	case "pointer":
+		return "", &NotFoundError{ID: 7}
+	case "value":
+		return "", ValueError{Field: "name"}
+	case "both":
+		return "", fmt.Errorf("%w: %w", ErrGone, &NotFoundError{ID: 1})
+	case "other":
+		return "", errors.New("other")
+	}
+	return "found", nil
+}
+
+func Delete(kind string) error {
+	_, err := Find(kind)
+	return err
+}
+`
+
+// errorsTest runs in the generated server of errorsSource.
+const errorsTest = `package api
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/codeupdateandmodificationsystem/protocol"
+)
+
+func TestErrorUnwrap(t *testing.T) {
+	tests := []struct {
+		kind    string
+		code    int
+		message string
+	}{
+		{"sentinel", 401, "unauthorized"},
+		{"wrapped", 401, "loading: unauthorized"},
+		{"pointer", 404, "7 not found"},
+		{"value", 422, "invalid name"},
+		// NotFoundError is mapped before ErrGone
+		{"both", 404, "gone: 1 not found"},
+		{"other", 0, "other"},
+		{"", 0, ""},
+	}
+	for _, fn := range []string{"Find", "Delete"} {
+		for _, test := range tests {
+			data, err := protocol.EncodeFunctionCall(fn, protocol.Options(), map[string]any{"kind": test.kind})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = AgrowsReceive(data)
+			if test.message == "" {
+				if err != nil {
+					t.Errorf("%s(%q) failed: %v", fn, test.kind, err)
+				}
+				continue
+			}
+			var agrowsErr *AgrowsError
+			if !errors.As(err, &agrowsErr) {
+				if test.code != 0 || err == nil || err.Error() != test.message {
+					t.Errorf("%s(%q) returned %#v, want an AgrowsError with code %d", fn, test.kind, err, test.code)
+				}
+				continue
+			}
+			if agrowsErr.Code != test.code || agrowsErr.Message != test.message {
+				t.Errorf("%s(%q) returned code %d and message %q, want %d and %q", fn, test.kind, agrowsErr.Code, agrowsErr.Message, test.code, test.message)
+			}
+		}
+	}
+}
+`
+
+func TestErrorUnwrap(t *testing.T) {
+	dir := newTestModule(t)
+	opts := Options{ErrorUnwrap: []string{"ErrUnauthorized:401", "NotFoundError:404", "ValueError:422", "ErrGone:410"}}
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api.go"), generate(t, errorsSource, opts))
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api_test.go"), errorsTest)
+	mustRunGo(t, dir, nil, "test", "./server")
+}
+
+func TestErrorUnwrapErrors(t *testing.T) {
+	tests := []struct {
+		entry string
+		want  string
+	}{
+		{"ErrGone", "error mapping 'ErrGone' is not of the form Name:code"},
+		{"Err-Gone:410", "is not of the form Name:code"},
+		{"ErrGone:0", "error mapping 'ErrGone:0' needs a positive code"},
+		{"ErrGone:gone", "needs a positive code"},
+		{"Missing:404", "--error-unwrap names Missing, which is neither a type nor a variable of the input"},
+		{"Plain:400", "type Plain named by --error-unwrap has no Error method"},
+	}
+	for _, test := range tests {
+		t.Run(test.entry, func(t *testing.T) {
+			opts := Options{FileName: "api.go", ErrorUnwrap: []string{test.entry}}
+			err := Generate(strings.NewReader(errorsSource), opts, &bytes.Buffer{})
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("got error %v, want one containing %q", err, test.want)
+			}
+		})
+	}
+
+	opts := Options{FileName: "api.go", ErrorUnwrap: []string{"ErrGone:410", "ErrGone:404"}}
+	if err := Generate(strings.NewReader(errorsSource), opts, &bytes.Buffer{}); err == nil || !strings.Contains(err.Error(), "error ErrGone is mapped more than once") {
+		t.Errorf("got error %v for a duplicate mapping", err)
+	}
+}
@@ -0,0 +1,156 @@
+package gen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"path/filepath"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+)
+
+// SourceFile is one of the Go files of a package an output is generated from.
+type SourceFile struct {
+	// Name names the file in positions of errors and in the output header.
+	Name string
+	Src  io.Reader
+}
+
+// parseSources parses files into a single tree, as if the package was declared
+// in one file. With check, a file whose build constraint excludes it or which
+// is in a test package fails, see checkFileConstraint and checkTestPackage.
+func parseSources(files []SourceFile, opts Options, check bool) (*dst.File, *decorator.Decorator, error) {
+	if len(files) == 0 {
+		return nil, nil, fmt.Errorf("no input files")
+	}
+	// a single decorator keeps the positions of all files apart
+	dec := decorator.NewDecorator(token.NewFileSet())
+	trees := make([]*dst.File, len(files))
+	for i, file := range files {
+		tree, err := dec.ParseFile(file.Name, file.Src, parser.ParseComments)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse file: %w", err)
+		}
+		if check {
+			fileOpts := opts
+			fileOpts.FileName = file.Name
+			if err := checkFileConstraint(tree, fileOpts); err != nil {
+				return nil, nil, err
+			}
+			if err := checkTestPackage(tree, fileOpts); err != nil {
+				return nil, nil, err
+			}
+		}
+		trees[i] = tree
+	}
+	if len(trees) == 1 {
+		return trees[0], dec, nil
+	}
+	tree, err := mergeTrees(trees, files, dec)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tree, dec, nil
+}
+
+// mergeTrees merges the trees of the files of a package into the first one.
+// Their imports are combined into a single declaration, followed by the other
+// declarations in the order of the files. Identifiers referring to a
+// declaration of another file are resolved to its object, so renaming and
+// removing declarations work across the files.
+func mergeTrees(trees []*dst.File, files []SourceFile, dec *decorator.Decorator) (*dst.File, error) {
+	merged := trees[0]
+	imports := &dst.GenDecl{Tok: token.IMPORT, Lparen: true, Rparen: true}
+	importPaths := make(map[string]string)
+	importFiles := make(map[string]string)
+	var decls []dst.Decl
+	for i, tree := range trees {
+		if tree.Name.Name != merged.Name.Name {
+			return nil, fmt.Errorf("input files %s and %s are in different packages %s and %s", files[0].Name, files[i].Name, merged.Name.Name, tree.Name.Name)
+		}
+		first := true
+		for _, decl := range tree.Decls {
+			genDecl, ok := decl.(*dst.GenDecl)
+			if !ok || genDecl.Tok != token.IMPORT {
+				if first && i > 0 {
+					// separates the files
+					decl.Decorations().Before = dst.EmptyLine
+				}
+				first = false
+				decls = append(decls, decl)
+				continue
+			}
+			for _, spec := range genDecl.Specs {
+				importSpec := spec.(*dst.ImportSpec)
+				name := importName(importSpec)
+				key := name
+				if name == "_" || name == "." {
+					key = name + " " + importSpec.Path.Value
+				}
+				if path, ok := importPaths[key]; ok {
+					if path != importSpec.Path.Value {
+						return nil, fmt.Errorf("import name %s refers to %s in %s and to %s in %s, rename one of them", name, path, importFiles[key], importSpec.Path.Value, files[i].Name)
+					}
+					continue
+				}
+				importPaths[key], importFiles[key] = importSpec.Path.Value, files[i].Name
+				importSpec.Decs.Before, importSpec.Decs.After = dst.NewLine, dst.NewLine
+				imports.Specs = append(imports.Specs, importSpec)
+			}
+		}
+	}
+	merged.Imports = nil
+	merged.Decls = nil
+	if len(imports.Specs) > 0 {
+		imports.Decs.Before = dst.EmptyLine
+		merged.Decls = append(merged.Decls, imports)
+		for _, spec := range imports.Specs {
+			merged.Imports = append(merged.Imports, spec.(*dst.ImportSpec))
+		}
+	}
+	merged.Decls = append(merged.Decls, decls...)
+
+	// the parser resolves identifiers only within their file
+	for i, tree := range trees {
+		astFile, ok := dec.Ast.Nodes[tree].(*ast.File)
+		if !ok {
+			continue
+		}
+		for _, unresolved := range astFile.Unresolved {
+			ident, ok := dec.Dst.Nodes[unresolved].(*dst.Ident)
+			if !ok {
+				continue
+			}
+			for j, other := range trees {
+				if obj := other.Scope.Lookup(ident.Name); j != i && obj != nil {
+					ident.Obj = obj
+					break
+				}
+			}
+		}
+	}
+	for _, tree := range trees[1:] {
+		for name, obj := range tree.Scope.Objects {
+			if merged.Scope.Lookup(name) == nil {
+				merged.Scope.Insert(obj)
+			}
+		}
+	}
+	return merged, nil
+}
+
+// sourceFileNames returns the base names of files, listed in the header of an
+// output generated from several files.
+func sourceFileNames(files []SourceFile) []string {
+	if len(files) < 2 {
+		return nil
+	}
+	names := make([]string, len(files))
+	for i, file := range files {
+		names[i] = filepath.Base(file.Name)
+	}
+	return names
+}
@@ -0,0 +1,101 @@
+package gen
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// usersFile declares the types and a function calling a helper of
+// handlersFile.
+const usersFile = `package api
+
+import "strings"
+
+type User struct {
+	Name string
+}
+
+func Greet(user User) string {
+	return greeting() + strings.ToUpper(user.Name)
+}
+`
+
+// handlersFile refers to Greet of usersFile, which the server renames.
+const handlersFile = `package api
+
+import "strings"
+
+var handler = Greet
+
+func greeting() string {
+	return strings.Repeat("hi ", 1)
+}
+
+func Welcome(name string) string {
+	return handler(User{Name: name})
+}
+`
+
+// filesTest runs in the server generated from usersFile and handlersFile.
+const filesTest = `package api
+
+import "testing"
+
+func TestFiles(t *testing.T) {
+	if got := agrows_Welcome("ada"); got != "hi ADA" {
+		t.Errorf("Welcome returned %q", got)
+	}
+}
+`
+
+func sourceFiles(sources ...string) []SourceFile {
+	files := make([]SourceFile, len(sources))
+	for i, src := range sources {
+		files[i] = SourceFile{Name: []string{"users.go", "handlers.go", "other.go"}[i], Src: strings.NewReader(src)}
+	}
+	return files
+}
+
+func TestGenerateFiles(t *testing.T) {
+	var server bytes.Buffer
+	if err := GenerateFiles(sourceFiles(usersFile, handlersFile), Options{}, &server); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"users.go", "handlers.go", "var handler = agrows_Greet", "func agrows_Welcome("} {
+		if !strings.Contains(server.String(), want) {
+			t.Errorf("the server has no %s:\n%s", want, server.String())
+		}
+	}
+	if strings.Count(server.String(), `"strings"`) != 1 {
+		t.Errorf("the imports of the files are not combined:\n%s", server.String())
+	}
+
+	dir := newTestModule(t)
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api.go"), server.Bytes())
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api_test.go"), filesTest)
+	mustRunGo(t, dir, nil, "test", "./server")
+
+	input, err := ParseFiles(sourceFiles(usersFile, handlersFile), "api")
+	if err != nil || len(input.Functions) != 2 {
+		t.Errorf("got %d functions, %v, want Greet and Welcome", len(input.Functions), err)
+	}
+}
+
+func TestGenerateFilesErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		other string
+		want  string
+	}{
+		{"package", "package other\n", "input files users.go and other.go are in different packages api and other"},
+		{"import", "package api\n\nimport strings \"example.com/strings\"\n\nvar _ = strings.X\n", `import name strings refers to "strings" in users.go and to "example.com/strings" in other.go`},
+	}
+	for _, test := range tests {
+		err := GenerateFiles(sourceFiles(usersFile, handlersFile, test.other), Options{}, &bytes.Buffer{})
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want one containing %q", test.name, err, test.want)
+		}
+	}
+}
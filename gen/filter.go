@@ -0,0 +1,175 @@
+package gen
+
+import (
+	"fmt"
+	"go/token"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+)
+
+// compileFilter compiles the names or regular expressions of --only or
+// --exclude, named by flag in errors. They must match a whole function name, so
+// a plain name only matches the function of that name.
+func compileFilter(flag string, patterns []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		if pattern == "" {
+			return nil, fmt.Errorf("empty pattern in %s", flag)
+		}
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern '%s' in %s: %w", pattern, flag, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// matchesAny reports whether name is matched by one of patterns.
+func matchesAny(patterns []*regexp.Regexp, name string) bool {
+	for _, re := range patterns {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// wraps reports whether the exported function name is exposed as an RPC
+// endpoint by --only and --exclude.
+func (opts *Options) wraps(name string) bool {
+	if len(opts.only) > 0 && !matchesAny(opts.only, name) {
+		return false
+	}
+	return !matchesAny(opts.exclude, name)
+}
+
+// checkOnlyMatches fails for a pattern of --only matching none of the exported
+// functions of node, which usually is a typo.
+func (opts *Options) checkOnlyMatches(node *dst.File) error {
+	for i, re := range opts.only {
+		found := false
+		for _, decl := range node.Decls {
+			if fn, ok := decl.(*dst.FuncDecl); ok && fn.Recv == nil && fn.Name.IsExported() && re.MatchString(fn.Name.Name) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("--only names no exported function: %s", opts.Only[i])
+		}
+	}
+	return nil
+}
+
+// interfaceMethods are the methods of interfaces of the standard library, e.g.
+// error or fmt.Stringer, which no one expects to become RPC functions.
+var interfaceMethods = map[string]bool{
+	"Error": true, "Format": true, "GoString": true, "String": true, "Unwrap": true,
+	"Is": true, "As": true, "MarshalJSON": true, "UnmarshalJSON": true,
+	"MarshalText": true, "UnmarshalText": true, "MarshalBinary": true,
+	"UnmarshalBinary": true, "Scan": true, "Value": true, "Len": true, "Less": true,
+	"Swap": true, "Read": true, "Write": true, "Close": true, "ServeHTTP": true,
+}
+
+// skippedMethods returns the exported methods of node as Type.Method, see
+// isSkippedMethod.
+func (opts *Options) skippedMethods(node *dst.File) []string {
+	var methods []string
+	for _, decl := range node.Decls {
+		if fn, ok := decl.(*dst.FuncDecl); ok && opts.isSkippedMethod(fn) {
+			methods = append(methods, receiverTypeName(fn)+"."+fn.Name.Name)
+		}
+	}
+	return methods
+}
+
+// isSkippedMethod reports whether fn is an exported method looking like an RPC
+// function. Only plain functions are RPC functions, so it is never exposed,
+// unlike an exported function. Methods with an ignore directive, left out by
+// --only and --exclude or of interfaceMethods are not reported.
+func (opts *Options) isSkippedMethod(fn *dst.FuncDecl) bool {
+	if fn.Recv == nil || !fn.Name.IsExported() || interfaceMethods[fn.Name.Name] || !opts.wraps(fn.Name.Name) {
+		return false
+	}
+	ignored, _, _ := exposeDirectives(fn)
+	return !ignored
+}
+
+// ignoreDirective keeps an exported function from being exposed as an RPC
+// function, e.g. because it is exported for other packages.
+const ignoreDirective = "//agrows:ignore"
+
+// exposeDirective exposes an unexported function as an RPC function, called by
+// the exported name following it or else by its name capitalized, e.g.
+// "//agrows:expose" on listUsers or "//agrows:expose Users".
+const exposeDirective = "//agrows:expose"
+
+// exposeDirectives reads the ignore and expose directives of fn. exposed is
+// the name fn is called by if it is exposed, empty otherwise.
+func exposeDirectives(fn *dst.FuncDecl) (ignored bool, exposed string, err error) {
+	expose := false
+	for _, line := range extractDocComment(fn) {
+		line = strings.TrimSpace(line)
+		if line == ignoreDirective {
+			ignored = true
+			continue
+		}
+		value, ok := strings.CutPrefix(line, exposeDirective)
+		if !ok || (value != "" && value[0] != ' ' && value[0] != '\t') {
+			continue
+		}
+		name := strings.TrimSpace(value)
+		if name == "" {
+			name = capitalize(fn.Name.Name)
+		}
+		if !token.IsIdentifier(name) || !token.IsExported(name) {
+			return false, "", fmt.Errorf("%s needs an exported name to call %s by, got '%s'", exposeDirective, fn.Name.Name, name)
+		}
+		if expose && name != exposed {
+			return false, "", fmt.Errorf("%s of %s names both %s and %s", exposeDirective, fn.Name.Name, exposed, name)
+		}
+		expose, exposed = true, name
+	}
+	if ignored && expose {
+		return false, "", fmt.Errorf("conflicting %s and %s directives of %s", ignoreDirective, exposeDirective, fn.Name.Name)
+	}
+	// exported functions are called by their own name, exposed or not
+	if expose && fn.Name.IsExported() && exposed != fn.Name.Name {
+		return false, "", fmt.Errorf("exported function %s can not be exposed as %s", fn.Name.Name, exposed)
+	}
+	return ignored, exposed, nil
+}
+
+// checkExposeDirectives fails for the invalid or conflicting ignore and expose
+// directives of the functions of node, naming their position.
+func checkExposeDirectives(node *dst.File, dec *decorator.Decorator) error {
+	for _, decl := range node.Decls {
+		if fn, ok := decl.(*dst.FuncDecl); ok && fn.Recv == nil {
+			if _, _, err := exposeDirectives(fn); err != nil {
+				return fmt.Errorf("invalid directive at %s: %w", nodePosition(dec, fn), err)
+			}
+		}
+	}
+	return nil
+}
+
+// rpcName returns the name the RPC function fn is called by: the name of its
+// expose directive or its own.
+func rpcName(fn *dst.FuncDecl) string {
+	if _, exposed, _ := exposeDirectives(fn); exposed != "" {
+		return exposed
+	}
+	return fn.Name.Name
+}
+
+// capitalize upper-cases the first letter of name.
+func capitalize(name string) string {
+	r, size := utf8.DecodeRuneInString(name)
+	return string(unicode.ToUpper(r)) + name[size:]
+}
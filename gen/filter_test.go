@@ -0,0 +1,224 @@
+package gen
+
+import (
+	"bytes"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFilters(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    Options
+		wrapped []string
+	}{
+		{"all", Options{}, []string{"Greet", "Add", "Reset"}},
+		{"only", Options{Only: []string{"Greet", "A.*"}}, []string{"Greet", "Add"}},
+		{"exclude", Options{Exclude: []string{"Res.*"}}, []string{"Greet", "Add"}},
+		{"only and exclude", Options{Only: []string{"Greet|Add"}, Exclude: []string{"Add"}}, []string{"Greet"}},
+		{"whole names", Options{Exclude: []string{"Gree", "dd"}}, []string{"Greet", "Add", "Reset"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := string(generate(t, apiSource, test.opts))
+			for _, name := range []string{"Greet", "Add", "Reset"} {
+				want := strings.Contains(strings.Join(test.wrapped, ","), name)
+				if got := strings.Contains(server, "func agrows_"+name+"("); got != want {
+					t.Errorf("%s renamed: %t, want %t", name, got, want)
+				}
+				if got := strings.Contains(server, "func "+name+"("); got == want {
+					t.Errorf("%s kept as it is: %t, want %t", name, got, !want)
+				}
+			}
+		})
+	}
+}
+
+func TestFilterErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{"no match", Options{Only: []string{"Greet", "Sub"}}, "--only names no exported function: Sub"},
+		{"invalid pattern", Options{Exclude: []string{"Add("}}, "invalid pattern 'Add(' in exclude"},
+		{"empty pattern", Options{Only: []string{""}}, "empty pattern in only"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.opts.FileName = "api.go"
+			err := Generate(strings.NewReader(apiSource), test.opts, &bytes.Buffer{})
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("got error %v, want one containing %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestFiltersOfVerifyAndBreakingChanges(t *testing.T) {
+	opts := Options{FileName: "api.go", Exclude: []string{"Reset"}}
+	server := generate(t, apiSource, opts)
+	mismatches, err := Verify(strings.NewReader(apiSource), bytes.NewReader(server), "agrows_server_api.go", opts)
+	if err != nil || len(mismatches) != 0 {
+		t.Errorf("got %v, %v for a server with an excluded function", mismatches, err)
+	}
+
+	input, err := Parse(strings.NewReader(apiSource), "api.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := generate(t, apiSource, Options{})
+	changes, err := BreakingChanges(bytes.NewReader(previous), "agrows_server_api.go", input, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, change := range changes {
+		got = append(got, change.String())
+	}
+	if want := []string{"Reset: removed"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got changes %q, want %q", got, want)
+	}
+}
+
+// directivesSource has an ignored exported function and exposed unexported
+// ones, one of them with a name of its own.
+const directivesSource = `package api
+
+// Normalize is used by other packages.
+//
+//agrows:ignore
+func Normalize(name string) string {
+	return name
+}
+
+//agrows:expose
+func listUsers() int {
+	return 0
+}
+
+//agrows:expose Count
+func countWords(text string) int {
+	return len(text)
+}
+
+func Ping() {}
+`
+
+func TestDirectives(t *testing.T) {
+	for _, opts := range []Options{{}, {Only: []string{"Ping"}}, {Exclude: []string{"Ping|Normalize"}}} {
+		server := string(generate(t, directivesSource, opts))
+		for _, want := range []string{"func Normalize(", "func agrows_ListUsers(", "func agrows_Count(", "AgrowsFunc_Count"} {
+			if !strings.Contains(server, want) {
+				t.Errorf("with %+v the server has no %s:\n%s", opts, want, server)
+			}
+		}
+		if strings.Contains(server, "agrows_Normalize") {
+			t.Errorf("with %+v the server renamed the ignored Normalize:\n%s", opts, server)
+		}
+	}
+	server := generate(t, directivesSource, Options{})
+	mismatches, err := Verify(strings.NewReader(directivesSource), bytes.NewReader(server), "agrows_server_api.go", Options{FileName: "api.go"})
+	if err != nil || len(mismatches) != 0 {
+		t.Errorf("got %v, %v for the server of exposed functions", mismatches, err)
+	}
+
+	client := string(generate(t, directivesSource, Options{Mode: CLIENT}))
+	for _, want := range []string{`global.Set("ListUsers"`, `global.Set("Count"`} {
+		if !strings.Contains(client, want) {
+			t.Errorf("the client has no %s:\n%s", want, client)
+		}
+	}
+}
+
+func TestDirectiveErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"conflict", "package api\n\n//agrows:ignore\n//agrows:expose\nfunc count() int { return 0 }\n", "invalid directive at api.go:5: conflicting //agrows:ignore and //agrows:expose directives of count"},
+		{"unexported name", "package api\n\n//agrows:expose total\nfunc count() int { return 0 }\n", "needs an exported name to call count by, got 'total'"},
+		{"renamed exported", "package api\n\n//agrows:expose Total\nfunc Count() int { return 0 }\n", "exported function Count can not be exposed as Total"},
+		{"server-to-client", "package api\n\n//agrows:expose\n//agrows:direction server-to-client\nfunc notify(text string) {}\n", "server-to-client function notify at api.go:5 can not be exposed"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Generate(strings.NewReader(test.src), Options{FileName: "api.go"}, &bytes.Buffer{})
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("got error %v, want one containing %q", err, test.want)
+			}
+		})
+	}
+}
+
+// methodsSource has exported methods next to an ignored one, one of
+// fmt.Stringer and an unexported one.
+const methodsSource = `package api
+
+type Store struct{}
+
+func (s *Store) Save(name string) error { return nil }
+
+func (s Store) Load() string { return "" }
+
+//agrows:ignore
+func (s Store) Reset() {}
+
+func (s Store) String() string { return "store" }
+
+func (s Store) reset() {}
+
+func Count() int { return 0 }
+`
+
+func TestSkippedMethods(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		want []string
+	}{
+		{"all", Options{}, []string{"Store.Save", "Store.Load"}},
+		{"exclude", Options{Exclude: []string{"Load"}}, []string{"Store.Save"}},
+		{"only", Options{Only: []string{"Count|Save"}}, []string{"Store.Save"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.opts.FileName = "api.go"
+			input, skipped, err := ListFiles([]SourceFile{{Name: "api.go", Src: strings.NewReader(methodsSource)}}, test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(input.Functions) != 1 || input.Functions[0].OriginalIdentifier.Name != "Count" {
+				t.Errorf("got RPC functions %v, want only Count", input.Functions)
+			}
+			var methods []string
+			for _, fn := range skipped {
+				if fn.Reason == "a method, only plain functions are RPC functions" {
+					methods = append(methods, fn.Name)
+				}
+			}
+			if !slices.Equal(methods, test.want) {
+				t.Errorf("got listed methods %v, want %v", methods, test.want)
+			}
+
+			if err := test.opts.check(); err != nil {
+				t.Fatal(err)
+			}
+			tree, _, err := parseFileToTree(strings.NewReader(methodsSource), "api.go")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := test.opts.skippedMethods(tree); !slices.Equal(got, test.want) {
+				t.Errorf("got skipped methods %v, want %v", got, test.want)
+			}
+		})
+	}
+
+	server := string(generate(t, methodsSource, Options{}))
+	if strings.Contains(server, "agrows_Save") || !strings.Contains(server, "func agrows_Count(") {
+		t.Errorf("got server with other RPC functions than Count:\n%s", server)
+	}
+}
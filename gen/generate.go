@@ -0,0 +1,870 @@
+package gen
+
+import (
+	"fmt"
+	"go/build/constraint"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"github.com/dave/jennifer/jen"
+	log "github.com/dikkadev/dnutlogger"
+	"github.com/samber/lo"
+)
+
+// Options configures Generate. Most fields correspond to a flag of the agrows
+// command. Empty fields take their documented defaults, so the zero value
+// generates a plain server in the package of the input, renaming the original
+// functions to agrows_<Func>.
+type Options struct {
+	// Mode is the kind of code generated: SERVER (the default), CLIENT,
+	// GOCLIENT, MOCK or TESTHELPERS.
+	Mode byte
+	// FileName names the input in positions of errors and in the output.
+	FileName string
+	// PackageName is the package of the output. Empty means the package of
+	// the input, or main for CLIENT.
+	PackageName string
+	// BuildTags is the build constraint of the output, e.g. "linux && amd64".
+	// Empty means "js && wasm && client" for CLIENT and none otherwise.
+	BuildTags string
+	// NoPlusBuild leaves out the // +build lines repeating BuildTags for
+	// toolchains before Go 1.17.
+	NoPlusBuild bool
+	// ParseTags are the build tags satisfied by //go:build lines in the doc
+	// comments of declarations, which are left out if theirs is not. Nil means
+	// js and wasm for CLIENT and the GOOS and GOARCH of the toolchain otherwise.
+	ParseTags []string
+	// ProtocolPath is the import path of the protocol package used by the
+	// generated code. Empty means the agrows protocol package.
+	ProtocolPath string
+	// FunctionFormat names the original functions kept in the server, %s is
+	// replaced by the function name. Empty means "agrows_%s".
+	FunctionFormat string
+	// ReceiverName names the function of the server decoding the calls and
+	// dispatching them to the functions. Empty means "AgrowsReceive".
+	ReceiverName string
+	// Strict fails instead of warning when the input has no exported
+	// functions.
+	Strict bool
+	// AllowIgnoredBuild generates from inputs whose build constraint is not
+	// satisfied by the tags of the server, e.g. //go:build ignore.
+	AllowIgnoredBuild bool
+	// AllowTestFiles generates from inputs in a _test package.
+	AllowTestFiles bool
+	// Compress enables compression in the protocol.
+	Compress bool
+	// Serialize is how the client encodes its calls to the server: "json"
+	// through the protocol package, or "msgpack" or "cbor" with MessagePack
+	// or CBOR, bypassing it. Empty means "json".
+	Serialize string
+	// ResponseFormat is how the server encodes the responses settling the calls
+	// of the client: "call" as a call of agrows_response in the protocol, or
+	// "json" as a JSON object with the fields id, result and error. Empty
+	// means "call".
+	ResponseFormat string
+	// WrapperFormat names the JavaScript wrappers of the client, %s is replaced
+	// by the function name. Empty means "%sWrapper".
+	WrapperFormat string
+	// Only and Exclude select the exported functions exposed as RPC endpoints
+	// by their names or regular expressions matching whole names. With Only,
+	// only the functions matched by one of its patterns are, and none matched
+	// by one of Exclude are. The others are kept like unexported functions.
+	Only    []string
+	Exclude []string
+	// NoLint lists the linters silenced by a //nolint comment above every
+	// generated function, "all" also silences the whole file.
+	NoLint []string
+	// Header replaces the comment at the top of the Go outputs. Lines which
+	// are no comments are commented out, and a "Code generated by agrows. DO
+	// NOT EDIT." line is added unless Header contains one. NoHeader leaves out
+	// the comment entirely, so the outputs are no longer recognized as
+	// generated.
+	Header   string
+	NoHeader bool
+	// Timestamp adds the date and time of generation to the header, taken
+	// from the SOURCE_DATE_EPOCH environment variable if it is set. Without
+	// it, the same input always yields the same output.
+	Timestamp bool
+	// Quiet leaves out the registration messages of the client.
+	Quiet bool
+
+	// WebSocket, HTTP, GRPC and GraphQL add a handler serving AgrowsReceive
+	// over the respective transport to the server.
+	WebSocket bool
+	HTTP      bool
+	GRPC      bool
+	GraphQL   bool
+	// GRPCPackage is the import path of the directory the protobuf code is
+	// generated in, required with GRPC.
+	GRPCPackage string
+	// ProtoOnly only writes the .proto file of GRPC instead of running protoc.
+	ProtoOnly bool
+	// GraphQLSchemaOnly writes the schema of GraphQL to the output instead of
+	// the Go code.
+	GraphQLSchemaOnly bool
+	// HTTPErrors sets how HTTP maps errors to status codes, "" or "codes".
+	HTTPErrors string
+	// ErrorUnwrap maps errors returned by the functions to an AgrowsError with a
+	// code, given as Name:code entries, e.g. "ErrNotFound:404". Name is a type
+	// of the input, matched with errors.As, or a variable such as a sentinel
+	// error, matched with errors.Is.
+	ErrorUnwrap []string
+	// ServerStruct generates AgrowsServer dispatching to an AgrowsHandler.
+	ServerStruct bool
+
+	// LogCalls logs every call and its result in the server, LogArgs its
+	// arguments too. LogLevel is the minimum level, "info" if empty.
+	LogCalls bool
+	LogArgs  bool
+	LogLevel string
+	// Metrics is the metrics backend of the server, "" or "prometheus".
+	Metrics string
+	// Trace is the tracing backend, "" or "otel".
+	Trace string
+	// Timeout is the default timeout of dispatched calls, zero disables it.
+	Timeout time.Duration
+	// ClientTimeout is the default time the WASM client waits for the response
+	// of a call before rejecting its Promise, zero disables it.
+	ClientTimeout time.Duration
+	// CacheTTL caches successful results for the given duration, zero
+	// disables caching.
+	CacheTTL time.Duration
+	// RateLimit limits the calls of every client, e.g. "10/s".
+	RateLimit string
+	// Auth authenticates calls, "" or "jwt". AuthAlgorithm is the accepted
+	// signing method, "HS256" if empty.
+	Auth          string
+	AuthAlgorithm string
+	// AuditLog records every call as a JSON line in the given file.
+	AuditLog    string
+	HealthCheck bool
+	Stats       bool
+	Batch       bool
+	Shutdown    bool
+	// Async generates AgrowsReceiveAsync with AsyncWorkers workers, 8 if zero.
+	Async        bool
+	AsyncWorkers int
+
+	// Benchmarks writes agrows_bench_test.go with benchmarks of AgrowsReceive
+	// to OutputDir along with the server.
+	Benchmarks bool
+	// TestStubs writes agrows_server_<file>_test.go with a table-driven test
+	// per function to OutputDir along with the server.
+	TestStubs bool
+
+	// Harness writes agrows_harness_test.go with a round trip test per function
+	// to OutputDir along with the server.
+	Harness bool
+	// SignatureGuard writes agrows_<server|client|goclient>_<input>_check.go to
+	// OutputDir, assigning the generated functions to their signatures so
+	// they fail to compile once the signatures drift.
+	SignatureGuard bool
+	// ValidateInit generates an init in the server checking that the functions
+	// it dispatches to still have the signatures they had at generation.
+	ValidateInit bool
+
+	// SharedTypes moves the types used by the functions out of the server and
+	// client outputs into agrows_types.go in OutputDir, which has no build
+	// tags, so both can be generated into the same package.
+	SharedTypes bool
+
+	// Types is the type-checked package of the input, e.g. loaded with
+	// golang.org/x/tools/go/packages. It tells which named types and aliases
+	// are structs, which the syntax only does for struct literals. Nil means
+	// only the syntax is used.
+	Types *types.Package
+
+	// OutputDir is where the files accompanying the output are written: the
+	// protobuf code of GRPC, the schema of GraphQL and agrows.js with EmitJS.
+	// Empty means none are written.
+	OutputDir string
+	// ClientOutputDir is the OutputDir of the client written by
+	// GenerateServerAndClient. Empty means OutputDir.
+	ClientOutputDir string
+	// EmitJS writes the JavaScript glue of the client to OutputDir.
+	EmitJS bool
+	// Force overwrites files in OutputDir that were not generated by agrows.
+	Force bool
+
+	// errorCodes are the entries of ErrorUnwrap parsed by check.
+	errorCodes []errorCode
+	// rateLimit and rateBurst are RateLimit parsed by check.
+	rateLimit float64
+	rateBurst int
+	// plusBuild are the // +build lines of BuildTags, derived by check.
+	plusBuild []string
+	// only and exclude are the compiled patterns of Only and Exclude.
+	only    []*regexp.Regexp
+	exclude []*regexp.Regexp
+	// generatedAt is the time written to the header with Timestamp, set by
+	// check.
+	generatedAt time.Time
+	// sourceFiles are the names of the input files listed in the header, set
+	// when generating from several files.
+	sourceFiles []string
+}
+
+// defaultProtocolPath is the import path of the agrows protocol package.
+const defaultProtocolPath = "github.com/codeupdateandmodificationsystem/protocol"
+
+// Validate reports the first invalid setting of opts, without generating
+// anything.
+func (opts Options) Validate() error {
+	return opts.check()
+}
+
+// check validates opts and fills in the defaults of empty fields.
+func (opts *Options) check() error {
+	if opts.Mode == 0 {
+		opts.Mode = SERVER
+	}
+	if opts.Mode > TESTHELPERS {
+		return fmt.Errorf("unknown mode %d", opts.Mode)
+	}
+	if opts.LogLevel == "" {
+		opts.LogLevel = "info"
+	}
+	if _, ok := logLevels[opts.LogLevel]; !ok {
+		return fmt.Errorf("unknown log level '%s'", opts.LogLevel)
+	}
+	if opts.Metrics != "" && opts.Metrics != "prometheus" {
+		return fmt.Errorf("unknown metrics backend '%s'", opts.Metrics)
+	}
+	if opts.Trace != "" && opts.Trace != "otel" {
+		return fmt.Errorf("unknown trace backend '%s'", opts.Trace)
+	}
+	if opts.ResponseFormat == "" {
+		opts.ResponseFormat = "call"
+	}
+	if opts.ResponseFormat != "call" && opts.ResponseFormat != "json" {
+		return fmt.Errorf("unknown response format '%s'", opts.ResponseFormat)
+	}
+	if opts.Serialize == "" {
+		opts.Serialize = "json"
+	}
+	if _, ok := serializeNames[opts.Serialize]; !ok {
+		return fmt.Errorf("unknown serialization '%s'", opts.Serialize)
+	}
+	if opts.Serialize != "json" && opts.Compress {
+		return fmt.Errorf("--compress can not be combined with --serialize=%s, which bypasses the protocol", opts.Serialize)
+	}
+	if opts.HTTPErrors != "" && opts.HTTPErrors != "codes" {
+		return fmt.Errorf("unknown http error mapping '%s'", opts.HTTPErrors)
+	}
+	opts.errorCodes = nil
+	if len(opts.ErrorUnwrap) > 0 {
+		var err error
+		if opts.errorCodes, err = parseErrorCodes(opts.ErrorUnwrap); err != nil {
+			return err
+		}
+	}
+	if opts.CacheTTL < 0 {
+		return fmt.Errorf("the cache TTL must not be negative, got %s", opts.CacheTTL)
+	}
+	if opts.Timeout < 0 {
+		return fmt.Errorf("the timeout must not be negative, got %s", opts.Timeout)
+	}
+	if opts.ClientTimeout < 0 {
+		return fmt.Errorf("the client timeout must not be negative, got %s", opts.ClientTimeout)
+	}
+	if opts.WrapperFormat == "" {
+		opts.WrapperFormat = "%sWrapper"
+	}
+	if !isIdentifierFormat(opts.WrapperFormat) {
+		return fmt.Errorf("the wrapper format must be an identifier containing %%s once, got '%s'", opts.WrapperFormat)
+	}
+	if opts.FunctionFormat == "" {
+		opts.FunctionFormat = "agrows_%s"
+	}
+	if !isIdentifierFormat(opts.FunctionFormat) {
+		return fmt.Errorf("the function format must be an identifier containing %%s once, got '%s'", opts.FunctionFormat)
+	}
+	if opts.FunctionFormat == "%s" {
+		return fmt.Errorf("the function format must differ from the function name")
+	}
+	if opts.ReceiverName == "" {
+		opts.ReceiverName = defaultReceiverName
+	}
+	if !token.IsIdentifier(opts.ReceiverName) || !token.IsExported(opts.ReceiverName) {
+		return fmt.Errorf("the receiver name must be an exported identifier, got '%s'", opts.ReceiverName)
+	}
+	// the blank identifier can not name a package
+	if opts.PackageName != "" && (!token.IsIdentifier(opts.PackageName) || opts.PackageName == "_") {
+		return fmt.Errorf("the package name must be an identifier other than _, got '%s'", opts.PackageName)
+	}
+	if opts.ProtocolPath == "" {
+		opts.ProtocolPath = defaultProtocolPath
+	}
+	if !isImportPath(opts.ProtocolPath) {
+		return fmt.Errorf("the protocol must be a Go import path, e.g. example.com/fork/protocol, got '%s'", opts.ProtocolPath)
+	}
+	if opts.BuildTags == "" && opts.Mode == CLIENT {
+		opts.BuildTags = "js && wasm && client"
+	}
+	opts.plusBuild = nil
+	if opts.BuildTags != "" {
+		expr, err := constraint.Parse("//go:build " + opts.BuildTags)
+		if err != nil {
+			return fmt.Errorf("invalid build tags '%s': %w", opts.BuildTags, err)
+		}
+		if !opts.NoPlusBuild {
+			opts.plusBuild, err = constraint.PlusBuildLines(expr)
+			if err != nil {
+				return fmt.Errorf("build tags '%s' can not be written as // +build lines, simplify them or disable these lines: %w", opts.BuildTags, err)
+			}
+		}
+	}
+	var err error
+	if opts.only, err = compileFilter("only", opts.Only); err != nil {
+		return err
+	}
+	if opts.exclude, err = compileFilter("exclude", opts.Exclude); err != nil {
+		return err
+	}
+	for _, linter := range opts.NoLint {
+		if !linterNamePattern.MatchString(linter) {
+			return fmt.Errorf("invalid linter name '%s' in nolint", linter)
+		}
+	}
+	if opts.Header != "" && opts.NoHeader {
+		return fmt.Errorf("--header can not be combined with --no-header")
+	}
+	opts.generatedAt = time.Time{}
+	if opts.Timestamp {
+		if opts.generatedAt, err = generationTime(); err != nil {
+			return err
+		}
+	}
+	opts.LogCalls = opts.LogCalls || opts.LogArgs
+	if opts.AsyncWorkers == 0 {
+		opts.AsyncWorkers = 8
+	}
+	if opts.AsyncWorkers < 1 {
+		return fmt.Errorf("the async workers must be at least 1, got %d", opts.AsyncWorkers)
+	}
+	if opts.Auth != "" && opts.Auth != "jwt" {
+		return fmt.Errorf("unknown auth method '%s'", opts.Auth)
+	}
+	if opts.AuthAlgorithm == "" {
+		opts.AuthAlgorithm = "HS256"
+	}
+	if opts.AuthAlgorithm != "HS256" && opts.AuthAlgorithm != "RS256" {
+		return fmt.Errorf("unknown auth algorithm '%s'", opts.AuthAlgorithm)
+	}
+	opts.rateLimit, opts.rateBurst = 0, 0
+	if opts.RateLimit != "" {
+		opts.rateLimit, opts.rateBurst, err = parseRateLimit(opts.RateLimit)
+		if err != nil {
+			return err
+		}
+	}
+	if opts.GRPC && opts.GRPCPackage == "" {
+		return fmt.Errorf("gRPC requires the import path of the protobuf package")
+	}
+	return nil
+}
+
+// isImportPath reports whether path is a valid import path: elements of
+// letters, digits and "-._~+" separated by single slashes, neither starting nor
+// ending with a dot, like the go command accepts in modules.
+func isImportPath(path string) bool {
+	if path == "" || strings.HasPrefix(path, "-") {
+		return false
+	}
+	for _, element := range strings.Split(path, "/") {
+		if element == "" || strings.HasPrefix(element, ".") || strings.HasSuffix(element, ".") {
+			return false
+		}
+		for _, r := range element {
+			if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || strings.ContainsRune("-._~+", r)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// generationTime returns the time of SOURCE_DATE_EPOCH in UTC if it is set,
+// as reproducible builds expect, and the current time otherwise.
+func generationTime() (time.Time, error) {
+	epoch, ok := os.LookupEnv("SOURCE_DATE_EPOCH")
+	if !ok || epoch == "" {
+		return time.Now(), nil
+	}
+	seconds, err := strconv.ParseInt(epoch, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH '%s', expected seconds since the Unix epoch", epoch)
+	}
+	return time.Unix(seconds, 0).UTC(), nil
+}
+
+// isIdentifierFormat reports whether format contains %s once and yields an
+// identifier for a function name.
+func isIdentifierFormat(format string) bool {
+	return strings.Count(format, "%") == 1 && strings.Contains(format, "%s") && token.IsIdentifier(fmt.Sprintf(format, "F"))
+}
+
+// Parse reads the Go source from src and collects its RPC functions and types.
+// fileName is used in positions of errors.
+func Parse(src io.Reader, fileName string) (Input, error) {
+	return ParseFiles([]SourceFile{{Name: fileName, Src: src}}, fileName)
+}
+
+// ParseFiles reads the Go files of a package and collects their RPC functions
+// and types, like Parse does for a single file. fileName names the package in
+// the returned Input.
+func ParseFiles(files []SourceFile, fileName string) (Input, error) {
+	opts := Options{FileName: fileName}
+	if err := opts.check(); err != nil {
+		return Input{}, err
+	}
+	input, _, err := opts.parseInput(files, false)
+	return input, err
+}
+
+// parseInput parses files into a single tree and extracts its input, named
+// after opts.FileName. With check, the files are checked like by
+// parseSources.
+func (opts *Options) parseInput(files []SourceFile, check bool) (Input, *dst.File, error) {
+	fileName := opts.FileName
+	tree, dec, err := parseSources(files, *opts, check)
+	if err != nil {
+		return Input{FileName: fileName}, nil, err
+	}
+	if err := filterConstrainedDecls(tree, dec, parseTags(*opts)); err != nil {
+		return Input{FileName: fileName}, nil, err
+	}
+	input, err := opts.extractInput(tree, dec, fileName)
+	return input, tree, err
+}
+
+// extractInput collects the RPC functions and types of the parsed tree.
+func (opts *Options) extractInput(tree *dst.File, dec *decorator.Decorator, fileName string) (Input, error) {
+	input := Input{
+		FileName:  fileName,
+		Functions: make([]FuncInfo, 0),
+		TypeMap:   make(map[string]dst.Node),
+	}
+
+	var err error
+	input.TypeMap = extractTypeMap(tree)
+	input.Functions, err = opts.extractFuncInfo(tree, input.TypeMap, dec)
+	if err != nil {
+		return input, fmt.Errorf("failed to extract functions: %w", err)
+	}
+	if err := opts.checkNameCollisions(tree, input.Functions, dec); err != nil {
+		return input, err
+	}
+
+	lo.ForEach(input.Functions, func(info FuncInfo, _ int) {
+		log.Debugf("Function: %s", info.String())
+	})
+	return input, nil
+}
+
+// Generate reads the Go source from src and writes the code selected by opts
+// to out. Files accompanying the output are written to opts.OutputDir.
+// Generators only read opts, so Generate may be called concurrently.
+func Generate(src io.Reader, opts Options, out io.Writer) error {
+	return GenerateFiles([]SourceFile{{Name: opts.FileName, Src: src}}, opts, out)
+}
+
+// GenerateFiles is Generate for the Go files of a package, which are merged
+// into a single output as if they were one file. opts.FileName names the
+// package, the header of the output lists the files.
+func GenerateFiles(files []SourceFile, opts Options, out io.Writer) error {
+	if err := opts.check(); err != nil {
+		return err
+	}
+	opts.sourceFiles = sourceFileNames(files)
+
+	inputData, tree, err := opts.parseInput(files, true)
+	if err != nil {
+		return err
+	}
+	return opts.generateInput(inputData, tree, out)
+}
+
+// GenerateServerAndClient reads the Go source from src once and writes the
+// server selected by opts to server and the client to client, both generated
+// from the same functions. opts.Mode is ignored, the other options apply to
+// both, except that the files accompanying the client are written to
+// opts.ClientOutputDir.
+func GenerateServerAndClient(src io.Reader, opts Options, server io.Writer, client io.Writer) error {
+	return GenerateServerAndClientFiles([]SourceFile{{Name: opts.FileName, Src: src}}, opts, server, client)
+}
+
+// GenerateServerAndClientFiles is GenerateServerAndClient for the Go files of
+// a package, see GenerateFiles.
+func GenerateServerAndClientFiles(files []SourceFile, opts Options, server io.Writer, client io.Writer) error {
+	opts.sourceFiles = sourceFileNames(files)
+	serverOpts, clientOpts := opts, opts
+	serverOpts.Mode, clientOpts.Mode = SERVER, CLIENT
+	if opts.ClientOutputDir != "" {
+		clientOpts.OutputDir = opts.ClientOutputDir
+	}
+	if err := serverOpts.check(); err != nil {
+		return err
+	}
+	if err := clientOpts.check(); err != nil {
+		return err
+	}
+
+	serverTree, dec, err := parseSources(files, opts, true)
+	if err != nil {
+		return err
+	}
+	serverKept, err := constrainedDecls(serverTree, dec, parseTags(serverOpts))
+	if err != nil {
+		return err
+	}
+	clientKept, err := constrainedDecls(serverTree, dec, parseTags(clientOpts))
+	if err != nil {
+		return err
+	}
+	// generating modifies the trees, so each output gets its own copy
+	clientTree := cloneTree(serverTree, dec)
+	applyConstrainedDecls(serverTree, serverKept)
+	applyConstrainedDecls(clientTree, clientKept)
+
+	serverInput, err := serverOpts.extractInput(serverTree, dec, opts.FileName)
+	if err != nil {
+		return err
+	}
+	// the functions only have to be extracted again if the build constraints
+	// left out different declarations
+	clientInput := serverInput
+	if !slices.Equal(serverKept, clientKept) {
+		if clientInput, err = clientOpts.extractInput(clientTree, dec, opts.FileName); err != nil {
+			return err
+		}
+	}
+
+	if err := serverOpts.generateInput(serverInput, serverTree, server); err != nil {
+		return fmt.Errorf("failed to generate server: %w", err)
+	}
+	if err := clientOpts.generateInput(clientInput, clientTree, client); err != nil {
+		return fmt.Errorf("failed to generate client: %w", err)
+	}
+	return nil
+}
+
+// generateInput writes the code selected by opts for the extracted input and
+// its tree to out.
+func (opts *Options) generateInput(inputData Input, tree *dst.File, out io.Writer) error {
+	var err error
+	if len(inputData.Functions) == 0 {
+		if opts.Strict {
+			return fmt.Errorf("no exported functions found in %s", inputData.FileName)
+		}
+		log.Warnf("No exported functions found in %s, the output will not contain any RPC", inputData.FileName)
+	}
+	if methods := opts.skippedMethods(tree); len(methods) > 0 {
+		log.Warnf("Methods are no RPC functions, skipping %s of %s (//agrows:ignore silences this)", strings.Join(methods, ", "), inputData.FileName)
+	}
+
+	var serverToClient []FuncInfo
+	inputData.Functions, serverToClient = splitServerToClient(inputData.Functions)
+
+	if hasSubscriptions(inputData.Functions) && (opts.ServerStruct || opts.HTTP || opts.GRPC || opts.GraphQL || opts.Mode == GOCLIENT) {
+		return fmt.Errorf("functions with an emit parameter are only supported by 'server', 'server-ws' and 'client' without --server-struct yet")
+	}
+	if opts.ServerStruct && opts.WebSocket {
+		return fmt.Errorf("--server-struct can not be combined with 'server-ws' yet")
+	}
+	if opts.ServerStruct && opts.Mode == TESTHELPERS {
+		return fmt.Errorf("'test-helpers' can not be combined with --server-struct yet")
+	}
+	if opts.ServerStruct && opts.Benchmarks {
+		return fmt.Errorf("--benchmarks can not be combined with --server-struct yet")
+	}
+	if opts.ServerStruct && opts.Harness {
+		return fmt.Errorf("--harness can not be combined with --server-struct yet")
+	}
+	if opts.ServerStruct && opts.TestStubs {
+		return fmt.Errorf("--test-stubs can not be combined with --server-struct yet")
+	}
+	if opts.ServerStruct && opts.Async {
+		return fmt.Errorf("--server-struct can not be combined with --async yet")
+	}
+	if opts.ServerStruct && opts.ValidateInit {
+		return fmt.Errorf("--validate-init can not be combined with --server-struct, whose functions are methods of the handler")
+	}
+	if opts.ServerStruct && opts.ReceiverName != defaultReceiverName {
+		return fmt.Errorf("--receiver-name can not be combined with --server-struct, whose receiver is AgrowsServer.Receive")
+	}
+
+	if opts.Mode == GOCLIENT {
+		if err := checkGoClientResults(inputData.Functions); err != nil {
+			return fmt.Errorf("failed to generate Go client: %w", err)
+		}
+	}
+
+	var protoMessages []protoMessage
+	if opts.GRPC {
+		protoMessages, err = collectProtoMessages(inputData.Functions, inputData.TypeMap)
+		if err != nil {
+			return fmt.Errorf("failed to map types to protobuf: %w", err)
+		}
+	}
+
+	var graphqlSchema string
+	var graphqlObjects graphqlTypes
+	if opts.GraphQL {
+		graphqlObjects, err = collectGraphQLTypes(inputData.Functions, inputData.TypeMap)
+		if err != nil {
+			return fmt.Errorf("failed to map types to GraphQL: %w", err)
+		}
+		graphqlSchema = generateGraphQLSchema(inputData.Functions, graphqlObjects)
+		if opts.GraphQLSchemaOnly {
+			// the schema takes the place of the Go output
+			_, err := io.WriteString(out, graphqlSchema)
+			return err
+		}
+	}
+
+	var errorTargets []errorTarget
+	if opts.Mode == SERVER && len(opts.errorCodes) > 0 {
+		errorTargets, err = opts.resolveErrorCodes(tree)
+		if err != nil {
+			return err
+		}
+	}
+
+	packageName := tree.Name.Name
+	imports := sourceImports(tree)
+	if opts.PackageName != "" {
+		packageName = opts.PackageName
+	}
+	newFile := jen.NewFile("main")
+	switch opts.Mode {
+	case SERVER:
+		if len(serverToClient) > 0 {
+			removeFunctions(tree, serverToClient)
+			pruneUnusedImports(tree)
+		}
+		opts.modifyOriginalFunctions(tree)
+		newFile.Add(generateFuncNameConstants(inputData.Functions))
+		newFile.Add(opts.generateRequestTypes(inputData.Functions))
+		newFile.Add(opts.generateServerReceiver(inputData.Functions))
+		if !opts.ServerStruct {
+			newFile.Add(opts.generateReceiverNameConstant())
+		}
+		newFile.Add(opts.generateDecodeRequest(opts.binaryCalls()))
+		newFile.Add(generateServerRecover())
+		newFile.Add(generateMetricsHook(SERVER))
+		newFile.Add(generateLogHook(SERVER))
+		newFile.Add(opts.generateAuthorize())
+		newFile.Add(generateAllowHook())
+		if opts.Timeout > 0 {
+			newFile.Add(opts.generateCallTimeoutVar())
+		}
+		if opts.CacheTTL > 0 {
+			newFile.Add(opts.generateCache())
+		}
+		if opts.LogCalls {
+			newFile.Add(opts.generateServerLogger())
+		}
+		if opts.Metrics == "prometheus" {
+			newFile.Add(generateServerMetrics())
+		}
+		if opts.Trace == "otel" {
+			newFile.Add(generateTraceContextArg())
+			newFile.Add(opts.generateServerTraceExtraction())
+		}
+		if opts.ServerStruct {
+			newFile.Add(opts.generateServerStruct(inputData.Functions))
+		}
+		if opts.Async {
+			newFile.Add(opts.generateAsyncReceiver())
+		}
+		if opts.tracksActiveRequests() {
+			newFile.Add(generateActiveRequests())
+		}
+		if opts.Shutdown {
+			newFile.Add(opts.generateShutdownFunc())
+		}
+		if opts.HealthCheck {
+			newFile.Add(generateHealthCheck(inputData.Functions))
+		}
+		if opts.ValidateInit {
+			newFile.Add(opts.generateInitValidation(inputData.Functions))
+		}
+		if opts.Stats {
+			newFile.Add(generateStatsCounters(inputData.Functions))
+		}
+		if opts.rateLimit > 0 {
+			newFile.Add(opts.generateRateLimiter())
+		}
+		if opts.Auth == "jwt" {
+			newFile.Add(opts.generateJWTValidation())
+		}
+		if opts.AuditLog != "" {
+			newFile.Add(opts.generateAuditLog())
+		}
+		if opts.Batch {
+			newFile.Add(opts.generateServerBatch())
+		}
+		newFile.Add(opts.generateServerResponses())
+		if opts.WebSocket {
+			newFile.Add(opts.generateWebSocketHandler())
+			newFile.Add(opts.generateConnectionAuthorize())
+		}
+		if opts.HTTP {
+			newFile.Add(opts.generateHTTPHandlers(inputData.Functions))
+		}
+		if opts.GRPC {
+			newFile.ImportName(opts.GRPCPackage, grpcProtoPackage)
+			newFile.Add(opts.generateGRPCServer(inputData.Functions, protoMessages))
+		}
+		if opts.GraphQL {
+			newFile.ImportName(graphqlPackage, "graphql")
+			newFile.Add(opts.generateGraphQLResolver(inputData.Functions, graphqlObjects, graphqlSchema))
+		}
+		if len(errorTargets) > 0 {
+			newFile.Add(generateErrorMapper(errorTargets))
+		}
+		if opts.needsAgrowsError() {
+			newFile.Add(generateAgrowsError())
+		}
+		if len(serverToClient) > 0 || hasSubscriptions(inputData.Functions) {
+			newFile.Add(generateSendToClient())
+		}
+		if len(serverToClient) > 0 {
+			newFile.Add(opts.generateServerToClientStubs(serverToClient))
+		}
+		if hasSubscriptions(inputData.Functions) {
+			newFile.Add(opts.generateServerEvents())
+		}
+	case CLIENT:
+		opts.removeOriginalAndUnexportedFunctions(tree, true)
+		removeUnusedTypes(tree, slices.Concat(inputData.Functions, serverToClient))
+		pruneUnusedImports(tree)
+		newFile.Add(opts.generateJsValueToAny())
+		for _, info := range inputData.Functions {
+			newFile.Add(opts.generateNewClientFunc(info))
+		}
+		newFile.Add(opts.generateJSSendMessageFunction())
+		newFile.Add(generateMetricsHook(CLIENT))
+		newFile.Add(generateLogHook(CLIENT))
+		if opts.Batch {
+			newFile.Add(opts.generateClientBatch())
+		}
+		newFile.Add(generateClientMetricsRecording())
+		if opts.Trace == "otel" {
+			newFile.Add(generateTraceContextArg())
+			newFile.Add(generateClientTraceContext())
+		}
+		if len(serverToClient) > 0 {
+			newFile.Add(opts.generateRequestTypes(serverToClient))
+			// calls of the server keep using the protocol
+			newFile.Add(opts.generateDecodeRequest(false))
+		}
+		if hasSubscriptions(inputData.Functions) {
+			newFile.Add(opts.generateClientEvents())
+		}
+		newFile.Add(opts.generateClientResponses())
+		// JSON responses are routed by AgrowsHandleMessage on their own
+		receives := len(serverToClient) > 0 || hasSubscriptions(inputData.Functions) || !opts.jsonResponses()
+		if receives {
+			newFile.Add(opts.generateClientReceiver(serverToClient, hasSubscriptions(inputData.Functions)))
+		}
+		newFile.Add(opts.generateClientMessageRouter(receives))
+		newFile.Add(opts.generateClientMain(inputData.Functions, receives))
+	case GOCLIENT:
+		opts.removeOriginalAndUnexportedFunctions(tree, false)
+		for _, info := range inputData.Functions {
+			newFile.Add(generateGoClientFunc(info))
+		}
+		newFile.Add(opts.generateGoClientHelpers())
+	case MOCK:
+		opts.removeOriginalAndUnexportedFunctions(tree, false)
+		pruneUnusedImports(tree)
+		newFile.Add(generateMockVars(inputData.Functions))
+		newFile.Add(opts.generateRequestTypes(inputData.Functions))
+		newFile.Add(opts.generateDecodeRequest(opts.binaryCalls()))
+		newFile.Add(opts.generateMockReceive(inputData.Functions))
+	case TESTHELPERS:
+		// the tests run in the package of the server output, which has the types
+		tree = &dst.File{Name: tree.Name}
+		newFile.Add(opts.generateTestHelpers(inputData.Functions))
+	}
+	if opts.binaryCalls() && opts.Mode != TESTHELPERS {
+		newFile.ImportName(msgpackPackage, "msgpack")
+		newFile.ImportName(cborPackage, "cbor")
+		// the test files next to the server encode calls like the client
+		newFile.Add(opts.generateSerializeCodec(opts.Mode != MOCK, opts.Mode == SERVER || opts.Mode == MOCK))
+	}
+
+	var sharedTypes *dst.File
+	if opts.SharedTypes && (opts.Mode == SERVER || opts.Mode == CLIENT || opts.Mode == GOCLIENT) {
+		sharedTypes = moveSharedTypes(tree, sharedTypeNames(slices.Concat(inputData.Functions, serverToClient), inputData.TypeMap))
+	}
+
+	if _, err := opts.writeCombinedTreeAndGenerated(tree, newFile, out, opts.Mode); err != nil {
+		return fmt.Errorf("failed to save combined file: %w", err)
+	}
+
+	if opts.OutputDir == "" {
+		return nil
+	}
+	if opts.GRPC {
+		proto := opts.generateProto(inputData.Functions, protoMessages)
+		if err := opts.writeProto(filepath.Join(opts.OutputDir, grpcProtoPackage), proto, opts.Force); err != nil {
+			return fmt.Errorf("failed to generate protobuf code: %w", err)
+		}
+	}
+	if opts.Benchmarks && opts.Mode == SERVER {
+		path := filepath.Join(opts.OutputDir, benchmarksFileName)
+		if err := opts.writeTestFile(path, packageName, opts.generateBenchmarks(inputData.Functions), opts.Force); err != nil {
+			return fmt.Errorf("failed to write benchmarks: %w", err)
+		}
+	}
+	if opts.TestStubs && opts.Mode == SERVER {
+		path := filepath.Join(opts.OutputDir, testStubsFileName(opts.FileName))
+		if err := opts.writeTestFile(path, packageName, opts.generateTestStubs(inputData.Functions), opts.Force); err != nil {
+			return fmt.Errorf("failed to write test stubs: %w", err)
+		}
+	}
+	if opts.Harness && opts.Mode == SERVER {
+		path := filepath.Join(opts.OutputDir, harnessFileName)
+		if err := opts.writeTestFile(path, packageName, opts.generateHarness(inputData.Functions, imports), opts.Force); err != nil {
+			return fmt.Errorf("failed to write harness: %w", err)
+		}
+	}
+	if opts.SignatureGuard && (opts.Mode == SERVER || opts.Mode == CLIENT || opts.Mode == GOCLIENT) {
+		path := filepath.Join(opts.OutputDir, guardFileName(opts.Mode, opts.FileName))
+		guard := opts.generateSignatureGuard(opts.Mode, inputData.Functions, serverToClient, imports)
+		if err := opts.writeSideFile(path, packageName, guard, opts.Mode, opts.Force); err != nil {
+			return fmt.Errorf("failed to write signature guard: %w", err)
+		}
+	}
+	if sharedTypes != nil {
+		// the package of the output, which is only decided when writing it
+		sharedTypes.Name = dst.NewIdent(tree.Name.Name)
+		if err := opts.writeSharedTypes(filepath.Join(opts.OutputDir, sharedTypesFileName), sharedTypes, opts.Force); err != nil {
+			return fmt.Errorf("failed to write shared types: %w", err)
+		}
+	}
+	if opts.EmitJS && opts.Mode == CLIENT {
+		if err := writeJSGlue(opts.OutputDir, opts.Force); err != nil {
+			return fmt.Errorf("failed to write JavaScript glue: %w", err)
+		}
+	}
+	if opts.GraphQL {
+		if err := writeGraphQLSchema(GraphQLSchemaPath(opts.OutputDir), graphqlSchema, opts.Force); err != nil {
+			return fmt.Errorf("failed to write GraphQL schema: %w", err)
+		}
+	}
+	return nil
+}
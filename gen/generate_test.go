@@ -0,0 +1,413 @@
+package gen
+
+import (
+	"bytes"
+	"flag"
+	"go/build"
+	"go/format"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+var goldenUpdate = flag.Bool("golden-update", false, "write the outputs of TestGenerateServer and TestGenerateClient to their .golden files")
+
+// testModuleVersions pins the modules the generated code may import, required
+// by the modules of newTestModule.
+var testModuleVersions = map[string]string{
+	"github.com/fxamacker/cbor/v2":      "v2.7.0",
+	"github.com/golang-jwt/jwt/v5":      "v5.2.1",
+	"github.com/gorilla/websocket":      "v1.5.3",
+	"github.com/vmihailenco/msgpack/v5": "v5.4.1",
+	"go.opentelemetry.io/otel":          "v1.32.0",
+	"go.opentelemetry.io/otel/sdk":      "v1.32.0",
+	"go.opentelemetry.io/otel/trace":    "v1.32.0",
+	"golang.org/x/time":                 "v0.8.0",
+}
+
+// apiSource is a small input with the kinds of functions most outputs are
+// generated for.
+const apiSource = `package api
+
+type User struct {
+	Name string
+	Age  int
+}
+
+func Greet(user User) (string, error) {
+	return "hello " + user.Name, nil
+}
+
+func Add(a, b int) int {
+	return a + b
+}
+
+func Reset() error {
+	return nil
+}
+`
+
+// generate generates the output of src selected by opts, failing the test if
+// generating fails.
+func generate(t *testing.T, src string, opts Options) []byte {
+	t.Helper()
+	if opts.FileName == "" {
+		opts.FileName = "api.go"
+	}
+	var out bytes.Buffer
+	if err := Generate(strings.NewReader(src), opts, &out); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	return out.Bytes()
+}
+
+// newTestModule creates a module in a temporary directory requiring the
+// protocol package of testdata/protocol and the given modules of
+// testModuleVersions, and returns its directory. It skips the test with -short
+// or without the go command, which compiles the generated code.
+func newTestModule(t *testing.T, modules ...string) string {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("compiling generated code is skipped with -short")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("compiling generated code requires the go command")
+	}
+	protocol, err := filepath.Abs(filepath.Join("testdata", "protocol"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	requires := []string{defaultProtocolPath + " v0.0.0"}
+	for _, module := range modules {
+		version, ok := testModuleVersions[module]
+		if !ok {
+			t.Fatalf("no version of %s in testModuleVersions", module)
+		}
+		requires = append(requires, module+" "+version)
+	}
+	sort.Strings(requires)
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "go.mod"), "module agrowstest\n\ngo 1.22\n\nrequire (\n\t"+
+		strings.Join(requires, "\n\t")+"\n)\n\nreplace "+defaultProtocolPath+" => "+protocol+"\n")
+	return dir
+}
+
+// writeFile writes data to path, creating its directory.
+func writeFile[T string | []byte](t *testing.T, path string, data T) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// runGo runs the go command with args in dir and returns its output. env is
+// added to the environment, e.g. GOOS=js. The go.sum of the module is written
+// as needed.
+func runGo(dir string, env []string, args ...string) (string, error) {
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	cmd.Env = append(append(os.Environ(), "GOFLAGS=-mod=mod"), env...)
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+// wasmEnv returns the environment running the tests of a generated client
+// with node through go_js_wasm_exec. It skips the test without node.
+func wasmEnv(t *testing.T, dir string) []string {
+	t.Helper()
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("running the WASM client requires node")
+	}
+	goroot := strings.TrimSpace(mustRunGo(t, dir, nil, "env", "GOROOT"))
+	path := strings.Join([]string{
+		filepath.Join(goroot, "lib", "wasm"),
+		filepath.Join(goroot, "misc", "wasm"),
+		os.Getenv("PATH"),
+	}, string(os.PathListSeparator))
+	return []string{"GOOS=js", "GOARCH=wasm", "PATH=" + path}
+}
+
+// mustRunGo is runGo failing the test if the command fails.
+func mustRunGo(t *testing.T, dir string, env []string, args ...string) string {
+	t.Helper()
+	out, err := runGo(dir, env, args...)
+	if err != nil {
+		t.Fatalf("go %s failed: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return out
+}
+
+func TestGenerateErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		opts Options
+		want string
+	}{
+		{"syntax error", "package api\n\nfunc Add(a int {}\n", Options{}, "api.go:3"},
+		{"unknown mode", apiSource, Options{Mode: TESTHELPERS + 1}, "unknown mode"},
+		{"function format", apiSource, Options{FunctionFormat: "%s"}, "the function format must differ from the function name"},
+		{"linter name", apiSource, Options{NoLint: []string{"errcheck", "Unused"}}, "invalid linter name 'Unused' in nolint"},
+		{"client timeout", apiSource, Options{Mode: CLIENT, ClientTimeout: -time.Second}, "the client timeout must not be negative"},
+		{"strict", "package api\n\nfunc add(a, b int) int { return a + b }\n", Options{Strict: true}, "no exported functions found in api.go"},
+		{"unsupported type", "package api\n\nfunc Watch(ch chan int) {}\n", Options{}, "function Watch parameter ch at api.go:3 has unsupported type chan int: channels can not be sent to or from the client"},
+		{"receiver name", apiSource, Options{ReceiverName: "receive"}, "the receiver name must be an exported identifier, got 'receive'"},
+		{"receiver name of server struct", apiSource, Options{ReceiverName: "ReceiveAPI", ServerStruct: true}, "--receiver-name can not be combined with --server-struct"},
+		{"validate init of server struct", apiSource, Options{ValidateInit: true, ServerStruct: true}, "--validate-init can not be combined with --server-struct"},
+		{"blank package", apiSource, Options{PackageName: "_"}, "the package name must be an identifier other than _"},
+		{"protocol path", apiSource, Options{ProtocolPath: "example.com//protocol"}, "the protocol must be a Go import path, e.g. example.com/fork/protocol, got 'example.com//protocol'"},
+		{"int map keys", "package api\n\nfunc Scores() (map[int]string, error) { return nil, nil }\n", Options{}, "maps need string keys to be sent as JSON objects"},
+		{"pointer parameter", "package api\n\ntype User struct{}\n\nfunc Save(user *User) {}\n", Options{}, "function Save parameter user at api.go:5 has unsupported type *User: only results can be pointers"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.opts.FileName = "api.go"
+			err := Generate(strings.NewReader(test.src), test.opts, &bytes.Buffer{})
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("got error %v, want one containing %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestGenerateCompiles(t *testing.T) {
+	dir := newTestModule(t)
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api.go"), generate(t, apiSource, Options{}))
+	writeFile(t, filepath.Join(dir, "client", "agrows_client_api.go"), generate(t, apiSource, Options{Mode: CLIENT}))
+	mustRunGo(t, dir, nil, "vet", "./server")
+	mustRunGo(t, dir, []string{"GOOS=js", "GOARCH=wasm"}, "vet", "-tags", "client", "./client")
+}
+
+func TestSeparatePackage(t *testing.T) {
+	dir := newTestModule(t)
+	pkg := filepath.Join(dir, "rpcserver")
+	if err := os.Mkdir(pkg, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{PackageName: "rpcserver", OutputDir: pkg, TestStubs: true, SignatureGuard: true, SharedTypes: true}
+	writeFile(t, filepath.Join(pkg, "agrows_server_api.go"), generate(t, apiSource, opts))
+	mustRunGo(t, dir, nil, "vet", "./rpcserver")
+}
+
+func TestOutputFormatting(t *testing.T) {
+	for _, mode := range []byte{SERVER, CLIENT, GOCLIENT, MOCK} {
+		output := generate(t, apiSource, Options{Mode: mode})
+		formatted, err := format.Source(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(output, formatted) {
+			t.Errorf("the output of mode %d is not gofmt-clean:\n%s", mode, output)
+		}
+	}
+
+	// without the client tags the toolchain leaves out the client
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "agrows_client_api.go"), generate(t, apiSource, Options{Mode: CLIENT}))
+	pkg, err := build.ImportDir(dir, 0)
+	if _, noGo := err.(*build.NoGoError); !noGo || len(pkg.IgnoredGoFiles) != 1 {
+		t.Errorf("the build constraint of the client was not taken: %v", err)
+	}
+}
+
+func TestPlusBuildLines(t *testing.T) {
+	tests := []struct {
+		opts Options
+		want string
+	}{
+		{Options{Mode: CLIENT}, "//go:build js && wasm && client\n// +build js,wasm,client\n\n"},
+		{Options{BuildTags: "(linux || darwin) && !cgo"}, "//go:build (linux || darwin) && !cgo\n// +build linux darwin\n// +build !cgo\n\n"},
+		{Options{Mode: CLIENT, NoPlusBuild: true}, "//go:build js && wasm && client\n\n"},
+	}
+	for _, test := range tests {
+		if output := string(generate(t, apiSource, test.opts)); !strings.HasPrefix(output, test.want) {
+			t.Errorf("%q: want the output to start with\n%s\ngot:\n%s", test.opts.BuildTags, test.want, output)
+		}
+	}
+}
+
+func TestTimestamp(t *testing.T) {
+	if output := string(generate(t, apiSource, Options{})); strings.Contains(output, "generated on") {
+		t.Errorf("the output has a generation time without Timestamp:\n%s", output)
+	}
+	t.Setenv("SOURCE_DATE_EPOCH", "86400")
+	if output := string(generate(t, apiSource, Options{Timestamp: true})); !strings.Contains(output, "This code was generated on 1970-01-02 at 00:00:00\n") {
+		t.Errorf("the output lacks the time of SOURCE_DATE_EPOCH:\n%s", output)
+	}
+	t.Setenv("SOURCE_DATE_EPOCH", "yesterday")
+	err := Generate(strings.NewReader(apiSource), Options{FileName: "api.go", Timestamp: true}, &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "invalid SOURCE_DATE_EPOCH 'yesterday'") {
+		t.Errorf("got error %v for an invalid SOURCE_DATE_EPOCH", err)
+	}
+}
+
+func TestNoLint(t *testing.T) {
+	server := string(generate(t, apiSource, Options{NoLint: []string{"errcheck", "unused"}}))
+	if !strings.Contains(server, "//nolint:errcheck,unused\nfunc AgrowsReceive(") {
+		t.Errorf("AgrowsReceive is not silenced:\n%s", server)
+	}
+	// the functions of the input are left alone
+	if strings.Contains(server, "//nolint:errcheck,unused\nfunc agrows_Add(") {
+		t.Errorf("agrows_Add of the input is silenced:\n%s", server)
+	}
+	if strings.Contains(server, "//nolint:all") {
+		t.Errorf("the file is silenced without all:\n%s", server)
+	}
+
+	client := string(generate(t, apiSource, Options{Mode: CLIENT, NoLint: []string{"all"}}))
+	for _, want := range []string{"lost.\n\n//nolint:all\npackage main", "//nolint:all\nfunc main() {"} {
+		if !strings.Contains(client, want) {
+			t.Errorf("the client does not contain %q:\n%s", want, client)
+		}
+	}
+}
+
+func TestGenerateServerAndClient(t *testing.T) {
+	for _, src := range []string{apiSource, constrainedSource} {
+		opts := Options{FileName: "api.go"}
+		var server, client bytes.Buffer
+		if err := GenerateServerAndClient(strings.NewReader(src), opts, &server, &client); err != nil {
+			t.Fatal(err)
+		}
+		opts.Mode = CLIENT
+		for _, output := range []struct {
+			got  []byte
+			want []byte
+		}{
+			{server.Bytes(), generate(t, src, Options{})},
+			{client.Bytes(), generate(t, src, opts)},
+		} {
+			if !bytes.Equal(output.got, output.want) {
+				t.Errorf("the output differs from generating it separately:\n%s\nwant:\n%s", output.got, output.want)
+			}
+		}
+	}
+}
+
+func TestGenerateConcurrently(t *testing.T) {
+	want := string(generate(t, apiSource, Options{}))
+	done := make(chan string)
+	for i := 0; i < 8; i++ {
+		go func() {
+			var out bytes.Buffer
+			if err := Generate(strings.NewReader(apiSource), Options{FileName: "api.go"}, &out); err != nil {
+				done <- err.Error()
+				return
+			}
+			done <- out.String()
+		}()
+	}
+	for i := 0; i < 8; i++ {
+		if got := <-done; got != want {
+			t.Errorf("concurrent Generate returned a different output:\n%s", got)
+		}
+	}
+}
+
+// generateTest is a fixture of testdata generated with opts, compared with
+// testdata/<name>.<kind>.golden.
+type generateTest struct {
+	name    string
+	fixture string
+	opts    Options
+}
+
+// testGolden generates the fixtures of tests in mode and compares the outputs
+// with their golden files of kind byte for byte, or writes them with
+// -golden-update.
+func testGolden(t *testing.T, mode byte, kind string, tests []generateTest) {
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			src, err := os.ReadFile(filepath.Join("testdata", test.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.opts.Mode = mode
+			test.opts.FileName = test.fixture
+			got := generate(t, string(src), test.opts)
+
+			golden := filepath.Join("testdata", test.name+"."+kind+".golden")
+			if *goldenUpdate {
+				writeFile(t, golden, got)
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v, run go test ./gen -golden-update to write it", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("output differs from %s, run go test ./gen -golden-update if the change is intended:\n%s", golden, got)
+			}
+		})
+	}
+}
+
+func TestGenerateServer(t *testing.T) {
+	testGolden(t, SERVER, "server", []generateTest{
+		{"basic", "basic.go", Options{}},
+		{"collections", "collections.go", Options{}},
+		{"context", "context.go", Options{}},
+		{"timeout", "basic.go", Options{Timeout: 5 * time.Second}},
+		{"function_format", "basic.go", Options{FunctionFormat: "rpc%s"}},
+		{"json_responses", "basic.go", Options{ResponseFormat: "json"}},
+	})
+}
+
+func TestGenerateClient(t *testing.T) {
+	testGolden(t, CLIENT, "client", []generateTest{
+		{"basic", "basic.go", Options{}},
+		{"collections", "collections.go", Options{}},
+		{"context", "context.go", Options{}},
+		{"wrapper_format", "basic.go", Options{WrapperFormat: "agrows%s", Quiet: true}},
+		{"json_responses", "basic.go", Options{ResponseFormat: "json"}},
+	})
+}
+
+func TestGenerateProtocolPath(t *testing.T) {
+	const fork = "agrowstest/protocol"
+	// generations with different protocols must not see each other's
+	outputs := make([][]byte, 4)
+	done := make(chan bool)
+	for i := range outputs {
+		go func() {
+			opts := Options{FileName: "api.go", Mode: CLIENT, Batch: true}
+			if i%2 == 0 {
+				opts.Mode, opts.ProtocolPath = SERVER, fork
+			}
+			var out bytes.Buffer
+			if err := Generate(strings.NewReader(apiSource), opts, &out); err != nil {
+				t.Error(err)
+			}
+			outputs[i] = out.Bytes()
+			done <- true
+		}()
+	}
+	for range outputs {
+		<-done
+	}
+	for i, out := range outputs {
+		want, other := defaultProtocolPath, fork
+		if i%2 == 0 {
+			want, other = fork, defaultProtocolPath
+		}
+		if !bytes.Contains(out, []byte(`"`+want+`"`)) || bytes.Contains(out, []byte(`"`+other+`"`)) {
+			t.Errorf("output %d does not import only %s:\n%s", i, want, out)
+		}
+	}
+
+	dir := newTestModule(t)
+	protocol, err := os.ReadFile(filepath.Join("testdata", "protocol", "protocol.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "protocol", "protocol.go"), protocol)
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api.go"), outputs[0])
+	mustRunGo(t, dir, nil, "vet", "./server")
+}
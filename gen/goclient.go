@@ -0,0 +1,167 @@
+package gen
+
+import (
+	"fmt"
+
+	"github.com/dave/jennifer/jen"
+)
+
+// checkGoClientResults returns an error for results the Go client can not
+// decode from the replies of AgrowsReceive, which formats them with %+v unless
+// they are encoded as JSON.
+func checkGoClientResults(infos []FuncInfo) error {
+	for _, info := range infos {
+		for i, resultInfo := range info.Results {
+			if resultInfo.IsStruct && i != jsonResult(info) {
+				return fmt.Errorf("struct result %s of %s at %s can not be decoded by the Go client yet", typeString(resultInfo.DstField.Type), info.OriginalIdentifier.Name, resultInfo.Position)
+			}
+		}
+	}
+	return nil
+}
+
+// generateGoClientFunc emits a function with the parameters of info, preceded
+// by the connection to call it on. It returns the non-error results of info
+// followed by an error reporting failed calls.
+func generateGoClientFunc(info FuncInfo) *jen.Statement {
+	doc := jen.Null()
+	for _, line := range info.Doc {
+		doc.Comment(line).Line()
+	}
+	if len(info.Doc) == 0 {
+		doc.Commentf("%s calls the RPC '%s' on the server connected to conn.", info.OriginalIdentifier.Name, info.Signature()).Line()
+	}
+
+	var values []int
+	firstString := -1
+	for i, resultInfo := range info.Results {
+		typeName := typeString(resultInfo.DstField.Type)
+		if typeName == "error" {
+			continue
+		}
+		if typeName == "string" && firstString < 0 {
+			firstString = i
+		}
+		values = append(values, i)
+	}
+
+	return doc.Func().Id(info.OriginalIdentifier.Name).ParamsFunc(func(g *jen.Group) {
+		g.Id("conn").Op("*").Qual(websocketPackage, "Conn")
+		for _, paramInfo := range info.Params {
+			g.Id(paramIdent(info, paramInfo.DstField.Names[0].Name)).Add(typeCode(paramInfo.DstField.Type, nil))
+		}
+	}).ParamsFunc(func(g *jen.Group) {
+		if len(values) == 0 {
+			g.Error()
+			return
+		}
+		for _, i := range values {
+			g.Id(fmt.Sprintf("ret%d", i)).Add(typeCode(info.Results[i].DstField.Type, nil))
+		}
+		g.Err().Error()
+	}).BlockFunc(func(g *jen.Group) {
+		replyName := "_"
+		if len(values) > 0 {
+			replyName = "reply"
+		}
+		g.List(jen.Id(replyName), jen.Err()).Op(":=").Id("agrowsCall").Call(
+			jen.Id("conn"),
+			jen.Lit(info.OriginalIdentifier.Name),
+			jen.Map(jen.String()).Any().ValuesFunc(func(g *jen.Group) {
+				for _, paramInfo := range info.Params {
+					name := paramInfo.DstField.Names[0].Name
+					g.Line().Lit(name).Op(":").Id(paramIdent(info, name))
+				}
+				if len(info.Params) > 0 {
+					g.Line()
+				}
+			}),
+		)
+		if len(values) == 0 {
+			g.Return(jen.Err())
+			return
+		}
+		g.If(jen.Err().Op("!=").Nil()).Block(jen.Return())
+
+		if i := jsonResult(info); i >= 0 {
+			g.If(jen.Err().Op("=").Qual("encoding/json", "Unmarshal").Call(jen.Index().Byte().Call(jen.Id("reply")), jen.Op("&").Id(fmt.Sprintf("ret%d", i))), jen.Err().Op("!=").Nil()).Block(
+				jen.Err().Op("=").Qual("fmt", "Errorf").Call(jen.Lit("%s: failed to decode result: %w"), jen.Lit(info.OriginalIdentifier.Name), jen.Err()),
+			)
+			g.Return()
+			return
+		}
+		if firstString >= 0 {
+			// the server only replies with the first string result
+			g.Id(fmt.Sprintf("ret%d", firstString)).Op("=").Id("reply")
+			g.Return()
+			return
+		}
+
+		g.Err().Op("=").Id("agrowsDecodeReply").CallFunc(func(callGroup *jen.Group) {
+			callGroup.Lit(info.OriginalIdentifier.Name)
+			callGroup.Id("reply")
+			for i, resultInfo := range info.Results {
+				if typeString(resultInfo.DstField.Type) == "error" {
+					callGroup.Nil()
+				} else {
+					callGroup.Op("&").Id(fmt.Sprintf("ret%d", i))
+				}
+			}
+		})
+		g.Return()
+	}).Line()
+}
+
+// generateGoClientHelpers emits agrowsCall, which sends a call over a connection
+// and waits for its reply, and agrowsDecodeReply, which scans the results
+// formatted by AgrowsReceive.
+func (opts *Options) generateGoClientHelpers() *jen.Statement {
+	call := jen.Comment("agrowsCall sends a call of functionName and waits for the reply. Replies are not").Line().
+		Comment("correlated with calls, so a connection must not be used by concurrent calls.").Line().
+		Func().Id("agrowsCall").Params(
+		jen.Id("conn").Op("*").Qual(websocketPackage, "Conn"),
+		jen.Id("functionName").String(),
+		jen.Id("args").Map(jen.String()).Any(),
+	).Params(jen.String(), jen.Error()).Block(
+		jen.List(jen.Id("data"), jen.Err()).Op(":=").Add(opts.generateEncodeCall(jen.Id("functionName"), jen.Id("args"))),
+		jen.If(jen.Err().Op("!=").Nil()).Block(
+			jen.Return(jen.Lit(""), jen.Qual("fmt", "Errorf").Call(jen.Lit("%s: failed to encode call: %w"), jen.Id("functionName"), jen.Err())),
+		),
+		jen.If(jen.Err().Op(":=").Id("conn").Dot("WriteMessage").Call(jen.Qual(websocketPackage, "BinaryMessage"), jen.Id("data")), jen.Err().Op("!=").Nil()).Block(
+			jen.Return(jen.Lit(""), jen.Qual("fmt", "Errorf").Call(jen.Lit("%s: failed to send call: %w"), jen.Id("functionName"), jen.Err())),
+		),
+		jen.List(jen.Id("_"), jen.Id("reply"), jen.Err()).Op(":=").Id("conn").Dot("ReadMessage").Call(),
+		jen.If(jen.Err().Op("!=").Nil()).Block(
+			jen.Return(jen.Lit(""), jen.Qual("fmt", "Errorf").Call(jen.Lit("%s: failed to read reply: %w"), jen.Id("functionName"), jen.Err())),
+		),
+		jen.If(jen.List(jen.Id("message"), jen.Id("failed")).Op(":=").Qual("strings", "CutPrefix").Call(jen.String().Call(jen.Id("reply")), jen.Lit("error: ")), jen.Id("failed")).Block(
+			jen.Return(jen.Lit(""), jen.Qual("fmt", "Errorf").Call(jen.Lit("%s: %s"), jen.Id("functionName"), jen.Id("message"))),
+		),
+		jen.Return(jen.String().Call(jen.Id("reply")), jen.Nil()),
+	).Line()
+
+	decode := jen.Comment("agrowsDecodeReply scans the results of a reply of the form 'v1', 'v2' into").Line().
+		Comment("targets, skipping the nil ones.").Line().
+		Func().Id("agrowsDecodeReply").Params(
+		jen.Id("functionName").String(),
+		jen.Id("reply").String(),
+		jen.Id("targets").Op("...").Any(),
+	).Error().Block(
+		jen.Id("values").Op(":=").Qual("strings", "Split").Call(
+			jen.Qual("strings", "TrimSuffix").Call(jen.Qual("strings", "TrimPrefix").Call(jen.Id("reply"), jen.Lit("'")), jen.Lit("'")),
+			jen.Lit("', '"),
+		),
+		jen.If(jen.Len(jen.Id("values")).Op("!=").Len(jen.Id("targets"))).Block(
+			jen.Return(jen.Qual("fmt", "Errorf").Call(jen.Lit("%s: expected %d results in reply, got %d"), jen.Id("functionName"), jen.Len(jen.Id("targets")), jen.Len(jen.Id("values")))),
+		),
+		jen.For(jen.List(jen.Id("i"), jen.Id("target")).Op(":=").Range().Id("targets")).Block(
+			jen.If(jen.Id("target").Op("==").Nil()).Block(jen.Continue()),
+			jen.If(jen.List(jen.Id("_"), jen.Err()).Op(":=").Qual("fmt", "Sscan").Call(jen.Id("values").Index(jen.Id("i")), jen.Id("target")), jen.Err().Op("!=").Nil()).Block(
+				jen.Return(jen.Qual("fmt", "Errorf").Call(jen.Lit("%s: failed to decode result %d: %w"), jen.Id("functionName"), jen.Id("i"), jen.Err())),
+			),
+		),
+		jen.Return(jen.Nil()),
+	).Line()
+
+	return jen.Add(call, decode)
+}
@@ -0,0 +1,477 @@
+package gen
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dave/dst"
+	"github.com/dave/jennifer/jen"
+)
+
+const graphqlPackage = "github.com/graph-gophers/graphql-go"
+
+// graphqlMutationDirective marks a function as a mutation in its doc comment,
+// all other functions are queries.
+const graphqlMutationDirective = "//agrows:mutation"
+
+// graphqlScalarTypes maps the Go types supported by the graphql subcommand to
+// their GraphQL scalar and the Go type graphql-go uses for it.
+var graphqlScalarTypes = map[string][2]string{
+	"string":  {"String", "string"},
+	"bool":    {"Boolean", "bool"},
+	"int":     {"Int", "int32"},
+	"int8":    {"Int", "int32"},
+	"int16":   {"Int", "int32"},
+	"int32":   {"Int", "int32"},
+	"rune":    {"Int", "int32"},
+	"int64":   {"Int", "int32"},
+	"uint":    {"Int", "int32"},
+	"uint8":   {"Int", "int32"},
+	"byte":    {"Int", "int32"},
+	"uint16":  {"Int", "int32"},
+	"uint32":  {"Int", "int32"},
+	"uint64":  {"Int", "int32"},
+	"float32": {"Float", "float64"},
+	"float64": {"Float", "float64"},
+}
+
+// graphqlObject is a struct from the source used as a GraphQL type (in
+// results) or input (in parameters).
+type graphqlObject struct {
+	Name   string
+	Fields []graphqlField
+}
+
+type graphqlField struct {
+	Name string
+	Type string
+}
+
+// graphqlTypes holds the structs used by the functions, split by whether they
+// are returned or received.
+type graphqlTypes struct {
+	Objects []graphqlObject
+	Inputs  []graphqlObject
+}
+
+func isMutation(info FuncInfo) bool {
+	for _, line := range info.Doc {
+		if strings.TrimSpace(line) == graphqlMutationDirective {
+			return true
+		}
+	}
+	return false
+}
+
+func graphqlFieldName(name string) string {
+	return strings.ToLower(name[:1]) + name[1:]
+}
+
+// graphqlResult returns the index of the result a GraphQL field resolves to,
+// or -1 if the function only returns an error or nothing.
+func graphqlResult(info FuncInfo) int {
+	for i, resultInfo := range info.Results {
+		if typeString(resultInfo.DstField.Type) != "error" {
+			return i
+		}
+	}
+	return -1
+}
+
+// collectGraphQLTypes checks that every function can be exposed as a GraphQL
+// field and collects the structs they use, including nested ones.
+func collectGraphQLTypes(infos []FuncInfo, typeMap map[string]dst.Node) (graphqlTypes, error) {
+	var types graphqlTypes
+	seenObjects := make(map[string]bool)
+	seenInputs := make(map[string]bool)
+
+	var visit func(typeName, usage string, input bool) error
+	visit = func(typeName, usage string, input bool) error {
+		seen, list := seenObjects, &types.Objects
+		if input {
+			seen, list = seenInputs, &types.Inputs
+		}
+		if elem, ok := strings.CutPrefix(typeName, "[]"); ok {
+			return visit(elem, usage, input)
+		}
+		if _, ok := graphqlScalarTypes[typeName]; ok || seen[typeName] {
+			return nil
+		}
+		structType, ok := typeMap[typeName].(*dst.StructType)
+		if !ok {
+			return fmt.Errorf("unsupported type %s of %s for GraphQL", typeName, usage)
+		}
+		seen[typeName] = true
+
+		object := graphqlObject{Name: typeName}
+		for _, field := range structType.Fields.List {
+			if len(field.Names) == 0 {
+				return fmt.Errorf("embedded field in %s is not supported for GraphQL", typeName)
+			}
+			fieldType := typeString(field.Type)
+			for _, name := range field.Names {
+				if !name.IsExported() {
+					continue
+				}
+				if err := visit(fieldType, fmt.Sprintf("field %s.%s", typeName, name.Name), input); err != nil {
+					return err
+				}
+				object.Fields = append(object.Fields, graphqlField{Name: name.Name, Type: fieldType})
+			}
+		}
+		*list = append(*list, object)
+		return nil
+	}
+
+	hasQuery := false
+	for _, info := range infos {
+		name := info.OriginalIdentifier.Name
+		if !isMutation(info) {
+			hasQuery = true
+		}
+		for _, paramInfo := range info.Params {
+			usage := fmt.Sprintf("parameter %s of %s", paramInfo.DstField.Names[0].Name, name)
+			if err := visit(typeString(paramInfo.DstField.Type), usage, true); err != nil {
+				return types, err
+			}
+		}
+		values := 0
+		for _, resultInfo := range info.Results {
+			typeName := typeString(resultInfo.DstField.Type)
+			if typeName == "error" {
+				continue
+			}
+			values++
+			if err := visit(typeName, "result of "+name, false); err != nil {
+				return types, err
+			}
+		}
+		if values > 1 {
+			return types, fmt.Errorf("%s at %s returns %d values, GraphQL fields resolve to a single value", name, info.Position, values)
+		}
+	}
+	if !hasQuery {
+		return types, fmt.Errorf("GraphQL schemas need a query, but every function is marked with %s", graphqlMutationDirective)
+	}
+	return types, nil
+}
+
+// graphqlType returns the non-null GraphQL type of the Go type typeName.
+// Slices are lists of non-null elements.
+func graphqlType(typeName string, input bool) string {
+	if elem, ok := strings.CutPrefix(typeName, "[]"); ok {
+		return "[" + graphqlType(elem, input) + "]!"
+	}
+	if scalar, ok := graphqlScalarTypes[typeName]; ok {
+		return scalar[0] + "!"
+	}
+	if input {
+		return typeName + "Input!"
+	}
+	return typeName + "!"
+}
+
+// generateGraphQLSchema renders the schema SDL with a Query field per function
+// and a Mutation field per function marked with //agrows:mutation. Functions
+// without a value result resolve to true once they succeed.
+func generateGraphQLSchema(infos []FuncInfo, types graphqlTypes) string {
+	var b strings.Builder
+
+	writeFields := func(mutations bool) {
+		for _, info := range infos {
+			if isMutation(info) != mutations {
+				continue
+			}
+			fmt.Fprintf(&b, "  %s", graphqlFieldName(info.OriginalIdentifier.Name))
+			if len(info.Params) > 0 {
+				args := make([]string, len(info.Params))
+				for i, paramInfo := range info.Params {
+					args[i] = fmt.Sprintf("%s: %s", paramInfo.DstField.Names[0].Name, graphqlType(typeString(paramInfo.DstField.Type), true))
+				}
+				fmt.Fprintf(&b, "(%s)", strings.Join(args, ", "))
+			}
+			if result := graphqlResult(info); result >= 0 {
+				fmt.Fprintf(&b, ": %s\n", graphqlType(typeString(info.Results[result].DstField.Type), false))
+			} else {
+				b.WriteString(": Boolean!\n")
+			}
+		}
+	}
+
+	b.WriteString("# Code generated by agrows. DO NOT EDIT.\n\n")
+	b.WriteString("schema {\n  query: Query\n")
+	hasMutation := false
+	for _, info := range infos {
+		hasMutation = hasMutation || isMutation(info)
+	}
+	if hasMutation {
+		b.WriteString("  mutation: Mutation\n")
+	}
+	b.WriteString("}\n\ntype Query {\n")
+	writeFields(false)
+	b.WriteString("}\n")
+	if hasMutation {
+		b.WriteString("\ntype Mutation {\n")
+		writeFields(true)
+		b.WriteString("}\n")
+	}
+
+	for _, object := range types.Objects {
+		fmt.Fprintf(&b, "\ntype %s {\n", object.Name)
+		for _, field := range object.Fields {
+			fmt.Fprintf(&b, "  %s: %s\n", graphqlFieldName(field.Name), graphqlType(field.Type, false))
+		}
+		b.WriteString("}\n")
+	}
+	for _, input := range types.Inputs {
+		fmt.Fprintf(&b, "\ninput %sInput {\n", input.Name)
+		for _, field := range input.Fields {
+			fmt.Fprintf(&b, "  %s: %s\n", graphqlFieldName(field.Name), graphqlType(field.Type, true))
+		}
+		b.WriteString("}\n")
+	}
+
+	return b.String()
+}
+
+// generateGraphQLArgType returns the Go type graphql-go decodes an argument of
+// the Go type typeName into.
+func generateGraphQLArgType(typeName string) *jen.Statement {
+	if elem, ok := strings.CutPrefix(typeName, "[]"); ok {
+		return jen.Index().Add(generateGraphQLArgType(elem))
+	}
+	if scalar, ok := graphqlScalarTypes[typeName]; ok {
+		return jen.Id(scalar[1])
+	}
+	return jen.Id("agrows" + typeName + "Input")
+}
+
+// generateFromGraphQL converts the decoded argument value to typeName.
+func generateFromGraphQL(typeName string, value *jen.Statement) *jen.Statement {
+	if elem, ok := strings.CutPrefix(typeName, "[]"); ok {
+		if isGraphQLIdentity(elem) {
+			return value
+		}
+		return generateGraphQLListConversion(generateGoType(typeName), value, generateFromGraphQL(elem, jen.Id("value")))
+	}
+	scalar, ok := graphqlScalarTypes[typeName]
+	if !ok {
+		return value.Dot("value").Call()
+	}
+	if scalar[1] == typeName {
+		return value
+	}
+	return jen.Id(typeName).Call(value)
+}
+
+// generateToGraphQL converts value of typeName to what its resolver returns.
+// Lists are converted also if their elements are not, as a nil slice would
+// resolve to null.
+func generateToGraphQL(typeName string, value *jen.Statement) *jen.Statement {
+	if elem, ok := strings.CutPrefix(typeName, "[]"); ok {
+		if isGraphQLIdentity(elem) {
+			return jen.Append(generateGraphQLResultType(typeName).Values(), value.Op("..."))
+		}
+		return generateGraphQLListConversion(generateGraphQLResultType(typeName), value, generateToGraphQL(elem, jen.Id("value")))
+	}
+	scalar, ok := graphqlScalarTypes[typeName]
+	if !ok {
+		return jen.Op("&").Id("agrows" + typeName + "Resolver").Values(value)
+	}
+	if scalar[1] == typeName {
+		return value
+	}
+	return jen.Id(scalar[1]).Call(value)
+}
+
+// generateGraphQLListConversion converts the slice value to a new slice of
+// listType, converting every element with convert of value.
+func generateGraphQLListConversion(listType jen.Code, value *jen.Statement, convert jen.Code) *jen.Statement {
+	return jen.Func().Params().Add(listType).Block(
+		jen.Id("values").Op(":=").Make(listType, jen.Len(value)),
+		jen.For(jen.List(jen.Id("index"), jen.Id("value")).Op(":=").Range().Add(value)).Block(
+			jen.Id("values").Index(jen.Id("index")).Op("=").Add(convert),
+		),
+		jen.Return(jen.Id("values")),
+	).Call()
+}
+
+// isGraphQLIdentity reports whether graphql-go uses the Go type typeName itself,
+// so its values need no conversion.
+func isGraphQLIdentity(typeName string) bool {
+	scalar, ok := graphqlScalarTypes[typeName]
+	return ok && scalar[1] == typeName
+}
+
+// generateGoType returns the Go type typeName, a type of the source or a slice
+// of one.
+func generateGoType(typeName string) *jen.Statement {
+	if elem, ok := strings.CutPrefix(typeName, "[]"); ok {
+		return jen.Index().Add(generateGoType(elem))
+	}
+	return jen.Id(typeName)
+}
+
+// generateGraphQLResultType returns the Go type a resolver of typeName returns.
+func generateGraphQLResultType(typeName string) *jen.Statement {
+	if elem, ok := strings.CutPrefix(typeName, "[]"); ok {
+		return jen.Index().Add(generateGraphQLResultType(elem))
+	}
+	if scalar, ok := graphqlScalarTypes[typeName]; ok {
+		return jen.Id(scalar[1])
+	}
+	return jen.Op("*").Id("agrows" + typeName + "Resolver")
+}
+
+// generateGraphQLResolver emits AgrowsResolver with a method per function, the
+// resolvers and inputs of the structs, the embedded schema and
+// NewAgrowsGraphQLSchema parsing it.
+func (opts *Options) generateGraphQLResolver(infos []FuncInfo, types graphqlTypes, schema string) *jen.Statement {
+	code := jen.Const().Id("agrowsGraphQLSchema").Op("=").Lit(schema).Line().Line()
+
+	code.Comment("NewAgrowsGraphQLSchema parses the generated schema with AgrowsResolver as its root").Line().
+		Comment("resolver, ready to be served with graphql-go's relay.Handler.").Line().
+		Func().Id("NewAgrowsGraphQLSchema").Params().Params(jen.Op("*").Qual(graphqlPackage, "Schema"), jen.Error()).Block(
+		jen.Return(jen.Qual(graphqlPackage, "ParseSchema").Call(jen.Id("agrowsGraphQLSchema"), jen.Op("&").Id("AgrowsResolver").Values())),
+	).Line().Line()
+
+	code.Comment("AgrowsResolver resolves every query and mutation with the functions of the source file.").Line().
+		Type().Id("AgrowsResolver").Struct().Line().Line()
+
+	for _, info := range infos {
+		name := info.OriginalIdentifier.Name
+		result := graphqlResult(info)
+
+		code.Func().Params(jen.Op("*").Id("AgrowsResolver")).Id(name).ParamsFunc(func(g *jen.Group) {
+			if info.TakesContext {
+				g.Id("ctx").Qual("context", "Context")
+			}
+			if len(info.Params) > 0 {
+				g.Id("args").StructFunc(func(s *jen.Group) {
+					for i, paramInfo := range info.Params {
+						s.Id(requestFieldName(info, i)).Add(generateGraphQLArgType(typeString(paramInfo.DstField.Type)))
+					}
+				})
+			}
+		}).ParamsFunc(func(g *jen.Group) {
+			if result >= 0 {
+				g.Add(generateGraphQLResultType(typeString(info.Results[result].DstField.Type)))
+			} else {
+				g.Bool()
+			}
+			g.Error()
+		}).BlockFunc(func(g *jen.Group) {
+			varNames := make([]string, len(info.Results))
+			errName := ""
+			for i, resultInfo := range info.Results {
+				if typeString(resultInfo.DstField.Type) == "error" {
+					varNames[i] = "err" + fmt.Sprint(i)
+					errName = varNames[i]
+				} else {
+					varNames[i] = "ret" + fmt.Sprint(i)
+				}
+			}
+
+			call := jen.Id(fmt.Sprintf(opts.FunctionFormat, name)).CallFunc(func(callGroup *jen.Group) {
+				if info.TakesContext {
+					callGroup.Id("ctx")
+				}
+				for i, paramInfo := range info.Params {
+					callGroup.Add(generateFromGraphQL(typeString(paramInfo.DstField.Type), jen.Id("args").Dot(requestFieldName(info, i))))
+				}
+			})
+			if len(varNames) == 0 {
+				g.Add(call)
+			} else {
+				g.ListFunc(func(retGroup *jen.Group) {
+					for _, varName := range varNames {
+						retGroup.Id(varName)
+					}
+				}).Op(":=").Add(call)
+			}
+
+			if result < 0 {
+				if errName != "" {
+					g.Return(jen.Id(errName).Op("==").Nil(), jen.Id(errName))
+				} else {
+					g.Return(jen.True(), jen.Nil())
+				}
+				return
+			}
+			typeName := typeString(info.Results[result].DstField.Type)
+			if errName != "" {
+				g.If(jen.Id(errName).Op("!=").Nil()).Block(
+					jen.Return(jen.Id(zeroGraphQLResult(typeName)), jen.Id(errName)),
+				)
+			}
+			g.Return(generateToGraphQL(typeName, jen.Id(varNames[result])), jen.Nil())
+		}).Line().Line()
+	}
+
+	for _, object := range types.Objects {
+		resolver := "agrows" + object.Name + "Resolver"
+		code.Type().Id(resolver).Struct(jen.Id("value").Id(object.Name)).Line().Line()
+		for _, field := range object.Fields {
+			code.Func().Params(jen.Id("r").Op("*").Id(resolver)).Id(field.Name).Params().Add(generateGraphQLResultType(field.Type)).Block(
+				jen.Return(generateToGraphQL(field.Type, jen.Id("r").Dot("value").Dot(field.Name))),
+			).Line().Line()
+		}
+	}
+
+	for _, input := range types.Inputs {
+		inputType := "agrows" + input.Name + "Input"
+		code.Type().Id(inputType).StructFunc(func(g *jen.Group) {
+			for _, field := range input.Fields {
+				g.Id(field.Name).Add(generateGraphQLArgType(field.Type))
+			}
+		}).Line().Line()
+		code.Func().Params(jen.Id("i").Id(inputType)).Id("value").Params().Id(input.Name).Block(
+			jen.Return(jen.Id(input.Name).Values(jen.DictFunc(func(d jen.Dict) {
+				for _, field := range input.Fields {
+					d[jen.Id(field.Name)] = generateFromGraphQL(field.Type, jen.Id("i").Dot(field.Name))
+				}
+			}))),
+		).Line().Line()
+	}
+
+	return code
+}
+
+// zeroGraphQLResult returns the zero value a resolver of typeName returns
+// alongside an error.
+func zeroGraphQLResult(typeName string) string {
+	scalar, ok := graphqlScalarTypes[typeName]
+	if !ok {
+		return "nil"
+	}
+	switch scalar[1] {
+	case "string":
+		return `""`
+	case "bool":
+		return "false"
+	default:
+		return "0"
+	}
+}
+
+// writeGraphQLSchema writes the schema SDL to path, refusing to replace a file
+// not generated by agrows unless force is set.
+func writeGraphQLSchema(path string, schema string, force bool) error {
+	if !force {
+		if err := CheckOverwritable(path); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(path, []byte(schema), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// GraphQLSchemaPath returns where the schema is written for the given output
+// directory.
+func GraphQLSchemaPath(outputDir string) string {
+	return filepath.Join(outputDir, "agrows.graphql")
+}
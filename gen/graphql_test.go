@@ -0,0 +1,112 @@
+package gen
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const graphqlSource = `package api
+
+type User struct {
+	Name string
+	Tags []string
+}
+
+func Import(users []User, scores []int) (int, error) {
+	return len(users), nil
+}
+
+func Names(users []User) []string {
+	return nil
+}
+
+func Users() []User {
+	return []User{{Name: "ada", Tags: []string{"admin"}}}
+}
+`
+
+const graphqlSchema = `# Code generated by agrows. DO NOT EDIT.
+
+schema {
+  query: Query
+}
+
+type Query {
+  import(users: [UserInput!]!, scores: [Int!]!): Int!
+  names(users: [UserInput!]!): [String!]!
+  users: [User!]!
+}
+
+type User {
+  name: String!
+  tags: [String!]!
+}
+
+input UserInput {
+  name: String!
+  tags: [String!]!
+}
+`
+
+// graphqlStub stands in for graphql-go, of which the resolver only uses
+// ParseSchema.
+const graphqlStub = `package graphql
+
+type Schema struct{}
+
+func ParseSchema(schema string, resolver any) (*Schema, error) {
+	return &Schema{}, nil
+}
+`
+
+// graphqlTest calls the resolver of graphqlSource the way graphql-go does.
+const graphqlTest = `package api
+
+import "testing"
+
+func TestResolver(t *testing.T) {
+	var resolver AgrowsResolver
+	var args struct {
+		Users  []agrowsUserInput
+		Scores []int32
+	}
+	args.Users = []agrowsUserInput{{Name: "ada", Tags: []string{"admin"}}, {Name: "alan"}}
+	if n, err := resolver.Import(args); n != 2 || err != nil {
+		t.Errorf("Import returned %d, %v, want 2", n, err)
+	}
+
+	var namesArgs struct{ Users []agrowsUserInput }
+	if names, err := resolver.Names(namesArgs); names == nil || len(names) != 0 || err != nil {
+		t.Errorf("Names returned %#v, %v, want an empty list for nil", names, err)
+	}
+	users, err := resolver.Users()
+	if err != nil || len(users) != 1 || users[0].Name() != "ada" || len(users[0].Tags()) != 1 || users[0].Tags()[0] != "admin" {
+		t.Errorf("Users resolved to %v", users)
+	}
+}
+`
+
+func TestGraphQLSlices(t *testing.T) {
+	schema := generate(t, graphqlSource, Options{GraphQL: true, GraphQLSchemaOnly: true})
+	if string(schema) != graphqlSchema {
+		t.Errorf("got schema\n%s\nwant\n%s", schema, graphqlSchema)
+	}
+
+	dir := newTestModule(t)
+	writeFile(t, filepath.Join(dir, "graphql", "go.mod"), "module "+graphqlPackage+"\n\ngo 1.22\n")
+	writeFile(t, filepath.Join(dir, "graphql", "graphql.go"), graphqlStub)
+	mustRunGo(t, dir, nil, "mod", "edit", "-require", graphqlPackage+"@v0.0.0", "-replace", graphqlPackage+"=./graphql")
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api.go"), generate(t, graphqlSource, Options{GraphQL: true}))
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api_test.go"), graphqlTest)
+	mustRunGo(t, dir, nil, "test", "./server")
+}
+
+func TestGraphQLUnsupported(t *testing.T) {
+	src := apiSource + "\nfunc Lookup(ids map[string]int) int { return 0 }\n"
+	err := Generate(strings.NewReader(src), Options{FileName: "api.go", GraphQL: true, GraphQLSchemaOnly: true}, &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "unsupported type map[string]int of parameter ids of Lookup for GraphQL") {
+		t.Errorf("got error %v", err)
+	}
+}
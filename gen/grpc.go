@@ -0,0 +1,357 @@
+package gen
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/dave/dst"
+	"github.com/dave/jennifer/jen"
+)
+
+const grpcPackage = "google.golang.org/grpc"
+
+// grpcProtoPackage is the name of the Go package protoc generates from the
+// .proto file, and of the directory it is written to.
+const grpcProtoPackage = "agrowspb"
+
+// protoScalarTypes maps the Go types supported by server-grpc to their proto
+// type and the Go type protoc generates for it.
+var protoScalarTypes = map[string][2]string{
+	"string":  {"string", "string"},
+	"bool":    {"bool", "bool"},
+	"int":     {"int64", "int64"},
+	"int8":    {"int32", "int32"},
+	"int16":   {"int32", "int32"},
+	"int32":   {"int32", "int32"},
+	"rune":    {"int32", "int32"},
+	"int64":   {"int64", "int64"},
+	"uint":    {"uint64", "uint64"},
+	"uint8":   {"uint32", "uint32"},
+	"byte":    {"uint32", "uint32"},
+	"uint16":  {"uint32", "uint32"},
+	"uint32":  {"uint32", "uint32"},
+	"uint64":  {"uint64", "uint64"},
+	"float32": {"float", "float32"},
+	"float64": {"double", "float64"},
+}
+
+// protoMessage is a struct from the source that is sent as a proto message.
+type protoMessage struct {
+	Name   string
+	Fields []protoField
+}
+
+type protoField struct {
+	// Name is the name of the Go field, it is used for the proto field too.
+	Name string
+	Type string
+}
+
+// grpcResultName returns the response field holding the result at index.
+func grpcResultName(index int) string {
+	return fmt.Sprintf("result%d", index)
+}
+
+// protoGoName returns the name protoc-gen-go gives the Go field generated for
+// the proto field name.
+func protoGoName(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c == '_' && i == 0:
+			b.WriteByte('X')
+		case c == '_' && i+1 < len(name) && isASCIILower(name[i+1]):
+			// the following letter is capitalized instead
+		case isASCIILower(c):
+			b.WriteByte(c - 'a' + 'A')
+			for ; i+1 < len(name) && isASCIILower(name[i+1]); i++ {
+				b.WriteByte(name[i+1])
+			}
+		default:
+			b.WriteByte(c)
+			for ; i+1 < len(name) && isASCIILower(name[i+1]); i++ {
+				b.WriteByte(name[i+1])
+			}
+		}
+	}
+	return b.String()
+}
+
+func isASCIILower(c byte) bool {
+	return 'a' <= c && c <= 'z'
+}
+
+// collectProtoMessages returns the structs used by the functions, including
+// structs nested in them, in the order they are first used.
+func collectProtoMessages(infos []FuncInfo, typeMap map[string]dst.Node) ([]protoMessage, error) {
+	var messages []protoMessage
+	seen := make(map[string]bool)
+
+	var visit func(typeName, usage string) error
+	visit = func(typeName, usage string) error {
+		if _, ok := protoScalarTypes[typeName]; ok || typeName == "error" || seen[typeName] {
+			return nil
+		}
+		structType, ok := typeMap[typeName].(*dst.StructType)
+		if !ok {
+			return fmt.Errorf("unsupported type %s of %s for gRPC", typeName, usage)
+		}
+		seen[typeName] = true
+
+		message := protoMessage{Name: typeName}
+		for _, field := range structType.Fields.List {
+			if len(field.Names) == 0 {
+				return fmt.Errorf("embedded field in %s is not supported for gRPC", typeName)
+			}
+			fieldType, ok := field.Type.(*dst.Ident)
+			if !ok {
+				return fmt.Errorf("unsupported type %s of field %s.%s for gRPC", typeString(field.Type), typeName, field.Names[0].Name)
+			}
+			for _, name := range field.Names {
+				if !name.IsExported() {
+					continue
+				}
+				if err := visit(fieldType.Name, fmt.Sprintf("field %s.%s", typeName, name.Name)); err != nil {
+					return err
+				}
+				message.Fields = append(message.Fields, protoField{Name: name.Name, Type: fieldType.Name})
+			}
+		}
+		messages = append(messages, message)
+		return nil
+	}
+
+	for _, info := range infos {
+		for _, paramInfo := range info.Params {
+			usage := fmt.Sprintf("parameter %s of %s", paramInfo.DstField.Names[0].Name, info.OriginalIdentifier.Name)
+			if err := visit(typeString(paramInfo.DstField.Type), usage); err != nil {
+				return nil, err
+			}
+		}
+		for _, resultInfo := range info.Results {
+			usage := fmt.Sprintf("result of %s", info.OriginalIdentifier.Name)
+			if err := visit(typeString(resultInfo.DstField.Type), usage); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return messages, nil
+}
+
+// protoType returns the proto type of the Go type typeName.
+func protoType(typeName string) string {
+	if scalar, ok := protoScalarTypes[typeName]; ok {
+		return scalar[0]
+	}
+	return typeName
+}
+
+// generateProto renders the .proto file declaring AgrowsService with one rpc
+// per function. Error results are not part of the response, they are returned
+// as the error of the call.
+func (opts *Options) generateProto(infos []FuncInfo, messages []protoMessage) string {
+	var b strings.Builder
+	b.WriteString("// Code generated by agrows. DO NOT EDIT.\n\n")
+	b.WriteString("syntax = \"proto3\";\n\n")
+	fmt.Fprintf(&b, "package %s;\n\n", grpcProtoPackage)
+	fmt.Fprintf(&b, "option go_package = \"%s;%s\";\n\n", opts.GRPCPackage, grpcProtoPackage)
+
+	b.WriteString("service AgrowsService {\n")
+	for _, info := range infos {
+		name := info.OriginalIdentifier.Name
+		fmt.Fprintf(&b, "  rpc %s(%sRequest) returns (%sResponse);\n", name, name, name)
+	}
+	b.WriteString("}\n")
+
+	for _, info := range infos {
+		name := info.OriginalIdentifier.Name
+		fmt.Fprintf(&b, "\nmessage %sRequest {\n", name)
+		for i, paramInfo := range info.Params {
+			fmt.Fprintf(&b, "  %s %s = %d;\n", protoType(paramInfo.DstField.Type.(*dst.Ident).Name), paramInfo.DstField.Names[0].Name, i+1)
+		}
+		b.WriteString("}\n")
+
+		fmt.Fprintf(&b, "\nmessage %sResponse {\n", name)
+		for i, resultInfo := range info.Results {
+			typeName := typeString(resultInfo.DstField.Type)
+			if typeName == "error" {
+				continue
+			}
+			fmt.Fprintf(&b, "  %s %s = %d;\n", protoType(typeName), grpcResultName(i), i+1)
+		}
+		b.WriteString("}\n")
+	}
+
+	for _, message := range messages {
+		fmt.Fprintf(&b, "\nmessage %s {\n", message.Name)
+		for i, field := range message.Fields {
+			fmt.Fprintf(&b, "  %s %s = %d;\n", protoType(field.Type), field.Name, i+1)
+		}
+		b.WriteString("}\n")
+	}
+
+	return b.String()
+}
+
+// generateFromProto converts value, as generated by protoc, to the Go type
+// typeName of the source.
+func generateFromProto(typeName string, value *jen.Statement) *jen.Statement {
+	scalar, ok := protoScalarTypes[typeName]
+	if !ok {
+		return jen.Id("agrows" + typeName + "FromProto").Call(value)
+	}
+	if scalar[1] == typeName {
+		return value
+	}
+	return jen.Id(typeName).Call(value)
+}
+
+// generateToProto converts value of the Go type typeName to the type protoc
+// generates for it.
+func generateToProto(typeName string, value *jen.Statement) *jen.Statement {
+	scalar, ok := protoScalarTypes[typeName]
+	if !ok {
+		return jen.Id("agrows" + typeName + "ToProto").Call(value)
+	}
+	if scalar[1] == typeName {
+		return value
+	}
+	return jen.Id(scalar[1]).Call(value)
+}
+
+// generateGRPCServer emits AgrowsGRPCServer implementing the service generated
+// by protoc with the renamed source functions, the converters between the
+// source structs and their messages, and RegisterAgrowsGRPCServer.
+func (opts *Options) generateGRPCServer(infos []FuncInfo, messages []protoMessage) *jen.Statement {
+	code := jen.Comment("AgrowsGRPCServer implements AgrowsServiceServer with the functions of the source file.").Line().
+		Type().Id("AgrowsGRPCServer").Struct(
+		jen.Qual(opts.GRPCPackage, "UnimplementedAgrowsServiceServer"),
+	).Line().Line()
+
+	for _, info := range infos {
+		name := info.OriginalIdentifier.Name
+		code.Func().Params(jen.Id("AgrowsGRPCServer")).Id(name).Params(
+			jen.Id("ctx").Qual("context", "Context"),
+			jen.Id("request").Op("*").Qual(opts.GRPCPackage, name+"Request"),
+		).Params(
+			jen.Id("response").Op("*").Qual(opts.GRPCPackage, name+"Response"),
+			jen.Err().Error(),
+		).BlockFunc(func(g *jen.Group) {
+			g.Defer().Id("agrowsRecover").Call(jen.Lit(name), jen.Op("&").Err())
+			call := jen.Id(fmt.Sprintf(opts.FunctionFormat, name)).CallFunc(func(callGroup *jen.Group) {
+				if info.TakesContext {
+					callGroup.Id("ctx")
+				}
+				for _, paramInfo := range info.Params {
+					getter := jen.Id("request").Dot("Get" + protoGoName(paramInfo.DstField.Names[0].Name)).Call()
+					callGroup.Add(generateFromProto(paramInfo.DstField.Type.(*dst.Ident).Name, getter))
+				}
+			})
+			if len(info.Results) == 0 {
+				g.Add(call)
+				g.Return(jen.Op("&").Qual(opts.GRPCPackage, name+"Response").Values(), jen.Nil())
+				return
+			}
+
+			varNames := make([]string, len(info.Results))
+			for i, resultInfo := range info.Results {
+				if typeString(resultInfo.DstField.Type) == "error" {
+					varNames[i] = "err" + fmt.Sprint(i)
+				} else {
+					varNames[i] = "ret" + fmt.Sprint(i)
+				}
+			}
+			g.ListFunc(func(retGroup *jen.Group) {
+				for _, varName := range varNames {
+					retGroup.Id(varName)
+				}
+			}).Op(":=").Add(call)
+			for i, resultInfo := range info.Results {
+				if typeString(resultInfo.DstField.Type) == "error" {
+					g.If(jen.Id(varNames[i]).Op("!=").Nil()).Block(
+						jen.Return(jen.Nil(), jen.Id(varNames[i])),
+					)
+				}
+			}
+			g.Return(jen.Op("&").Qual(opts.GRPCPackage, name+"Response").Values(jen.DictFunc(func(d jen.Dict) {
+				for i, resultInfo := range info.Results {
+					typeName := typeString(resultInfo.DstField.Type)
+					if typeName == "error" {
+						continue
+					}
+					d[jen.Id(protoGoName(grpcResultName(i)))] = generateToProto(typeName, jen.Id(varNames[i]))
+				}
+			})), jen.Nil())
+		}).Line().Line()
+	}
+
+	for _, message := range messages {
+		code.Func().Id("agrows" + message.Name + "FromProto").Params(
+			jen.Id("m").Op("*").Qual(opts.GRPCPackage, message.Name),
+		).Id(message.Name).Block(
+			jen.Return(jen.Id(message.Name).Values(jen.DictFunc(func(d jen.Dict) {
+				for _, field := range message.Fields {
+					d[jen.Id(field.Name)] = generateFromProto(field.Type, jen.Id("m").Dot("Get"+protoGoName(field.Name)).Call())
+				}
+			}))),
+		).Line().Line()
+
+		code.Func().Id("agrows"+message.Name+"ToProto").Params(
+			jen.Id("v").Id(message.Name),
+		).Op("*").Qual(opts.GRPCPackage, message.Name).Block(
+			jen.Return(jen.Op("&").Qual(opts.GRPCPackage, message.Name).Values(jen.DictFunc(func(d jen.Dict) {
+				for _, field := range message.Fields {
+					d[jen.Id(protoGoName(field.Name))] = generateToProto(field.Type, jen.Id("v").Dot(field.Name))
+				}
+			}))),
+		).Line().Line()
+	}
+
+	code.Comment("RegisterAgrowsGRPCServer registers AgrowsGRPCServer on s.").Line().
+		Func().Id("RegisterAgrowsGRPCServer").Params(jen.Id("s").Qual(grpcPackage, "ServiceRegistrar")).Block(
+		jen.Qual(opts.GRPCPackage, "RegisterAgrowsServiceServer").Call(jen.Id("s"), jen.Id("AgrowsGRPCServer").Values()),
+	).Line()
+
+	return code
+}
+
+// writeProto writes the .proto file to protoDir and, unless opts.ProtoOnly is set,
+// runs protoc on it to generate the Go message and service code next to it.
+// Like the Go output, a .proto file not generated by agrows is only replaced
+// with force.
+func (opts *Options) writeProto(protoDir string, proto string, force bool) error {
+	if err := os.MkdirAll(protoDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", protoDir, err)
+	}
+	protoFile := filepath.Join(protoDir, "agrows.proto")
+	if !force {
+		if err := CheckOverwritable(protoFile); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(protoFile, []byte(proto), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", protoFile, err)
+	}
+	if opts.ProtoOnly {
+		return nil
+	}
+
+	cmd := exec.Command("protoc",
+		"--proto_path="+protoDir,
+		"--go_out="+protoDir,
+		"--go_opt=paths=source_relative",
+		"--go-grpc_out="+protoDir,
+		"--go-grpc_opt=paths=source_relative",
+		"agrows.proto",
+	)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to run protoc on %s: %w", protoFile, err)
+	}
+	return nil
+}
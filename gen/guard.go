@@ -0,0 +1,104 @@
+package gen
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/dave/jennifer/jen"
+)
+
+// guardFileName returns the file --signature-guard writes next to the output
+// generated in mode from the input fileName.
+func guardFileName(mode byte, fileName string) string {
+	env := "server"
+	switch mode {
+	case CLIENT:
+		env = "client"
+	case GOCLIENT:
+		env = "goclient"
+	}
+	return fmt.Sprintf("agrows_%s_%s_check.go", env, strings.TrimSuffix(filepath.Base(fileName), ".go"))
+}
+
+// sourceParams emits the parameter types of the source function of info.
+func sourceParams(info FuncInfo, imports map[string]string) []jen.Code {
+	var params []jen.Code
+	if info.TakesContext {
+		params = append(params, jen.Qual("context", "Context"))
+	}
+	for _, param := range info.Params {
+		params = append(params, typeCode(param.DstField.Type, imports))
+	}
+	if info.Emit != nil {
+		params = append(params, jen.Func().Params(typeCode(info.Emit.DstField.Type, imports)))
+	}
+	return params
+}
+
+// sourceSignature emits the type of the source function of info.
+func sourceSignature(info FuncInfo, imports map[string]string) *jen.Statement {
+	return jen.Func().Params(sourceParams(info, imports)...).ParamsFunc(func(g *jen.Group) {
+		for _, result := range info.Results {
+			g.Add(typeCode(result.DstField.Type, imports))
+		}
+	})
+}
+
+// clientSignature emits the type of the function generated for info in mode,
+// CLIENT or GOCLIENT.
+func clientSignature(mode byte, info FuncInfo, imports map[string]string) *jen.Statement {
+	return jen.Func().ParamsFunc(func(g *jen.Group) {
+		if mode == GOCLIENT {
+			g.Op("*").Qual(websocketPackage, "Conn")
+		}
+		for _, param := range info.Params {
+			g.Add(typeCode(param.DstField.Type, imports))
+		}
+		if info.Emit != nil {
+			g.Func().Params(typeCode(info.Emit.DstField.Type, imports))
+		}
+	}).ParamsFunc(func(g *jen.Group) {
+		if mode == CLIENT {
+			g.Any()
+			return
+		}
+		for _, result := range info.Results {
+			if typeString(result.DstField.Type) != "error" {
+				g.Add(typeCode(result.DstField.Type, imports))
+			}
+		}
+		g.Error()
+	})
+}
+
+// generateSignatureGuard assigns every function of the output generated in
+// mode to its signature at the time of generation, so the package fails to
+// compile once a signature changes without agrows being run again.
+// serverToClient are kept with their original signature in both server and
+// client.
+func (opts *Options) generateSignatureGuard(mode byte, infos []FuncInfo, serverToClient []FuncInfo, imports map[string]string) *jen.Statement {
+	return jen.Comment("The functions are assigned to their signatures at the time of generation, so a").Line().
+		Comment("changed signature fails to compile until agrows is run again.").Line().
+		Var().DefsFunc(func(g *jen.Group) {
+		for _, info := range infos {
+			name := info.OriginalIdentifier.Name
+			if mode == SERVER {
+				g.Id("_").Add(sourceSignature(info, imports)).Op("=").Id(fmt.Sprintf(opts.FunctionFormat, name))
+			} else {
+				g.Id("_").Add(clientSignature(mode, info, imports)).Op("=").Id(name)
+			}
+		}
+		if mode == GOCLIENT {
+			return
+		}
+		for _, info := range serverToClient {
+			signature := sourceSignature(info, imports)
+			if mode == SERVER {
+				// the calls of the server report failing to send them
+				signature = jen.Func().Params(sourceParams(info, imports)...).Error()
+			}
+			g.Id("_").Add(signature).Op("=").Id(info.OriginalIdentifier.Name)
+		}
+	}).Line()
+}
@@ -0,0 +1,32 @@
+package gen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSignatureGuard(t *testing.T) {
+	dir := newTestModule(t)
+	server := filepath.Join(dir, "server")
+	if err := os.Mkdir(server, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	generated := string(generate(t, apiSource, Options{SignatureGuard: true, OutputDir: server}))
+	writeFile(t, filepath.Join(server, "agrows_server_api.go"), generated)
+	guard, err := os.ReadFile(filepath.Join(server, "agrows_server_api_check.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(strings.Join(strings.Fields(string(guard)), " "), "_ func(int, int) int = agrows_Add") {
+		t.Errorf("the guard does not check agrows_Add:\n%s", guard)
+	}
+	mustRunGo(t, dir, nil, "vet", "./server")
+
+	// a signature changed without generating again fails to compile
+	writeFile(t, filepath.Join(server, "agrows_server_api.go"), strings.Replace(generated, "func agrows_Add(a, b int) int", "func agrows_Add(a, b int64) int", 1))
+	if out, err := runGo(dir, nil, "vet", "./server"); err == nil || !strings.Contains(out, "agrows_server_api_check.go") {
+		t.Errorf("got %v, want the guard to fail:\n%s", err, out)
+	}
+}
@@ -0,0 +1,95 @@
+package gen
+
+import (
+	"fmt"
+	"go/token"
+	"strconv"
+
+	"github.com/dave/dst"
+	"github.com/dave/jennifer/jen"
+)
+
+// harnessFileName is the file --harness writes next to the server output.
+const harnessFileName = "agrows_harness_test.go"
+
+// sourceImports maps the names of the packages imported by tree to their
+// import paths.
+func sourceImports(tree *dst.File) map[string]string {
+	imports := make(map[string]string)
+	for _, decl := range tree.Decls {
+		genDecl, ok := decl.(*dst.GenDecl)
+		if !ok || genDecl.Tok != token.IMPORT {
+			continue
+		}
+		for _, spec := range genDecl.Specs {
+			importSpec := spec.(*dst.ImportSpec)
+			path, err := strconv.Unquote(importSpec.Path.Value)
+			if err != nil {
+				continue
+			}
+			imports[importName(importSpec)] = path
+		}
+	}
+	return imports
+}
+
+// generateHarness emits a TestAgrowsRoundTrip_<Func> per function, encoding a
+// call the way the client does and checking that AgrowsReceive decodes it and
+// dispatches it to the function.
+func (opts *Options) generateHarness(infos []FuncInfo, imports map[string]string) *jen.Statement {
+	code := jen.Commentf("agrowsCheckRoundTrip passes data to %s and fails the test if the", opts.ReceiverName).Line().
+		Comment("call of funcName did not reach the function: it could not be decoded, its").Line().
+		Comment("arguments did not fit the parameters or it was not dispatched.").Line().
+		Func().Id("agrowsCheckRoundTrip").Params(
+		jen.Id("t").Op("*").Qual("testing", "T"),
+		jen.Id("funcName").String(),
+		jen.Id("data").Index().Byte(),
+	).BlockFunc(func(g *jen.Group) {
+		g.Id("t").Dot("Helper").Call()
+		opts.generateReceiveArgs(g, "t")
+		g.List(jen.Id("_"), jen.Err()).Op(":=").Add(opts.generateReceiveCall(jen.Id("data")))
+		g.If(jen.Err().Op("==").Nil()).Block(jen.Return())
+		g.For(jen.List(jen.Id("_"), jen.Id("failure")).Op(":=").Range().Index().String().Values(
+			jen.Lit("failed to decode"),
+			jen.Lit("is not in the received arguments"),
+			jen.Lit("unknown function"),
+		)).Block(
+			jen.If(jen.Qual("strings", "Contains").Call(jen.Err().Dot("Error").Call(), jen.Id("failure"))).Block(
+				jen.Id("t").Dot("Fatalf").Call(jen.Lit("call of %s did not reach the function: %v"), jen.Id("funcName"), jen.Err()),
+			),
+		)
+		g.Comment("any other error comes from the function itself, which was reached")
+	}).Line().Line()
+
+	for _, info := range infos {
+		name := info.OriginalIdentifier.Name
+		code.Func().Id("TestAgrowsRoundTrip_" + name).Params(jen.Id("t").Op("*").Qual("testing", "T")).BlockFunc(func(g *jen.Group) {
+			// the arguments are typed like the parameters of the client function
+			for _, paramInfo := range info.Params {
+				param := paramInfo.DstField
+				g.Var().Id(paramIdent(info, param.Names[0].Name)).Add(typeCode(param.Type, imports))
+			}
+			g.List(jen.Id("data"), jen.Err()).Op(":=").Add(opts.generateEncodeCall(
+				jen.Lit(name),
+				jen.Map(jen.String()).Any().ValuesFunc(func(g *jen.Group) {
+					for _, paramInfo := range info.Params {
+						param := paramInfo.DstField
+						g.Line().Lit(param.Names[0].Name).Op(":").Id(paramIdent(info, param.Names[0].Name))
+					}
+					if info.Emit != nil {
+						g.Line().Lit(subscriptionArg).Op(":").Lit("agrows-harness")
+					}
+					if len(info.Params) > 0 || info.Emit != nil {
+						g.Line()
+					}
+				}),
+			))
+			g.If(jen.Err().Op("!=").Nil()).Block(
+				jen.Id("t").Dot("Fatalf").Call(jen.Lit(fmt.Sprintf("failed to encode call of %s: %%v", name)), jen.Err()),
+			)
+			g.Id("agrowsCheckRoundTrip").Call(jen.Id("t"), jen.Id(funcNameConstant(info)), jen.Id("data"))
+		}).Line().Line()
+	}
+
+	return code
+}
@@ -0,0 +1,35 @@
+package gen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHarness(t *testing.T) {
+	dir := newTestModule(t)
+	server := filepath.Join(dir, "server")
+	if err := os.Mkdir(server, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(server, "agrows_server_calls.go"), generate(t, callsSource, Options{FileName: "calls.go", Harness: true, OutputDir: server}))
+	out := mustRunGo(t, dir, nil, "test", "-v", "./server")
+	for _, name := range callsFunctions {
+		if !strings.Contains(out, "--- PASS: TestAgrowsRoundTrip_"+name) {
+			t.Errorf("TestAgrowsRoundTrip_%s did not pass:\n%s", name, out)
+		}
+	}
+}
+
+func TestHarnessRejectsUnsupportedTypes(t *testing.T) {
+	dir := t.TempDir()
+	src := "package api\n\nfunc NamedReturns(eyjo complex128) (text string, err error) {\n\treturn\n}\n"
+	err := Generate(strings.NewReader(src), Options{FileName: "api.go", Harness: true, OutputDir: dir}, &strings.Builder{})
+	if err == nil || !strings.Contains(err.Error(), "unsupported type complex128") {
+		t.Fatalf("got error %v, want one rejecting complex128", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, harnessFileName)); !os.IsNotExist(err) {
+		t.Errorf("the harness was written for a rejected input")
+	}
+}
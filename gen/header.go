@@ -0,0 +1,133 @@
+package gen
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strings"
+)
+
+// generatedLine is the line the go tool recognizes generated files by. It
+// starts the default header and is added to headers of --header lacking one.
+const generatedLine = "// " + generatedMarker + ". DO NOT EDIT."
+
+// generateHeader returns the comment at the top of the Go outputs: the
+// default line comments, the --header text or nothing with --no-header.
+func (opts *Options) generateHeader() string {
+	if opts.NoHeader {
+		return ""
+	}
+	if opts.Header != "" {
+		return customHeader(opts.Header)
+	}
+
+	header := generatedLine + "\n"
+	if version := toolVersion(); version != "" {
+		header += "// agrows version: " + version + "\n"
+	}
+	// without --timestamp, the same input always yields the same output
+	if !opts.generatedAt.IsZero() {
+		header += fmt.Sprintf("// This code was generated on %s at %s\n", opts.generatedAt.Format("2006-01-02"), opts.generatedAt.Format("15:04:05"))
+	}
+	header += "// Any changes made to this file will be lost.\n"
+	if len(opts.sourceFiles) > 0 {
+		header += "// Source files: " + strings.Join(opts.sourceFiles, ", ") + "\n"
+	}
+	// the blank line keeps the header from becoming the package doc
+	return header + "\n"
+}
+
+// agrowsModulePath is the module agrows is built from, whose version the
+// header names.
+const agrowsModulePath = "github.com/codeupdateandmodificationsystem/agrows"
+
+// toolVersion returns the version of the agrows module the running binary was
+// built with, also when gen is used as a library, see buildVersion.
+func toolVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	return buildVersion(info)
+}
+
+// buildVersion returns the version of the agrows module in info. Builds of a
+// checkout have no version, their VCS revision is returned instead, marked
+// with +dirty if the checkout had changes. It returns "" if info names neither.
+func buildVersion(info *debug.BuildInfo) string {
+	module := &info.Main
+	if module.Path != agrowsModulePath {
+		module = nil
+		for _, dep := range info.Deps {
+			if dep.Path == agrowsModulePath {
+				module = dep
+				break
+			}
+		}
+		if module == nil {
+			return ""
+		}
+		if module.Replace != nil {
+			module = module.Replace
+		}
+	}
+	if module.Version != "" && module.Version != "(devel)" {
+		return module.Version
+	}
+	// the VCS settings only describe the main module
+	if module != &info.Main {
+		return ""
+	}
+	var revision, modified string
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value
+		}
+	}
+	if revision == "" {
+		return ""
+	}
+	if len(revision) > 12 {
+		revision = revision[:12]
+	}
+	if modified == "true" {
+		revision += "+dirty"
+	}
+	return "(devel) " + revision
+}
+
+// customHeader turns text into the header of the outputs. A text of comments
+// is kept as it is, other lines are commented out. The generated line follows
+// unless text already marks the file as generated by agrows. The header is
+// followed by a blank line, so it does not become the package doc.
+func customHeader(text string) string {
+	text = strings.TrimRight(text, "\n")
+	var header strings.Builder
+	if isComment(text) {
+		header.WriteString(text + "\n")
+	} else {
+		for _, line := range strings.Split(text, "\n") {
+			header.WriteString(strings.TrimRight("// "+line, " ") + "\n")
+		}
+	}
+	if !strings.Contains(text, generatedMarker) {
+		header.WriteString("\n" + generatedLine + "\n")
+	}
+	return header.String() + "\n"
+}
+
+// isComment reports whether text is a block comment or every line of it is a
+// line comment.
+func isComment(text string) bool {
+	if strings.HasPrefix(text, "/*") && strings.HasSuffix(text, "*/") {
+		return true
+	}
+	for _, line := range strings.Split(text, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "//") {
+			return false
+		}
+	}
+	return true
+}
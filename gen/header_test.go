@@ -0,0 +1,103 @@
+package gen
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"testing"
+)
+
+func TestHeader(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	tests := []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{"default", Options{}, "// Code generated by agrows. DO NOT EDIT.\n// Any changes made to this file will be lost.\n\npackage api\n"},
+		{"timestamp", Options{Timestamp: true}, "// Code generated by agrows. DO NOT EDIT.\n// This code was generated on 2023-11-14 at 22:13:20\n// Any changes made to this file will be lost.\n\npackage api\n"},
+		{"build tags", Options{BuildTags: "linux"}, "//go:build linux\n// +build linux\n\n// Code generated by agrows. DO NOT EDIT.\n"},
+		{"custom", Options{Header: "Copyright 2024 The Authors"}, "// Copyright 2024 The Authors\n\n// Code generated by agrows. DO NOT EDIT.\n\npackage api\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out := generate(t, apiSource, test.opts)
+			if !strings.HasPrefix(string(out), test.want) {
+				t.Fatalf("output starts with\n%s\nwant\n%s", out[:min(len(out), len(test.want)+40)], test.want)
+			}
+			file, err := parser.ParseFile(token.NewFileSet(), "api.go", out, parser.ParseComments|parser.PackageClauseOnly)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ast.IsGenerated(file) {
+				t.Error("the output is not recognized as generated")
+			}
+			if file.Doc != nil {
+				t.Errorf("the header became the package doc %q", file.Doc.Text())
+			}
+		})
+	}
+}
+
+func TestNoHeader(t *testing.T) {
+	out := string(generate(t, apiSource, Options{Mode: CLIENT, NoHeader: true}))
+	if !strings.HasPrefix(out, "//go:build js && wasm && client\n// +build js,wasm,client\n\npackage main\n") {
+		t.Errorf("the output keeps a header or lost its build constraint:\n%s", out)
+	}
+}
+
+func TestIsGeneratedAfterLongHeader(t *testing.T) {
+	dir := t.TempDir()
+	license := strings.Repeat("Permission is hereby granted, free of charge, to any person.\n", 20)
+	for _, test := range []struct {
+		opts Options
+		want bool
+	}{
+		{Options{Header: license}, true},
+		{Options{NoHeader: true}, false},
+	} {
+		path := filepath.Join(dir, "agrows_server_api.go")
+		writeFile(t, path, generate(t, apiSource+"\n// "+generatedMarker+" in a comment after the package clause\n", test.opts))
+		if got, err := IsGenerated(path); err != nil || got != test.want {
+			t.Errorf("IsGenerated with header %v returned %t, %v, want %t", test.opts.Header != "", got, err, test.want)
+		}
+	}
+}
+
+func TestBuildVersion(t *testing.T) {
+	const revision = "0123456789abcdef0123456789abcdef01234567"
+	main := func(version string, settings ...debug.BuildSetting) *debug.BuildInfo {
+		return &debug.BuildInfo{Main: debug.Module{Path: agrowsModulePath, Version: version}, Settings: settings}
+	}
+	library := func(dep *debug.Module) *debug.BuildInfo {
+		return &debug.BuildInfo{
+			Main:     debug.Module{Path: "example.com/app", Version: "(devel)"},
+			Deps:     []*debug.Module{dep},
+			Settings: []debug.BuildSetting{{Key: "vcs.revision", Value: revision}},
+		}
+	}
+	tests := []struct {
+		name string
+		info *debug.BuildInfo
+		want string
+	}{
+		{"release", main("v1.2.3"), "v1.2.3"},
+		{"pseudo-version", main("v0.0.0-20240629195301-09c2f6712250"), "v0.0.0-20240629195301-09c2f6712250"},
+		{"checkout", main("(devel)", debug.BuildSetting{Key: "vcs.revision", Value: revision}), "(devel) 0123456789ab"},
+		{"modified checkout", main("(devel)", debug.BuildSetting{Key: "vcs.revision", Value: revision}, debug.BuildSetting{Key: "vcs.modified", Value: "true"}), "(devel) 0123456789ab+dirty"},
+		{"no revision", main("(devel)"), ""},
+		{"library", library(&debug.Module{Path: agrowsModulePath, Version: "v1.2.3"}), "v1.2.3"},
+		{"replaced library", library(&debug.Module{Path: agrowsModulePath, Version: "v1.2.3", Replace: &debug.Module{Path: "../agrows"}}), ""},
+		{"other module", library(&debug.Module{Path: "example.com/other", Version: "v1.2.3"}), ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := buildVersion(test.info); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package gen
+
+import (
+	"github.com/dave/jennifer/jen"
+)
+
+// healthCheckFunction is the reserved name --health-check answers calls of.
+// Exported Go functions start upper case, so it can not clash with them.
+const healthCheckFunction = "agrows_health"
+
+// generateHealthCase emits the dispatch case of the health check, placed before
+// the cases of the functions.
+func (opts *Options) generateHealthCase(g *jen.Group) {
+	if !opts.HealthCheck {
+		return
+	}
+	g.Empty()
+	g.Comment("reserved: reports the state of the server, see agrowsHealthCheck")
+	g.Case(jen.Lit(healthCheckFunction)).Block(
+		jen.Return(jen.Id("agrowsHealthCheck").Call(), jen.Nil()),
+	)
+}
+
+// generateHealthCheck emits agrowsHealthCheck and the state it reports.
+func generateHealthCheck(infos []FuncInfo) *jen.Statement {
+	code := jen.Comment("AgrowsVersion is reported by the health check, e.g. set with").Line().
+		Comment("-ldflags \"-X <package>.AgrowsVersion=v1.2.3\".").Line().
+		Var().Id("AgrowsVersion").Op("=").Lit("dev").Line().Line()
+
+	code.Var().Id("agrowsStartTime").Op("=").Qual("time", "Now").Call().Line().Line()
+
+	code.Var().Id("agrowsRegisteredFunctions").Op("=").Index().String().ValuesFunc(func(g *jen.Group) {
+		for _, info := range infos {
+			g.Lit(info.OriginalIdentifier.Name)
+		}
+	}).Line().Line()
+
+	code.Comment("agrowsHealthCheck answers calls of '"+healthCheckFunction+"' with the uptime in seconds, the").Line().
+		Comment("number of other calls running, the registered functions and AgrowsVersion as JSON.").Line().
+		Func().Id("agrowsHealthCheck").Params().String().Block(
+		jen.List(jen.Id("data"), jen.Id("_")).Op(":=").Qual("encoding/json", "Marshal").Call(jen.Struct(
+			jen.Id("Uptime").Float64().Tag(map[string]string{"json": "uptime"}),
+			jen.Id("ActiveRequests").Int64().Tag(map[string]string{"json": "activeRequests"}),
+			jen.Id("RegisteredFunctions").Index().String().Tag(map[string]string{"json": "registeredFunctions"}),
+			jen.Id("Version").String().Tag(map[string]string{"json": "version"}),
+		).Values(jen.Dict{
+			jen.Id("Uptime"):              jen.Qual("time", "Since").Call(jen.Id("agrowsStartTime")).Dot("Seconds").Call(),
+			jen.Id("ActiveRequests"):      jen.Id("agrowsActiveRequests").Dot("Load").Call().Op("-").Lit(1),
+			jen.Id("RegisteredFunctions"): jen.Id("agrowsRegisteredFunctions"),
+			jen.Id("Version"):             jen.Id("AgrowsVersion"),
+		})),
+		jen.Return(jen.String().Call(jen.Id("data"))),
+	).Line()
+
+	return code
+}
@@ -0,0 +1,197 @@
+package gen
+
+import (
+	"fmt"
+
+	"github.com/dave/jennifer/jen"
+)
+
+// generateHTTPHandlers emits an AgrowsHTTP_<Func> handler per function, their
+// shared helpers and RegisterAgrowsHTTPHandlers. Handlers take a POST body of
+// the form {"params": {...}} and answer with {"result": ...} or {"error": "..."}.
+func (opts *Options) generateHTTPHandlers(infos []FuncInfo) *jen.Statement {
+	code := jen.Null()
+
+	for _, info := range infos {
+		code.Add(opts.generateHTTPHandler(info))
+	}
+
+	code.Comment("RegisterAgrowsHTTPHandlers registers the handler of every function on mux at").Line().
+		Comment("prefix + \"/\" + function name.").Line().
+		Func().Id("RegisterAgrowsHTTPHandlers").Params(
+		jen.Id("mux").Op("*").Qual("net/http", "ServeMux"),
+		jen.Id("prefix").String(),
+	).BlockFunc(func(g *jen.Group) {
+		for _, info := range infos {
+			g.Id("mux").Dot("HandleFunc").Call(
+				jen.Id("prefix").Op("+").Lit("/"+info.OriginalIdentifier.Name),
+				jen.Id(httpHandlerName(info)),
+			)
+		}
+	}).Line().Line()
+
+	code.Add(opts.generateHTTPHelpers())
+
+	return code
+}
+
+func httpHandlerName(info FuncInfo) string {
+	return "AgrowsHTTP_" + info.OriginalIdentifier.Name
+}
+
+func (opts *Options) generateHTTPHandler(info FuncInfo) *jen.Statement {
+	name := info.OriginalIdentifier.Name
+
+	return jen.Commentf("%s serves the RPC '%s' over HTTP.", httpHandlerName(info), info.Signature()).Line().
+		Func().Id(httpHandlerName(info)).Params(
+		jen.Id("w").Qual("net/http", "ResponseWriter"),
+		jen.Id("r").Op("*").Qual("net/http", "Request"),
+	).BlockFunc(func(g *jen.Group) {
+		g.If(jen.Id("r").Dot("Method").Op("!=").Qual("net/http", "MethodPost")).Block(
+			jen.Id("agrowsWriteHTTPError").Call(
+				jen.Id("w"),
+				jen.Qual("net/http", "StatusMethodNotAllowed"),
+				jen.Qual("fmt", "Errorf").Call(jen.Lit("%s: method %s not allowed"), jen.Lit(name), jen.Id("r").Dot("Method")),
+			),
+			jen.Return(),
+		)
+
+		g.Add(opts.generateShutdownCheck(
+			jen.Id("agrowsWriteHTTPError").Call(jen.Id("w"), jen.Qual("net/http", "StatusServiceUnavailable"), shutdownError()),
+			jen.Return(),
+		))
+
+		if len(info.Params) != 0 {
+			g.Var().Id("request").Id(requestTypeName(info))
+			g.If(
+				jen.Err().Op(":=").Id("agrowsDecodeHTTPRequest").CallFunc(func(g *jen.Group) {
+					g.Id("r")
+					g.Op("&").Id("request")
+					for _, paramInfo := range info.Params {
+						g.Lit(paramInfo.DstField.Names[0].Name)
+					}
+				}),
+				jen.Err().Op("!=").Nil(),
+			).Block(
+				jen.Id("agrowsWriteHTTPError").Call(
+					jen.Id("w"),
+					jen.Qual("net/http", "StatusBadRequest"),
+					jen.Qual("fmt", "Errorf").Call(jen.Lit("%s: %w"), jen.Lit(name), jen.Err()),
+				),
+				jen.Return(),
+			)
+		}
+
+		call := jen.Id(fmt.Sprintf(opts.FunctionFormat, name)).CallFunc(func(g *jen.Group) {
+			if info.TakesContext {
+				g.Id("r").Dot("Context").Call()
+			}
+			for i := range info.Params {
+				g.Id("request").Dot(requestFieldName(info, i))
+			}
+		})
+
+		var values []jen.Code
+		errName := ""
+		if len(info.Results) == 0 {
+			g.Add(call)
+		} else {
+			names := make([]jen.Code, len(info.Results))
+			for i, result := range info.Results {
+				if typeString(result.DstField.Type) == "error" && errName == "" {
+					errName = fmt.Sprintf("err%d", i)
+					names[i] = jen.Id(errName)
+					continue
+				}
+				names[i] = jen.Id(fmt.Sprintf("ret%d", i))
+				values = append(values, jen.Id(fmt.Sprintf("ret%d", i)))
+			}
+			g.List(names...).Op(":=").Add(call)
+		}
+
+		if errName != "" {
+			g.If(jen.Id(errName).Op("!=").Nil()).BlockFunc(func(g *jen.Group) {
+				if len(opts.errorCodes) > 0 {
+					g.Id(errName).Op("=").Add(opts.generateMapErrorCall(jen.Id(errName)))
+				}
+				g.Id("agrowsWriteHTTPError").Call(jen.Id("w"), jen.Id("agrowsHTTPStatus").Call(jen.Id(errName)), jen.Id(errName))
+				g.Return()
+			})
+		}
+
+		var response jen.Code
+		switch len(values) {
+		case 0:
+			response = jen.Map(jen.String()).Any().Values()
+		case 1:
+			response = jen.Map(jen.String()).Any().Values(jen.Dict{jen.Lit("result"): values[0]})
+		default:
+			response = jen.Map(jen.String()).Any().Values(jen.Dict{jen.Lit("result"): jen.Index().Any().Values(values...)})
+		}
+		g.Id("agrowsWriteJSON").Call(jen.Id("w"), jen.Qual("net/http", "StatusOK"), response)
+	}).Line().Line()
+}
+
+func (opts *Options) generateHTTPHelpers() *jen.Statement {
+	writeJSON := jen.Func().Id("agrowsWriteJSON").Params(
+		jen.Id("w").Qual("net/http", "ResponseWriter"),
+		jen.Id("status").Int(),
+		jen.Id("value").Any(),
+	).Block(
+		jen.Id("w").Dot("Header").Call().Dot("Set").Call(jen.Lit("Content-Type"), jen.Lit("application/json")),
+		jen.Id("w").Dot("WriteHeader").Call(jen.Id("status")),
+		jen.Id("_").Op("=").Qual("encoding/json", "NewEncoder").Call(jen.Id("w")).Dot("Encode").Call(jen.Id("value")),
+	).Line().Line()
+
+	writeError := jen.Func().Id("agrowsWriteHTTPError").Params(
+		jen.Id("w").Qual("net/http", "ResponseWriter"),
+		jen.Id("status").Int(),
+		jen.Err().Error(),
+	).Block(
+		jen.Id("agrowsWriteJSON").Call(jen.Id("w"), jen.Id("status"), jen.Map(jen.String()).String().Values(jen.Dict{
+			jen.Lit("error"): jen.Err().Dot("Error").Call(),
+		})),
+	).Line().Line()
+
+	decode := jen.Func().Id("agrowsDecodeHTTPRequest").Params(
+		jen.Id("r").Op("*").Qual("net/http", "Request"),
+		jen.Id("request").Any(),
+		jen.Id("required").Op("...").String(),
+	).Error().Block(
+		jen.Var().Id("body").Struct(
+			jen.Id("Params").Map(jen.String()).Qual("encoding/json", "RawMessage").Tag(map[string]string{"json": "params"}),
+		),
+		jen.If(jen.Err().Op(":=").Qual("encoding/json", "NewDecoder").Call(jen.Id("r").Dot("Body")).Dot("Decode").Call(jen.Op("&").Id("body")), jen.Err().Op("!=").Nil()).Block(
+			jen.Return(jen.Qual("fmt", "Errorf").Call(jen.Lit("failed to decode request body: %w"), jen.Err())),
+		),
+		jen.For(jen.List(jen.Id("_"), jen.Id("key")).Op(":=").Range().Id("required")).Block(
+			jen.If(jen.List(jen.Id("_"), jen.Id("ok")).Op(":=").Id("body").Dot("Params").Index(jen.Id("key")), jen.Op("!").Id("ok")).Block(
+				jen.Return(jen.Qual("fmt", "Errorf").Call(jen.Lit("parameter '%s' is not in the received arguments"), jen.Id("key"))),
+			),
+		),
+		jen.List(jen.Id("data"), jen.Err()).Op(":=").Qual("encoding/json", "Marshal").Call(jen.Id("body").Dot("Params")),
+		jen.If(jen.Err().Op("!=").Nil()).Block(
+			jen.Return(jen.Err()),
+		),
+		jen.If(jen.Err().Op(":=").Qual("encoding/json", "Unmarshal").Call(jen.Id("data"), jen.Id("request")), jen.Err().Op("!=").Nil()).Block(
+			jen.Return(jen.Qual("fmt", "Errorf").Call(jen.Lit("failed to decode parameters: %w"), jen.Err())),
+		),
+		jen.Return(jen.Nil()),
+	).Line().Line()
+
+	status := jen.Func().Id("agrowsHTTPStatus").Params(jen.Err().Error()).Int().BlockFunc(func(g *jen.Group) {
+		if opts.HTTPErrors == "codes" {
+			g.Var().Id("agrowsErr").Op("*").Id("AgrowsError")
+			g.If(
+				jen.Qual("errors", "As").Call(jen.Err(), jen.Op("&").Id("agrowsErr")).Op("&&").
+					Id("agrowsErr").Dot("Code").Op(">=").Lit(400).Op("&&").
+					Id("agrowsErr").Dot("Code").Op("<=").Lit(599),
+			).Block(
+				jen.Return(jen.Id("agrowsErr").Dot("Code")),
+			)
+		}
+		g.Return(jen.Qual("net/http", "StatusInternalServerError"))
+	}).Line()
+
+	return jen.Add(writeJSON, writeError, decode, status)
+}
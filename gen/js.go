@@ -0,0 +1,113 @@
+package gen
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// jsGlue connects the page to the server and provides the global sendMessage
+// the WASM client sends its calls with. Lost connections are re-established
+// with exponential backoff, calls made in the meantime are queued.
+const jsGlue = `// Code generated by agrows. DO NOT EDIT.
+
+// agrowsConnect opens a WebSocket to url and installs the global sendMessage
+// used by the agrows WASM client. Options:
+//   maxRetries: reconnection attempts before giving up (default 10)
+//   baseDelay:  delay before the first reconnection in ms, doubled on every
+//               further attempt (default 250)
+//   maxDelay:   upper bound of the delay in ms (default 30000)
+//   onMessage:  called with the data of every message from the server not
+//               handled by the global agrowsHandleMessage of the client,
+//               which handles responses, events and calls of
+//               server-to-client functions
+//   onError:    called with an Error for calls that can not be sent
+// Calls made while disconnected are queued and sent once the connection is
+// back. Once all retries failed, queued and further calls are rejected through
+// onError. The returned object closes the connection for good.
+function agrowsConnect(url, options = {}) {
+  const maxRetries = options.maxRetries ?? 10;
+  const baseDelay = options.baseDelay ?? 250;
+  const maxDelay = options.maxDelay ?? 30000;
+  const onMessage = options.onMessage ?? (() => {});
+  const onError = options.onError ?? ((error) => console.error(error));
+
+  const queue = [];
+  let socket = null;
+  let retries = 0;
+  let failed = false;
+  let closed = false;
+
+  function flush() {
+    while (queue.length > 0 && socket.readyState === WebSocket.OPEN) {
+      socket.send(queue.shift());
+    }
+  }
+
+  function open() {
+    socket = new WebSocket(url);
+    socket.binaryType = "arraybuffer";
+    socket.onopen = () => {
+      retries = 0;
+      flush();
+    };
+    socket.onmessage = (event) => {
+      // the client routes responses, events and calls meant for it, text
+      // replies come from servers not answering with responses
+      if (typeof globalThis.agrowsHandleMessage === "function" && globalThis.agrowsHandleMessage(event.data)) {
+        return;
+      }
+      onMessage(event.data);
+    };
+    socket.onclose = () => {
+      if (closed) {
+        return;
+      }
+      if (retries >= maxRetries) {
+        failed = true;
+        const dropped = queue.splice(0);
+        onError(new Error("agrows: connection to " + url + " lost, giving up after " + retries + " retries and dropping " + dropped.length + " queued calls"));
+        return;
+      }
+      // full jitter keeps many clients from reconnecting at the same moment
+      const delay = Math.min(baseDelay * 2 ** retries, maxDelay) * Math.random();
+      retries++;
+      setTimeout(open, delay);
+    };
+  }
+
+  globalThis.sendMessage = (data) => {
+    if (closed || failed) {
+      onError(new Error("agrows: not connected to " + url));
+      return;
+    }
+    queue.push(data);
+    flush();
+  };
+
+  open();
+
+  return {
+    close() {
+      closed = true;
+      queue.length = 0;
+      socket.close();
+    },
+  };
+}
+`
+
+// writeJSGlue writes the JavaScript glue to agrows.js in outputDir, refusing to
+// replace a file not generated by agrows unless force is set.
+func writeJSGlue(outputDir string, force bool) error {
+	path := filepath.Join(outputDir, "agrows.js")
+	if !force {
+		if err := CheckOverwritable(path); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(path, []byte(jsGlue), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package gen
+
+import (
+	"github.com/dave/jennifer/jen"
+)
+
+const jwtPackage = "github.com/golang-jwt/jwt/v5"
+
+// generateJWTCheck emits the validation of the token a call was made with. The
+// claims of the token are passed to AgrowsAuthorize.
+func (opts *Options) generateJWTCheck() jen.Code {
+	if opts.Auth != "jwt" {
+		return jen.Null()
+	}
+	return jen.Add(
+		jen.List(jen.Id("claims"), jen.Err()).Op(":=").Id("agrowsValidateJWT").Call(jen.Id("token")).Line(),
+		jen.If(jen.Err().Op("!=").Nil()).Block(
+			jen.Return(jen.Lit(""), jen.Op("&").Id("AgrowsError").Values(jen.Dict{
+				jen.Id("Code"):    jen.Lit(401),
+				jen.Id("Message"): jen.Lit("unauthorized: ").Op("+").Err().Dot("Error").Call(),
+			})),
+		),
+	)
+}
+
+// generateJWTValidation emits AgrowsJWTSecret and agrowsValidateJWT, which
+// verifies tokens signed with --auth-algorithm.
+func (opts *Options) generateJWTValidation() *jen.Statement {
+	secret := "the HMAC secret"
+	var key jen.Code = jen.Return(jen.Id("AgrowsJWTSecret"), jen.Nil())
+	if opts.AuthAlgorithm == "RS256" {
+		secret = "the PEM encoded RSA public key"
+		key = jen.Return(jen.Qual(jwtPackage, "ParseRSAPublicKeyFromPEM").Call(jen.Id("AgrowsJWTSecret")))
+	}
+
+	code := jen.Commentf("AgrowsJWTSecret is %s the tokens of calls are verified with.", secret).Line().
+		Comment("Calls are rejected while it is empty.").Line().
+		Var().Id("AgrowsJWTSecret").Index().Byte().Line().Line()
+
+	code.Commentf("agrowsValidateJWT verifies token to be signed with %s and not expired, and", opts.AuthAlgorithm).Line().
+		Comment("returns its claims.").Line().
+		Func().Id("agrowsValidateJWT").Params(jen.Id("token").String()).Params(jen.Map(jen.String()).Any(), jen.Error()).Block(
+		jen.If(jen.Len(jen.Id("AgrowsJWTSecret")).Op("==").Lit(0)).Block(
+			jen.Return(jen.Nil(), jen.Qual("errors", "New").Call(jen.Lit("AgrowsJWTSecret is not set"))),
+		),
+		jen.Id("claims").Op(":=").Qual(jwtPackage, "MapClaims").Values(),
+		jen.List(jen.Id("_"), jen.Err()).Op(":=").Qual(jwtPackage, "ParseWithClaims").Call(
+			jen.Id("token"),
+			jen.Id("claims"),
+			jen.Func().Params(jen.Op("*").Qual(jwtPackage, "Token")).Params(jen.Any(), jen.Error()).Block(key),
+			jen.Qual(jwtPackage, "WithValidMethods").Call(jen.Index().String().Values(jen.Lit(opts.AuthAlgorithm))),
+		),
+		jen.If(jen.Err().Op("!=").Nil()).Block(
+			jen.Return(jen.Nil(), jen.Err()),
+		),
+		jen.Return(jen.Id("claims"), jen.Nil()),
+	).Line()
+
+	if opts.WebSocket {
+		code.Line().Comment("agrowsRequestToken returns the bearer token of r, or its token query parameter as").Line().
+			Comment("browsers can not set headers on WebSocket connections.").Line().
+			Func().Id("agrowsRequestToken").Params(jen.Id("r").Op("*").Qual("net/http", "Request")).String().Block(
+			jen.If(jen.List(jen.Id("token"), jen.Id("ok")).Op(":=").Qual("strings", "CutPrefix").Call(jen.Id("r").Dot("Header").Dot("Get").Call(jen.Lit("Authorization")), jen.Lit("Bearer ")), jen.Id("ok")).Block(
+				jen.Return(jen.Id("token")),
+			),
+			jen.Return(jen.Id("r").Dot("URL").Dot("Query").Call().Dot("Get").Call(jen.Lit("token"))),
+		).Line()
+	}
+
+	return code
+}
@@ -0,0 +1,109 @@
+package gen
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// jwtSource has a function to call with tokens.
+const jwtSource = `package api
+
+func Add(a int, b int) int {
+	return a + b
+}
+`
+
+// jwtTest runs in the generated server of jwtSource with --auth=jwt and
+// --rate-limit=2/m, whose limiter does not refill during the test.
+const jwtTest = `package api
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/codeupdateandmodificationsystem/protocol"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func sign(t *testing.T, secret string, claims jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// code returns the code of the AgrowsError of the call with token, 0 if it
+// succeeded.
+func code(t *testing.T, token string) int {
+	t.Helper()
+	data, err := protocol.EncodeFunctionCall("Add", protocol.Options(), map[string]any{"a": 1, "b": 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = AgrowsReceive(data, "ada", token)
+	var agrowsErr *AgrowsError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &agrowsErr):
+		return agrowsErr.Code
+	default:
+		t.Fatalf("call failed: %v", err)
+		return 0
+	}
+}
+
+func TestJWT(t *testing.T) {
+	AgrowsJWTSecret = []byte("secret")
+	var subjects []any
+	AgrowsAuthorize = func(claims map[string]any, fn string, args map[string]protocol.Argument) error {
+		subjects = append(subjects, claims["sub"])
+		return nil
+	}
+	valid := sign(t, "secret", jwt.MapClaims{"sub": "ada", "exp": time.Now().Add(time.Hour).Unix()})
+
+	// rejected tokens do not use up the limit of 2 calls
+	for _, token := range []string{
+		"",
+		sign(t, "other", jwt.MapClaims{"sub": "ada"}),
+		sign(t, "secret", jwt.MapClaims{"sub": "ada", "exp": time.Now().Add(-time.Hour).Unix()}),
+	} {
+		if got := code(t, token); got != 401 {
+			t.Errorf("got code %d for token %q, want 401", got, token)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if got := code(t, valid); got != 0 {
+			t.Fatalf("call %d with a valid token failed with code %d", i, got)
+		}
+	}
+	if got := code(t, valid); got != 429 {
+		t.Errorf("got code %d for a call over the limit, want 429", got)
+	}
+
+	if len(subjects) != 2 || subjects[0] != "ada" || subjects[1] != "ada" {
+		t.Errorf("AgrowsAuthorize got the subjects %v, want the claims of the two allowed calls", subjects)
+	}
+}
+`
+
+func TestJWT(t *testing.T) {
+	dir := newTestModule(t, "github.com/golang-jwt/jwt/v5", "github.com/gorilla/websocket", "golang.org/x/time")
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api.go"), generate(t, jwtSource, Options{Auth: "jwt", RateLimit: "2/m"}))
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api_test.go"), jwtTest)
+	mustRunGo(t, dir, nil, "test", "./server")
+
+	variants := map[string]Options{
+		"ws":     {Auth: "jwt", WebSocket: true},
+		"http":   {Auth: "jwt", HTTP: true},
+		"struct": {Auth: "jwt", ServerStruct: true},
+		"batch":  {Auth: "jwt", Batch: true, AuthAlgorithm: "RS256"},
+	}
+	for name, opts := range variants {
+		writeFile(t, filepath.Join(dir, name, "agrows_server_api.go"), generate(t, jwtSource, opts))
+	}
+	mustRunGo(t, dir, nil, "vet", "./ws", "./http", "./struct", "./batch")
+}
@@ -0,0 +1,154 @@
+package gen
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/dave/dst"
+)
+
+// SkippedFunc is an exported function of the input that is no RPC function, as
+// reported by ListFiles.
+type SkippedFunc struct {
+	Name string
+	// Position is the "file:line" of the function in the source.
+	Position string
+	Reason   string
+}
+
+type skippedDump struct {
+	Name     string `json:"name"`
+	Position string `json:"position"`
+	Reason   string `json:"reason"`
+}
+
+// ListFiles parses files like GenerateFiles does with opts and returns the RPC
+// functions it would generate code for, along with the exported functions it
+// leaves out and why. Nothing is generated or written.
+func ListFiles(files []SourceFile, opts Options) (Input, []SkippedFunc, error) {
+	if err := opts.check(); err != nil {
+		return Input{}, nil, err
+	}
+
+	tree, dec, err := parseSources(files, opts, true)
+	if err != nil {
+		return Input{FileName: opts.FileName}, nil, err
+	}
+	keep, err := constrainedDecls(tree, dec, parseTags(opts))
+	if err != nil {
+		return Input{FileName: opts.FileName}, nil, err
+	}
+
+	var skipped []SkippedFunc
+	for i, decl := range tree.Decls {
+		fn, ok := decl.(*dst.FuncDecl)
+		if !ok || !fn.Name.IsExported() {
+			continue
+		}
+		name, reason := fn.Name.Name, ""
+		switch {
+		case fn.Recv != nil:
+			if !opts.isSkippedMethod(fn) {
+				continue
+			}
+			name, reason = receiverTypeName(fn)+"."+fn.Name.Name, "a method, only plain functions are RPC functions"
+		case !keep[i]:
+			reason = "left out by its //go:build line"
+		case !opts.isRPCFunction(fn):
+			reason = opts.skipReason(fn)
+		}
+		if reason != "" {
+			skipped = append(skipped, SkippedFunc{Name: name, Position: nodePosition(dec, fn), Reason: reason})
+		}
+	}
+
+	applyConstrainedDecls(tree, keep)
+	input, err := opts.extractInput(tree, dec, opts.FileName)
+	return input, skipped, err
+}
+
+// skipReason explains why the exported function fn is no RPC function.
+func (opts *Options) skipReason(fn *dst.FuncDecl) string {
+	ignored, _, _ := exposeDirectives(fn)
+	switch {
+	case ignored:
+		return "ignored by " + ignoreDirective
+	case len(opts.only) > 0 && !matchesAny(opts.only, fn.Name.Name):
+		return "not matched by --only"
+	default:
+		return "matched by --exclude"
+	}
+}
+
+// WriteList writes the RPC functions of input and the skipped functions as
+// returned by ListFiles, along with configPath, the config file the options
+// were read from, unless it is "". It writes the JSON of DumpFuncs with these
+// added if asJSON is set, otherwise text, one function per block.
+func WriteList(w io.Writer, input Input, skipped []SkippedFunc, configPath string, asJSON bool) error {
+	if asJSON {
+		dump := newInputDump(input)
+		dump.Config = configPath
+		for _, fn := range skipped {
+			dump.Skipped = append(dump.Skipped, skippedDump(fn))
+		}
+		return encodeDump(w, dump)
+	}
+
+	describe := func(info *ParamReflectInfo, named bool) string {
+		text := typeString(info.DstField.Type)
+		if named && len(info.DstField.Names) > 0 && info.DstField.Names[0] != nil {
+			text = info.DstField.Names[0].Name + " " + text
+		}
+		if info.IsStruct {
+			text += " [struct]"
+		}
+		return text
+	}
+
+	var b strings.Builder
+	if configPath != "" {
+		fmt.Fprintf(&b, "Config: %s\n\n", configPath)
+	}
+	for _, info := range input.Functions {
+		fmt.Fprintf(&b, "%s (%s)\n", info.ToIdentifierString(), info.Position)
+		params := make([]string, 0, len(info.Params))
+		for _, param := range info.Params {
+			params = append(params, describe(param, true))
+		}
+		results := make([]string, 0, len(info.Results))
+		for _, result := range info.Results {
+			results = append(results, describe(result, false))
+		}
+		fmt.Fprintf(&b, "    params:  %s\n", strings.Join(params, ", "))
+		fmt.Fprintf(&b, "    results: %s\n", strings.Join(results, ", "))
+
+		var notes []string
+		if info.TakesContext {
+			notes = append(notes, "takes a context")
+		}
+		if info.Timeout > 0 {
+			notes = append(notes, "timeout "+info.Timeout.String())
+		}
+		if info.ServerToClient {
+			notes = append(notes, "server-to-client")
+		}
+		if info.Emit != nil {
+			notes = append(notes, "emits "+describe(info.Emit, false))
+		}
+		if len(notes) > 0 {
+			fmt.Fprintf(&b, "    %s\n", strings.Join(notes, ", "))
+		}
+	}
+	if len(input.Functions) == 0 {
+		fmt.Fprintf(&b, "no RPC functions in %s\n", input.FileName)
+	}
+	if len(skipped) > 0 {
+		b.WriteString("\nSkipped:\n")
+		for _, fn := range skipped {
+			fmt.Fprintf(&b, "    %s (%s): %s\n", fn.Name, fn.Position, fn.Reason)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
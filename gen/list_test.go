@@ -0,0 +1,106 @@
+package gen
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// listSource has RPC functions with a context, a timeout and a struct, and
+// exported functions left out for every reason.
+const listSource = `package api
+
+import (
+	"context"
+	"os"
+)
+
+type User struct {
+	Name string
+}
+
+// Load gives up after a second.
+//
+//agrows:timeout 1s
+func Load(ctx context.Context, id int) (User, error) {
+	return User{}, nil
+}
+
+func Save(user User) error {
+	return nil
+}
+
+//agrows:ignore
+func Normalize(name string) string {
+	return name
+}
+
+func MustLoad(id int) User {
+	return User{}
+}
+
+//go:build !wasm
+func Export(path string) error {
+	return os.WriteFile(path, nil, 0o644)
+}
+`
+
+func listFunctions(t *testing.T, opts Options) (Input, []SkippedFunc) {
+	t.Helper()
+	opts.FileName = "api.go"
+	input, skipped, err := ListFiles([]SourceFile{{Name: "api.go", Src: strings.NewReader(listSource)}}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return input, skipped
+}
+
+func TestListFiles(t *testing.T) {
+	input, skipped := listFunctions(t, Options{Mode: CLIENT, Exclude: []string{"Must.*"}})
+	var b bytes.Buffer
+	if err := WriteList(&b, input, skipped, "", false); err != nil {
+		t.Fatal(err)
+	}
+	want := `Load (api.go:15)
+    params:  id int
+    results: User [struct], error
+    takes a context, timeout 1s
+Save (api.go:19)
+    params:  user User [struct]
+    results: error
+
+Skipped:
+    Normalize (api.go:24): ignored by //agrows:ignore
+    MustLoad (api.go:28): matched by --exclude
+    Export (api.go:33): left out by its //go:build line
+`
+	if b.String() != want {
+		t.Errorf("got list\n%s\nwant\n%s", b.String(), want)
+	}
+
+	input, skipped = listFunctions(t, Options{Only: []string{"Save"}})
+	b.Reset()
+	if err := WriteList(&b, input, skipped, "project/agrows.yaml", true); err != nil {
+		t.Fatal(err)
+	}
+	var dump struct {
+		Config    string `json:"config"`
+		Functions []struct {
+			Name string `json:"name"`
+		} `json:"functions"`
+		Skipped []skippedDump `json:"skipped"`
+	}
+	if err := json.Unmarshal(b.Bytes(), &dump); err != nil {
+		t.Fatalf("%v:\n%s", err, b.String())
+	}
+	if dump.Config != "project/agrows.yaml" {
+		t.Errorf("got config %q, want project/agrows.yaml", dump.Config)
+	}
+	if len(dump.Functions) != 1 || dump.Functions[0].Name != "Save" {
+		t.Errorf("got functions %+v, want Save", dump.Functions)
+	}
+	if len(dump.Skipped) != 4 || dump.Skipped[0] != (skippedDump{"Load", "api.go:15", "not matched by --only"}) {
+		t.Errorf("got skipped functions %+v", dump.Skipped)
+	}
+}
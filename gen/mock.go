@@ -0,0 +1,155 @@
+package gen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dave/jennifer/jen"
+)
+
+func mockVarName(info FuncInfo) string {
+	return "Mock" + info.OriginalIdentifier.Name
+}
+
+// generateMockFuncType emits the signature of the mock of info, which is the
+// one of the source function.
+func generateMockFuncType(info FuncInfo) *jen.Statement {
+	return jen.Func().ParamsFunc(func(g *jen.Group) {
+		if info.TakesContext {
+			g.Qual("context", "Context")
+		}
+		for _, paramInfo := range info.Params {
+			g.Add(typeCode(paramInfo.DstField.Type, nil))
+		}
+		if info.Emit != nil {
+			g.Func().Params(typeCode(info.Emit.DstField.Type, nil))
+		}
+	}).ParamsFunc(func(g *jen.Group) {
+		for _, resultInfo := range info.Results {
+			g.Add(typeCode(resultInfo.DstField.Type, nil))
+		}
+	})
+}
+
+// generateMockVars emits a Mock<Func> variable per function and
+// AgrowsMockReset.
+func generateMockVars(infos []FuncInfo) *jen.Statement {
+	code := jen.Comment("The mocks AgrowsMockReceive dispatches to. A nil mock behaves like a function").Line().
+		Comment("returning the zero values of its results.").Line().
+		Var().DefsFunc(func(g *jen.Group) {
+		for _, info := range infos {
+			g.Id(mockVarName(info)).Add(generateMockFuncType(info))
+		}
+	}).Line().Line()
+
+	code.Comment("AgrowsMockReset sets all mocks back to nil, e.g. between tests.").Line().
+		Func().Id("AgrowsMockReset").Params().BlockFunc(func(g *jen.Group) {
+		for _, info := range infos {
+			g.Id(mockVarName(info)).Op("=").Nil()
+		}
+	}).Line()
+
+	return code
+}
+
+// generateMockReceive emits AgrowsMockReceive, which decodes calls like
+// AgrowsReceive and replies like it, but calls the mocks instead of the
+// functions.
+func (opts *Options) generateMockReceive(infos []FuncInfo) *jen.Statement {
+	return jen.Comment("AgrowsMockReceive decodes a function call from data like AgrowsReceive, calls").Line().
+		Comment("its mock and returns the results formatted like AgrowsReceive would.").Line().
+		Func().Id("AgrowsMockReceive").Params(jen.Id("data").Index().Byte()).Params(jen.String(), jen.Error()).Block(
+		jen.List(jen.Id("functionName"), jen.Id("args"), jen.Err()).Op(":=").Add(opts.generateDecodeCall(jen.Id("data"))),
+		jen.If(jen.Err().Op("!=").Nil()).Block(
+			jen.Return(jen.Lit(""), jen.Qual("fmt", "Errorf").Call(jen.Lit("failed to decode function call: %w"), jen.Err())),
+		),
+		jen.Switch(jen.Id("functionName")).BlockFunc(func(g *jen.Group) {
+			for _, info := range infos {
+				g.Case(jen.Lit(info.OriginalIdentifier.Name)).BlockFunc(func(caseGroup *jen.Group) {
+					generateMockCase(caseGroup, info)
+				})
+			}
+			g.Default().Block(
+				jen.Return(jen.Lit(""), jen.Qual("fmt", "Errorf").Call(jen.Lit("unknown function '%s'"), jen.Id("functionName"))),
+			)
+		}),
+	).Line()
+}
+
+// generateMockCase emits the dispatch of a call of info to its mock.
+func generateMockCase(g *jen.Group, info FuncInfo) {
+	generateRequestDecoding(g, info, jen.Lit(""))
+
+	firstError := ""
+	firstString := ""
+	varNames := make([]string, len(info.Results))
+	for i, resultInfo := range info.Results {
+		switch typeString(resultInfo.DstField.Type) {
+		case "error":
+			varNames[i] = fmt.Sprintf("err%d", i)
+			if firstError == "" {
+				firstError = varNames[i]
+			}
+		case "string":
+			varNames[i] = fmt.Sprintf("str%d", i)
+			if firstString == "" {
+				firstString = varNames[i]
+			}
+		default:
+			varNames[i] = fmt.Sprintf("ret%d", i)
+		}
+		g.Var().Id(varNames[i]).Add(typeCode(resultInfo.DstField.Type, nil))
+	}
+
+	call := jen.Id(mockVarName(info)).CallFunc(func(call *jen.Group) {
+		if info.TakesContext {
+			call.Qual("context", "Background").Call()
+		}
+		for i := range info.Params {
+			call.Id("request").Dot(requestFieldName(info, i))
+		}
+		if info.Emit != nil {
+			// there is no client to send the events to
+			call.Func().Params(typeCode(info.Emit.DstField.Type, nil)).Block()
+		}
+	})
+	if len(varNames) > 0 {
+		call = jen.ListFunc(func(list *jen.Group) {
+			for _, varName := range varNames {
+				list.Id(varName)
+			}
+		}).Op("=").Add(call)
+	}
+	g.If(jen.Id(mockVarName(info)).Op("!=").Nil()).Block(call)
+
+	if firstError != "" {
+		g.If(jen.Id(firstError).Op("!=").Nil()).Block(
+			jen.Return(jen.Lit(""), jen.Id(firstError)),
+		)
+	}
+
+	var values []string
+	for _, varName := range varNames {
+		if !strings.HasPrefix(varName, "err") {
+			values = append(values, varName)
+		}
+	}
+	switch {
+	case len(values) == 0:
+		g.Return(jen.Lit(""), jen.Nil())
+	case jsonResult(info) >= 0:
+		g.Return(generateEncodeResult(g, varNames[jsonResult(info)]), jen.Nil())
+	case firstString != "":
+		g.Return(jen.Id(firstString), jen.Nil())
+	default:
+		// the errors are part of the reply like in AgrowsReceive, they are nil here
+		g.Return(jen.Qual("fmt", "Sprintf").Call(
+			jen.Lit(fmt.Sprintf("'%%+v'%s", strings.Repeat(", '%+v'", len(varNames)-1))),
+			jen.ListFunc(func(list *jen.Group) {
+				for _, varName := range varNames {
+					list.Id(varName)
+				}
+			}),
+		), jen.Nil())
+	}
+}
@@ -0,0 +1,74 @@
+package gen
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// mockSource has a function with a result and one failing.
+const mockSource = `package api
+
+type greetError string
+
+func (e greetError) Error() string { return string(e) }
+
+func Add(a, b int) int {
+	return a + b
+}
+
+func Greet(name string) (string, error) {
+	if name == "" {
+		return "", greetError("no name")
+	}
+	return "hi " + name, nil
+}
+`
+
+// mockTest calls the mocks of mockSource through AgrowsMockReceive.
+const mockTest = `package api
+
+import (
+	"testing"
+
+	"github.com/codeupdateandmodificationsystem/protocol"
+)
+
+func receive(t *testing.T, functionName string, args map[string]any) (string, error) {
+	t.Helper()
+	data, err := protocol.EncodeFunctionCall(functionName, protocol.Options(), args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return AgrowsMockReceive(data)
+}
+
+func TestMock(t *testing.T) {
+	MockAdd = func(a, b int) int { return a * b }
+	MockGreet = func(name string) (string, error) { return "", greetError("mocked " + name) }
+
+	if result, err := receive(t, "Add", map[string]any{"a": 6, "b": 7}); result != "'42'" || err != nil {
+		t.Errorf("Add returned %q, %v, want the result of MockAdd", result, err)
+	}
+	if result, err := receive(t, "Greet", map[string]any{"name": "ada"}); result != "" || err == nil || err.Error() != "mocked ada" {
+		t.Errorf("Greet returned %q, %v, want the error of MockGreet", result, err)
+	}
+	if _, err := receive(t, "Add", map[string]any{"a": 6}); err == nil {
+		t.Error("Add succeeded without the parameter b")
+	}
+
+	AgrowsMockReset()
+	if result, err := receive(t, "Add", map[string]any{"a": 6, "b": 7}); result != "'0'" || err != nil {
+		t.Errorf("Add returned %q, %v after the reset, want the zero value", result, err)
+	}
+	if result, err := receive(t, "Greet", map[string]any{"name": "ada"}); result != "" || err != nil {
+		t.Errorf("Greet returned %q, %v after the reset, want the zero values", result, err)
+	}
+}
+`
+
+func TestMock(t *testing.T) {
+	dir := newTestModule(t)
+	writeFile(t, filepath.Join(dir, "mock", "agrows_mock.go"), generate(t, mockSource, Options{Mode: MOCK}))
+	writeFile(t, filepath.Join(dir, "mock", "agrows_mock_test.go"), mockTest)
+	mustRunGo(t, dir, nil, "test", "./mock")
+}
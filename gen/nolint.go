@@ -0,0 +1,32 @@
+package gen
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/dave/dst"
+)
+
+// linterNamePattern matches the names golangci-lint accepts in //nolint.
+var linterNamePattern = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// noLintAll reports whether --nolint silences every linter.
+func (opts *Options) noLintAll() bool {
+	for _, linter := range opts.NoLint {
+		if linter == "all" {
+			return true
+		}
+	}
+	return false
+}
+
+// addNoLint puts a //nolint comment with the linters of --nolint directly
+// above every function declared in file, after its doc comment.
+func (opts *Options) addNoLint(file *dst.File) {
+	directive := "//nolint:" + strings.Join(opts.NoLint, ",")
+	for _, decl := range file.Decls {
+		if funcDecl, ok := decl.(*dst.FuncDecl); ok {
+			funcDecl.Decs.Start.Append(directive)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package gen
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dave/jennifer/jen"
+)
+
+const ratePackage = "golang.org/x/time/rate"
+
+// parseRateLimit parses a --rate-limit value of the form N/unit, e.g. 10/s,
+// 100/m or 5/500ms, into calls per second and the burst, which allows N calls
+// at once.
+func parseRateLimit(value string) (float64, int, error) {
+	count, unit, ok := strings.Cut(value, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("rate limit '%s' is not of the form N/unit, e.g. 10/s", value)
+	}
+	n, err := strconv.ParseFloat(count, 64)
+	if err != nil || n <= 0 {
+		return 0, 0, fmt.Errorf("rate limit '%s' needs a positive number of calls", value)
+	}
+	if unit != "" && (unit[0] < '0' || unit[0] > '9') {
+		unit = "1" + unit
+	}
+	period, err := time.ParseDuration(unit)
+	if err != nil || period <= 0 {
+		return 0, 0, fmt.Errorf("rate limit '%s' has an invalid unit", value)
+	}
+	return n / period.Seconds(), int(math.Max(1, math.Ceil(n))), nil
+}
+
+// generateRateLimitCheck emits the check rejecting calls of clients that
+// exceeded their rate.
+func (opts *Options) generateRateLimitCheck() jen.Code {
+	if opts.rateLimit <= 0 {
+		return jen.Null()
+	}
+	return jen.If(jen.Op("!").Id("agrowsAllow").Call(jen.Id("clientID"))).Block(
+		jen.Return(jen.Lit(""), jen.Op("&").Id("AgrowsError").Values(jen.Dict{
+			jen.Id("Code"):    jen.Lit(429),
+			jen.Id("Message"): jen.Lit("rate limit exceeded"),
+		})),
+	)
+}
+
+// generateRateLimiter emits the limiters of the clients and
+// AgrowsClearClientLimiter.
+func (opts *Options) generateRateLimiter() *jen.Statement {
+	code := jen.Const().Defs(
+		jen.Comment("agrowsRateLimit is the number of calls per second a client may make."),
+		jen.Id("agrowsRateLimit").Qual(ratePackage, "Limit").Op("=").Lit(opts.rateLimit),
+		jen.Id("agrowsRateBurst").Op("=").Lit(opts.rateBurst),
+	).Line().Line()
+
+	code.Var().Id("agrowsLimiters").Qual("sync", "Map").Line().Line()
+
+	code.Comment("agrowsAllow reports whether clientID may make another call now, creating its").Line().
+		Comment("limiter on its first call.").Line().
+		Func().Id("agrowsAllow").Params(jen.Id("clientID").String()).Bool().Block(
+		jen.List(jen.Id("limiter"), jen.Id("ok")).Op(":=").Id("agrowsLimiters").Dot("Load").Call(jen.Id("clientID")),
+		jen.If(jen.Op("!").Id("ok")).Block(
+			jen.List(jen.Id("limiter"), jen.Id("_")).Op("=").Id("agrowsLimiters").Dot("LoadOrStore").Call(
+				jen.Id("clientID"),
+				jen.Qual(ratePackage, "NewLimiter").Call(jen.Id("agrowsRateLimit"), jen.Id("agrowsRateBurst")),
+			),
+		),
+		jen.Return(jen.Id("limiter").Assert(jen.Op("*").Qual(ratePackage, "Limiter")).Dot("Allow").Call()),
+	).Line().Line()
+
+	code.Comment("AgrowsClearClientLimiter drops the limiter of clientID, e.g. once it disconnected.").Line().
+		Func().Id("AgrowsClearClientLimiter").Params(jen.Id("clientID").String()).Block(
+		jen.Id("agrowsLimiters").Dot("Delete").Call(jen.Id("clientID")),
+	).Line()
+
+	return code
+}
+
+// generateAllowCheck emits the call of AgrowsAllow in AgrowsReceive.
+func generateAllowCheck() jen.Code {
+	return jen.If(jen.Id("AgrowsAllow").Op("!=").Nil().Op("&&").Op("!").Id("AgrowsAllow").Call(jen.Id("functionName"))).Block(
+		jen.Return(jen.Lit(""), jen.Qual("fmt", "Errorf").Call(jen.Lit("%w: %s"), jen.Id("ErrAgrowsRateLimited"), jen.Id("functionName"))),
+	)
+}
+
+// generateAllowHook emits AgrowsAllow, which leaves limiting calls per function
+// to the user.
+func generateAllowHook() *jen.Statement {
+	return jen.Comment("ErrAgrowsRateLimited wraps the errors of calls rejected by AgrowsAllow.").Line().
+		Var().Id("ErrAgrowsRateLimited").Op("=").Qual("errors", "New").Call(jen.Lit("rate limited")).Line().Line().
+		Comment("AgrowsAllow, if set, is called with the name of every authorized call before it is").Line().
+		Comment("dispatched, see the AgrowsFunc_ constants. Returning false rejects the call").Line().
+		Comment("without running the function.").Line().
+		Var().Id("AgrowsAllow").Func().Params(jen.Id("fn").String()).Bool().Line()
+}
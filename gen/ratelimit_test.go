@@ -0,0 +1,102 @@
+package gen
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		value string
+		limit float64
+		burst int
+		err   string
+	}{
+		{"10/s", 10, 10, ""},
+		{"120/m", 2, 120, ""},
+		{"5/2s", 2.5, 5, ""},
+		{"0.5/s", 0.5, 1, ""},
+		{"10", 0, 0, "rate limit '10' is not of the form N/unit"},
+		{"0/s", 0, 0, "needs a positive number of calls"},
+		{"ten/s", 0, 0, "needs a positive number of calls"},
+		{"10/week", 0, 0, "rate limit '10/week' has an invalid unit"},
+		{"10/-1s", 0, 0, "has an invalid unit"},
+	}
+	for _, test := range tests {
+		limit, burst, err := parseRateLimit(test.value)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: got error %v, want one containing %q", test.value, err, test.err)
+			}
+			continue
+		}
+		if err != nil || limit != test.limit || burst != test.burst {
+			t.Errorf("%s: got %v, %d, %v, want %v, %d", test.value, limit, burst, err, test.limit, test.burst)
+		}
+	}
+}
+
+// rateLimitSource has a function to call until the limit is reached.
+const rateLimitSource = `package api
+
+func Add(a int, b int) int {
+	return a + b
+}
+`
+
+// rateLimitTest runs in the generated server of rateLimitSource with
+// --rate-limit 5/m, whose limiters do not refill during the test.
+const rateLimitTest = `package api
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/codeupdateandmodificationsystem/protocol"
+)
+
+// calls makes n calls as clientID and returns how many were allowed.
+func calls(t *testing.T, clientID string, n int) int {
+	t.Helper()
+	data, err := protocol.EncodeFunctionCall("Add", protocol.Options(), map[string]any{"a": 1, "b": 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowed := 0
+	for i := 0; i < n; i++ {
+		_, err := AgrowsReceive(data, clientID)
+		var agrowsErr *AgrowsError
+		switch {
+		case err == nil:
+			allowed++
+		case !errors.As(err, &agrowsErr) || agrowsErr.Code != 429:
+			t.Fatalf("call %d of %s failed: %v", i, clientID, err)
+		}
+	}
+	return allowed
+}
+
+func TestBurst(t *testing.T) {
+	if allowed := calls(t, "ada", 8); allowed != 5 {
+		t.Errorf("ada made %d calls in a burst, want 5", allowed)
+	}
+	if allowed := calls(t, "alan", 8); allowed != 5 {
+		t.Errorf("alan made %d calls in a burst, want 5 of a limiter of its own", allowed)
+	}
+	if allowed := calls(t, "ada", 1); allowed != 0 {
+		t.Error("ada made a call over the limit")
+	}
+	AgrowsClearClientLimiter("ada")
+	if allowed := calls(t, "ada", 8); allowed != 5 {
+		t.Errorf("ada made %d calls after the limiter of ada was cleared, want 5", allowed)
+	}
+}
+`
+
+func TestRateLimit(t *testing.T) {
+	dir := newTestModule(t, "golang.org/x/time")
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api.go"), generate(t, rateLimitSource, Options{RateLimit: "5/m"}))
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api_test.go"), rateLimitTest)
+	mustRunGo(t, dir, nil, "test", "./server")
+}
@@ -0,0 +1,364 @@
+package gen
+
+import (
+	"github.com/dave/jennifer/jen"
+)
+
+// callArg is the argument a client passes the ID of a call in, to be sent
+// back with its response.
+const callArg = "agrowsCall"
+
+// responseFunction is the reserved name of the messages answering a call with
+// the response format "call". Its arguments are "call", the ID of the call, and
+// either "result" or "error".
+const responseFunction = "agrows_response"
+
+// jsonResponses reports whether responses are sent as JSON objects in text
+// messages rather than as calls of responseFunction in binary ones.
+func (opts *Options) jsonResponses() bool {
+	return opts.ResponseFormat == "json"
+}
+
+// generateResponseType emits agrowsResponse, the JSON object responses are
+// encoded as with the response format "json". error is left out on success.
+func generateResponseType(id jen.Code) *jen.Statement {
+	return jen.Comment("agrowsResponse answers the call with the ID, with either its result or its error.").Line().
+		Type().Id("agrowsResponse").Struct(
+		jen.Id("ID").Add(id).Tag(map[string]string{"json": "id"}),
+		jen.Id("Result").String().Tag(map[string]string{"json": "result,omitempty"}),
+		jen.Id("Error").Op("*").String().Tag(map[string]string{"json": "error,omitempty"}),
+	).Line().Line()
+}
+
+// generateServerResponses emits AgrowsResponses, which encodes the responses
+// to the calls the server received.
+func (opts *Options) generateServerResponses() *jen.Statement {
+	messages := "binary WebSocket messages"
+	if opts.jsonResponses() {
+		messages = "text WebSocket messages"
+	}
+	code := jen.Comment("AgrowsResponses encodes the responses to the calls in data, given the result and").Line().
+		Commentf("error %s returned for it. They are sent back to the client, e.g. as", opts.ReceiverName).Line().
+		Commentf("%s, and settle the calls waiting for them. Calls without an", messages).Line().
+		Comment("ID get no response, so nil is returned for clients not waiting for one.").Line().
+		Func().Id("AgrowsResponses").Params(
+		jen.Id("data").Index().Byte(),
+		jen.Id("result").String(),
+		jen.Err().Error(),
+	).Params(jen.Index().Index().Byte(), jen.Error()).BlockFunc(func(g *jen.Group) {
+		if opts.Batch {
+			g.If(jen.Qual("bytes", "HasPrefix").Call(jen.Id("data"), jen.Index().Byte().Call(jen.Id("agrowsBatchMagic")))).Block(
+				jen.Return(jen.Id("agrowsBatchResponses").Call(jen.Id("data"), jen.Id("result"), jen.Err())),
+			)
+		}
+		g.Id("call").Op(":=").Id("agrowsCallID").Call(jen.Id("data"))
+		g.If(jen.Id("call").Op("==").Nil()).Block(
+			jen.Return(jen.Nil(), jen.Nil()),
+		)
+		g.List(jen.Id("response"), jen.Id("encodeErr")).Op(":=").Id("agrowsEncodeResponse").Call(jen.Id("call"), jen.Id("result"), jen.Err())
+		g.If(jen.Id("encodeErr").Op("!=").Nil()).Block(
+			jen.Return(jen.Nil(), jen.Id("encodeErr")),
+		)
+		g.Return(jen.Index().Index().Byte().Values(jen.Id("response")), jen.Nil())
+	}).Line().Line()
+
+	code.Comment("agrowsCallID returns the ID of the call in data, nil if it has none.").Line().
+		Func().Id("agrowsCallID").Params(jen.Id("data").Index().Byte()).Any().Block(
+		jen.List(jen.Id("_"), jen.Id("args"), jen.Err()).Op(":=").Add(opts.generateDecodeCall(jen.Id("data"))),
+		jen.If(jen.Err().Op("!=").Nil()).Block(
+			jen.Return(jen.Nil()),
+		),
+		jen.Return(jen.Id("args").Index(jen.Lit(callArg)).Dot("Value")),
+	).Line().Line()
+
+	if opts.jsonResponses() {
+		code.Add(generateResponseType(jen.Any()))
+	}
+
+	code.Func().Id("agrowsEncodeResponse").Params(
+		jen.Id("call").Any(),
+		jen.Id("result").String(),
+		jen.Err().Error(),
+	).Params(jen.Index().Byte(), jen.Error()).BlockFunc(func(g *jen.Group) {
+		if opts.jsonResponses() {
+			g.Id("response").Op(":=").Id("agrowsResponse").Values(jen.Dict{
+				jen.Id("ID"): jen.Id("call"),
+			})
+			g.If(jen.Err().Op("!=").Nil()).Block(
+				jen.Id("message").Op(":=").Err().Dot("Error").Call(),
+				jen.Id("response").Dot("Error").Op("=").Op("&").Id("message"),
+			).Else().Block(
+				jen.Id("response").Dot("Result").Op("=").Id("result"),
+			)
+			g.Return(jen.Qual("encoding/json", "Marshal").Call(jen.Id("response")))
+			return
+		}
+		g.Id("responseArgs").Op(":=").Map(jen.String()).Any().Values(jen.Dict{
+			jen.Lit("call"): jen.Id("call"),
+		})
+		g.If(jen.Err().Op("!=").Nil()).Block(
+			jen.Id("responseArgs").Index(jen.Lit("error")).Op("=").Err().Dot("Error").Call(),
+		).Else().Block(
+			jen.Id("responseArgs").Index(jen.Lit("result")).Op("=").Id("result"),
+		)
+		g.Return(jen.Qual(opts.ProtocolPath, "EncodeFunctionCall").Call(jen.Lit(responseFunction), opts.generateProtocolOptions(), jen.Id("responseArgs")))
+	}).Line()
+
+	if opts.Batch {
+		code.Line().Comment("agrowsBatchResponses encodes the responses to the calls of a batch from the reply").Line().
+			Comment("of agrowsReceiveBatch, or err for all of them if the batch as a whole failed.").Line().
+			Func().Id("agrowsBatchResponses").Params(
+			jen.Id("data").Index().Byte(),
+			jen.Id("reply").String(),
+			jen.Err().Error(),
+		).Params(jen.Index().Index().Byte(), jen.Error()).Block(
+			jen.Var().Id("results").Index().Id("agrowsBatchResult"),
+			jen.If(jen.Err().Op("==").Nil()).Block(
+				jen.If(jen.Id("decodeErr").Op(":=").Qual("encoding/json", "Unmarshal").Call(jen.Index().Byte().Call(jen.Id("reply")), jen.Op("&").Id("results")), jen.Id("decodeErr").Op("!=").Nil()).Block(
+					jen.Return(jen.Nil(), jen.Qual("fmt", "Errorf").Call(jen.Lit("failed to decode batch reply: %w"), jen.Id("decodeErr"))),
+				),
+			),
+			jen.Id("data").Op("=").Id("data").Index(jen.Len(jen.Id("agrowsBatchMagic")), jen.Empty()),
+			jen.Var().Id("responses").Index().Index().Byte(),
+			jen.For(jen.Id("i").Op(":=").Lit(0), jen.Len(jen.Id("data")).Op(">").Lit(0), jen.Id("i").Op("++")).Block(
+				jen.List(jen.Id("size"), jen.Id("n")).Op(":=").Qual("encoding/binary", "Uvarint").Call(jen.Id("data")),
+				jen.If(jen.Id("n").Op("<=").Lit(0).Op("||").Id("size").Op(">").Uint64().Call(jen.Len(jen.Id("data")).Op("-").Id("n"))).Block(
+					jen.Break(),
+				),
+				jen.Id("call").Op(":=").Id("agrowsCallID").Call(jen.Id("data").Index(jen.Id("n"), jen.Id("n").Op("+").Int().Call(jen.Id("size")))),
+				jen.Id("data").Op("=").Id("data").Index(jen.Id("n").Op("+").Int().Call(jen.Id("size")), jen.Empty()),
+				jen.If(jen.Id("call").Op("==").Nil()).Block(
+					jen.Continue(),
+				),
+				jen.List(jen.Id("result"), jen.Id("callErr")).Op(":=").List(jen.Lit(""), jen.Err()),
+				jen.If(jen.Err().Op("==").Nil().Op("&&").Id("i").Op("<").Len(jen.Id("results"))).Block(
+					jen.Id("result").Op("=").Id("results").Index(jen.Id("i")).Dot("Result"),
+					jen.If(jen.Id("results").Index(jen.Id("i")).Dot("Error").Op("!=").Lit("")).Block(
+						jen.Id("callErr").Op("=").Qual("errors", "New").Call(jen.Id("results").Index(jen.Id("i")).Dot("Error")),
+					),
+				),
+				jen.List(jen.Id("response"), jen.Id("encodeErr")).Op(":=").Id("agrowsEncodeResponse").Call(jen.Id("call"), jen.Id("result"), jen.Id("callErr")),
+				jen.If(jen.Id("encodeErr").Op("!=").Nil()).Block(
+					jen.Return(jen.Nil(), jen.Id("encodeErr")),
+				),
+				jen.Id("responses").Op("=").Append(jen.Id("responses"), jen.Id("response")),
+			),
+			jen.Return(jen.Id("responses"), jen.Nil()),
+		).Line()
+	}
+
+	return code
+}
+
+// generateClientResponses emits the calls of the client waiting for their
+// responses and agrowsSettleCall settling them, with agrowsHandleResponse for
+// calls of responseFunction or agrowsResponse for JSON responses.
+func (opts *Options) generateClientResponses() *jen.Statement {
+	code := jen.Comment("agrowsPendingCall is a call waiting for its response from the server.").Line().
+		Type().Id("agrowsPendingCall").Struct(
+		jen.Id("functionName").String(),
+		jen.Id("start").Qual("time", "Time"),
+		jen.Comment("jsonResult is set for calls of functions returning a slice, a map or a pointer,"),
+		jen.Comment("whose result is its JSON encoding"),
+		jen.Id("jsonResult").Bool(),
+		jen.Id("resolve").Qual("syscall/js", "Value"),
+		jen.Id("reject").Qual("syscall/js", "Value"),
+		jen.Do(func(s *jen.Statement) {
+			if opts.ClientTimeout > 0 {
+				s.Comment("timer rejects the call once AgrowsClientTimeout passes without a response").Line().
+					Id("timer").Op("*").Qual("time", "Timer")
+			}
+		}),
+	).Line().Line()
+
+	if opts.ClientTimeout > 0 {
+		code.Comment("AgrowsClientTimeout is the time a call waits for its response before its Promise").Line().
+			Comment("is rejected, zero disables it.").Line().
+			Var().Id("AgrowsClientTimeout").Op("=").Add(generateDuration(opts.ClientTimeout)).Line().Line()
+	}
+
+	code.Var().Defs(
+		jen.Id("agrowsPendingCallsMutex").Qual("sync", "Mutex"),
+		jen.Id("agrowsPendingCalls").Op("=").Map(jen.String()).Id("agrowsPendingCall").Values(),
+	).Line().Line()
+
+	code.Comment("agrowsBeginCall registers a call of functionName waiting for its response and").Line().
+		Comment("returns its ID along with the Promise the response settles. jsonResult resolves").Line().
+		Comment("the Promise with the decoded JSON of the result instead of its text.").Line().
+		Func().Id("agrowsBeginCall").Params(
+		jen.Id("functionName").String(),
+		jen.Id("start").Qual("time", "Time"),
+		jen.Id("jsonResult").Bool(),
+	).Params(jen.String(), jen.Qual("syscall/js", "Value")).Block(
+		jen.Id("id").Op(":=").Make(jen.Index().Byte(), jen.Lit(16)),
+		jen.Qual("crypto/rand", "Read").Call(jen.Id("id")),
+		jen.Id("call").Op(":=").Qual("encoding/hex", "EncodeToString").Call(jen.Id("id")),
+		jen.Id("pending").Op(":=").Id("agrowsPendingCall").Values(jen.Dict{
+			jen.Id("functionName"): jen.Id("functionName"),
+			jen.Id("start"):        jen.Id("start"),
+			jen.Id("jsonResult"):   jen.Id("jsonResult"),
+		}),
+		jen.Comment("the executor runs before New returns"),
+		jen.Id("executor").Op(":=").Qual("syscall/js", "FuncOf").Call(jen.Func().Params(
+			jen.Id("this").Qual("syscall/js", "Value"),
+			jen.Id("p").Index().Qual("syscall/js", "Value"),
+		).Any().Block(
+			jen.List(jen.Id("pending").Dot("resolve"), jen.Id("pending").Dot("reject")).Op("=").List(jen.Id("p").Index(jen.Lit(0)), jen.Id("p").Index(jen.Lit(1))),
+			jen.Return(jen.Nil()),
+		)),
+		jen.Id("promise").Op(":=").Qual("syscall/js", "Global").Call().Dot("Get").Call(jen.Lit("Promise")).Dot("New").Call(jen.Id("executor")),
+		jen.Id("executor").Dot("Release").Call(),
+		jen.Id("agrowsPendingCallsMutex").Dot("Lock").Call(),
+		jen.Defer().Id("agrowsPendingCallsMutex").Dot("Unlock").Call(),
+		jen.Do(func(s *jen.Statement) {
+			if opts.ClientTimeout > 0 {
+				s.Comment("armed with the lock held, so the timer finds the call").Line().
+					If(jen.Id("AgrowsClientTimeout").Op(">").Lit(0)).Block(
+					jen.Id("pending").Dot("timer").Op("=").Qual("time", "AfterFunc").Call(jen.Id("AgrowsClientTimeout"), jen.Func().Params().Block(
+						jen.Id("agrowsTimeoutCall").Call(jen.Id("call")),
+					)),
+				)
+			}
+		}),
+		jen.Id("agrowsPendingCalls").Index(jen.Id("call")).Op("=").Id("pending"),
+		jen.Return(jen.Id("call"), jen.Id("promise")),
+	).Line().Line()
+
+	code.Comment("agrowsEndCall removes call from the pending calls, e.g. once it could not be sent.").Line().
+		Func().Id("agrowsEndCall").Params(jen.Id("call").String()).Params(jen.Id("agrowsPendingCall"), jen.Bool()).Block(
+		jen.Id("agrowsPendingCallsMutex").Dot("Lock").Call(),
+		jen.Defer().Id("agrowsPendingCallsMutex").Dot("Unlock").Call(),
+		jen.List(jen.Id("pending"), jen.Id("ok")).Op(":=").Id("agrowsPendingCalls").Index(jen.Id("call")),
+		jen.Delete(jen.Id("agrowsPendingCalls"), jen.Id("call")),
+		jen.Do(func(s *jen.Statement) {
+			if opts.ClientTimeout > 0 {
+				s.If(jen.Id("pending").Dot("timer").Op("!=").Nil()).Block(
+					jen.Id("pending").Dot("timer").Dot("Stop").Call(),
+				)
+			}
+		}),
+		jen.Return(jen.Id("pending"), jen.Id("ok")),
+	).Line().Line()
+
+	if opts.ClientTimeout > 0 {
+		code.Comment("agrowsTimeoutCall rejects call once it waited AgrowsClientTimeout for its").Line().
+			Comment("response, unless the response settled it first. A late response is dropped.").Line().
+			Func().Id("agrowsTimeoutCall").Params(jen.Id("call").String()).Block(
+			jen.List(jen.Id("pending"), jen.Id("ok")).Op(":=").Id("agrowsEndCall").Call(jen.Id("call")),
+			jen.If(jen.Op("!").Id("ok")).Block(
+				jen.Return(),
+			),
+			jen.Err().Op(":=").Qual("fmt", "Errorf").Call(jen.Lit("call of %s timed out after %s"), jen.Id("pending").Dot("functionName"), jen.Qual("time", "Since").Call(jen.Id("pending").Dot("start")).Dot("Round").Call(jen.Qual("time", "Millisecond"))),
+			jen.Id("agrowsRecordMetrics").Call(jen.Id("pending").Dot("functionName"), jen.Id("pending").Dot("start"), jen.Err()),
+			jen.Id("pending").Dot("reject").Dot("Invoke").Call(generateJsGlobalError(jen.Err().Dot("Error").Call())),
+		).Line().Line()
+	}
+
+	code.Comment("agrowsSettleCall settles call with its response from the server, resolving its").Line().
+		Comment("Promise with the result or rejecting it with err. Responses to unknown calls are").Line().
+		Comment("dropped.").Line().
+		Func().Id("agrowsSettleCall").Params(
+		jen.Id("call").String(),
+		jen.Id("result").String(),
+		jen.Err().Error(),
+	).Block(
+		jen.List(jen.Id("pending"), jen.Id("ok")).Op(":=").Id("agrowsEndCall").Call(jen.Id("call")),
+		jen.If(jen.Op("!").Id("ok")).Block(
+			jen.Id("agrowsLog").Call(jen.Lit("debug"), jen.Qual("fmt", "Sprintf").Call(jen.Lit("dropping response to unknown call '%s'"), jen.Id("call"))),
+			jen.Return(),
+		),
+		jen.Id("agrowsRecordMetrics").Call(jen.Id("pending").Dot("functionName"), jen.Id("pending").Dot("start"), jen.Err()),
+		jen.If(jen.Err().Op("!=").Nil()).Block(
+			jen.Id("pending").Dot("reject").Dot("Invoke").Call(generateJsGlobalError(jen.Err().Dot("Error").Call())),
+			jen.Return(),
+		),
+		jen.If(jen.Id("pending").Dot("jsonResult")).Block(
+			jen.Comment("e.g. an array for a slice, an object for a map, null for a nil pointer"),
+			jen.Id("pending").Dot("resolve").Dot("Invoke").Call(jen.Qual("syscall/js", "Global").Call().Dot("Get").Call(jen.Lit("JSON")).Dot("Call").Call(jen.Lit("parse"), jen.Id("result"))),
+			jen.Return(),
+		),
+		jen.Id("pending").Dot("resolve").Dot("Invoke").Call(jen.Id("result")),
+	).Line().Line()
+
+	if opts.jsonResponses() {
+		code.Add(generateResponseType(jen.String()))
+		return code
+	}
+
+	code.Comment("agrowsHandleResponse settles the call answered by the arguments of a call of").Line().
+		Commentf("%s.", responseFunction).Line().
+		Func().Id("agrowsHandleResponse").Params(
+		jen.Id("args").Map(jen.String()).Qual(opts.ProtocolPath, "Argument"),
+	).Error().Block(
+		jen.List(jen.Id("call"), jen.Id("_")).Op(":=").Id("args").Index(jen.Lit("call")).Dot("Value").Assert(jen.String()),
+		jen.List(jen.Id("result"), jen.Id("_")).Op(":=").Id("args").Index(jen.Lit("result")).Dot("Value").Assert(jen.String()),
+		jen.Var().Err().Error(),
+		jen.If(jen.List(jen.Id("message"), jen.Id("failed")).Op(":=").Id("args").Index(jen.Lit("error")), jen.Id("failed")).Block(
+			jen.Err().Op("=").Qual("errors", "New").Call(jen.Qual("fmt", "Sprint").Call(jen.Id("message").Dot("Value"))),
+		),
+		jen.Id("agrowsSettleCall").Call(jen.Id("call"), jen.Id("result"), jen.Err()),
+		jen.Return(jen.Nil()),
+	).Line()
+
+	return code
+}
+
+// generateClientMessageRouter emits AgrowsHandleMessage, routing every message
+// from the server to what handles it, and handleMessageWrapper exposing it to
+// JavaScript as agrowsHandleMessage. receive tells whether the client has
+// AgrowsReceive for binary messages.
+func (opts *Options) generateClientMessageRouter(receive bool) *jen.Statement {
+	code := jen.Comment("AgrowsHandleMessage handles a message from the server: responses settle the").Line().
+		Comment("pending call with their ID, events and calls of server-to-client functions are").Line().
+		Comment("passed to AgrowsReceive. It returns false for messages not meant for the client,").Line().
+		Comment("like the text replies of servers not answering with responses.").Line().
+		Func().Id("AgrowsHandleMessage").Params(jen.Id("data").Index().Byte()).Params(jen.Bool(), jen.Error()).BlockFunc(func(g *jen.Group) {
+		if opts.jsonResponses() {
+			g.Var().Id("response").Id("agrowsResponse")
+			g.If(jen.Err().Op(":=").Qual("encoding/json", "Unmarshal").Call(jen.Id("data"), jen.Op("&").Id("response")), jen.Err().Op("==").Nil().Op("&&").Id("response").Dot("ID").Op("!=").Lit("")).Block(
+				jen.Var().Id("callErr").Error(),
+				jen.If(jen.Id("response").Dot("Error").Op("!=").Nil()).Block(
+					jen.Id("callErr").Op("=").Qual("errors", "New").Call(jen.Op("*").Id("response").Dot("Error")),
+				),
+				jen.Id("agrowsSettleCall").Call(jen.Id("response").Dot("ID"), jen.Id("response").Dot("Result"), jen.Id("callErr")),
+				jen.Return(jen.True(), jen.Nil()),
+			)
+		}
+		if !receive {
+			g.Return(jen.False(), jen.Nil())
+			return
+		}
+		g.List(jen.Id("functionName"), jen.Id("args"), jen.Err()).Op(":=").Qual(opts.ProtocolPath, "DecodeFunctionCall").Call(jen.Id("data"), opts.generateProtocolOptions())
+		g.If(jen.Err().Op("!=").Nil()).Block(
+			jen.Return(jen.False(), jen.Nil()),
+		)
+		g.Return(jen.True(), jen.Id("agrowsDispatch").Call(jen.Id("functionName"), jen.Id("args")))
+	}).Line().Line()
+
+	code.Comment("handleMessageWrapper exposes AgrowsHandleMessage to JavaScript, taking the text or").Line().
+		Comment("the ArrayBuffer or Uint8Array of a message. It returns whether the message was").Line().
+		Comment("meant for the client, errors handling it are logged.").Line().
+		Func().Id("handleMessageWrapper").Params(
+		jen.Id("this").Qual("syscall/js", "Value"),
+		jen.Id("p").Index().Qual("syscall/js", "Value"),
+	).Any().Block(
+		jen.If(jen.Len(jen.Id("p")).Op("!=").Lit(1)).Block(
+			jen.Return(generateJsGlobalError(jen.Qual("fmt", "Sprintf").Call(jen.Lit("expected 1 arguments, got %d"), jen.Len(jen.Id("p"))))),
+		),
+		jen.Var().Id("data").Index().Byte(),
+		jen.If(jen.Id("p").Index(jen.Lit(0)).Dot("Type").Call().Op("==").Qual("syscall/js", "TypeString")).Block(
+			jen.Id("data").Op("=").Index().Byte().Call(jen.Id("p").Index(jen.Lit(0)).Dot("String").Call()),
+		).Else().Block(
+			jen.Id("array").Op(":=").Qual("syscall/js", "Global").Call().Dot("Get").Call(jen.Lit("Uint8Array")).Dot("New").Call(jen.Id("p").Index(jen.Lit(0))),
+			jen.Id("data").Op("=").Make(jen.Index().Byte(), jen.Id("array").Dot("Length").Call()),
+			jen.Qual("syscall/js", "CopyBytesToGo").Call(jen.Id("data"), jen.Id("array")),
+		),
+		jen.List(jen.Id("handled"), jen.Err()).Op(":=").Id("AgrowsHandleMessage").Call(jen.Id("data")),
+		jen.If(jen.Err().Op("!=").Nil()).Block(
+			jen.Id("agrowsLog").Call(jen.Lit("error"), jen.Err().Dot("Error").Call()),
+		),
+		jen.Return(jen.Id("handled")),
+	).Line()
+
+	return code
+}
@@ -0,0 +1,388 @@
+package gen
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// responsesServerTest runs in the generated server: calls with an ID get a
+// response carrying their result or error, calls without one get none.
+const responsesServerTest = `package api
+
+import (
+	"testing"
+
+	"github.com/codeupdateandmodificationsystem/protocol"
+)
+
+func respond(t *testing.T, functionName string, args map[string]any, result string, err error) map[string]protocol.Argument {
+	t.Helper()
+	data, encodeErr := protocol.EncodeFunctionCall(functionName, protocol.Options(), args)
+	if encodeErr != nil {
+		t.Fatal(encodeErr)
+	}
+	responses, encodeErr := AgrowsResponses(data, result, err)
+	if encodeErr != nil {
+		t.Fatal(encodeErr)
+	}
+	if _, ok := args["agrowsCall"]; !ok {
+		if responses != nil {
+			t.Errorf("got responses %q to a call without an ID", responses)
+		}
+		return nil
+	}
+	if len(responses) != 1 {
+		t.Fatalf("got %d responses, want 1", len(responses))
+	}
+	name, response, decodeErr := protocol.DecodeFunctionCall(responses[0], protocol.Options())
+	if decodeErr != nil || name != "agrows_response" {
+		t.Fatalf("got response %q, %v", name, decodeErr)
+	}
+	return response
+}
+
+type failure string
+
+func (f failure) Error() string { return string(f) }
+
+func TestResponses(t *testing.T) {
+	response := respond(t, "Add", map[string]any{"a": 1, "b": 2, "agrowsCall": "c1"}, "3", nil)
+	if _, failed := response["error"]; failed || response["call"].Value != "c1" || response["result"].Value != "3" {
+		t.Errorf("got response %v, want the result of c1", response)
+	}
+	response = respond(t, "Reset", map[string]any{"agrowsCall": "c2"}, "", failure("reset failed"))
+	if response["call"].Value != "c2" || response["error"].Value != "reset failed" {
+		t.Errorf("got response %v, want the error of c2", response)
+	}
+	respond(t, "Add", map[string]any{"a": 1, "b": 2}, "3", nil)
+}
+`
+
+// responsesClientTest runs in the generated client: the Promise of a call is
+// settled by the response to the ID it was sent with, also when the response
+// goes through AgrowsHandleMessage.
+const responsesClientTest = `//go:build js && wasm && client
+
+package main
+
+import (
+	"syscall/js"
+	"testing"
+
+	"github.com/codeupdateandmodificationsystem/protocol"
+)
+
+// await returns the result of promise, or the message of the Error rejecting it.
+func await(promise js.Value) (string, bool) {
+	done := make(chan bool)
+	var result string
+	resolve := js.FuncOf(func(this js.Value, p []js.Value) any {
+		result = p[0].String()
+		done <- false
+		return nil
+	})
+	reject := js.FuncOf(func(this js.Value, p []js.Value) any {
+		result = p[0].Get("message").String()
+		done <- true
+		return nil
+	})
+	defer resolve.Release()
+	defer reject.Release()
+	promise.Call("then", resolve, reject)
+	rejected := <-done
+	return result, rejected
+}
+
+func TestResponses(t *testing.T) {
+	var calls []string
+	js.Global().Set("sendMessage", js.FuncOf(func(this js.Value, p []js.Value) any {
+		data := make([]byte, p[0].Length())
+		js.CopyBytesToGo(data, p[0])
+		_, args, err := protocol.DecodeFunctionCall(data, protocol.Options())
+		if err != nil {
+			t.Errorf("failed to decode the sent call: %v", err)
+		}
+		calls = append(calls, args["agrowsCall"].Value.(string))
+		return nil
+	}))
+	add := func() js.Value {
+		return AddWrapper(js.Null(), []js.Value{js.ValueOf(1), js.ValueOf(2)}).(js.Value)
+	}
+	respond := func(args map[string]any) {
+		data, err := protocol.EncodeFunctionCall("agrows_response", protocol.Options(), args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := AgrowsReceive(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resolved, rejected := add(), add()
+	if len(calls) != 2 || calls[0] == calls[1] {
+		t.Fatalf("got call IDs %q, want two distinct ones", calls)
+	}
+	respond(map[string]any{"call": calls[1], "error": "no more numbers"})
+	respond(map[string]any{"call": calls[0], "result": "3"})
+	// responses to unknown calls are dropped
+	respond(map[string]any{"call": "unknown", "result": "0"})
+
+	if result, failed := await(resolved); failed || result != "3" {
+		t.Errorf("got %q, rejected %t, want 3", result, failed)
+	}
+	if result, failed := await(rejected); !failed || result != "no more numbers" {
+		t.Errorf("got %q, rejected %t, want the error", result, failed)
+	}
+}
+
+func TestHandleMessage(t *testing.T) {
+	var call string
+	js.Global().Set("sendMessage", js.FuncOf(func(this js.Value, p []js.Value) any {
+		data := make([]byte, p[0].Length())
+		js.CopyBytesToGo(data, p[0])
+		_, args, _ := protocol.DecodeFunctionCall(data, protocol.Options())
+		call = args["agrowsCall"].Value.(string)
+		return nil
+	}))
+	promise := AddWrapper(js.Null(), []js.Value{js.ValueOf(1), js.ValueOf(2)}).(js.Value)
+
+	// text replies of servers without responses are left to onMessage
+	if handled, err := AgrowsHandleMessage([]byte("3")); handled || err != nil {
+		t.Errorf("got %t, %v for a text reply, want it not to be handled", handled, err)
+	}
+	data, err := protocol.EncodeFunctionCall("agrows_response", protocol.Options(), map[string]any{"call": call, "result": "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if handled, err := AgrowsHandleMessage(data); !handled || err != nil {
+		t.Fatalf("got %t, %v for the response", handled, err)
+	}
+	if result, failed := await(promise); failed || result != "3" {
+		t.Errorf("got %q, rejected %t, want 3", result, failed)
+	}
+}
+`
+
+func TestResponses(t *testing.T) {
+	dir := newTestModule(t)
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api.go"), generate(t, apiSource, Options{}))
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api_test.go"), responsesServerTest)
+	writeFile(t, filepath.Join(dir, "client", "agrows_client_api.go"), generate(t, apiSource, Options{Mode: CLIENT}))
+	writeFile(t, filepath.Join(dir, "client", "agrows_client_api_test.go"), responsesClientTest)
+	mustRunGo(t, dir, nil, "test", "./server")
+	mustRunGo(t, dir, wasmEnv(t, dir), "test", "-tags", "client", "./client")
+}
+
+// jsonResponsesServerTest runs in a server generated with the response format
+// "json": responses are JSON objects with the ID of their call.
+const jsonResponsesServerTest = `package api
+
+import (
+	"testing"
+
+	"github.com/codeupdateandmodificationsystem/protocol"
+)
+
+type failure string
+
+func (f failure) Error() string { return string(f) }
+
+func TestJSONResponses(t *testing.T) {
+	tests := []struct {
+		call   string
+		result string
+		err    error
+		want   string
+	}{
+		{"c1", "3", nil, ` + "`" + `{"id":"c1","result":"3"}` + "`" + `},
+		{"c2", "", failure("reset failed"), ` + "`" + `{"id":"c2","error":"reset failed"}` + "`" + `},
+	}
+	for _, test := range tests {
+		data, err := protocol.EncodeFunctionCall("Add", protocol.Options(), map[string]any{"a": 1, "b": 2, "agrowsCall": test.call})
+		if err != nil {
+			t.Fatal(err)
+		}
+		responses, err := AgrowsResponses(data, test.result, test.err)
+		if err != nil || len(responses) != 1 || string(responses[0]) != test.want {
+			t.Errorf("got responses %q, %v, want %s", responses, err, test.want)
+		}
+	}
+}
+`
+
+// jsonResponsesClientTest runs in a client generated with the response format
+// "json": AgrowsHandleMessage settles calls and declines other text.
+const jsonResponsesClientTest = `//go:build js && wasm && client
+
+package main
+
+import (
+	"syscall/js"
+	"testing"
+
+	"github.com/codeupdateandmodificationsystem/protocol"
+)
+
+func TestJSONResponses(t *testing.T) {
+	var calls []string
+	js.Global().Set("sendMessage", js.FuncOf(func(this js.Value, p []js.Value) any {
+		data := make([]byte, p[0].Length())
+		js.CopyBytesToGo(data, p[0])
+		_, args, err := protocol.DecodeFunctionCall(data, protocol.Options())
+		if err != nil {
+			t.Errorf("failed to decode the sent call: %v", err)
+		}
+		calls = append(calls, args["agrowsCall"].Value.(string))
+		return nil
+	}))
+	receive := func(text string) bool {
+		handled, err := AgrowsHandleMessage([]byte(text))
+		if err != nil {
+			t.Errorf("handling %q failed: %v", text, err)
+		}
+		return handled
+	}
+
+	promise := AddWrapper(js.Null(), []js.Value{js.ValueOf(1), js.ValueOf(2)}).(js.Value)
+	for _, text := range []string{"hello", "{}", ` + "`" + `{"result":"3"}` + "`" + `} {
+		if receive(text) {
+			t.Errorf("%q was taken as a response", text)
+		}
+	}
+	if !receive(` + "`" + `{"id":"` + "`" + ` + calls[0] + ` + "`" + `","result":"3"}` + "`" + `) {
+		t.Fatal("the response was declined")
+	}
+	done := make(chan string)
+	resolve := js.FuncOf(func(this js.Value, p []js.Value) any {
+		done <- p[0].String()
+		return nil
+	})
+	defer resolve.Release()
+	promise.Call("then", resolve)
+	if result := <-done; result != "3" {
+		t.Errorf("got %q, want 3", result)
+	}
+}
+`
+
+func TestJSONResponses(t *testing.T) {
+	opts := Options{ResponseFormat: "json"}
+	dir := newTestModule(t)
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api.go"), generate(t, apiSource, opts))
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api_test.go"), jsonResponsesServerTest)
+	opts.Mode = CLIENT
+	writeFile(t, filepath.Join(dir, "client", "agrows_client_api.go"), generate(t, apiSource, opts))
+	writeFile(t, filepath.Join(dir, "client", "agrows_client_api_test.go"), jsonResponsesClientTest)
+	mustRunGo(t, dir, nil, "test", "./server")
+	mustRunGo(t, dir, wasmEnv(t, dir), "test", "-tags", "client", "./client")
+}
+
+// clientTimeoutTest runs in the generated client: calls without a response
+// are rejected once AgrowsClientTimeout passes, answered calls are resolved and
+// zero disables the timer.
+const clientTimeoutTest = `//go:build js && wasm && client
+
+package main
+
+import (
+	"strings"
+	"syscall/js"
+	"testing"
+	"time"
+
+	"github.com/codeupdateandmodificationsystem/protocol"
+)
+
+// await returns the result of promise, or the message of the Error rejecting it.
+func await(promise js.Value) (string, bool) {
+	done := make(chan bool)
+	var result string
+	resolve := js.FuncOf(func(this js.Value, p []js.Value) any {
+		result = p[0].String()
+		done <- false
+		return nil
+	})
+	reject := js.FuncOf(func(this js.Value, p []js.Value) any {
+		result = p[0].Get("message").String()
+		done <- true
+		return nil
+	})
+	defer resolve.Release()
+	defer reject.Release()
+	promise.Call("then", resolve, reject)
+	rejected := <-done
+	return result, rejected
+}
+
+func pendingCalls() []string {
+	agrowsPendingCallsMutex.Lock()
+	defer agrowsPendingCallsMutex.Unlock()
+	var calls []string
+	for call := range agrowsPendingCalls {
+		calls = append(calls, call)
+	}
+	return calls
+}
+
+func TestClientTimeout(t *testing.T) {
+	js.Global().Set("sendMessage", js.FuncOf(func(this js.Value, p []js.Value) any { return nil }))
+	add := func() js.Value {
+		return AddWrapper(js.Null(), []js.Value{js.ValueOf(1), js.ValueOf(2)}).(js.Value)
+	}
+	if AgrowsClientTimeout != 100*time.Millisecond {
+		t.Fatalf("the default timeout is %s, want 100ms", AgrowsClientTimeout)
+	}
+
+	start := time.Now()
+	result, rejected := await(add())
+	if !rejected || !strings.HasPrefix(result, "call of Add timed out after") {
+		t.Errorf("got %q, rejected %t, want a timeout", result, rejected)
+	}
+	if elapsed := time.Since(start); elapsed < AgrowsClientTimeout {
+		t.Errorf("the call was rejected after %s", elapsed)
+	}
+	if calls := pendingCalls(); len(calls) != 0 {
+		t.Errorf("the timed out call is still pending: %v", calls)
+	}
+
+	promise := add()
+	calls := pendingCalls()
+	if len(calls) != 1 {
+		t.Fatalf("got %d pending calls, want 1", len(calls))
+	}
+	data, err := protocol.EncodeFunctionCall("agrows_response", protocol.Options(), map[string]any{"call": calls[0], "result": "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := AgrowsReceive(data); err != nil {
+		t.Fatal(err)
+	}
+	if result, rejected := await(promise); rejected || result != "3" {
+		t.Errorf("got %q, rejected %t, want 3", result, rejected)
+	}
+
+	AgrowsClientTimeout = 0
+	add()
+	calls = pendingCalls()
+	if len(calls) != 1 || agrowsPendingCalls[calls[0]].timer != nil {
+		t.Errorf("a call without a timeout got a timer")
+	}
+}
+`
+
+func TestClientTimeout(t *testing.T) {
+	client := generate(t, apiSource, Options{Mode: CLIENT, ClientTimeout: 100 * time.Millisecond})
+	if !strings.Contains(string(client), "var AgrowsClientTimeout = 100 * time.Millisecond") {
+		t.Fatalf("the client does not declare the default timeout:\n%s", client)
+	}
+	if strings.Contains(string(generate(t, apiSource, Options{Mode: CLIENT})), "AgrowsClientTimeout") {
+		t.Error("a client without --client-timeout declares AgrowsClientTimeout")
+	}
+
+	dir := newTestModule(t)
+	writeFile(t, filepath.Join(dir, "client", "agrows_client_api.go"), client)
+	writeFile(t, filepath.Join(dir, "client", "agrows_client_api_test.go"), clientTimeoutTest)
+	mustRunGo(t, dir, wasmEnv(t, dir), "test", "-tags", "client", "./client")
+}
@@ -0,0 +1,249 @@
+package gen
+
+import (
+	"fmt"
+	"go/token"
+	"strconv"
+	"strings"
+
+	"github.com/dave/dst"
+	"github.com/dave/jennifer/jen"
+)
+
+// directionDirective marks a function the server calls in the client, e.g.
+// "//agrows:direction server-to-client".
+const directionDirective = "//agrows:direction"
+
+// extractDirectionDirective reports whether doc contains a direction directive
+// making the function run in the client.
+func extractDirectionDirective(doc []string) (bool, error) {
+	for _, line := range doc {
+		value, ok := strings.CutPrefix(strings.TrimSpace(line), directionDirective+" ")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(value) {
+		case "server-to-client":
+			return true, nil
+		case "client-to-server":
+			return false, nil
+		default:
+			return false, fmt.Errorf("unknown direction '%s', expected server-to-client or client-to-server", strings.TrimSpace(value))
+		}
+	}
+	return false, nil
+}
+
+// isServerToClient reports whether fn carries a server-to-client direction
+// directive. Invalid directives were already reported by extractFuncInfo.
+func isServerToClient(fn *dst.FuncDecl) bool {
+	serverToClient, _ := extractDirectionDirective(extractDocComment(fn))
+	return serverToClient
+}
+
+// splitServerToClient separates the functions the server calls in the client
+// from those the client calls in the server.
+func splitServerToClient(infos []FuncInfo) ([]FuncInfo, []FuncInfo) {
+	var toServer, toClient []FuncInfo
+	for _, info := range infos {
+		if info.ServerToClient {
+			toClient = append(toClient, info)
+		} else {
+			toServer = append(toServer, info)
+		}
+	}
+	return toServer, toClient
+}
+
+// removeFunctions drops the declarations of the given functions from tree.
+func removeFunctions(tree *dst.File, infos []FuncInfo) {
+	names := make(map[string]bool, len(infos))
+	for _, info := range infos {
+		names[info.OriginalIdentifier.Name] = true
+	}
+	var decls []dst.Decl
+	for _, decl := range tree.Decls {
+		if fn, ok := decl.(*dst.FuncDecl); ok && fn.Recv == nil && names[fn.Name.Name] {
+			continue
+		}
+		decls = append(decls, decl)
+	}
+	tree.Decls = decls
+}
+
+// importName returns the name the file refers to the import by. Without an
+// explicit name it is guessed from the path, skipping a major version suffix,
+// e.g. jwt for github.com/golang-jwt/jwt/v5 and yaml for gopkg.in/yaml.v3.
+func importName(spec *dst.ImportSpec) string {
+	if spec.Name != nil {
+		return spec.Name.Name
+	}
+	path, err := strconv.Unquote(spec.Path.Value)
+	if err != nil {
+		return ""
+	}
+	elements := strings.Split(path, "/")
+	name := elements[len(elements)-1]
+	if len(elements) > 1 && len(name) > 1 && name[0] == 'v' && strings.Trim(name[1:], "0123456789") == "" {
+		name = elements[len(elements)-2]
+	}
+	name, _, _ = strings.Cut(name, ".")
+	return strings.TrimPrefix(name, "go-")
+}
+
+// pruneUnusedImports drops the imports of tree no remaining declaration refers
+// to, e.g. after functions were removed from it.
+func pruneUnusedImports(tree *dst.File) {
+	for _, decl := range tree.Decls {
+		if genDecl, ok := decl.(*dst.GenDecl); ok && genDecl.Tok == token.IMPORT {
+			genDecl.Specs = filterUnusedImports(tree.Decls, genDecl.Specs)
+		}
+	}
+}
+
+// filterUnusedImports returns the import specs whose package is referred to by
+// decls, by its local name or the last element of its path. Blank and dot
+// imports are always kept, since their use can not be told from the names.
+func filterUnusedImports(decls []dst.Decl, specs []dst.Spec) []dst.Spec {
+	used := make(map[string]bool)
+	for _, decl := range decls {
+		if genDecl, ok := decl.(*dst.GenDecl); ok && genDecl.Tok == token.IMPORT {
+			continue
+		}
+		dst.Inspect(decl, func(n dst.Node) bool {
+			// a package is only referred to as the qualifier of a selector,
+			// other identifiers of the same name are locals
+			if selector, ok := n.(*dst.SelectorExpr); ok {
+				if ident, ok := selector.X.(*dst.Ident); ok {
+					used[ident.Name] = true
+				}
+			}
+			return true
+		})
+	}
+
+	var kept []dst.Spec
+	for _, spec := range specs {
+		name := importName(spec.(*dst.ImportSpec))
+		if name == "_" || name == "." || used[name] {
+			kept = append(kept, spec)
+		}
+	}
+	return kept
+}
+
+// generateServerToClientStubs emits a function per server-to-client function,
+// encoding its call and passing it to AgrowsSendToClient.
+func (opts *Options) generateServerToClientStubs(infos []FuncInfo) *jen.Statement {
+	code := jen.Null()
+
+	for _, info := range infos {
+		name := info.OriginalIdentifier.Name
+		for _, line := range info.Doc {
+			code.Comment(line).Line()
+		}
+		if len(info.Doc) == 0 {
+			code.Commentf("%s calls '%s' in the client through AgrowsSendToClient.", name, info.Signature()).Line()
+		}
+		code.Func().Id(name).ParamsFunc(func(g *jen.Group) {
+			for _, paramInfo := range info.Params {
+				g.Id(paramIdent(info, paramInfo.DstField.Names[0].Name)).Add(typeCode(paramInfo.DstField.Type, nil))
+			}
+		}).Error().Block(
+			jen.If(jen.Id("AgrowsSendToClient").Op("==").Nil()).Block(
+				jen.Return(jen.Qual("errors", "New").Call(jen.Lit(fmt.Sprintf("%s: AgrowsSendToClient is not set", name)))),
+			),
+			jen.List(jen.Id("data"), jen.Err()).Op(":=").Qual(opts.ProtocolPath, "EncodeFunctionCall").Call(
+				jen.Lit(name),
+				opts.generateProtocolOptions(),
+				jen.Map(jen.String()).Any().ValuesFunc(func(g *jen.Group) {
+					for _, paramInfo := range info.Params {
+						paramName := paramInfo.DstField.Names[0].Name
+						g.Line().Lit(paramName).Op(":").Id(paramIdent(info, paramName))
+					}
+					g.Line()
+				}),
+			),
+			jen.If(jen.Err().Op("!=").Nil()).Block(
+				jen.Return(jen.Qual("fmt", "Errorf").Call(jen.Lit(fmt.Sprintf("%s: failed to encode call: %%w", name)), jen.Err())),
+			),
+			jen.Return(jen.Id("AgrowsSendToClient").Call(jen.Id("data"))),
+		).Line().Line()
+	}
+
+	return code
+}
+
+// generateClientReceiver emits AgrowsReceive of the client, decoding the calls
+// sent by the server, agrowsDispatch, which runs the server-to-client functions
+// called, settles calls with their responses and passes on events, and
+// receiveMessageWrapper exposing AgrowsReceive to JavaScript as receiveMessage.
+func (opts *Options) generateClientReceiver(infos []FuncInfo, events bool) *jen.Statement {
+	code := jen.Comment("AgrowsReceive decodes a call sent by the server from data and dispatches it.").Line().
+		Func().Id("AgrowsReceive").Params(jen.Id("data").Index().Byte()).Params(jen.Err().Error()).Block(
+		jen.List(jen.Id("functionName"), jen.Id("args"), jen.Err()).Op(":=").Qual(opts.ProtocolPath, "DecodeFunctionCall").Call(jen.Id("data"), opts.generateProtocolOptions()),
+		jen.If(jen.Err().Op("!=").Nil()).Block(
+			jen.Return(jen.Qual("fmt", "Errorf").Call(jen.Lit("failed to decode function call: %w"), jen.Err())),
+		),
+		jen.Return(jen.Id("agrowsDispatch").Call(jen.Id("functionName"), jen.Id("args"))),
+	).Line().Line()
+
+	code.Comment("agrowsDispatch runs the server-to-client function called, settles the call answered").Line().
+		Comment("or passes on the event.").Line().
+		Func().Id("agrowsDispatch").Params(
+		jen.Id("functionName").String(),
+		jen.Id("args").Map(jen.String()).Qual(opts.ProtocolPath, "Argument"),
+	).Params(jen.Err().Error()).Block(
+		jen.Defer().Func().Params().Block(
+			jen.If(jen.Id("r").Op(":=").Recover(), jen.Id("r").Op("!=").Nil()).Block(
+				jen.Err().Op("=").Qual("fmt", "Errorf").Call(jen.Lit("%s: panic: %v"), jen.Id("functionName"), jen.Id("r")),
+			),
+		).Call(),
+		jen.Switch(jen.Id("functionName")).BlockFunc(func(g *jen.Group) {
+			for _, info := range infos {
+				g.Case(jen.Lit(info.OriginalIdentifier.Name)).BlockFunc(func(caseGroup *jen.Group) {
+					generateRequestDecoding(caseGroup, info)
+					caseGroup.Id(info.OriginalIdentifier.Name).CallFunc(func(call *jen.Group) {
+						for i := range info.Params {
+							call.Id("request").Dot(requestFieldName(info, i))
+						}
+					})
+					caseGroup.Return(jen.Nil())
+				})
+			}
+			if !opts.jsonResponses() {
+				g.Case(jen.Lit(responseFunction)).Block(
+					jen.Return(jen.Id("agrowsHandleResponse").Call(jen.Id("args"))),
+				)
+			}
+			if events {
+				g.Case(jen.Lit(eventFunction)).Block(
+					jen.Return(jen.Id("agrowsHandleEvent").Call(jen.Id("args"))),
+				)
+			}
+			g.Default().Block(
+				jen.Return(jen.Qual("fmt", "Errorf").Call(jen.Lit("unknown function '%s'"), jen.Id("functionName"))),
+			)
+		}),
+	).Line().Line()
+
+	code.Comment("receiveMessageWrapper exposes AgrowsReceive to JavaScript, taking the Uint8Array of").Line().
+		Comment("a binary message from the server.").Line().
+		Func().Id("receiveMessageWrapper").Params(
+		jen.Id("this").Qual("syscall/js", "Value"),
+		jen.Id("p").Index().Qual("syscall/js", "Value"),
+	).Any().Block(
+		jen.If(jen.Len(jen.Id("p")).Op("!=").Lit(1)).Block(
+			jen.Return(generateJsGlobalError(jen.Qual("fmt", "Sprintf").Call(jen.Lit("expected 1 arguments, got %d"), jen.Len(jen.Id("p"))))),
+		),
+		jen.Id("data").Op(":=").Make(jen.Index().Byte(), jen.Id("p").Index(jen.Lit(0)).Dot("Length").Call()),
+		jen.Qual("syscall/js", "CopyBytesToGo").Call(jen.Id("data"), jen.Id("p").Index(jen.Lit(0))),
+		jen.If(jen.Err().Op(":=").Id("AgrowsReceive").Call(jen.Id("data")), jen.Err().Op("!=").Nil()).Block(
+			jen.Id("agrowsLog").Call(jen.Lit("error"), jen.Err().Dot("Error").Call()),
+			jen.Return(generateJsGlobalError(jen.Err().Dot("Error").Call())),
+		),
+		jen.Return(jen.Nil()),
+	).Line()
+
+	return code
+}
@@ -0,0 +1,146 @@
+package gen
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dave/dst"
+)
+
+func TestExtractDirectionDirective(t *testing.T) {
+	tests := []struct {
+		doc            []string
+		serverToClient bool
+		err            string
+	}{
+		{nil, false, ""},
+		{[]string{"// Notify shows a note.", "//agrows:direction server-to-client"}, true, ""},
+		{[]string{"//agrows:direction client-to-server"}, false, ""},
+		{[]string{"//agrows:direction sideways"}, false, "unknown direction 'sideways'"},
+	}
+	for _, test := range tests {
+		serverToClient, err := extractDirectionDirective(test.doc)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q: got error %v, want one containing %q", test.doc, err, test.err)
+			}
+			continue
+		}
+		if err != nil || serverToClient != test.serverToClient {
+			t.Errorf("%q: got %t, %v, want %t", test.doc, serverToClient, err, test.serverToClient)
+		}
+	}
+}
+
+func TestImportName(t *testing.T) {
+	tests := []struct {
+		name, path, want string
+	}{
+		{"", `"syscall/js"`, "js"},
+		{"js", `"syscall/js"`, "js"},
+		{"", `"github.com/golang-jwt/jwt/v5"`, "jwt"},
+		{"", `"gopkg.in/yaml.v3"`, "yaml"},
+		{"", `"github.com/mattn/go-sqlite3"`, "sqlite3"},
+		{"_", `"embed"`, "_"},
+	}
+	for _, test := range tests {
+		spec := &dst.ImportSpec{Path: &dst.BasicLit{Value: test.path}}
+		if test.name != "" {
+			spec.Name = dst.NewIdent(test.name)
+		}
+		if got := importName(spec); got != test.want {
+			t.Errorf("%s %s: got %q, want %q", test.name, test.path, got, test.want)
+		}
+	}
+}
+
+// reverseSource has a function the server calls in the client.
+const reverseSource = `package api
+
+var notified []string
+
+// Notify records message in the client.
+//
+//agrows:direction server-to-client
+func Notify(message string) {
+	notified = append(notified, message)
+}
+
+func Echo(text string) string {
+	return text
+}
+`
+
+// reverseServerTest checks that Notify of the generated server passes the
+// encoded call to AgrowsSendToClient.
+const reverseServerTest = `package api
+
+import (
+	"testing"
+
+	"github.com/codeupdateandmodificationsystem/protocol"
+)
+
+func TestNotify(t *testing.T) {
+	if err := Notify("hi"); err == nil {
+		t.Error("Notify succeeded without AgrowsSendToClient")
+	}
+
+	var sent []byte
+	AgrowsSendToClient = func(data []byte) error {
+		sent = data
+		return nil
+	}
+	if err := Notify("hi"); err != nil {
+		t.Fatal(err)
+	}
+	functionName, args, err := protocol.DecodeFunctionCall(sent, protocol.Options())
+	if err != nil || functionName != "Notify" || args["message"].Value != "hi" {
+		t.Errorf("sent %s(%v), %v, want Notify(hi)", functionName, args, err)
+	}
+}
+`
+
+// reverseClientTest runs the calls of the server in the generated client.
+const reverseClientTest = `//go:build js && wasm && client
+
+package main
+
+import (
+	"testing"
+
+	"github.com/codeupdateandmodificationsystem/protocol"
+)
+
+func TestReceive(t *testing.T) {
+	data, err := protocol.EncodeFunctionCall("Notify", protocol.Options(), map[string]any{"message": "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := AgrowsReceive(data); err != nil {
+		t.Fatal(err)
+	}
+	if len(notified) != 1 || notified[0] != "hi" {
+		t.Errorf("got notifications %q, want hi", notified)
+	}
+
+	data, err = protocol.EncodeFunctionCall("Echo", protocol.Options(), map[string]any{"text": "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := AgrowsReceive(data); err == nil {
+		t.Error("the client ran Echo, a function of the server")
+	}
+}
+`
+
+func TestServerToClient(t *testing.T) {
+	dir := newTestModule(t)
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api.go"), generate(t, reverseSource, Options{}))
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api_test.go"), reverseServerTest)
+	writeFile(t, filepath.Join(dir, "client", "agrows_client_api.go"), generate(t, reverseSource, Options{Mode: CLIENT}))
+	writeFile(t, filepath.Join(dir, "client", "agrows_client_api_test.go"), reverseClientTest)
+	mustRunGo(t, dir, nil, "test", "./server")
+	mustRunGo(t, dir, wasmEnv(t, dir), "test", "-tags", "client", "./client")
+}
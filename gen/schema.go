@@ -0,0 +1,292 @@
+package gen
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/dave/dst"
+)
+
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// jsonSchemaScalarTypes maps the Go basic types to their JSON Schema type.
+var jsonSchemaScalarTypes = map[string]string{
+	"string":  "string",
+	"bool":    "boolean",
+	"int":     "integer",
+	"int8":    "integer",
+	"int16":   "integer",
+	"int32":   "integer",
+	"rune":    "integer",
+	"int64":   "integer",
+	"uint":    "integer",
+	"uint8":   "integer",
+	"byte":    "integer",
+	"uint16":  "integer",
+	"uint32":  "integer",
+	"uint64":  "integer",
+	"float32": "number",
+	"float64": "number",
+}
+
+// jsonSchema is a JSON Schema, kept as a map since its keywords depend on the
+// type it describes.
+type jsonSchema map[string]any
+
+// jsonSchemaDocument is the schema written by --emit-schema. It describes an
+// object with a property per function, whose params, result and events
+// properties describe the call. Structs are referenced from $defs.
+type jsonSchemaDocument struct {
+	Schema     string                `json:"$schema"`
+	Comment    string                `json:"$comment"`
+	Title      string                `json:"title"`
+	Type       string                `json:"type"`
+	Properties map[string]jsonSchema `json:"properties"`
+	Defs       map[string]jsonSchema `json:"$defs,omitempty"`
+}
+
+// jsonSchemaBuilder converts the types of the input, collecting the structs
+// they use in defs.
+type jsonSchemaBuilder struct {
+	typeMap map[string]dst.Node
+	defs    map[string]jsonSchema
+}
+
+// schemaOf returns the schema of the Go type expr, used by usage.
+func (b *jsonSchemaBuilder) schemaOf(expr dst.Expr, usage string) (jsonSchema, error) {
+	switch t := expr.(type) {
+	case *dst.Ident:
+		if t.Path != "" {
+			return nil, fmt.Errorf("unsupported type %s of %s for JSON Schema", typeString(t), usage)
+		}
+		if scalar, ok := jsonSchemaScalarTypes[t.Name]; ok {
+			return jsonSchema{"type": scalar}, nil
+		}
+		if t.Name == "any" {
+			return jsonSchema{}, nil
+		}
+		return b.namedSchema(t.Name, usage)
+	case *dst.SelectorExpr:
+		if typeString(t) == "time.Time" {
+			return jsonSchema{"type": "string", "format": "date-time"}, nil
+		}
+	case *dst.StarExpr:
+		schema, err := b.schemaOf(t.X, usage)
+		if err != nil {
+			return nil, err
+		}
+		return jsonSchema{"anyOf": []jsonSchema{schema, {"type": "null"}}}, nil
+	case *dst.ArrayType:
+		if elt, ok := t.Elt.(*dst.Ident); ok && (elt.Name == "byte" || elt.Name == "uint8") && t.Len == nil {
+			// encoding/json sends byte slices as base64
+			return jsonSchema{"type": "string", "contentEncoding": "base64"}, nil
+		}
+		items, err := b.schemaOf(t.Elt, usage)
+		if err != nil {
+			return nil, err
+		}
+		schema := jsonSchema{"type": "array", "items": items}
+		if lit, ok := t.Len.(*dst.BasicLit); ok {
+			if n, err := strconv.Atoi(lit.Value); err == nil {
+				schema["minItems"] = n
+				schema["maxItems"] = n
+			}
+		}
+		return schema, nil
+	case *dst.MapType:
+		if key, ok := t.Key.(*dst.Ident); !ok || key.Name != "string" {
+			break
+		}
+		values, err := b.schemaOf(t.Value, usage)
+		if err != nil {
+			return nil, err
+		}
+		return jsonSchema{"type": "object", "additionalProperties": values}, nil
+	case *dst.InterfaceType:
+		return jsonSchema{}, nil
+	}
+	return nil, fmt.Errorf("unsupported type %s of %s for JSON Schema", typeString(expr), usage)
+}
+
+// namedSchema returns the schema of a type declared in the input. Structs are
+// added to defs and referenced, other types are described like the type they
+// are based on.
+func (b *jsonSchemaBuilder) namedSchema(name, usage string) (jsonSchema, error) {
+	node, ok := b.typeMap[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown type %s of %s for JSON Schema", name, usage)
+	}
+	structType, ok := node.(*dst.StructType)
+	if !ok {
+		return b.schemaOf(node.(dst.Expr), usage)
+	}
+
+	ref := jsonSchema{"$ref": "#/$defs/" + name}
+	if _, ok := b.defs[name]; ok {
+		return ref, nil
+	}
+	// registered before the fields, so recursive types refer to it
+	def := jsonSchema{"type": "object"}
+	b.defs[name] = def
+
+	properties := make(map[string]jsonSchema)
+	required := []string{}
+	for _, field := range structType.Fields.List {
+		if len(field.Names) == 0 {
+			return nil, fmt.Errorf("embedded field %s in %s is not supported for JSON Schema", typeString(field.Type), name)
+		}
+		var tag reflect.StructTag
+		if field.Tag != nil {
+			value, err := strconv.Unquote(field.Tag.Value)
+			if err == nil {
+				tag = reflect.StructTag(value)
+			}
+		}
+		jsonName, options, _ := strings.Cut(tag.Get("json"), ",")
+		if jsonName == "-" && options == "" {
+			continue
+		}
+		for _, fieldName := range field.Names {
+			if !fieldName.IsExported() {
+				continue
+			}
+			propertyName := jsonName
+			if propertyName == "" {
+				propertyName = fieldName.Name
+			}
+			schema, err := b.schemaOf(field.Type, fmt.Sprintf("field %s.%s", name, fieldName.Name))
+			if err != nil {
+				return nil, err
+			}
+			properties[propertyName] = schema
+			if !strings.Contains(","+options+",", ",omitempty,") {
+				required = append(required, propertyName)
+			}
+		}
+	}
+	def["properties"] = properties
+	def["required"] = required
+	return ref, nil
+}
+
+// functionSchema describes a call of info: its params by name, the result
+// derived from the results other than error, and the events of an emit
+// parameter.
+func (b *jsonSchemaBuilder) functionSchema(info FuncInfo) (jsonSchema, error) {
+	name := info.OriginalIdentifier.Name
+
+	params := make(map[string]jsonSchema)
+	required := []string{}
+	for _, paramInfo := range info.Params {
+		paramName := paramInfo.DstField.Names[0].Name
+		schema, err := b.schemaOf(paramInfo.DstField.Type, fmt.Sprintf("parameter %s of %s", paramName, name))
+		if err != nil {
+			return nil, err
+		}
+		params[paramName] = schema
+		required = append(required, paramName)
+	}
+	properties := map[string]jsonSchema{
+		"params": {
+			"type":                 "object",
+			"properties":           params,
+			"required":             required,
+			"additionalProperties": false,
+		},
+	}
+
+	var results []jsonSchema
+	for _, resultInfo := range info.Results {
+		if typeString(resultInfo.DstField.Type) == "error" {
+			continue
+		}
+		schema, err := b.schemaOf(resultInfo.DstField.Type, "result of "+name)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, schema)
+	}
+	switch len(results) {
+	case 0:
+	case 1:
+		properties["result"] = results[0]
+	default:
+		properties["result"] = jsonSchema{
+			"type":        "array",
+			"prefixItems": results,
+			"items":       false,
+		}
+	}
+
+	if info.Emit != nil {
+		schema, err := b.schemaOf(info.Emit.DstField.Type, "events of "+name)
+		if err != nil {
+			return nil, err
+		}
+		properties["events"] = schema
+	}
+
+	schema := jsonSchema{
+		"type":       "object",
+		"properties": properties,
+	}
+	var description []string
+	for _, line := range info.Doc {
+		if strings.HasPrefix(strings.TrimSpace(line), "//agrows:") {
+			continue
+		}
+		description = append(description, strings.TrimSpace(strings.TrimPrefix(line, "//")))
+	}
+	// the blank line separating the directives from the text is dropped too
+	if text := strings.TrimSpace(strings.Join(description, "\n")); text != "" {
+		schema["description"] = text
+	}
+	if info.ServerToClient {
+		schema["$comment"] = "called by the server in the client"
+	}
+	return schema, nil
+}
+
+// generateJSONSchema describes the functions of input and the types they use
+// as JSON Schema, so other tools can generate clients or validators from it.
+func generateJSONSchema(input Input) (jsonSchemaDocument, error) {
+	builder := &jsonSchemaBuilder{
+		typeMap: input.TypeMap,
+		defs:    make(map[string]jsonSchema),
+	}
+	document := jsonSchemaDocument{
+		Schema:     jsonSchemaDialect,
+		Comment:    generatedMarker + ". DO NOT EDIT.",
+		Title:      "agrows functions of " + input.FileName,
+		Type:       "object",
+		Properties: make(map[string]jsonSchema, len(input.Functions)),
+		Defs:       builder.defs,
+	}
+	for _, info := range input.Functions {
+		schema, err := builder.functionSchema(info)
+		if err != nil {
+			return document, fmt.Errorf("%s at %s: %w", info.OriginalIdentifier.Name, info.Position, err)
+		}
+		document.Properties[info.OriginalIdentifier.Name] = schema
+	}
+	return document, nil
+}
+
+// WriteJSONSchema writes a JSON Schema of the functions of input and the types
+// they use to w, so other tools can generate clients or validators from it.
+func WriteJSONSchema(w io.Writer, input Input) error {
+	document, err := generateJSONSchema(input)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode schema: %w", err)
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
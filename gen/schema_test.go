@@ -0,0 +1,102 @@
+package gen
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// schemaSource has a struct with json tags, several results and events.
+const schemaSource = `package api
+
+import "time"
+
+type User struct {
+	Name    string ` + "`json:\"name\"`" + `
+	Age     int    ` + "`json:\"age,omitempty\"`" + `
+	Secret  string ` + "`json:\"-\"`" + `
+	Tags    []string
+	Created time.Time
+}
+
+type Tick struct {
+	N int
+}
+
+// Greet greets user.
+//
+//agrows:timeout 5s
+func Greet(user User) (string, error) {
+	return "hi " + user.Name, nil
+}
+
+func Pair(a, b int) (int, string) {
+	return a, ""
+}
+
+func Count(to int, emit func(Tick)) {}
+`
+
+func TestEmitSchema(t *testing.T) {
+	input, err := Parse(strings.NewReader(schemaSource), "api.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data bytes.Buffer
+	if err := WriteJSONSchema(&data, input); err != nil {
+		t.Fatal(err)
+	}
+	var schema struct {
+		Schema     string `json:"$schema"`
+		Properties map[string]any
+		Defs       map[string]any `json:"$defs"`
+	}
+	if err := json.Unmarshal(data.Bytes(), &schema); err != nil {
+		t.Fatal(err)
+	}
+	if schema.Schema != "https://json-schema.org/draft/2020-12/schema" {
+		t.Errorf("got $schema %q", schema.Schema)
+	}
+
+	tests := []struct {
+		got  any
+		want string
+	}{
+		{schema.Properties["Greet"], `{
+			"description": "Greet greets user.",
+			"properties": {
+				"params": {"additionalProperties": false, "properties": {"user": {"$ref": "#/$defs/User"}}, "required": ["user"], "type": "object"},
+				"result": {"type": "string"}
+			},
+			"type": "object"
+		}`},
+		{schema.Properties["Pair"].(map[string]any)["properties"].(map[string]any)["result"], `{
+			"items": false,
+			"prefixItems": [{"type": "integer"}, {"type": "string"}],
+			"type": "array"
+		}`},
+		{schema.Properties["Count"].(map[string]any)["properties"].(map[string]any)["events"], `{"$ref": "#/$defs/Tick"}`},
+		{schema.Defs["User"], `{
+			"properties": {
+				"Created": {"format": "date-time", "type": "string"},
+				"Tags": {"items": {"type": "string"}, "type": "array"},
+				"age": {"type": "integer"},
+				"name": {"type": "string"}
+			},
+			"required": ["name", "Tags", "Created"],
+			"type": "object"
+		}`},
+	}
+	for _, test := range tests {
+		var want any
+		if err := json.Unmarshal([]byte(test.want), &want); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(test.got, want) {
+			got, _ := json.Marshal(test.got)
+			t.Errorf("got %s, want %s", got, test.want)
+		}
+	}
+}
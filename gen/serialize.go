@@ -0,0 +1,141 @@
+package gen
+
+import (
+	"github.com/dave/jennifer/jen"
+)
+
+// msgpackPackage and cborPackage are the import paths of the packages encoding
+// the calls with --serialize=msgpack and --serialize=cbor.
+const (
+	msgpackPackage = "github.com/vmihailenco/msgpack/v5"
+	cborPackage    = "github.com/fxamacker/cbor/v2"
+)
+
+// serializeNames are the names of the encodings of --serialize used in
+// comments of the generated code.
+var serializeNames = map[string]string{"json": "JSON", "msgpack": "MessagePack", "cbor": "CBOR"}
+
+// binaryCalls reports whether the calls of the client are encoded by
+// agrowsEncodeCall with a binary encoding instead of by the protocol package.
+func (opts *Options) binaryCalls() bool {
+	return opts.Serialize != "json"
+}
+
+// generateEncodeCall emits the encoding of a call of functionName with args,
+// yielding the data and an error.
+func (opts *Options) generateEncodeCall(functionName, args jen.Code) *jen.Statement {
+	if opts.binaryCalls() {
+		return jen.Id("agrowsEncodeCall").Call(functionName, args)
+	}
+	return jen.Qual(opts.ProtocolPath, "EncodeFunctionCall").Call(functionName, opts.generateProtocolOptions(), args)
+}
+
+// generateDecodeCall emits the decoding of a call of the client from data,
+// yielding the function name, the arguments and an error.
+func (opts *Options) generateDecodeCall(data jen.Code) *jen.Statement {
+	if opts.binaryCalls() {
+		return jen.Id("agrowsDecodeCall").Call(data)
+	}
+	return jen.Qual(opts.ProtocolPath, "DecodeFunctionCall").Call(data, opts.generateProtocolOptions())
+}
+
+// generateSerializeCodec emits the binary encoding of calls bypassing the
+// protocol package: agrowsMarshal and agrowsUnmarshal, agrowsEncodeCall if
+// encode is set and agrowsDecodeCall if decode is set. Struct fields are named
+// after their json tags, so values are decoded into the same fields as from
+// JSON.
+func (opts *Options) generateSerializeCodec(encode, decode bool) *jen.Statement {
+	name := serializeNames[opts.Serialize]
+	code := jen.Commentf("agrowsBinaryCall is a call of the client encoded with %s.", name).Line().
+		Type().Id("agrowsBinaryCall").Struct(
+		jen.Id("Function").String().Tag(map[string]string{"json": "function"}),
+		jen.Id("Args").Map(jen.String()).Any().Tag(map[string]string{"json": "args"}),
+	).Line().Line()
+
+	switch opts.Serialize {
+	case "msgpack":
+		code.Comment("agrowsMarshal encodes v with MessagePack, naming struct fields after their json").Line().
+			Comment("tags. Numbers are encoded as small as possible, whole floats as integers, so").Line().
+			Comment("the numbers of JavaScript objects decode into integer fields like from JSON.").Line().
+			Func().Id("agrowsMarshal").Params(jen.Id("v").Any()).Params(jen.Index().Byte(), jen.Error()).Block(
+			jen.Var().Id("buf").Qual("bytes", "Buffer"),
+			jen.Id("encoder").Op(":=").Qual(msgpackPackage, "NewEncoder").Call(jen.Op("&").Id("buf")),
+			jen.Id("encoder").Dot("SetCustomStructTag").Call(jen.Lit("json")),
+			jen.Id("encoder").Dot("UseCompactInts").Call(jen.True()),
+			jen.Id("encoder").Dot("UseCompactFloats").Call(jen.True()),
+			jen.If(jen.Err().Op(":=").Id("encoder").Dot("Encode").Call(jen.Id("v")), jen.Err().Op("!=").Nil()).Block(
+				jen.Return(jen.Nil(), jen.Err()),
+			),
+			jen.Return(jen.Id("buf").Dot("Bytes").Call(), jen.Nil()),
+		).Line().Line()
+
+		code.Comment("agrowsUnmarshal decodes data encoded by agrowsMarshal into v.").Line().
+			Func().Id("agrowsUnmarshal").Params(jen.Id("data").Index().Byte(), jen.Id("v").Any()).Error().Block(
+			jen.Id("decoder").Op(":=").Qual(msgpackPackage, "NewDecoder").Call(jen.Qual("bytes", "NewReader").Call(jen.Id("data"))),
+			jen.Id("decoder").Dot("SetCustomStructTag").Call(jen.Lit("json")),
+			jen.Return(jen.Id("decoder").Dot("Decode").Call(jen.Id("v"))),
+		).Line()
+	case "cbor":
+		// the options are fixed and valid, so the modes never fail to build
+		code.Comment("agrowsCBOREncoder sorts map keys, so equal values are always encoded the same,").Line().
+			Comment("and encodes times as tagged RFC 3339 strings keeping their nanoseconds.").Line().
+			Var().List(jen.Id("agrowsCBOREncoder"), jen.Id("_")).Op("=").Qual(cborPackage, "EncOptions").Values(jen.Dict{
+			jen.Id("Sort"):    jen.Qual(cborPackage, "SortCoreDeterministic"),
+			jen.Id("Time"):    jen.Qual(cborPackage, "TimeRFC3339Nano"),
+			jen.Id("TimeTag"): jen.Qual(cborPackage, "EncTagRequired"),
+		}).Dot("EncMode").Call().Line().Line()
+
+		code.Comment("agrowsCBORDecoder decodes maps into any as map[string]any, like JSON.").Line().
+			Var().List(jen.Id("agrowsCBORDecoder"), jen.Id("_")).Op("=").Qual(cborPackage, "DecOptions").Values(jen.Dict{
+			jen.Id("DefaultMapType"): jen.Qual("reflect", "TypeOf").Call(jen.Map(jen.String()).Any().Call(jen.Nil())),
+		}).Dot("DecMode").Call().Line().Line()
+
+		code.Comment("agrowsMarshal encodes v with CBOR, naming struct fields after their json tags.").Line().
+			Func().Id("agrowsMarshal").Params(jen.Id("v").Any()).Params(jen.Index().Byte(), jen.Error()).Block(
+			jen.Return(jen.Id("agrowsCBOREncoder").Dot("Marshal").Call(jen.Id("v"))),
+		).Line().Line()
+
+		code.Comment("agrowsUnmarshal decodes data encoded by agrowsMarshal into v.").Line().
+			Func().Id("agrowsUnmarshal").Params(jen.Id("data").Index().Byte(), jen.Id("v").Any()).Error().Block(
+			jen.Return(jen.Id("agrowsCBORDecoder").Dot("Unmarshal").Call(jen.Id("data"), jen.Id("v"))),
+		).Line()
+	}
+
+	if encode {
+		code.Line().Comment("agrowsEncodeCall encodes a call of functionName with args.").Line().
+			Func().Id("agrowsEncodeCall").Params(
+			jen.Id("functionName").String(),
+			jen.Id("args").Map(jen.String()).Any(),
+		).Params(jen.Index().Byte(), jen.Error()).Block(
+			jen.Return(jen.Id("agrowsMarshal").Call(jen.Id("agrowsBinaryCall").Values(jen.Dict{
+				jen.Id("Function"): jen.Id("functionName"),
+				jen.Id("Args"):     jen.Id("args"),
+			}))),
+		).Line()
+	}
+
+	if decode {
+		code.Line().Comment("agrowsDecodeCall decodes a call encoded by agrowsEncodeCall. Its arguments hold").Line().
+			Comment("the decoded values, e.g. a map[string]any for a struct, which agrowsDecodeRequest").Line().
+			Comment("converts to the parameter types.").Line().
+			Func().Id("agrowsDecodeCall").Params(jen.Id("data").Index().Byte()).Params(
+			jen.String(),
+			jen.Map(jen.String()).Qual(opts.ProtocolPath, "Argument"),
+			jen.Error(),
+		).Block(
+			jen.Var().Id("call").Id("agrowsBinaryCall"),
+			jen.If(jen.Err().Op(":=").Id("agrowsUnmarshal").Call(jen.Id("data"), jen.Op("&").Id("call")), jen.Err().Op("!=").Nil()).Block(
+				jen.Return(jen.Lit(""), jen.Nil(), jen.Err()),
+			),
+			jen.Id("args").Op(":=").Make(jen.Map(jen.String()).Qual(opts.ProtocolPath, "Argument"), jen.Len(jen.Id("call").Dot("Args"))),
+			jen.For(jen.List(jen.Id("key"), jen.Id("value")).Op(":=").Range().Id("call").Dot("Args")).Block(
+				jen.Id("args").Index(jen.Id("key")).Op("=").Qual(opts.ProtocolPath, "Argument").Values(jen.Dict{
+					jen.Id("Value"): jen.Id("value"),
+				}),
+			),
+			jen.Return(jen.Id("call").Dot("Function"), jen.Id("args"), jen.Nil()),
+		).Line()
+	}
+
+	return code
+}
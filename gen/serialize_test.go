@@ -0,0 +1,208 @@
+package gen
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// serializeSource takes a struct with bytes, a time and nested collections.
+const serializeSource = `package api
+
+import "time"
+
+type Meta struct {
+	Tags    []string
+	Sizes   map[string]int
+	Created time.Time
+}
+
+type Attachment struct {
+	Name string ` + "`json:\"name\"`" + `
+	Data []byte
+	Meta Meta
+}
+
+var received Attachment
+
+func Store(attachment Attachment, raw []byte) int {
+	received = attachment
+	return len(raw)
+}
+`
+
+// serializeTest runs in the generated server of serializeSource, calling Store
+// with a call encoded like agrowsEncodeCall of the client does. Its verb tells
+// whether the encoding keeps the zone offset of times.
+const serializeTest = `package api
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	created := time.Date(2024, 2, 29, 13, 14, 15, 123456789, time.FixedZone("CET", 3600))
+	want := Attachment{
+		Name: "report",
+		Data: []byte{0, 1, 2, 255},
+		Meta: Meta{Tags: []string{"a", "b"}, Sizes: map[string]int{"x": 1, "y": 300}, Created: created},
+	}
+	data, err := agrowsMarshal(agrowsBinaryCall{Function: "Store", Args: map[string]any{"attachment": want, "raw": []byte{1, 2, 3}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := AgrowsReceive(data)
+	if err != nil || result != "'3'" {
+		t.Fatalf("Store returned %%q, %%v, want '3'", result, err)
+	}
+
+	got := received
+	if !got.Meta.Created.Equal(created) {
+		t.Errorf("got time %%s, want %%s", got.Meta.Created, created)
+	}
+	if _, offset := got.Meta.Created.Zone(); %t && offset != 3600 {
+		t.Errorf("got zone offset %%d, want 3600", offset)
+	}
+	got.Meta.Created = created
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %%#v, want %%#v", got, want)
+	}
+}
+`
+
+// testSerialize generates the server and client of serializeSource with the
+// encoding of --serialize, compiles both and runs serializeTest in the server.
+func testSerialize(t *testing.T, serialize, module string, keepsZone bool) {
+	dir := newTestModule(t, module)
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api.go"), generate(t, serializeSource, Options{Serialize: serialize}))
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api_test.go"), fmt.Sprintf(serializeTest, keepsZone))
+	writeFile(t, filepath.Join(dir, "client", "agrows_client_api.go"), generate(t, serializeSource, Options{Mode: CLIENT, Serialize: serialize}))
+	mustRunGo(t, dir, nil, "vet", "./server")
+	mustRunGo(t, dir, []string{"GOOS=js", "GOARCH=wasm"}, "vet", "-tags", "client", "./client")
+	mustRunGo(t, dir, nil, "test", "./server")
+}
+
+func TestSerializeCBOR(t *testing.T) {
+	testSerialize(t, "cbor", cborPackage, true)
+}
+
+func TestSerializeMsgpack(t *testing.T) {
+	// MessagePack times are instants, decoded in the local zone
+	testSerialize(t, "msgpack", msgpackPackage, false)
+}
+
+func TestSerializeOptions(t *testing.T) {
+	for _, mode := range []byte{SERVER, CLIENT} {
+		if !bytes.Equal(generate(t, apiSource, Options{Mode: mode, Serialize: "json"}), generate(t, apiSource, Options{Mode: mode})) {
+			t.Errorf("--serialize=json differs from the default in mode %d", mode)
+		}
+	}
+	tests := []struct {
+		opts Options
+		want string
+	}{
+		{Options{Serialize: "protobuf"}, "unknown serialization 'protobuf'"},
+		{Options{Serialize: "msgpack", Compress: true}, "--compress can not be combined with --serialize=msgpack"},
+		{Options{Serialize: "cbor", Compress: true}, "--compress can not be combined with --serialize=cbor"},
+	}
+	for _, test := range tests {
+		test.opts.FileName = "api.go"
+		err := Generate(strings.NewReader(apiSource), test.opts, &bytes.Buffer{})
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("got error %v, want one containing %q", err, test.want)
+		}
+	}
+}
+
+// benchmarkSource takes a struct of 10 fields, as compared by
+// TestSerializeBenchmarks.
+const benchmarkSource = `package api
+
+type Record struct {
+	ID       int64
+	Name     string
+	Email    string
+	Age      int
+	Score    float64
+	Active   bool
+	Tags     []string
+	Balance  float64
+	Country  string
+	Sessions int
+}
+
+func Save(record Record) int {
+	return record.Sessions
+}
+`
+
+// benchmarkTest decodes a call of Save in the generated server of
+// benchmarkSource, encoded with the given expression, and reports its size.
+const benchmarkTest = `package api
+
+import (
+	"testing"
+
+	"github.com/codeupdateandmodificationsystem/protocol"
+)
+
+// only the JSON encoding goes through the protocol package
+var _ = protocol.Options
+
+func BenchmarkDecode(b *testing.B) {
+	record := Record{
+		ID:       1234567,
+		Name:     "Ada Lovelace",
+		Email:    "ada@example.com",
+		Age:      36,
+		Score:    98.5,
+		Active:   true,
+		Tags:     []string{"math", "engines"},
+		Balance:  1024.75,
+		Country:  "GB",
+		Sessions: 42,
+	}
+	data, err := %s
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := AgrowsReceive(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(len(data)), "bytes/call")
+}
+`
+
+// TestSerializeBenchmarks runs BenchmarkDecode in the servers of every
+// encoding and logs their results, see go test -v -run TestSerializeBenchmarks.
+func TestSerializeBenchmarks(t *testing.T) {
+	const binaryCall = `agrowsMarshal(agrowsBinaryCall{Function: "Save", Args: map[string]any{"record": record}})`
+	encodings := []struct {
+		serialize string
+		encode    string
+	}{
+		{"json", `protocol.EncodeFunctionCall("Save", protocol.Options(), map[string]any{"record": record})`},
+		{"msgpack", binaryCall},
+		{"cbor", binaryCall},
+	}
+	dir := newTestModule(t, msgpackPackage, cborPackage)
+	packages := []string{"test", "-run", "^$", "-bench", ".", "-benchtime", "1000x"}
+	for _, encoding := range encodings {
+		pkg := filepath.Join(dir, encoding.serialize)
+		writeFile(t, filepath.Join(pkg, "agrows_server_api.go"), generate(t, benchmarkSource, Options{Serialize: encoding.serialize}))
+		writeFile(t, filepath.Join(pkg, "agrows_server_api_test.go"), fmt.Sprintf(benchmarkTest, encoding.encode))
+		packages = append(packages, "./"+encoding.serialize)
+	}
+	out := mustRunGo(t, dir, nil, packages...)
+	if strings.Count(out, "BenchmarkDecode") != len(encodings) || strings.Count(out, "bytes/call") != len(encodings) {
+		t.Fatalf("not every benchmark reported its size:\n%s", out)
+	}
+	t.Log(out)
+}
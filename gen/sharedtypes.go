@@ -0,0 +1,90 @@
+package gen
+
+import (
+	"bytes"
+	"fmt"
+	"go/token"
+	"os"
+
+	"github.com/dave/dst"
+	"github.com/dave/jennifer/jen"
+)
+
+// sharedTypesFileName is the file --shared-types writes next to the output.
+const sharedTypesFileName = "agrows_types.go"
+
+// sharedTypeNames returns the types of typeMap used by the parameters, results
+// and emit callbacks of infos, directly or through the fields of other types
+// of typeMap.
+func sharedTypeNames(infos []FuncInfo, typeMap map[string]dst.Node) map[string]bool {
+	return referencedTypes(funcTypes(infos), typeMap)
+}
+
+// moveSharedTypes removes the declarations of the types in names from tree and
+// returns a file declaring them instead, with the imports of tree they use.
+// Declarations grouping other types keep those in tree.
+func moveSharedTypes(tree *dst.File, names map[string]bool) *dst.File {
+	shared := &dst.File{Name: dst.NewIdent(tree.Name.Name)}
+	var decls []dst.Decl
+	for _, decl := range tree.Decls {
+		genDecl, ok := decl.(*dst.GenDecl)
+		if !ok || genDecl.Tok != token.TYPE {
+			decls = append(decls, decl)
+			continue
+		}
+		var moved, kept []dst.Spec
+		for _, spec := range genDecl.Specs {
+			if names[spec.(*dst.TypeSpec).Name.Name] {
+				moved = append(moved, spec)
+			} else {
+				kept = append(kept, spec)
+			}
+		}
+		switch {
+		case len(moved) == 0:
+			decls = append(decls, decl)
+		case len(kept) == 0:
+			shared.Decls = append(shared.Decls, decl)
+		default:
+			genDecl.Specs = kept
+			decls = append(decls, decl)
+			sharedDecl := &dst.GenDecl{Tok: token.TYPE, Specs: moved, Lparen: len(moved) > 1, Rparen: len(moved) > 1}
+			if len(moved) == 1 {
+				// without parentheses the doc comment belongs to the declaration
+				spec := moved[0].(*dst.TypeSpec)
+				sharedDecl.Decs.Before = dst.EmptyLine
+				sharedDecl.Decs.Start, spec.Decs.Start = spec.Decs.Start, nil
+			}
+			shared.Decls = append(shared.Decls, sharedDecl)
+		}
+	}
+	tree.Decls = decls
+
+	for _, decl := range tree.Decls {
+		if genDecl, ok := decl.(*dst.GenDecl); ok && genDecl.Tok == token.IMPORT {
+			shared.Decls = append([]dst.Decl{dst.Clone(genDecl).(*dst.GenDecl)}, shared.Decls...)
+		}
+	}
+	pruneUnusedImports(shared)
+	pruneUnusedImports(tree)
+	return shared
+}
+
+// writeSharedTypes writes the types moved out of the output to path. The file
+// has no build tags, so the server and the client share it when they are
+// generated into the same package.
+func (opts *Options) writeSharedTypes(path string, types *dst.File, force bool) error {
+	if !force {
+		if err := CheckOverwritable(path); err != nil {
+			return err
+		}
+	}
+	var buffer bytes.Buffer
+	if _, err := opts.writeCombinedTreeAndGenerated(types, jen.NewFile(types.Name.Name), &buffer, TESTHELPERS); err != nil {
+		return fmt.Errorf("failed to generate %s: %w", path, err)
+	}
+	if err := os.WriteFile(path, buffer.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
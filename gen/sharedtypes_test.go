@@ -0,0 +1,35 @@
+package gen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSharedTypes(t *testing.T) {
+	dir := newTestModule(t)
+	pkg := filepath.Join(dir, "api")
+	if err := os.Mkdir(pkg, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	src := apiSource + "\ntype Unused struct {\n\tID int\n}\n"
+	server := generate(t, src, Options{SharedTypes: true, OutputDir: pkg, BuildTags: "!wasm"})
+	if strings.Contains(string(server), "type User struct") || !strings.Contains(string(server), "type Unused struct") {
+		t.Errorf("the server should only keep the unused type:\n%s", server)
+	}
+	types, err := os.ReadFile(filepath.Join(pkg, sharedTypesFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(types), "type User struct") || strings.Contains(string(types), "Unused") || strings.Contains(string(types), "//go:build") {
+		t.Errorf("%s should declare User without build tags:\n%s", sharedTypesFileName, types)
+	}
+	client := generate(t, src, Options{Mode: CLIENT, SharedTypes: true, OutputDir: pkg, PackageName: "api"})
+
+	// both compile in one package next to the shared types
+	writeFile(t, filepath.Join(pkg, "agrows_server_api.go"), server)
+	writeFile(t, filepath.Join(pkg, "agrows_client_api.go"), client)
+	mustRunGo(t, dir, nil, "build", "./api")
+	mustRunGo(t, dir, wasmEnv(t, dir), "build", "-tags", "client", "./api")
+}
@@ -0,0 +1,77 @@
+package gen
+
+import (
+	"github.com/dave/jennifer/jen"
+)
+
+// tracksActiveRequests reports whether AgrowsReceive counts the calls it is
+// running in agrowsActiveRequests.
+func (opts *Options) tracksActiveRequests() bool {
+	return opts.Shutdown || opts.HealthCheck
+}
+
+// generateShutdownCheck emits the start of a dispatch counting the call as
+// active and, when --shutdown is set, rejecting it with reject once shutting
+// down. The counter is incremented before the check, so AgrowsShutdown can not
+// miss a call that passed it.
+func (opts *Options) generateShutdownCheck(reject ...jen.Code) jen.Code {
+	if !opts.tracksActiveRequests() {
+		return jen.Null()
+	}
+	code := jen.Add(
+		jen.Id("agrowsActiveRequests").Dot("Add").Call(jen.Lit(1)),
+		jen.Line(),
+		jen.Defer().Id("agrowsActiveRequests").Dot("Add").Call(jen.Lit(-1)),
+	)
+	if opts.Shutdown {
+		code.Line().If(jen.Id("agrowsShuttingDown").Dot("Load").Call()).Block(reject...)
+	}
+	return code
+}
+
+// generateActiveRequests emits the counter of the calls AgrowsReceive is running.
+// shutdownError is the error calls are rejected with once shutting down.
+func shutdownError() *jen.Statement {
+	return jen.Qual("errors", "New").Call(jen.Lit("server is shutting down"))
+}
+
+func generateActiveRequests() *jen.Statement {
+	return jen.Var().Id("agrowsActiveRequests").Qual("sync/atomic", "Int64").Line()
+}
+
+// generateShutdownFunc emits AgrowsShutdown and the state it shares with
+// AgrowsReceive. With --async it also stops the worker pool.
+func (opts *Options) generateShutdownFunc() *jen.Statement {
+	code := jen.Var().Defs(
+		jen.Id("agrowsShuttingDown").Qual("sync/atomic", "Bool"),
+		jen.Id("agrowsShutdownCh").Op("=").Make(jen.Chan().Struct()),
+		jen.Id("agrowsShutdownOnce").Qual("sync", "Once"),
+	).Line().Line()
+
+	code.Comment("AgrowsShutdown rejects further calls and waits for the active ones to complete,").Line().
+		Comment("or returns the error of ctx if it is done first. Once all calls completed,").Line().
+		Comment("agrowsShutdownCh is closed.").Line().
+		Func().Id("AgrowsShutdown").Params(jen.Id("ctx").Qual("context", "Context")).Error().BlockFunc(func(g *jen.Group) {
+		g.Id("agrowsShuttingDown").Dot("Store").Call(jen.True())
+		g.Id("ticker").Op(":=").Qual("time", "NewTicker").Call(jen.Lit(10).Op("*").Qual("time", "Millisecond"))
+		g.Defer().Id("ticker").Dot("Stop").Call()
+		g.For(jen.Id("agrowsActiveRequests").Dot("Load").Call().Op(">").Lit(0)).Block(
+			jen.Select().Block(
+				jen.Case(jen.Op("<-").Id("ctx").Dot("Done").Call()).Block(
+					jen.Return(jen.Id("ctx").Dot("Err").Call()),
+				),
+				jen.Case(jen.Op("<-").Id("ticker").Dot("C")),
+			),
+		)
+		if opts.Async {
+			g.Comment("calls still queued in the pool are rejected by now")
+			g.Id("agrowsShutdownPool").Call()
+		}
+		g.Id("agrowsShutdownOnce").Dot("Do").Call(jen.Func().Params().Block(
+			jen.Close(jen.Id("agrowsShutdownCh")),
+		))
+		g.Return(jen.Nil())
+	}).Line()
+
+	return code
+}
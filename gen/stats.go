@@ -0,0 +1,62 @@
+package gen
+
+import (
+	"github.com/dave/jennifer/jen"
+)
+
+// generateStatsRecording emits the per call counter updates of AgrowsReceive.
+// Like the call logging, the deferred update must come before the recover, so
+// panics count as failures.
+func (opts *Options) generateStatsRecording() jen.Code {
+	if !opts.Stats {
+		return jen.Null()
+	}
+	return jen.If(jen.List(jen.Id("stats"), jen.Id("ok")).Op(":=").Id("AgrowsFunctionStats").Index(jen.Id("functionName")), jen.Id("ok")).Block(
+		jen.Id("stats").Dot("Calls").Dot("Add").Call(jen.Lit(1)),
+		jen.Id("stats").Dot("TotalBytes").Dot("Add").Call(jen.Int64().Call(jen.Len(jen.Id("data")))),
+		jen.Defer().Func().Params().Block(
+			jen.If(jen.Err().Op("!=").Nil()).Block(
+				jen.Id("stats").Dot("Failures").Dot("Add").Call(jen.Lit(1)),
+			).Else().Block(
+				jen.Id("stats").Dot("Successes").Dot("Add").Call(jen.Lit(1)),
+			),
+		).Call(),
+	)
+}
+
+// generateStatsCounters emits AgrowsFuncStats with one entry per function in
+// AgrowsFunctionStats, and AgrowsGetStats.
+func generateStatsCounters(infos []FuncInfo) *jen.Statement {
+	code := jen.Comment("AgrowsFuncStats counts the calls of a function. TotalBytes sums the size of the").Line().
+		Comment("received calls.").Line().
+		Type().Id("AgrowsFuncStats").Struct(
+		jen.List(jen.Id("Calls"), jen.Id("Successes"), jen.Id("Failures")).Qual("sync/atomic", "Int64"),
+		jen.Id("TotalBytes").Qual("sync/atomic", "Int64"),
+	).Line().Line()
+
+	code.Comment("AgrowsFunctionStats holds the counters of every function, keyed by the AgrowsFunc_").Line().
+		Comment("constants. The map itself is not changed after init.").Line().
+		Var().Id("AgrowsFunctionStats").Op("=").Map(jen.String()).Op("*").Id("AgrowsFuncStats").Values().Line().Line()
+
+	code.Func().Id("init").Params().BlockFunc(func(g *jen.Group) {
+		for _, info := range infos {
+			g.Id("AgrowsFunctionStats").Index(jen.Id(funcNameConstant(info))).Op("=").Op("&").Id("AgrowsFuncStats").Values()
+		}
+	}).Line().Line()
+
+	code.Comment("AgrowsGetStats returns a copy of the current counters of every function.").Line().
+		Func().Id("AgrowsGetStats").Params().Map(jen.String()).Op("*").Id("AgrowsFuncStats").Block(
+		jen.Id("snapshot").Op(":=").Make(jen.Map(jen.String()).Op("*").Id("AgrowsFuncStats"), jen.Len(jen.Id("AgrowsFunctionStats"))),
+		jen.For(jen.List(jen.Id("name"), jen.Id("stats")).Op(":=").Range().Id("AgrowsFunctionStats")).Block(
+			jen.Id("copied").Op(":=").Op("&").Id("AgrowsFuncStats").Values(),
+			jen.Id("copied").Dot("Calls").Dot("Store").Call(jen.Id("stats").Dot("Calls").Dot("Load").Call()),
+			jen.Id("copied").Dot("Successes").Dot("Store").Call(jen.Id("stats").Dot("Successes").Dot("Load").Call()),
+			jen.Id("copied").Dot("Failures").Dot("Store").Call(jen.Id("stats").Dot("Failures").Dot("Load").Call()),
+			jen.Id("copied").Dot("TotalBytes").Dot("Store").Call(jen.Id("stats").Dot("TotalBytes").Dot("Load").Call()),
+			jen.Id("snapshot").Index(jen.Id("name")).Op("=").Id("copied"),
+		),
+		jen.Return(jen.Id("snapshot")),
+	).Line()
+
+	return code
+}
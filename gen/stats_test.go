@@ -0,0 +1,77 @@
+package gen
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// statsSource has a function succeeding and one panicking.
+const statsSource = `package api
+
+func Echo(text string) string {
+	return text
+}
+
+func Explode(reason string) {
+	panic(reason)
+}
+`
+
+// statsTest checks the counters of the generated server of statsSource with
+// --stats.
+const statsTest = `package api
+
+import (
+	"testing"
+
+	"github.com/codeupdateandmodificationsystem/protocol"
+)
+
+func TestStats(t *testing.T) {
+	var size int64
+	for _, call := range []struct {
+		functionName string
+		args         map[string]any
+	}{
+		{"Echo", map[string]any{"text": "a"}},
+		{"Echo", map[string]any{"text": "b"}},
+		{"Explode", map[string]any{"reason": "boom"}},
+	} {
+		data, err := protocol.EncodeFunctionCall(call.functionName, protocol.Options(), call.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if call.functionName == "Echo" {
+			size += int64(len(data))
+		}
+		AgrowsReceive(data)
+	}
+
+	stats := AgrowsGetStats()
+	echo, explode := stats[AgrowsFunc_Echo], stats[AgrowsFunc_Explode]
+	if echo.Calls.Load() != 2 || echo.Successes.Load() != 2 || echo.Failures.Load() != 0 || echo.TotalBytes.Load() != size {
+		t.Errorf("got Echo stats %d calls, %d successes, %d failures, %d bytes, want 2, 2, 0, %d",
+			echo.Calls.Load(), echo.Successes.Load(), echo.Failures.Load(), echo.TotalBytes.Load(), size)
+	}
+	if explode.Calls.Load() != 1 || explode.Failures.Load() != 1 {
+		t.Errorf("got Explode stats %d calls, %d failures, want the panic as failure", explode.Calls.Load(), explode.Failures.Load())
+	}
+
+	// the copy does not change with later calls
+	data, err := protocol.EncodeFunctionCall("Echo", protocol.Options(), map[string]any{"text": "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	AgrowsReceive(data)
+	if echo.Calls.Load() != 2 || AgrowsFunctionStats[AgrowsFunc_Echo].Calls.Load() != 3 {
+		t.Error("AgrowsGetStats did not return a copy of the counters")
+	}
+}
+`
+
+func TestStats(t *testing.T) {
+	dir := newTestModule(t)
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api.go"), generate(t, statsSource, Options{Stats: true}))
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api_test.go"), statsTest)
+	mustRunGo(t, dir, nil, "test", "./server")
+}
@@ -0,0 +1,196 @@
+package gen
+
+import (
+	"fmt"
+
+	"github.com/dave/dst"
+	"github.com/dave/jennifer/jen"
+)
+
+// subscriptionArg is the argument a client passes the ID of the subscription
+// of a call in, for functions with an emit parameter.
+const subscriptionArg = "agrowsSubscription"
+
+// eventFunction is the reserved name of the calls carrying events of a
+// subscription from the server to the client.
+const eventFunction = "agrows_event"
+
+// emitEventType returns T if expr is func(T), the type of an emit parameter.
+func emitEventType(expr dst.Expr) (dst.Expr, bool) {
+	funcType, ok := expr.(*dst.FuncType)
+	if !ok || funcType.Params == nil || len(funcType.Params.List) != 1 || len(funcType.Params.List[0].Names) > 1 {
+		return nil, false
+	}
+	if funcType.Results != nil && len(funcType.Results.List) > 0 {
+		return nil, false
+	}
+	return funcType.Params.List[0].Type, true
+}
+
+// hasSubscriptions reports whether any of infos streams events to the client.
+func hasSubscriptions(infos []FuncInfo) bool {
+	for _, info := range infos {
+		if info.Emit != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// generateEmitFunc emits the func(T) passed as emit parameter when dispatching
+// info, sending every event to the subscription of the call.
+func generateEmitFunc(info FuncInfo) *jen.Statement {
+	return jen.Func().Params(jen.Id("event").Add(typeCode(info.Emit.DstField.Type, nil))).Block(
+		jen.Id("agrowsEmit").Call(jen.Id("subscription"), jen.Id("event"), jen.False()),
+	)
+}
+
+// generateSendToClient emits AgrowsSendToClient, the hook the server passes
+// calls of server-to-client functions and events to.
+func generateSendToClient() *jen.Statement {
+	return jen.Comment("AgrowsSendToClient is called with every encoded call of a function running in the").Line().
+		Comment("client and every event, e.g. to send it as a binary WebSocket message. The calls").Line().
+		Comment("fail and events are dropped while it is nil.").Line().
+		Var().Id("AgrowsSendToClient").Func().Params(jen.Id("data").Index().Byte()).Error().Line()
+}
+
+// generateServerEvents emits agrowsEmit, which sends the events of a
+// subscription through AgrowsSendToClient.
+func (opts *Options) generateServerEvents() *jen.Statement {
+	logFailure := func(msg jen.Code) jen.Code {
+		return jen.Id("agrowsLog").Call(jen.Lit("error"), msg)
+	}
+
+	return jen.Comment("agrowsEmit sends event to the subscription of a call. With done, it tells the").Line().
+		Comment("client that the call returned and no further events follow instead. Calls").Line().
+		Comment("without a subscription drop their events.").Line().
+		Func().Id("agrowsEmit").Params(
+		jen.Id("subscription").Any(),
+		jen.Id("event").Any(),
+		jen.Id("done").Bool(),
+	).Block(
+		jen.If(jen.Id("subscription").Op("==").Nil()).Block(
+			jen.Return(),
+		),
+		jen.If(jen.Id("AgrowsSendToClient").Op("==").Nil()).Block(
+			logFailure(jen.Lit("dropping event: AgrowsSendToClient is not set")),
+			jen.Return(),
+		),
+		jen.Id("eventArgs").Op(":=").Map(jen.String()).Any().Values(jen.Dict{
+			jen.Lit("subscription"): jen.Id("subscription"),
+		}),
+		jen.If(jen.Id("done")).Block(
+			jen.Id("eventArgs").Index(jen.Lit("done")).Op("=").True(),
+		).Else().Block(
+			jen.Id("eventArgs").Index(jen.Lit("event")).Op("=").Id("event"),
+		),
+		jen.List(jen.Id("data"), jen.Err()).Op(":=").Qual(opts.ProtocolPath, "EncodeFunctionCall").Call(jen.Lit(eventFunction), opts.generateProtocolOptions(), jen.Id("eventArgs")),
+		jen.If(jen.Err().Op("!=").Nil()).Block(
+			logFailure(jen.Lit("failed to encode event: ").Op("+").Err().Dot("Error").Call()),
+			jen.Return(),
+		),
+		jen.If(jen.Err().Op(":=").Id("AgrowsSendToClient").Call(jen.Id("data")), jen.Err().Op("!=").Nil()).Block(
+			logFailure(jen.Lit("failed to send event: ").Op("+").Err().Dot("Error").Call()),
+		),
+	).Line()
+}
+
+// generateClientSubscribe emits the start of a client function with an emit
+// parameter, registering emit for the events of the call.
+func generateClientSubscribe(info FuncInfo) *jen.Statement {
+	emitName := paramIdent(info, info.Emit.DstField.Names[0].Name)
+	return jen.Id("subscription").Op(":=").Id("agrowsSubscribe").Call(
+		jen.Func().Params(jen.Id("event").Any()).Error().Block(
+			jen.Var().Id("decoded").Add(typeCode(info.Emit.DstField.Type, nil)),
+			jen.If(jen.Err().Op(":=").Id("agrowsDecodeEvent").Call(jen.Id("event"), jen.Op("&").Id("decoded")), jen.Err().Op("!=").Nil()).Block(
+				jen.Return(jen.Err()),
+			),
+			jen.Id(emitName).Call(jen.Id("decoded")),
+			jen.Return(jen.Nil()),
+		),
+	)
+}
+
+// generateWrapperEmit emits the emit func(T) of a wrapper, passing every event
+// to the JavaScript callback at index of its arguments.
+func generateWrapperEmit(g *jen.Group, info FuncInfo, index int) {
+	emitName := info.Emit.DstField.Names[0].Name
+	g.If(jen.Id("p").Index(jen.Lit(index)).Dot("Type").Call().Op("!=").Qual("syscall/js", "TypeFunction")).Block(
+		jen.Return(generateJsGlobalError(jen.Lit(fmt.Sprintf("parameter '%s' must be a function", emitName)))),
+	)
+	g.Id(paramIdent(info, emitName)).Op(":=").Func().Params(jen.Id("event").Add(typeCode(info.Emit.DstField.Type, nil))).Block(
+		jen.List(jen.Id("encoded"), jen.Err()).Op(":=").Qual("encoding/json", "Marshal").Call(jen.Id("event")),
+		jen.If(jen.Err().Op("!=").Nil()).Block(
+			jen.Id("agrowsLog").Call(jen.Lit("error"), jen.Lit(fmt.Sprintf("%s: failed to encode event: ", info.OriginalIdentifier.Name)).Op("+").Err().Dot("Error").Call()),
+			jen.Return(),
+		),
+		jen.Id("p").Index(jen.Lit(index)).Dot("Invoke").Call(jen.Qual("syscall/js", "Global").Call().Dot("Get").Call(jen.Lit("JSON")).Dot("Call").Call(jen.Lit("parse"), jen.String().Call(jen.Id("encoded")))),
+	)
+}
+
+// generateClientEvents emits the subscriptions of the client and
+// agrowsHandleEvent, which passes the events received from the server to them.
+func (opts *Options) generateClientEvents() *jen.Statement {
+	code := jen.Var().Defs(
+		jen.Id("agrowsSubscriptionsMutex").Qual("sync", "Mutex"),
+		jen.Id("agrowsSubscriptions").Op("=").Map(jen.String()).Func().Params(jen.Id("event").Any()).Error().Values(),
+	).Line().Line()
+
+	code.Comment("agrowsSubscribe registers handler for the events of a call and returns the ID").Line().
+		Comment("of its subscription. IDs are random, so a server may send events to all").Line().
+		Comment("clients and each drops those of other clients.").Line().
+		Func().Id("agrowsSubscribe").Params(jen.Id("handler").Func().Params(jen.Id("event").Any()).Error()).String().Block(
+		jen.Id("id").Op(":=").Make(jen.Index().Byte(), jen.Lit(16)),
+		jen.Qual("crypto/rand", "Read").Call(jen.Id("id")),
+		jen.Id("subscription").Op(":=").Qual("encoding/hex", "EncodeToString").Call(jen.Id("id")),
+		jen.Id("agrowsSubscriptionsMutex").Dot("Lock").Call(),
+		jen.Defer().Id("agrowsSubscriptionsMutex").Dot("Unlock").Call(),
+		jen.Id("agrowsSubscriptions").Index(jen.Id("subscription")).Op("=").Id("handler"),
+		jen.Return(jen.Id("subscription")),
+	).Line().Line()
+
+	code.Func().Id("agrowsUnsubscribe").Params(jen.Id("subscription").String()).Block(
+		jen.Id("agrowsSubscriptionsMutex").Dot("Lock").Call(),
+		jen.Defer().Id("agrowsSubscriptionsMutex").Dot("Unlock").Call(),
+		jen.Delete(jen.Id("agrowsSubscriptions"), jen.Id("subscription")),
+	).Line().Line()
+
+	code.Comment("agrowsHandleEvent passes an event received from the server to its subscription,").Line().
+		Comment("dropping it if the subscription is unknown. The last event of a call only").Line().
+		Comment("ends its subscription.").Line().
+		Func().Id("agrowsHandleEvent").Params(
+		jen.Id("args").Map(jen.String()).Qual(opts.ProtocolPath, "Argument"),
+	).Error().Block(
+		jen.List(jen.Id("subscription"), jen.Id("_")).Op(":=").Id("args").Index(jen.Lit("subscription")).Dot("Value").Assert(jen.String()),
+		jen.List(jen.Id("_"), jen.Id("done")).Op(":=").Id("args").Index(jen.Lit("done")),
+		jen.Id("agrowsSubscriptionsMutex").Dot("Lock").Call(),
+		jen.List(jen.Id("handler"), jen.Id("ok")).Op(":=").Id("agrowsSubscriptions").Index(jen.Id("subscription")),
+		jen.If(jen.Id("done")).Block(
+			jen.Delete(jen.Id("agrowsSubscriptions"), jen.Id("subscription")),
+		),
+		jen.Id("agrowsSubscriptionsMutex").Dot("Unlock").Call(),
+		jen.If(jen.Op("!").Id("ok")).Block(
+			jen.Id("agrowsLog").Call(jen.Lit("debug"), jen.Qual("fmt", "Sprintf").Call(jen.Lit("dropping event of unknown subscription '%s'"), jen.Id("subscription"))),
+			jen.Return(jen.Nil()),
+		),
+		jen.If(jen.Id("done")).Block(
+			jen.Return(jen.Nil()),
+		),
+		jen.Return(jen.Id("handler").Call(jen.Id("args").Index(jen.Lit("event")).Dot("Value"))),
+	).Line().Line()
+
+	code.Comment("agrowsDecodeEvent converts a decoded event into target, the type of the emit").Line().
+		Comment("parameter.").Line().
+		Func().Id("agrowsDecodeEvent").Params(jen.Id("event").Any(), jen.Id("target").Any()).Error().Block(
+		jen.List(jen.Id("data"), jen.Err()).Op(":=").Qual("encoding/json", "Marshal").Call(jen.Id("event")),
+		jen.If(jen.Err().Op("!=").Nil()).Block(
+			jen.Return(jen.Qual("fmt", "Errorf").Call(jen.Lit("failed to encode event: %w"), jen.Err())),
+		),
+		jen.If(jen.Err().Op(":=").Qual("encoding/json", "Unmarshal").Call(jen.Id("data"), jen.Id("target")), jen.Err().Op("!=").Nil()).Block(
+			jen.Return(jen.Qual("fmt", "Errorf").Call(jen.Lit("failed to decode event: %w"), jen.Err())),
+		),
+		jen.Return(jen.Nil()),
+	).Line()
+
+	return code
+}
@@ -0,0 +1,119 @@
+package gen
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// subscribeSource has a function emitting events.
+const subscribeSource = `package api
+
+type Tick struct {
+	N int
+}
+
+func Count(to int, emit func(Tick)) string {
+	for i := 1; i <= to; i++ {
+		emit(Tick{N: i})
+	}
+	return "counted"
+}
+`
+
+// subscribeServerTest checks the events the generated server of
+// subscribeSource sends to the subscription of a call.
+const subscribeServerTest = `package api
+
+import (
+	"testing"
+
+	"github.com/codeupdateandmodificationsystem/protocol"
+)
+
+func TestCount(t *testing.T) {
+	var events []map[string]protocol.Argument
+	AgrowsSendToClient = func(data []byte) error {
+		functionName, args, err := protocol.DecodeFunctionCall(data, protocol.Options())
+		if err != nil || functionName != "agrows_event" {
+			t.Errorf("sent %s, %v, want an agrows_event", functionName, err)
+		}
+		events = append(events, args)
+		return nil
+	}
+
+	data, err := protocol.EncodeFunctionCall("Count", protocol.Options(), map[string]any{"to": 2, "agrowsSubscription": "s1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := AgrowsReceive(data); result != "counted" || err != nil {
+		t.Fatalf("Count returned %q, %v", result, err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 2 and the end of the subscription", len(events))
+	}
+	for i, args := range events[:2] {
+		event, _ := args["event"].Value.(map[string]any)
+		if args["subscription"].Value != "s1" || event["N"] != float64(i+1) {
+			t.Errorf("got event %v, want N %d of s1", args, i+1)
+		}
+	}
+	if _, done := events[2]["done"]; !done || events[2]["subscription"].Value != "s1" {
+		t.Errorf("got last event %v, want the end of s1", events[2])
+	}
+}
+`
+
+// subscribeClientTest passes events to a subscription of the generated client
+// of subscribeSource.
+const subscribeClientTest = `//go:build js && wasm && client
+
+package main
+
+import (
+	"testing"
+
+	"github.com/codeupdateandmodificationsystem/protocol"
+)
+
+func receive(t *testing.T, args map[string]any) {
+	t.Helper()
+	data, err := protocol.EncodeFunctionCall("agrows_event", protocol.Options(), args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := AgrowsReceive(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	var ticks []Tick
+	subscription := agrowsSubscribe(func(event any) error {
+		var tick Tick
+		if err := agrowsDecodeEvent(event, &tick); err != nil {
+			return err
+		}
+		ticks = append(ticks, tick)
+		return nil
+	})
+
+	receive(t, map[string]any{"subscription": subscription, "event": map[string]any{"N": 1}})
+	receive(t, map[string]any{"subscription": "unknown", "event": map[string]any{"N": 2}})
+	receive(t, map[string]any{"subscription": subscription, "done": true})
+	receive(t, map[string]any{"subscription": subscription, "event": map[string]any{"N": 3}})
+
+	if len(ticks) != 1 || ticks[0].N != 1 {
+		t.Errorf("got ticks %v, want only the one before the end of the subscription", ticks)
+	}
+}
+`
+
+func TestSubscriptions(t *testing.T) {
+	dir := newTestModule(t)
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api.go"), generate(t, subscribeSource, Options{}))
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api_test.go"), subscribeServerTest)
+	writeFile(t, filepath.Join(dir, "client", "agrows_client_api.go"), generate(t, subscribeSource, Options{Mode: CLIENT}))
+	writeFile(t, filepath.Join(dir, "client", "agrows_client_api_test.go"), subscribeClientTest)
+	mustRunGo(t, dir, nil, "test", "./server")
+	mustRunGo(t, dir, wasmEnv(t, dir), "test", "-tags", "client", "./client")
+}
@@ -0,0 +1,30 @@
+package api
+
+import "fmt"
+
+type User struct {
+	Name string
+	Age  int
+}
+
+// Greet greets user by name.
+func Greet(user User) (string, error) {
+	if user.Name == "" {
+		return "", fmt.Errorf("user without name")
+	}
+	return fmt.Sprintf("hello %s", user.Name), nil
+}
+
+func Add(a, b int) int {
+	return a + b
+}
+
+func Reset() error {
+	return nil
+}
+
+func Ping() {}
+
+func helper() string {
+	return "not exposed"
+}
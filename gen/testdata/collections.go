@@ -0,0 +1,36 @@
+package api
+
+type Limits struct {
+	Max   int
+	Ratio float64
+}
+
+type Settings struct {
+	Enabled bool
+	Limits  Limits
+}
+
+type User struct {
+	Name string
+	Age  int
+}
+
+func Import(users []User) (int, error) {
+	return len(users), nil
+}
+
+func Configure(settings map[string]Settings) error {
+	return nil
+}
+
+func Tags() []string {
+	return []string{"go", "wasm"}
+}
+
+func Counts() (map[string]int, error) {
+	return map[string]int{"users": 2}, nil
+}
+
+func Find(id int) (*User, error) {
+	return &User{Name: "Ada", Age: 36}, nil
+}
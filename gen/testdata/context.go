@@ -0,0 +1,23 @@
+package api
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// Delete only returns an error.
+func Delete(ctx context.Context, id int) error {
+	if id < 0 {
+		return ErrNotFound
+	}
+	return ctx.Err()
+}
+
+// Lookup takes a context and returns a result.
+//
+//agrows:timeout 2s
+func Lookup(ctx context.Context, key string) (string, error) {
+	return key, ctx.Err()
+}
@@ -0,0 +1,3 @@
+module github.com/codeupdateandmodificationsystem/protocol
+
+go 1.22
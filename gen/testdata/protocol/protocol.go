@@ -0,0 +1,59 @@
+// Package protocol stands in for the agrows protocol package in the tests
+// compiling and running generated code. It has the API the generated code
+// uses and encodes calls as JSON, so arguments are decoded like by the real
+// protocol: numbers as float64, structs as maps.
+package protocol
+
+import "encoding/json"
+
+// Argument is an argument of a decoded call.
+type Argument struct {
+	Value any
+}
+
+// Opts are the options of encoding and decoding calls.
+type Opts struct {
+	compress bool
+}
+
+// Option sets an option of Opts.
+type Option func(*Opts)
+
+// Options returns the Opts set by options.
+func Options(options ...Option) Opts {
+	var opts Opts
+	for _, option := range options {
+		option(&opts)
+	}
+	return opts
+}
+
+// Compression enables compression, which the stand-in ignores.
+func Compression(compress bool) Option {
+	return func(opts *Opts) {
+		opts.compress = compress
+	}
+}
+
+type call struct {
+	Name string         `json:"name"`
+	Args map[string]any `json:"args"`
+}
+
+// EncodeFunctionCall encodes a call of the function name with args.
+func EncodeFunctionCall(name string, _ Opts, args map[string]any) ([]byte, error) {
+	return json.Marshal(call{Name: name, Args: args})
+}
+
+// DecodeFunctionCall decodes a call encoded by EncodeFunctionCall.
+func DecodeFunctionCall(data []byte, _ Opts) (string, map[string]Argument, error) {
+	var c call
+	if err := json.Unmarshal(data, &c); err != nil {
+		return "", nil, err
+	}
+	args := make(map[string]Argument, len(c.Args))
+	for name, value := range c.Args {
+		args[name] = Argument{Value: value}
+	}
+	return c.Name, args, nil
+}
@@ -0,0 +1,124 @@
+package gen
+
+import (
+	"github.com/dave/dst"
+	"github.com/dave/jennifer/jen"
+)
+
+// zeroArgument emits the zero value of a parameter the way a client sends it:
+// structs and maps as objects, slices as arrays.
+func (opts *Options) zeroArgument(paramInfo *ParamReflectInfo) jen.Code {
+	if paramInfo.IsStruct {
+		return jen.Map(jen.String()).Any().Values()
+	}
+	switch t := paramInfo.DstField.Type.(type) {
+	case *dst.ArrayType:
+		// binary encodings carry bytes as such, not as an array of numbers
+		if opts.binaryCalls() && typeString(t) == "[]byte" {
+			return jen.Index().Byte().Values()
+		}
+		return jen.Index().Any().Values()
+	case *dst.MapType:
+		return jen.Map(jen.String()).Any().Values()
+	case *dst.Ident:
+		switch t.Name {
+		case "string":
+			return jen.Lit("")
+		case "bool":
+			return jen.False()
+		case "int":
+			return jen.Lit(0)
+		}
+		if _, ok := jsonSchemaScalarTypes[t.Name]; ok {
+			return jen.Id(t.Name).Call(jen.Lit(0))
+		}
+	}
+	return jen.Op("*").New(typeCode(paramInfo.DstField.Type, nil))
+}
+
+// zeroArguments emits the params of a call of info with the zero value of
+// every parameter.
+func (opts *Options) zeroArguments(info FuncInfo) jen.Code {
+	return jen.Map(jen.String()).Any().ValuesFunc(func(g *jen.Group) {
+		for _, paramInfo := range info.Params {
+			g.Line().Lit(paramInfo.DstField.Names[0].Name).Op(":").Add(opts.zeroArgument(paramInfo))
+		}
+		if len(info.Params) > 0 {
+			g.Line()
+		}
+	})
+}
+
+// generateReceiveArgs declares the arguments AgrowsReceive takes besides the
+// data, tb names the *testing.T or *testing.B in scope.
+func (opts *Options) generateReceiveArgs(g *jen.Group, tb string) {
+	if opts.Trace == "otel" {
+		g.Id("ctx").Op(":=").Qual("context", "Background").Call()
+	}
+	if opts.rateLimit > 0 {
+		g.Id("clientID").Op(":=").Id(tb).Dot("Name").Call()
+	}
+	if opts.Auth == "jwt" {
+		g.Comment("replace with a valid token to get past --auth")
+		g.Id("token").Op(":=").Lit("")
+	}
+}
+
+// generateTestHelpers emits agrowsMakeCall and a TestAgrowsReceive_<Func> per
+// function, calling it through AgrowsReceive with zero values. The tests only
+// fail if the call was not dispatched, since the function may reject zero
+// values or panic, which the server reports as an error of the call.
+func (opts *Options) generateTestHelpers(infos []FuncInfo) *jen.Statement {
+	code := jen.Comment("agrowsMakeCall encodes a call of funcName with params and passes it to").Line().
+		Commentf("%s, failing the test if the call can not be encoded.", opts.ReceiverName).Line().
+		Func().Id("agrowsMakeCall").Params(
+		jen.Id("t").Op("*").Qual("testing", "T"),
+		jen.Id("funcName").String(),
+		jen.Id("params").Map(jen.String()).Any(),
+	).Params(jen.String(), jen.Error()).BlockFunc(func(g *jen.Group) {
+		g.Id("t").Dot("Helper").Call()
+		g.List(jen.Id("data"), jen.Err()).Op(":=").Add(opts.generateEncodeCall(jen.Id("funcName"), jen.Id("params")))
+		g.If(jen.Err().Op("!=").Nil()).Block(
+			jen.Id("t").Dot("Fatalf").Call(jen.Lit("failed to encode call of %s: %v"), jen.Id("funcName"), jen.Err()),
+		)
+		opts.generateReceiveArgs(g, "t")
+		g.Return(opts.generateReceiveCall(jen.Id("data")))
+	}).Line().Line().
+		Comment("agrowsCheckDispatched fails the test if err of a call of funcName means that").Line().
+		Comment("the call did not reach the function: it could not be decoded, its arguments").Line().
+		Comment("did not fit the parameters or it was not dispatched. Other errors are those").Line().
+		Comment("of the function, e.g. rejecting zero values, and are only logged.").Line().
+		Func().Id("agrowsCheckDispatched").Params(
+		jen.Id("t").Op("*").Qual("testing", "T"),
+		jen.Id("funcName").String(),
+		jen.Err().Error(),
+	).Block(
+		jen.Id("t").Dot("Helper").Call(),
+		jen.If(jen.Err().Op("==").Nil()).Block(jen.Return()),
+		jen.For(jen.List(jen.Id("_"), jen.Id("failure")).Op(":=").Range().Index().String().Values(
+			jen.Lit("failed to decode"),
+			jen.Lit("is not in the received arguments"),
+			jen.Lit("unknown function"),
+		)).Block(
+			jen.If(jen.Qual("strings", "Contains").Call(jen.Err().Dot("Error").Call(), jen.Id("failure"))).Block(
+				jen.Id("t").Dot("Fatalf").Call(jen.Lit("call of %s was not dispatched: %v"), jen.Id("funcName"), jen.Err()),
+			),
+		),
+		jen.Id("t").Dot("Logf").Call(jen.Lit("%s returned an error for zero values: %v"), jen.Id("funcName"), jen.Err()),
+	).Line().Line()
+
+	for _, info := range infos {
+		name := info.OriginalIdentifier.Name
+		code.Func().Id("TestAgrowsReceive_"+name).Params(jen.Id("t").Op("*").Qual("testing", "T")).Block(
+			jen.Comment("TODO: call with realistic arguments and check the result"),
+			jen.List(jen.Id("_"), jen.Err()).Op(":=").Id("agrowsMakeCall").Call(
+				jen.Id("t"),
+				jen.Id(funcNameConstant(info)),
+				opts.zeroArguments(info),
+			),
+			jen.Id("agrowsCheckDispatched").Call(jen.Id("t"), jen.Id(funcNameConstant(info)), jen.Err()),
+		).Line().Line()
+	}
+
+	return code
+}
@@ -0,0 +1,88 @@
+package gen
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// callsSource has functions that panic or reject zero values.
+const callsSource = `package api
+
+type User struct {
+	Name string
+	Age  int
+}
+
+type Limits struct {
+	Max   int
+	Ratio float64
+}
+
+type Settings struct {
+	Enabled bool
+	Limits  Limits
+}
+
+type callError string
+
+func (e callError) Error() string { return string(e) }
+
+// Explode panics, which the server recovers from.
+func Explode(reason string) {
+	panic(reason)
+}
+
+// Import fails for zero values, as the function itself rejects them.
+func Import(users []User) (string, error) {
+	if len(users) != 2 {
+		return "", callError("expected 2 users")
+	}
+	return "imported " + users[0].Name + " and " + users[1].Name, nil
+}
+
+func Delete(id int) error {
+	if id < 0 {
+		return callError("invalid id")
+	}
+	return nil
+}
+
+func NamedReturns(ratio float64) (text string, err error) {
+	return
+}
+
+func Configure(settings map[string]Settings) error {
+	for name, s := range settings {
+		if s.Enabled && s.Limits.Max <= 0 {
+			return callError(name + ": enabled without a limit")
+		}
+	}
+	return nil
+}
+
+func Greet(user User, greeting string) string {
+	return greeting + " " + user.Name
+}
+`
+
+// callsFunctions are the functions of callsSource.
+var callsFunctions = []string{"Explode", "Import", "Delete", "NamedReturns", "Configure", "Greet"}
+
+func TestTestHelpers(t *testing.T) {
+	dir := newTestModule(t)
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_calls.go"), generate(t, callsSource, Options{}))
+	writeFile(t, filepath.Join(dir, "server", "agrows_test_helpers_test.go"), generate(t, callsSource, Options{Mode: TESTHELPERS}))
+	out := mustRunGo(t, dir, nil, "test", "-v", "./server")
+	for _, name := range callsFunctions {
+		if !strings.Contains(out, "--- PASS: TestAgrowsReceive_"+name) {
+			t.Errorf("TestAgrowsReceive_%s did not pass:\n%s", name, out)
+		}
+	}
+	// the errors of the functions themselves are logged
+	for _, want := range []string{"Explode returned an error for zero values", "expected 2 users"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not log %q:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package gen
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/dave/jennifer/jen"
+)
+
+// testStubsFileName returns the file --test-stubs writes next to the server
+// output generated from the input fileName.
+func testStubsFileName(fileName string) string {
+	return fmt.Sprintf("agrows_server_%s_test.go", strings.TrimSuffix(filepath.Base(fileName), ".go"))
+}
+
+// testCase emits a case of a test table with one field per line, in the
+// given order.
+func testCase(fields ...jen.Code) *jen.Statement {
+	return jen.ValuesFunc(func(g *jen.Group) {
+		for _, field := range fields {
+			g.Line().Add(field)
+		}
+		g.Line()
+	})
+}
+
+// generateTestStubs emits a table-driven TestAgrows<Func> per function with a
+// case of zero values to be extended by the user, and the cases of the error
+// paths of AgrowsReceive itself.
+func (opts *Options) generateTestStubs(infos []FuncInfo) *jen.Statement {
+	code := jen.Comment("agrowsTestCall encodes a call of funcName with params and passes it to").Line().
+		Commentf("%s, failing the test if the call can not be encoded.", opts.ReceiverName).Line().
+		Func().Id("agrowsTestCall").Params(
+		jen.Id("t").Op("*").Qual("testing", "T"),
+		jen.Id("funcName").String(),
+		jen.Id("params").Map(jen.String()).Any(),
+	).Params(jen.String(), jen.Error()).BlockFunc(func(g *jen.Group) {
+		g.Id("t").Dot("Helper").Call()
+		g.List(jen.Id("data"), jen.Err()).Op(":=").Add(opts.generateEncodeCall(jen.Id("funcName"), jen.Id("params")))
+		g.If(jen.Err().Op("!=").Nil()).Block(
+			jen.Id("t").Dot("Fatalf").Call(jen.Lit("failed to encode call of %s: %v"), jen.Id("funcName"), jen.Err()),
+		)
+		opts.generateReceiveArgs(g, "t")
+		g.Return(opts.generateReceiveCall(jen.Id("data")))
+	}).Line().Line().
+		Commentf("agrowsIsDecodeError reports whether %s failed to decode the call or", opts.ReceiverName).Line().
+		Comment("its arguments, as opposed to an error of the called function.").Line().
+		Func().Id("agrowsIsDecodeError").Params(jen.Err().Error()).Bool().Block(
+		jen.Return(jen.Err().Op("!=").Nil().Op("&&").Parens(
+			jen.Qual("strings", "Contains").Call(jen.Err().Dot("Error").Call(), jen.Lit("failed to decode")).Op("||").
+				Qual("strings", "Contains").Call(jen.Err().Dot("Error").Call(), jen.Lit("is not in the received arguments")),
+		)),
+	).Line().Line()
+
+	for _, info := range infos {
+		name := info.OriginalIdentifier.Name
+		code.Func().Id("TestAgrows"+name).Params(jen.Id("t").Op("*").Qual("testing", "T")).Block(
+			jen.Comment("TODO: add cases with realistic arguments and their results"),
+			jen.Id("tests").Op(":=").Index().Struct(
+				jen.Id("name").String(),
+				jen.Id("params").Map(jen.String()).Any(),
+				jen.Comment("want is the expected result, an empty want only checks the decoding"),
+				jen.Id("want").String(),
+				jen.Id("wantErr").Bool(),
+			).Values(
+				testCase(
+					jen.Id("name").Op(":").Lit("zero values"),
+					jen.Id("params").Op(":").Add(opts.zeroArguments(info)),
+				),
+			),
+			jen.For(jen.List(jen.Id("_"), jen.Id("tt")).Op(":=").Range().Id("tests")).Block(
+				jen.Id("t").Dot("Run").Call(jen.Id("tt").Dot("name"), jen.Func().Params(jen.Id("t").Op("*").Qual("testing", "T")).Block(
+					jen.List(jen.Id("got"), jen.Err()).Op(":=").Id("agrowsTestCall").Call(jen.Id("t"), jen.Id(funcNameConstant(info)), jen.Id("tt").Dot("params")),
+					jen.Switch().Block(
+						jen.Case(jen.Id("tt").Dot("wantErr")).Block(
+							jen.If(jen.Err().Op("==").Nil()).Block(
+								jen.Id("t").Dot("Fatalf").Call(jen.Lit(fmt.Sprintf("%s: expected an error, got %%q", name)), jen.Id("got")),
+							),
+						),
+						jen.Case(jen.Id("agrowsIsDecodeError").Call(jen.Err())).Block(
+							jen.Id("t").Dot("Fatalf").Call(jen.Lit(fmt.Sprintf("%s: %%v", name)), jen.Err()),
+						),
+						jen.Case(jen.Id("tt").Dot("want").Op("!=").Lit("")).Block(
+							jen.If(jen.Err().Op("!=").Nil()).Block(
+								jen.Id("t").Dot("Fatalf").Call(jen.Lit(fmt.Sprintf("%s failed: %%v", name)), jen.Err()),
+							),
+							jen.If(jen.Id("got").Op("!=").Id("tt").Dot("want")).Block(
+								jen.Id("t").Dot("Errorf").Call(jen.Lit(fmt.Sprintf("%s = %%q, want %%q", name)), jen.Id("got"), jen.Id("tt").Dot("want")),
+							),
+						),
+						jen.Case(jen.Err().Op("!=").Nil()).Block(
+							jen.Comment("the function was reached, but may reject the arguments or panic"),
+							jen.Id("t").Dot("Logf").Call(jen.Lit(fmt.Sprintf("%s returned an error: %%v", name)), jen.Err()),
+						),
+					),
+				)),
+			),
+		).Line().Line()
+	}
+
+	code.Func().Id("TestAgrowsReceiveErrorPaths").Params(jen.Id("t").Op("*").Qual("testing", "T")).Block(
+		jen.Id("tests").Op(":=").Index().Struct(
+			jen.Id("name").String(),
+			jen.Id("funcName").String(),
+			jen.Id("params").Map(jen.String()).Any(),
+			jen.Id("wantErr").String(),
+		).ValuesFunc(func(g *jen.Group) {
+			g.Add(testCase(
+				jen.Id("name").Op(":").Lit("unknown function"),
+				jen.Id("funcName").Op(":").Lit("agrows_unknown_function"),
+				jen.Id("params").Op(":").Map(jen.String()).Any().Values(),
+				jen.Id("wantErr").Op(":").Lit("unknown function"),
+			))
+			for _, info := range infos {
+				if len(info.Params) == 0 {
+					continue
+				}
+				g.Add(testCase(
+					jen.Id("name").Op(":").Lit("missing argument of "+info.OriginalIdentifier.Name),
+					jen.Id("funcName").Op(":").Id(funcNameConstant(info)),
+					jen.Id("params").Op(":").Map(jen.String()).Any().Values(),
+					jen.Id("wantErr").Op(":").Lit(fmt.Sprintf("parameter '%s' is not in the received arguments", info.Params[0].DstField.Names[0].Name)),
+				))
+			}
+		}),
+		jen.For(jen.List(jen.Id("_"), jen.Id("tt")).Op(":=").Range().Id("tests")).Block(
+			jen.Id("t").Dot("Run").Call(jen.Id("tt").Dot("name"), jen.Func().Params(jen.Id("t").Op("*").Qual("testing", "T")).Block(
+				jen.List(jen.Id("_"), jen.Err()).Op(":=").Id("agrowsTestCall").Call(jen.Id("t"), jen.Id("tt").Dot("funcName"), jen.Id("tt").Dot("params")),
+				jen.If(jen.Err().Op("==").Nil().Op("||").Op("!").Qual("strings", "Contains").Call(jen.Err().Dot("Error").Call(), jen.Id("tt").Dot("wantErr"))).Block(
+					jen.Id("t").Dot("Fatalf").Call(jen.Lit("expected an error containing %q, got %v"), jen.Id("tt").Dot("wantErr"), jen.Err()),
+				),
+			)),
+		),
+	).Line()
+
+	return code
+}
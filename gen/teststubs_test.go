@@ -0,0 +1,35 @@
+package gen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTestStubs(t *testing.T) {
+	dir := newTestModule(t)
+	server := filepath.Join(dir, "server")
+	if err := os.Mkdir(server, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(server, "agrows_server_calls.go"), generate(t, callsSource, Options{FileName: "calls.go", TestStubs: true, OutputDir: server}))
+	if _, err := os.Stat(filepath.Join(server, "agrows_server_calls_test.go")); err != nil {
+		t.Fatal(err)
+	}
+	out := mustRunGo(t, dir, nil, "test", "-v", "./server")
+	for _, name := range callsFunctions {
+		if !strings.Contains(out, "--- PASS: TestAgrows"+name+"/zero_values") {
+			t.Errorf("TestAgrows%s did not pass:\n%s", name, out)
+		}
+	}
+	for _, want := range []string{
+		"--- PASS: TestAgrowsReceiveErrorPaths/unknown_function",
+		"--- PASS: TestAgrowsReceiveErrorPaths/missing_argument_of_Import",
+		"Import returned an error: expected 2 users",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not contain %q:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package gen
+
+import (
+	"fmt"
+
+	"github.com/dave/jennifer/jen"
+)
+
+// generateInitValidation emits the table of the functions the server dispatches
+// to, along with the signatures it calls them with, and an init checking it
+// with agrowsValidateFunctions. A server whose functions changed without agrows
+// being run again thus fails on start instead of on the first call. The table
+// is a variable, so tests can pass agrowsValidateFunctions a tampered copy.
+func (opts *Options) generateInitValidation(infos []FuncInfo) *jen.Statement {
+	return jen.Comment("agrowsFunction is a function the server dispatches to and a nil func of the").Line().
+		Comment("signature the server calls it with.").Line().
+		Type().Id("agrowsFunction").Struct(
+		jen.Id("fn").Any(),
+		jen.Id("signature").Any(),
+	).Line().Line().
+		Comment("agrowsFunctions are the functions of the server by name, checked on init.").Line().
+		Var().Id("agrowsFunctions").Op("=").Map(jen.String()).Id("agrowsFunction").Values(jen.DictFunc(func(d jen.Dict) {
+		for _, info := range infos {
+			name := fmt.Sprintf(opts.FunctionFormat, info.OriginalIdentifier.Name)
+			d[jen.Lit(name)] = jen.Values(jen.Id(name), jen.Parens(sourceSignature(info, nil)).Call(jen.Nil()))
+		}
+	})).Line().Line().
+		Func().Id("init").Params().Block(
+		jen.Id("agrowsValidateFunctions").Call(jen.Id("agrowsFunctions")),
+	).Line().Line().
+		Comment("agrowsValidateFunctions panics if a function of functions is missing or does not").Line().
+		Comment("have the signature the server calls it with, which means agrows was not run again").Line().
+		Comment("after the functions changed.").Line().
+		Func().Id("agrowsValidateFunctions").Params(jen.Id("functions").Map(jen.String()).Id("agrowsFunction")).Block(
+		jen.For(jen.List(jen.Id("name"), jen.Id("function")).Op(":=").Range().Id("functions")).Block(
+			jen.Id("fn").Op(":=").Qual("reflect", "ValueOf").Call(jen.Id("function").Dot("fn")),
+			jen.If(jen.Id("fn").Dot("Kind").Call().Op("!=").Qual("reflect", "Func").Op("||").Id("fn").Dot("IsNil").Call()).Block(
+				jen.Panic(jen.Qual("fmt", "Sprintf").Call(jen.Lit("agrows: function %s is missing, run agrows again"), jen.Id("name"))),
+			),
+			jen.If(
+				jen.Id("signature").Op(":=").Qual("reflect", "TypeOf").Call(jen.Id("function").Dot("signature")),
+				jen.Id("fn").Dot("Type").Call().Op("!=").Id("signature"),
+			).Block(
+				jen.Panic(jen.Qual("fmt", "Sprintf").Call(jen.Lit("agrows: function %s is %s, but the server calls it as %s, run agrows again"), jen.Id("name"), jen.Id("fn").Dot("Type").Call(), jen.Id("signature"))),
+			),
+		),
+	).Line()
+}
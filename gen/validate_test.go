@@ -0,0 +1,50 @@
+package gen
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// validateInitTest runs in the generated server, whose init already checked
+// agrowsFunctions, and passes agrowsValidateFunctions tampered copies.
+const validateInitTest = `package api
+
+import "testing"
+
+func validatePanic(functions map[string]agrowsFunction) (message any) {
+	defer func() { message = recover() }()
+	agrowsValidateFunctions(functions)
+	return nil
+}
+
+func TestValidateFunctions(t *testing.T) {
+	if message := validatePanic(agrowsFunctions); message != nil {
+		t.Fatalf("the generated functions do not validate: %v", message)
+	}
+
+	changed := map[string]agrowsFunction{}
+	for name, function := range agrowsFunctions {
+		changed[name] = function
+	}
+	changed["agrows_Add"] = agrowsFunction{func(a, b int) (int, error) { return a + b, nil }, changed["agrows_Add"].signature}
+	want := "agrows: function agrows_Add is func(int, int) (int, error), but the server calls it as func(int, int) int, run agrows again"
+	if message := validatePanic(changed); message != want {
+		t.Errorf("got panic %v, want %q", message, want)
+	}
+
+	var missing func() error
+	changed = map[string]agrowsFunction{"agrows_Reset": {missing, agrowsFunctions["agrows_Reset"].signature}}
+	want = "agrows: function agrows_Reset is missing, run agrows again"
+	if message := validatePanic(changed); message != want {
+		t.Errorf("got panic %v, want %q", message, want)
+	}
+}
+`
+
+func TestValidateInit(t *testing.T) {
+	dir := newTestModule(t)
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api.go"), generate(t, apiSource, Options{ValidateInit: true}))
+	writeFile(t, filepath.Join(dir, "server", "agrows_server_api_test.go"), validateInitTest)
+	mustRunGo(t, dir, nil, "vet", "./server")
+	mustRunGo(t, dir, nil, "test", "./server")
+}
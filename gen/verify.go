@@ -0,0 +1,143 @@
+package gen
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/dave/dst"
+)
+
+// Mismatch is a difference between the functions of a source file and the
+// functions kept in a server generated from it, as found by Verify.
+type Mismatch struct {
+	// Kind is "added" for a function missing in the generated file, "removed"
+	// for one missing in the source and "changed" for differing signatures.
+	Kind     string
+	Function string
+	// Source and Generated are the signatures in the files, empty if the
+	// function is missing there.
+	Source    string
+	Generated string
+}
+
+func (m Mismatch) String() string {
+	switch m.Kind {
+	case "added":
+		return fmt.Sprintf("added: %s%s is not in the generated file", m.Function, m.Source)
+	case "removed":
+		return fmt.Sprintf("removed: %s%s is not in the source", m.Function, m.Generated)
+	default:
+		return fmt.Sprintf("changed: %s%s was generated as %s%s", m.Function, m.Source, m.Function, m.Generated)
+	}
+}
+
+// Verify compares the RPC functions of the source file with the functions the
+// server generated from it kept, renamed by opts.FunctionFormat, and returns
+// the functions added, removed or changed since. opts.FileName and
+// generatedName are used in positions of errors.
+func Verify(source io.Reader, generated io.Reader, generatedName string, opts Options) ([]Mismatch, error) {
+	if err := opts.check(); err != nil {
+		return nil, err
+	}
+	// the functions left out by --only and --exclude are kept as they are
+
+	sourceTree, dec, err := parseFileToTree(source, opts.FileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse source: %w", err)
+	}
+	// the server leaves out the functions excluded by their build constraint
+	opts.Mode = SERVER
+	if err := filterConstrainedDecls(sourceTree, dec, parseTags(opts)); err != nil {
+		return nil, err
+	}
+	generatedTree, _, err := parseFileToTree(generated, generatedName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse generated file: %w", err)
+	}
+
+	var names []string
+	sourceSignatures := make(map[string]string)
+	for _, decl := range sourceTree.Decls {
+		// the server-to-client functions are replaced by calls of the client
+		if fn, ok := decl.(*dst.FuncDecl); ok && opts.isRPCFunction(fn) && !isServerToClient(fn) {
+			names = append(names, rpcName(fn))
+			sourceSignatures[rpcName(fn)] = signatureString(fn.Type)
+		}
+	}
+
+	generatedSignatures := make(map[string]string)
+	for _, decl := range generatedTree.Decls {
+		fn, ok := decl.(*dst.FuncDecl)
+		if !ok || fn.Recv != nil {
+			continue
+		}
+		name, ok := originalName(fn.Name.Name, opts.FunctionFormat)
+		if !ok {
+			continue
+		}
+		if _, ok := sourceSignatures[name]; !ok {
+			names = append(names, name)
+		}
+		generatedSignatures[name] = signatureString(fn.Type)
+	}
+
+	var mismatches []Mismatch
+	for _, name := range names {
+		sourceSignature, inSource := sourceSignatures[name]
+		generatedSignature, inGenerated := generatedSignatures[name]
+		switch {
+		case !inGenerated:
+			mismatches = append(mismatches, Mismatch{Kind: "added", Function: name, Source: sourceSignature})
+		case !inSource:
+			mismatches = append(mismatches, Mismatch{Kind: "removed", Function: name, Generated: generatedSignature})
+		case sourceSignature != generatedSignature:
+			mismatches = append(mismatches, Mismatch{Kind: "changed", Function: name, Source: sourceSignature, Generated: generatedSignature})
+		}
+	}
+	return mismatches, nil
+}
+
+// originalName returns the name of the RPC function a server renamed to name
+// with functionFormat, if it is one.
+func originalName(name string, functionFormat string) (string, bool) {
+	prefix, suffix, _ := strings.Cut(functionFormat, "%s")
+	name, ok := strings.CutPrefix(name, prefix)
+	if !ok {
+		return "", false
+	}
+	name, ok = strings.CutSuffix(name, suffix)
+	return name, ok && dst.IsExported(name)
+}
+
+// signatureString formats the parameter and result types of a function type,
+// without the parameter names, e.g. "(string, int) (string, error)".
+func signatureString(fnType *dst.FuncType) string {
+	fieldTypes := func(fields *dst.FieldList) []string {
+		var types []string
+		if fields == nil {
+			return types
+		}
+		for _, field := range fields.List {
+			fieldType := typeString(field.Type)
+			if fn, ok := field.Type.(*dst.FuncType); ok {
+				// typeString elides the signature, which matters for emit
+				fieldType = "func" + signatureString(fn)
+			}
+			for range max(len(field.Names), 1) {
+				types = append(types, fieldType)
+			}
+		}
+		return types
+	}
+
+	signature := "(" + strings.Join(fieldTypes(fnType.Params), ", ") + ")"
+	switch results := fieldTypes(fnType.Results); len(results) {
+	case 0:
+	case 1:
+		signature += " " + results[0]
+	default:
+		signature += " (" + strings.Join(results, ", ") + ")"
+	}
+	return signature
+}
@@ -0,0 +1,38 @@
+package gen
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	opts := Options{FileName: "api.go", FunctionFormat: "rpc%s"}
+	server := generate(t, apiSource, opts)
+	mismatches, err := Verify(strings.NewReader(apiSource), bytes.NewReader(server), "agrows_server_api.go", opts)
+	if err != nil || len(mismatches) != 0 {
+		t.Fatalf("got %v, %v for an up to date server", mismatches, err)
+	}
+
+	changed := strings.NewReplacer(
+		"func Add(a, b int) int", "func Add(a, b int64) int",
+		"func Reset() error {\n\treturn nil\n}\n", "func Ping() {}\n",
+	).Replace(apiSource)
+	mismatches, err = Verify(strings.NewReader(changed), bytes.NewReader(server), "agrows_server_api.go", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, mismatch := range mismatches {
+		got = append(got, mismatch.String())
+	}
+	want := []string{
+		"changed: Add(int64, int64) int was generated as Add(int, int) int",
+		"added: Ping() is not in the generated file",
+		"removed: Reset() error is not in the source",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got mismatches %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,148 @@
+package gen
+
+import (
+	"github.com/dave/jennifer/jen"
+)
+
+const websocketPackage = "github.com/gorilla/websocket"
+
+// responseMessageType returns the type of the WebSocket messages carrying
+// responses, text for JSON and binary otherwise.
+func (opts *Options) responseMessageType() string {
+	if opts.jsonResponses() {
+		return "TextMessage"
+	}
+	return "BinaryMessage"
+}
+
+// generateWebSocketHandler emits AgrowsWebSocketHandler, which feeds binary
+// messages from a connection into AgrowsReceive and writes the responses back,
+// and AgrowsServeHTTP, which serves it on an address.
+func (opts *Options) generateWebSocketHandler() *jen.Statement {
+	// with --auth=jwt, every call on a connection is made with the token it was
+	// opened with
+	tokenParam := func(g *jen.Group) {
+		if opts.Auth == "jwt" {
+			g.Id("token").String()
+		}
+	}
+	tokenArg := func(g *jen.Group) {
+		if opts.Auth == "jwt" {
+			g.Id("token")
+		}
+	}
+
+	handler := jen.Comment("AgrowsWebSocketHandler reads binary messages from conn, dispatches them with").Line().
+		Commentf("%s and writes the responses of AgrowsResponses back. Calls without an", opts.ReceiverName).Line().
+		Comment("ID get their result as a text message instead, or one prefixed by \"error: \"").Line().
+		Comment("if they failed. It returns once the connection is closed, a normal closure is").Line().
+		Comment("not reported as an error.").Line().
+		Func().Id("AgrowsWebSocketHandler").ParamsFunc(func(g *jen.Group) {
+		g.Id("conn").Op("*").Qual(websocketPackage, "Conn")
+		tokenParam(g)
+	}).Error().Block(
+		jen.Return(jen.Id("AgrowsWebSocketHandlerWithValue").CallFunc(func(g *jen.Group) {
+			g.Id("conn")
+			g.Nil()
+			tokenArg(g)
+		})),
+	).Line().Line().
+		Comment("AgrowsWebSocketHandlerWithValue is like AgrowsWebSocketHandler, but authorizes every").Line().
+		Comment("call with AgrowsAuthorizeConnection and value first.").Line().
+		Func().Id("AgrowsWebSocketHandlerWithValue").ParamsFunc(func(g *jen.Group) {
+		g.Id("conn").Op("*").Qual(websocketPackage, "Conn")
+		g.Id("value").Any()
+		tokenParam(g)
+	}).Error().BlockFunc(func(g *jen.Group) {
+		g.Defer().Id("conn").Dot("Close").Call()
+		if opts.Trace == "otel" {
+			g.Id("ctx").Op(":=").Qual("context", "Background").Call()
+		}
+		if opts.rateLimit > 0 {
+			g.Comment("every connection is rate limited on its own")
+			g.Id("clientID").Op(":=").Id("conn").Dot("RemoteAddr").Call().Dot("String").Call()
+			g.Defer().Id("AgrowsClearClientLimiter").Call(jen.Id("clientID"))
+		}
+		g.For().Block(
+			jen.List(jen.Id("messageType"), jen.Id("data"), jen.Err()).Op(":=").Id("conn").Dot("ReadMessage").Call(),
+			jen.If(jen.Err().Op("!=").Nil()).Block(
+				jen.If(jen.Qual(websocketPackage, "IsCloseError").Call(jen.Err(), jen.Qual(websocketPackage, "CloseNormalClosure"), jen.Qual(websocketPackage, "CloseGoingAway"))).Block(
+					jen.Return(jen.Nil()),
+				),
+				jen.Return(jen.Err()),
+			),
+			jen.If(jen.Id("messageType").Op("!=").Qual(websocketPackage, "BinaryMessage")).Block(
+				jen.Continue(),
+			),
+			jen.Var().Id("result").String(),
+			jen.Err().Op("=").Id("agrowsAuthorizeConnection").Call(jen.Id("value"), jen.Id("data")),
+			jen.If(jen.Err().Op("==").Nil()).Block(
+				jen.List(jen.Id("result"), jen.Err()).Op("=").Add(opts.generateReceiveCall(jen.Id("data"))),
+			),
+			jen.List(jen.Id("responses"), jen.Id("responseErr")).Op(":=").Id("AgrowsResponses").Call(jen.Id("data"), jen.Id("result"), jen.Err()),
+			jen.If(jen.Id("responseErr").Op("!=").Nil()).Block(
+				jen.Id("agrowsLog").Call(jen.Lit("error"), jen.Lit("failed to encode response: ").Op("+").Id("responseErr").Dot("Error").Call()),
+			).Else().If(jen.Len(jen.Id("responses")).Op(">").Lit(0)).Block(
+				jen.For(jen.List(jen.Id("_"), jen.Id("response")).Op(":=").Range().Id("responses")).Block(
+					jen.If(jen.Err().Op(":=").Id("conn").Dot("WriteMessage").Call(jen.Qual(websocketPackage, opts.responseMessageType()), jen.Id("response")), jen.Err().Op("!=").Nil()).Block(
+						jen.Return(jen.Err()),
+					),
+				),
+				jen.Continue(),
+			),
+			jen.Comment("clients not waiting for responses get the result as text"),
+			jen.If(jen.Err().Op("!=").Nil()).Block(
+				jen.Id("result").Op("=").Lit("error: ").Op("+").Err().Dot("Error").Call(),
+			),
+			jen.If(jen.Err().Op(":=").Id("conn").Dot("WriteMessage").Call(jen.Qual(websocketPackage, "TextMessage"), jen.Index().Byte().Call(jen.Id("result"))), jen.Err().Op("!=").Nil()).Block(
+				jen.Return(jen.Err()),
+			),
+		)
+	}).Line()
+
+	serve := jen.Var().Id("agrowsUpgrader").Op("=").Qual(websocketPackage, "Upgrader").Values().Line().Line().
+		Comment("AgrowsServeHTTP listens on addr and serves AgrowsWebSocketHandler for every").Line().
+		Comment("WebSocket connection upgraded on any path.").Line().
+		Func().Id("AgrowsServeHTTP").Params(jen.Id("addr").String()).Error().BlockFunc(func(g *jen.Group) {
+		g.Id("mux").Op(":=").Qual("net/http", "NewServeMux").Call()
+		g.Id("mux").Dot("HandleFunc").Call(jen.Lit("/"), jen.Func().Params(
+			jen.Id("w").Qual("net/http", "ResponseWriter"),
+			jen.Id("r").Op("*").Qual("net/http", "Request"),
+		).Block(
+			jen.Var().Id("value").Any(),
+			jen.If(jen.Id("AgrowsConnectionValue").Op("!=").Nil()).Block(
+				jen.Var().Err().Error(),
+				jen.If(jen.List(jen.Id("value"), jen.Err()).Op("=").Id("AgrowsConnectionValue").Call(jen.Id("r")), jen.Err().Op("!=").Nil()).Block(
+					jen.Qual("net/http", "Error").Call(jen.Id("w"), jen.Err().Dot("Error").Call(), jen.Qual("net/http", "StatusUnauthorized")),
+					jen.Return(),
+				),
+			),
+			jen.List(jen.Id("conn"), jen.Err()).Op(":=").Id("agrowsUpgrader").Dot("Upgrade").Call(jen.Id("w"), jen.Id("r"), jen.Nil()),
+			jen.If(jen.Err().Op("!=").Nil()).Block(
+				jen.Comment("Upgrade already replied with an HTTP error"),
+				jen.Return(),
+			),
+			jen.Id("_").Op("=").Id("AgrowsWebSocketHandlerWithValue").CallFunc(func(g *jen.Group) {
+				g.Id("conn")
+				g.Id("value")
+				if opts.Auth == "jwt" {
+					g.Id("agrowsRequestToken").Call(jen.Id("r"))
+				}
+			}),
+		))
+		g.Id("server").Op(":=").Op("&").Qual("net/http", "Server").Values(jen.Dict{
+			jen.Id("Addr"):    jen.Id("addr"),
+			jen.Id("Handler"): jen.Id("mux"),
+		})
+		if opts.Shutdown {
+			g.Comment("stop listening once AgrowsShutdown completed")
+			g.Go().Func().Params().Block(
+				jen.Op("<-").Id("agrowsShutdownCh"),
+				jen.Id("server").Dot("Close").Call(),
+			).Call()
+		}
+		g.Return(jen.Id("server").Dot("ListenAndServe").Call())
+	}).Line()
+
+	return jen.Add(handler, serve)
+}
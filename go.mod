@@ -1,20 +1,20 @@
 module github.com/codeupdateandmodificationsystem/agrows
 
-go 1.22.1
-
-toolchain go1.22.5
+go 1.26.0
 
 require (
+	github.com/BurntSushi/toml v1.4.0
 	github.com/dave/dst v0.27.3
 	github.com/dave/jennifer v1.7.0
-	github.com/samber/lo v1.44.0
 	github.com/dikkadev/dnutlogger v0.0.0-20240629195301-09c2f6712250
+	github.com/samber/lo v1.44.0
 	github.com/spf13/pflag v1.0.5
+	golang.org/x/tools v0.50.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
-	golang.org/x/mod v0.17.0 // indirect
-	golang.org/x/sync v0.7.0 // indirect
+	golang.org/x/mod v0.41.0 // indirect
+	golang.org/x/sync v0.23.0 // indirect
 	golang.org/x/text v0.16.0 // indirect
-	golang.org/x/tools v0.21.1-0.20240508182429-e35e4ccd0d2d // indirect
 )
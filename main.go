@@ -0,0 +1,793 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/build"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/codeupdateandmodificationsystem/agrows/gen"
+	log "github.com/dikkadev/dnutlogger"
+	flag "github.com/spf13/pflag"
+)
+
+const (
+	mockFileName        = "agrows_mock.go"
+	testHelpersFileName = "agrows_test_helpers_test.go"
+)
+
+func main() {
+	if err := run(); err != nil {
+		var usage usageError
+		if errors.As(err, &usage) {
+			printUsage(usage.Error())
+		} else {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// usageError is an error in the command line, reported along with the usage.
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+// run runs agrows on the command line. It is the only place deciding about
+// the files written, so nothing is left behind when it fails.
+func run() error {
+	inputParameter := flag.StringArrayP("input", "i", nil, "Input file or directory of a package, repeat for several files of a package (default: $GOFILE when run by go generate, required otherwise)")
+	pkgParameter := flag.String("pkg", "", "Go package to generate from instead of --input, e.g. ./internal/api, loaded with its type information")
+	outputParameter := flag.StringP("output", "o", "", "Output file (default: agrows_<server|client|goclient>_<input_file>, agrows_mock.go for mock, agrows_test_helpers_test.go for test-helpers)")
+	serverOutputParameter := flag.String("server-output", "", "Server output file of the 'both' subcommand (default: agrows_server_<input_file>)")
+	clientOutputParameter := flag.String("client-output", "", "Client output file of the 'both' subcommand (default: agrows_client_<input_file>)")
+	debugParameter := flag.BoolP("dbg", "D", false, "Enable debug logging")
+	protocolParameter := flag.String("protocol", "", "Import path of the protocol package used by the generated code, e.g. a fork with the same API (default: github.com/codeupdateandmodificationsystem/protocol)")
+	shouldCompressParameter := flag.BoolP("compress", "c", false, "If compression should be used in the protocol")
+	serializeParameter := flag.String("serialize", "json", "How the client encodes its calls to the server (json|msgpack|cbor), msgpack and cbor bypass the protocol package")
+	responseFormatParameter := flag.String("response-format", "call", "How the server encodes the responses to the calls of the client (call|json)")
+	logCallsParameter := flag.Bool("log-calls", false, "Log every call and its result with log/slog in the generated server")
+	logArgsParameter := flag.Bool("log-args", false, "Also log the call arguments (may contain PII); implies --log-calls")
+	logLevelParameter := flag.String("log-level", "info", "Minimum level of the generated server logger (debug|info|warn|error)")
+	metricsParameter := flag.String("metrics", "", "Generate call metrics in the server using the given backend (prometheus)")
+	traceParameter := flag.String("trace", "", "Generate tracing in server and client using the given backend (otel)")
+	errorUnwrapParameter := flag.StringSlice("error-unwrap", nil, "Comma-separated Name:code mappings of errors of the input, e.g. ErrNotFound:404, replaced by an AgrowsError with the code when returned by a function in the generated server")
+	httpErrorsParameter := flag.String("http-errors", "", "How server-http maps errors to status codes ('codes' uses the Code of a returned AgrowsError)")
+	serverStructParameter := flag.Bool("server-struct", false, "Generate the server receiver as a Receive method on an AgrowsServer dispatching to a user provided AgrowsHandler")
+	grpcPackageParameter := flag.String("grpc-package", "", "Import path of the directory server-grpc generates the protobuf code in (required for server-grpc)")
+	protoOnlyParameter := flag.Bool("proto-only", false, "Only write the .proto file of server-grpc instead of also running protoc on it")
+	graphqlSchemaOnlyParameter := flag.Bool("graphql-schema-only", false, "Only write the schema of the graphql subcommand, without the Go resolver")
+	emitJSParameter := flag.Bool("emit-js", false, "Also write agrows.js, connecting the page to the server for the WASM client")
+	timeoutParameter := flag.Duration("timeout", 0, "Default timeout of calls dispatched by the generated server, overridable per function with //agrows:timeout (0 disables it)")
+	clientTimeoutParameter := flag.Duration("client-timeout", 0, "Default time the generated WASM client waits for the response of a call before rejecting its Promise (0 disables it)")
+	cacheTTLParameter := flag.Duration("cache-ttl", 0, "Cache successful results in the generated server for the given duration, keyed by function and arguments (0 disables it)")
+	rateLimitParameter := flag.String("rate-limit", "", "Limit the calls every client may make through AgrowsReceive, e.g. 10/s (AgrowsReceive then takes a client ID)")
+	auditLogParameter := flag.String("audit-log", "", "Record every call of the generated server as a JSON line in the given file")
+	authParameter := flag.String("auth", "", "Authenticate calls in the generated server (jwt, AgrowsReceive then takes a token)")
+	authAlgorithmParameter := flag.String("auth-algorithm", "HS256", "Signing method of the tokens accepted by --auth=jwt (HS256|RS256)")
+	healthCheckParameter := flag.Bool("health-check", false, "Answer calls of 'agrows_health' in the generated server with its uptime, active calls, functions and version")
+	statsParameter := flag.Bool("stats", false, "Count the calls, successes, failures and received bytes of every function in the generated server")
+	wrapperFormatParameter := flag.String("wrapper-format", "%sWrapper", "Name of the JavaScript wrappers in the generated client, %s is replaced by the function name")
+	functionFormatParameter := flag.String("function-format", "agrows_%s", "Name the original functions are renamed to in the generated server, %s is replaced by the function name")
+	receiverNameParameter := flag.String("receiver-name", "AgrowsReceive", "Name of the function of the generated server decoding and dispatching the calls, e.g. to generate several servers into one package")
+	packageParameter := flag.String("package", "", "Package of the output (default: the package of the input, main for client)")
+	buildTagsParameter := flag.String("build-tags", "", "Build constraint of the output, e.g. 'linux && amd64' (default: 'js && wasm && client' for client, none otherwise)")
+	noPlusBuildParameter := flag.Bool("no-plus-build", false, "Do not repeat the build constraint of the output as // +build lines for Go before 1.17")
+	parseTagsParameter := flag.StringSlice("parse-tags", nil, "Comma-separated build tags satisfied by //go:build lines above declarations of the input, others are left out (default: js,wasm for client, the GOOS and GOARCH of the toolchain otherwise)")
+	batchParameter := flag.Bool("batch", false, "Support sending several calls in one message (AgrowsBeginBatch/AgrowsFlush in the client)")
+	strictParameter := flag.Bool("strict", false, "Fail instead of warning when the input has no exported functions")
+	allowIgnoredBuildParameter := flag.Bool("allow-ignored-build", false, "Generate even if the build constraint of the input excludes it from the build, e.g. //go:build ignore")
+	allowTestFilesParameter := flag.Bool("allow-test-files", false, "Generate even if the input is a _test.go file or in a _test package")
+	onlyParameter := flag.StringSlice("only", nil, "Comma-separated names or regular expressions of the exported functions exposed as RPC endpoints, the others are kept as they are")
+	excludeParameter := flag.StringSlice("exclude", nil, "Comma-separated names or regular expressions of exported functions not exposed as RPC endpoints, kept as they are")
+	noLintParameter := flag.StringSlice("nolint", nil, "Comma-separated linters silenced by a //nolint comment above every generated function ('all' also silences the whole file)")
+	headerParameter := flag.String("header", "", "File or text replacing the comment at the top of the Go outputs, e.g. a license header (a 'Code generated by agrows. DO NOT EDIT.' line is added unless it contains one)")
+	noHeaderParameter := flag.Bool("no-header", false, "Leave out the comment at the top of the Go outputs, which are then no longer recognized as generated")
+	timestampParameter := flag.Bool("timestamp", false, "Write the date and time of generation into the header, taken from SOURCE_DATE_EPOCH if set (default: none, so the same input yields the same output)")
+	quietParameter := flag.Bool("quiet", false, "Do not log the registered functions in the generated client")
+	shutdownParameter := flag.Bool("shutdown", false, "Generate AgrowsShutdown(ctx), rejecting new calls and waiting for active ones to complete")
+	asyncParameter := flag.Bool("async", false, "Also generate AgrowsReceiveAsync dispatching calls on a bounded worker pool")
+	asyncWorkersParameter := flag.Int("async-workers", 8, "Number of workers of the pool generated by --async")
+	benchmarksParameter := flag.Bool("benchmarks", false, "Also write agrows_bench_test.go with benchmarks of AgrowsReceive next to the server output")
+	testStubsParameter := flag.Bool("test-stubs", false, "Also write agrows_server_<input_file>_test.go with a table-driven test per function next to the server output")
+	harnessParameter := flag.Bool("harness", false, "Also write agrows_harness_test.go with a test per function sending a call encoded like the client's through AgrowsReceive")
+	sharedTypesParameter := flag.Bool("shared-types", false, "Write the types used by the functions to agrows_types.go without build tags next to the output instead of into it, so server and client can share a package")
+	signatureGuardParameter := flag.Bool("signature-guard", false, "Also write agrows_<server|client|goclient>_<input_file>_check.go next to the output, failing to compile once the function signatures drift")
+	validateInitParameter := flag.Bool("validate-init", false, "Generate an init in the server panicking if a function it dispatches to is missing or changed its signature since generation")
+	jsonParameter := flag.Bool("json", false, "Print the report of the 'list' subcommand as JSON")
+	dumpFuncsParameter := flag.Bool("dump-funcs", false, "Print the discovered functions and types as JSON to stdout and exit without generating")
+	emitSchemaParameter := flag.String("emit-schema", "", "Also write a JSON Schema (draft 2020-12) of the functions and their types to the given file ('-' for stdout), no subcommand is needed for only writing it")
+	forceParameter := flag.Bool("force", false, "Overwrite the output even if it exists and was not generated by agrows")
+	dryRunParameter := flag.Bool("dry-run", false, "Run the whole generation but discard the output instead of writing it")
+	warnBreakingParameter := flag.Bool("warn-breaking", false, "Warn about changes of the functions breaking the clients of the server output being replaced")
+	failOnBreakingParameter := flag.Bool("fail-on-breaking", false, "Fail instead of only warning about breaking changes, implies --warn-breaking")
+	configParameter := flag.String("config", "", "Config file (default: agrows.yaml, agrows.yml or agrows.toml in the directory of the input or the working directory, or the closest of their parents)")
+
+	flag.Parse()
+
+	configPath := *configParameter
+	if configPath == "" {
+		var err error
+		configPath, err = discoverConfig(*inputParameter)
+		if err != nil {
+			return err
+		}
+	}
+	var cfg config
+	if configPath != "" {
+		var err error
+		cfg, err = loadConfig(configPath)
+		if err != nil {
+			return err
+		}
+		// the flags given on the command line take precedence
+		if err := cfg.applyFlags(); err != nil {
+			return usageError("Error: " + err.Error())
+		}
+	}
+
+	if *debugParameter {
+		log.SetMinLevel(log.DEBUG)
+		log.Debug("Debug logging enabled")
+	}
+	if configPath != "" {
+		log.Debugf("Using config %s", configPath)
+	}
+
+	opts := gen.Options{
+		Strict:            *strictParameter,
+		AllowIgnoredBuild: *allowIgnoredBuildParameter,
+		AllowTestFiles:    *allowTestFilesParameter,
+		Compress:          *shouldCompressParameter,
+		Serialize:         *serializeParameter,
+		ResponseFormat:    *responseFormatParameter,
+		WrapperFormat:     *wrapperFormatParameter,
+		FunctionFormat:    *functionFormatParameter,
+		ReceiverName:      *receiverNameParameter,
+		PackageName:       *packageParameter,
+		BuildTags:         *buildTagsParameter,
+		NoPlusBuild:       *noPlusBuildParameter,
+		ParseTags:         *parseTagsParameter,
+		Only:              *onlyParameter,
+		Exclude:           *excludeParameter,
+		NoLint:            *noLintParameter,
+		NoHeader:          *noHeaderParameter,
+		Timestamp:         *timestampParameter,
+		Quiet:             *quietParameter,
+		GRPCPackage:       *grpcPackageParameter,
+		ProtoOnly:         *protoOnlyParameter,
+		GraphQLSchemaOnly: *graphqlSchemaOnlyParameter,
+		HTTPErrors:        *httpErrorsParameter,
+		ErrorUnwrap:       *errorUnwrapParameter,
+		ServerStruct:      *serverStructParameter,
+		LogCalls:          *logCallsParameter,
+		LogArgs:           *logArgsParameter,
+		LogLevel:          *logLevelParameter,
+		Metrics:           *metricsParameter,
+		Trace:             *traceParameter,
+		Timeout:           *timeoutParameter,
+		ClientTimeout:     *clientTimeoutParameter,
+		CacheTTL:          *cacheTTLParameter,
+		RateLimit:         *rateLimitParameter,
+		Auth:              *authParameter,
+		AuthAlgorithm:     *authAlgorithmParameter,
+		AuditLog:          *auditLogParameter,
+		HealthCheck:       *healthCheckParameter,
+		Stats:             *statsParameter,
+		Batch:             *batchParameter,
+		Shutdown:          *shutdownParameter,
+		Async:             *asyncParameter,
+		AsyncWorkers:      *asyncWorkersParameter,
+		Benchmarks:        *benchmarksParameter,
+		TestStubs:         *testStubsParameter,
+		Harness:           *harnessParameter,
+		SignatureGuard:    *signatureGuardParameter,
+		ValidateInit:      *validateInitParameter,
+		SharedTypes:       *sharedTypesParameter,
+		EmitJS:            *emitJSParameter,
+		Force:             *forceParameter,
+		ProtocolPath:      *protocolParameter,
+	}
+	cli := cliOptions{
+		dumpFuncs:      *dumpFuncsParameter,
+		json:           *jsonParameter,
+		emitSchema:     *emitSchemaParameter,
+		force:          *forceParameter,
+		dryRun:         *dryRunParameter,
+		warnBreaking:   *warnBreakingParameter || *failOnBreakingParameter,
+		failOnBreaking: *failOnBreakingParameter,
+		serverOutput:   *serverOutputParameter,
+		clientOutput:   *clientOutputParameter,
+		configPath:     configPath,
+	}
+
+	header, err := headerText(*headerParameter)
+	if err != nil {
+		return err
+	}
+	opts.Header = header
+
+	inputs := *inputParameter
+	if *pkgParameter != "" {
+		if len(inputs) > 0 {
+			return usageError("Error: --pkg can not be combined with --input")
+		}
+		files, pkgTypes, err := loadPackage(*pkgParameter)
+		if err != nil {
+			return err
+		}
+		opts.Types = pkgTypes
+		return generateInput(opts, cli, files, *outputParameter, flag.Args())
+	}
+	if len(inputs) == 0 {
+		// go generate runs agrows in the directory of the file with the
+		// directive, whose name it passes in GOFILE
+		if goFile := os.Getenv("GOFILE"); goFile != "" {
+			inputs = []string{goFile}
+			log.Debugf("Using %s of package %s from go generate", goFile, os.Getenv("GOPACKAGE"))
+		}
+	}
+	if len(inputs) > 0 || len(cfg.Inputs) == 0 {
+		return generateInput(opts, cli, inputs, *outputParameter, flag.Args())
+	}
+	if *outputParameter != "" && len(cfg.Inputs) > 1 {
+		return usageError("Error: --output can not be used with several inputs in the config, use their output patterns")
+	}
+	for _, input := range cfg.Inputs {
+		// the subcommand on the command line takes precedence
+		args := flag.Args()
+		if len(args) == 0 && input.Command != "" {
+			args = []string{input.Command}
+		}
+		output := *outputParameter
+		inputCLI := cli
+		switch {
+		case len(args) > 0 && args[0] == "both":
+			if inputCLI.serverOutput == "" {
+				inputCLI.serverOutput = cfg.outputPath(input, "server")
+			}
+			if inputCLI.clientOutput == "" {
+				inputCLI.clientOutput = cfg.outputPath(input, "client")
+			}
+		case output == "" && len(args) > 0:
+			command := args[0]
+			if command == "verify" {
+				// verify reads the output of server
+				command = "server"
+			}
+			output = cfg.outputPath(input, command)
+		}
+		log.Debugf("Generating %s from config", input.Input)
+		if err := generateInput(opts, inputCLI, []string{cfg.path(input.Input)}, output, args); err != nil {
+			return fmt.Errorf("%s: %w", input.Input, err)
+		}
+	}
+	return nil
+}
+
+// cliOptions are the flags of a run deciding what is done with the generated
+// code, as opposed to how it is generated.
+type cliOptions struct {
+	dumpFuncs      bool
+	json           bool
+	emitSchema     string
+	force          bool
+	dryRun         bool
+	warnBreaking   bool
+	failOnBreaking bool
+	// serverOutput and clientOutput are the output files of 'both', "" for
+	// the default ones.
+	serverOutput string
+	clientOutput string
+	// configPath is the config file the flags were read from, "" if there is
+	// none.
+	configPath string
+}
+
+// generateInput runs agrows on an input file, or on the files of a package
+// given as a directory or as several inputs, which are merged into a single
+// output. args are the subcommand and its arguments, outputFile is "" for the
+// default output file.
+func generateInput(opts gen.Options, cli cliOptions, inputs []string, outputFile string, args []string) error {
+	if len(inputs) == 0 {
+		return usageError("Error: --input parameter is required, unless a config file lists the inputs")
+	}
+	files, err := inputFiles(inputs)
+	if err != nil {
+		return err
+	}
+	// input names the package in errors and the default output files after
+	// the file or, for several files, their directory
+	input, inputDir := files[0], filepath.Dir(files[0])
+	inputName := filepath.Base(input)
+	if len(files) > 1 {
+		input = inputDir
+		if base := filepath.Base(input); base == "." || base == ".." {
+			if input, err = filepath.Abs(input); err != nil {
+				return err
+			}
+		}
+		inputName = filepath.Base(input) + ".go"
+	}
+	opts.FileName = input
+	if input == "-" {
+		// names the input in errors and the files named after it
+		opts.FileName = "stdin"
+	}
+	// functions of test files are only compiled into the test binary
+	for _, file := range files {
+		if !opts.AllowTestFiles && strings.HasSuffix(filepath.Base(file), "_test.go") {
+			return fmt.Errorf("input file %s is a test file, whose functions can not be called from non-test code; use --allow-test-files to override", file)
+		}
+	}
+
+	serverCmd := flag.NewFlagSet("server", flag.ExitOnError)
+	clientCmd := flag.NewFlagSet("client", flag.ExitOnError)
+	command := ""
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	if len(args) < 1 && ((!cli.dumpFuncs && cli.emitSchema == "") || cli.dryRun) {
+		return usageError("Error: expected 'server', 'server-ws', 'server-http', 'server-grpc', 'graphql', 'client', 'goclient', 'mock', 'test-helpers', 'verify', 'both' or 'list' subcommand")
+	}
+
+	verify, both, list := false, false, false
+	switch command {
+	case "":
+		// only reachable with --dump-funcs or --emit-schema, which do not generate code
+	case "server":
+		err := serverCmd.Parse(args[1:])
+		if err != nil {
+			return fmt.Errorf("failed to parse 'server' subcommand: %w", err)
+		}
+		opts.Mode = gen.SERVER
+	case "server-ws":
+		err := serverCmd.Parse(args[1:])
+		if err != nil {
+			return fmt.Errorf("failed to parse 'server-ws' subcommand: %w", err)
+		}
+		opts.Mode = gen.SERVER
+		opts.WebSocket = true
+	case "server-http":
+		err := serverCmd.Parse(args[1:])
+		if err != nil {
+			return fmt.Errorf("failed to parse 'server-http' subcommand: %w", err)
+		}
+		opts.Mode = gen.SERVER
+		opts.HTTP = true
+	case "server-grpc":
+		err := serverCmd.Parse(args[1:])
+		if err != nil {
+			return fmt.Errorf("failed to parse 'server-grpc' subcommand: %w", err)
+		}
+		opts.Mode = gen.SERVER
+		opts.GRPC = true
+		if opts.GRPCPackage == "" {
+			return usageError("Error: 'server-grpc' requires --grpc-package")
+		}
+	case "graphql":
+		err := serverCmd.Parse(args[1:])
+		if err != nil {
+			return fmt.Errorf("failed to parse 'graphql' subcommand: %w", err)
+		}
+		opts.Mode = gen.SERVER
+		opts.GraphQL = true
+	case "client":
+		err := clientCmd.Parse(args[1:])
+		if err != nil {
+			return fmt.Errorf("failed to parse 'client' subcommand: %w", err)
+		}
+		opts.Mode = gen.CLIENT
+	case "goclient":
+		err := clientCmd.Parse(args[1:])
+		if err != nil {
+			return fmt.Errorf("failed to parse 'goclient' subcommand: %w", err)
+		}
+		opts.Mode = gen.GOCLIENT
+	case "mock":
+		err := serverCmd.Parse(args[1:])
+		if err != nil {
+			return fmt.Errorf("failed to parse 'mock' subcommand: %w", err)
+		}
+		opts.Mode = gen.MOCK
+	case "test-helpers":
+		err := serverCmd.Parse(args[1:])
+		if err != nil {
+			return fmt.Errorf("failed to parse 'test-helpers' subcommand: %w", err)
+		}
+		opts.Mode = gen.TESTHELPERS
+	case "verify":
+		err := serverCmd.Parse(args[1:])
+		if err != nil {
+			return fmt.Errorf("failed to parse 'verify' subcommand: %w", err)
+		}
+		opts.Mode = gen.SERVER
+		verify = true
+	case "both":
+		err := serverCmd.Parse(args[1:])
+		if err != nil {
+			return fmt.Errorf("failed to parse 'both' subcommand: %w", err)
+		}
+		opts.Mode = gen.SERVER
+		both = true
+	case "list":
+		err := serverCmd.Parse(args[1:])
+		if err != nil {
+			return fmt.Errorf("failed to parse 'list' subcommand: %w", err)
+		}
+		opts.Mode = gen.SERVER
+		list = true
+	default:
+		return usageError(fmt.Sprintf("Error: unknown subcommand '%s'", command))
+	}
+
+	if (cli.serverOutput != "" || cli.clientOutput != "") && !both {
+		return usageError("Error: --server-output and --client-output are only used by 'both'")
+	}
+	if err := opts.Validate(); err != nil {
+		return usageError("Error: " + err.Error())
+	}
+	if input == "-" {
+		// the default output files are named after the input
+		switch {
+		case both && (cli.serverOutput == "" || cli.clientOutput == ""):
+			return usageError("Error: 'both' requires --server-output and --client-output when reading the input from stdin")
+		case !both && !list && command != "" && outputFile == "":
+			return usageError("Error: --output is required when reading the input from stdin")
+		case verify && outputFile == "-":
+			return usageError("Error: 'verify' can not read both the input and the output from stdin")
+		}
+	}
+	if len(files) > 1 && verify {
+		return usageError("Error: 'verify' does not support several input files yet")
+	}
+
+	sources := make([]inputSource, len(files))
+	for i, file := range files {
+		sources[i].name = file
+		if input == "-" {
+			sources[i].name = opts.FileName
+			sources[i].src, err = io.ReadAll(os.Stdin)
+		} else {
+			sources[i].src, err = os.ReadFile(file)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to open input file: %w", err)
+		}
+	}
+
+	if list {
+		// only reports the functions, no file is created or truncated
+		inputData, skipped, err := gen.ListFiles(sourceFiles(sources), opts)
+		if err != nil {
+			return err
+		}
+		return gen.WriteList(os.Stdout, inputData, skipped, cli.configPath, cli.json)
+	}
+
+	if cli.dumpFuncs || cli.emitSchema != "" {
+		inputData, err := gen.ParseFiles(sourceFiles(sources), opts.FileName)
+		if err != nil {
+			return err
+		}
+		if len(inputData.Functions) == 0 && opts.Strict {
+			return fmt.Errorf("no exported functions found in %s", inputData.FileName)
+		}
+		if cli.dumpFuncs {
+			if err := gen.DumpFuncs(inputData, os.Stdout); err != nil {
+				return fmt.Errorf("failed to dump functions: %w", err)
+			}
+			if !cli.dryRun {
+				return nil
+			}
+		}
+		if cli.emitSchema != "" {
+			var schema bytes.Buffer
+			if err := gen.WriteJSONSchema(&schema, inputData); err != nil {
+				return fmt.Errorf("failed to generate JSON Schema: %w", err)
+			}
+			if !cli.dryRun {
+				if err := writeOutput(cli.emitSchema, schema.Bytes(), cli.force); err != nil {
+					return fmt.Errorf("failed to write JSON Schema: %w", err)
+				}
+			}
+			if len(args) < 1 {
+				return nil
+			}
+		}
+	}
+
+	if both {
+		return generateBoth(sources, opts, cli, inputDir, inputName, outputFile)
+	}
+
+	outputDir := inputDir
+	if outputFile == "" {
+		switch {
+		case opts.GraphQLSchemaOnly && opts.GraphQL:
+			outputFile = gen.GraphQLSchemaPath(outputDir)
+		case opts.Mode == gen.MOCK:
+			outputFile = filepath.Join(outputDir, mockFileName)
+		case opts.Mode == gen.TESTHELPERS:
+			outputFile = filepath.Join(outputDir, testHelpersFileName)
+		default:
+			var env string
+			switch opts.Mode {
+			case gen.SERVER:
+				env = "server"
+			case gen.CLIENT:
+				env = "client"
+			case gen.GOCLIENT:
+				env = "goclient"
+			}
+			outputFile = filepath.Join(outputDir, fmt.Sprintf("agrows_%s_%s", env, inputName))
+		}
+	} else if outputFile != "-" {
+		outputDir = filepath.Dir(outputFile)
+	}
+
+	if verify {
+		return verifyOutput(sources[0].src, outputFile, opts)
+	}
+
+	if cli.warnBreaking && opts.Mode == gen.SERVER && !opts.GraphQLSchemaOnly && outputFile != "-" {
+		if err := checkBreaking(sources, outputFile, opts, cli.failOnBreaking); err != nil {
+			return err
+		}
+	}
+
+	if !cli.dryRun {
+		if outputFile != "-" && !cli.force {
+			// fail before the files accompanying the output are written
+			if err := gen.CheckOverwritable(outputFile); err != nil {
+				return fmt.Errorf("failed to create output file: %w", err)
+			}
+		}
+		opts.OutputDir = outputDir
+	}
+
+	// the output is only written once it was generated completely
+	var output bytes.Buffer
+	if err := gen.GenerateFiles(sourceFiles(sources), opts, &output); err != nil {
+		return err
+	}
+
+	if cli.dryRun {
+		what := ""
+		if opts.GraphQLSchemaOnly && opts.GraphQL {
+			what = " of schema"
+		}
+		fmt.Fprintf(os.Stderr, "Dry run: generated %d bytes%s from %s, nothing was written\n", output.Len(), what, opts.FileName)
+		return nil
+	}
+	if err := writeOutput(outputFile, output.Bytes(), cli.force); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+	return nil
+}
+
+// generateBoth generates the server and the client of sources from a single
+// parse for the 'both' subcommand, writing them to cli.serverOutput and
+// cli.clientOutput. The default ones are named after inputName in inputDir.
+func generateBoth(sources []inputSource, opts gen.Options, cli cliOptions, inputDir, inputName, outputFile string) error {
+	if outputFile != "" {
+		return usageError("Error: 'both' writes two files, use --server-output and --client-output instead of --output")
+	}
+
+	// the files accompanying an output are written next to it
+	outputs := []struct {
+		env  string
+		file string
+		dir  string
+	}{
+		{env: "server", file: cli.serverOutput},
+		{env: "client", file: cli.clientOutput},
+	}
+	for i, output := range outputs {
+		output.dir = inputDir
+		if output.file == "" {
+			output.file = filepath.Join(output.dir, fmt.Sprintf("agrows_%s_%s", output.env, inputName))
+		} else if output.file != "-" {
+			output.dir = filepath.Dir(output.file)
+		}
+		outputs[i] = output
+	}
+	server, client := outputs[0], outputs[1]
+
+	if cli.warnBreaking && server.file != "-" {
+		if err := checkBreaking(sources, server.file, opts, cli.failOnBreaking); err != nil {
+			return err
+		}
+	}
+
+	if !cli.dryRun {
+		for _, output := range outputs {
+			if output.file != "-" && !cli.force {
+				// fail before the files accompanying the outputs are written
+				if err := gen.CheckOverwritable(output.file); err != nil {
+					return fmt.Errorf("failed to create output file: %w", err)
+				}
+			}
+		}
+		opts.OutputDir, opts.ClientOutputDir = server.dir, client.dir
+	}
+
+	var serverCode, clientCode bytes.Buffer
+	if err := gen.GenerateServerAndClientFiles(sourceFiles(sources), opts, &serverCode, &clientCode); err != nil {
+		return err
+	}
+
+	if cli.dryRun {
+		fmt.Fprintf(os.Stderr, "Dry run: generated %d bytes of server and %d bytes of client from %s, nothing was written\n", serverCode.Len(), clientCode.Len(), opts.FileName)
+		return nil
+	}
+	if err := writeOutput(server.file, serverCode.Bytes(), cli.force); err != nil {
+		return fmt.Errorf("failed to write server output file: %w", err)
+	}
+	if err := writeOutput(client.file, clientCode.Bytes(), cli.force); err != nil {
+		return fmt.Errorf("failed to write client output file: %w", err)
+	}
+	return nil
+}
+
+// verifyOutput compares the functions of the input src with those of the
+// server generated into outputFile, "-" meaning stdin, printing every mismatch.
+func verifyOutput(src []byte, outputFile string, opts gen.Options) error {
+	var generated []byte
+	var err error
+	if outputFile == "-" {
+		generated, err = io.ReadAll(os.Stdin)
+	} else {
+		generated, err = os.ReadFile(outputFile)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open generated file: %w", err)
+	}
+	mismatches, err := gen.Verify(bytes.NewReader(src), bytes.NewReader(generated), outputFile, opts)
+	if err != nil {
+		return err
+	}
+	for _, mismatch := range mismatches {
+		fmt.Println(mismatch)
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("%s does not match %s, regenerate it", outputFile, opts.FileName)
+	}
+	return nil
+}
+
+// checkBreaking warns about the changes of the functions in sources breaking
+// the clients of the server previously generated into outputFile, if there is
+// one. With fail, they are an error.
+func checkBreaking(sources []inputSource, outputFile string, opts gen.Options, fail bool) error {
+	previous, err := os.ReadFile(outputFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open previous output: %w", err)
+	}
+	inputData, err := gen.ParseFiles(sourceFiles(sources), opts.FileName)
+	if err != nil {
+		return err
+	}
+	changes, err := gen.BreakingChanges(bytes.NewReader(previous), outputFile, inputData, opts)
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		fmt.Fprintln(os.Stderr, "Breaking change:", change)
+	}
+	if fail && len(changes) > 0 {
+		return fmt.Errorf("%d breaking changes in %s, run without --fail-on-breaking to accept them", len(changes), opts.FileName)
+	}
+	return nil
+}
+
+// inputSource is an input file read into memory, so it can be parsed more than
+// once.
+type inputSource struct {
+	name string
+	src  []byte
+}
+
+// sourceFiles returns readers of sources for the gen package.
+func sourceFiles(sources []inputSource) []gen.SourceFile {
+	files := make([]gen.SourceFile, len(sources))
+	for i, source := range sources {
+		files[i] = gen.SourceFile{Name: source.name, Src: bytes.NewReader(source.src)}
+	}
+	return files
+}
+
+// headerText returns the header given by --header: the content of the file it
+// names, or else the value itself.
+func headerText(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	info, err := os.Stat(value)
+	if err != nil || !info.Mode().IsRegular() {
+		return value, nil
+	}
+	text, err := os.ReadFile(value)
+	if err != nil {
+		return "", fmt.Errorf("failed to read header: %w", err)
+	}
+	return string(text), nil
+}
+
+// inputFiles expands inputs to the files agrows reads. A directory stands for
+// the Go files of its package that go build compiles, without its tests and
+// the files generated by agrows.
+func inputFiles(inputs []string) ([]string, error) {
+	var files []string
+	for _, input := range inputs {
+		if input == "-" && len(inputs) > 1 {
+			return nil, usageError("Error: --input - can not be combined with other inputs")
+		}
+		if info, err := os.Stat(input); err != nil || !info.IsDir() {
+			// missing files are reported when reading them
+			files = append(files, input)
+			continue
+		}
+		pkg, err := build.ImportDir(input, 0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the Go files of %s: %w", input, err)
+		}
+		for _, name := range pkg.GoFiles {
+			path := filepath.Join(input, name)
+			generated, err := gen.IsGenerated(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to open input file: %w", err)
+			}
+			if !generated {
+				files = append(files, path)
+			}
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no input files found in %s", strings.Join(inputs, ", "))
+	}
+	return files, nil
+}
+
+// writeOutput writes data to path, "-" meaning stdout. A file that could not be
+// written completely is removed.
+func writeOutput(path string, data []byte, force bool) error {
+	if path == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	file, err := gen.CreateOutputFile(path, force)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
+
+// printUsage prints message and the usage of agrows to stderr.
+func printUsage(message string) {
+	fmt.Fprintln(os.Stderr, message)
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "agrows - Almost Good RPC Over WebSockets")
+	fmt.Fprintln(os.Stderr, "Usage:")
+	fmt.Fprintln(os.Stderr, "  agrows --input <input_file|input_dir>|--pkg <package> [--output <output_file>] [--config <config_file>] [--dbg] <server|server-ws|server-http|server-grpc|graphql|client|goclient|mock|test-helpers|verify|both|list>")
+	fmt.Fprintln(os.Stderr, "Flags:")
+	flag.PrintDefaults()
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMain runs agrows instead of the tests if runAgrows started the test
+// binary, since run parses the flags of a single command line.
+func TestMain(m *testing.M) {
+	if os.Getenv("AGROWS_TEST_RUN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// apiSource is an input with a single RPC function.
+const apiSource = `package api
+
+func Add(a, b int) int {
+	return a + b
+}
+`
+
+// runAgrows runs agrows with args in dir, reading stdin and with env added to
+// the environment. It returns stdout and stderr and whether agrows succeeded.
+func runAgrows(t *testing.T, dir, stdin string, env []string, args ...string) (string, string, bool) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = dir
+	// GOFILE and GOPACKAGE are set when the tests run by go generate
+	cmd.Env = append(append(os.Environ(), "AGROWS_TEST_RUN=1", "GOFILE=", "GOPACKAGE="), env...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	if _, exited := err.(*exec.ExitError); err != nil && !exited {
+		t.Fatal(err)
+	}
+	return stdout.String(), stderr.String(), err == nil
+}
+
+// writeInput writes apiSource to api.go in a temporary directory and returns
+// the directory.
+func writeInput(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "api.go"), []byte(apiSource), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestErrorOutput(t *testing.T) {
+	dir := writeInput(t)
+	stdout, stderr, ok := runAgrows(t, dir, "", nil, "--input", "missing.go", "server")
+	if ok || stdout != "" {
+		t.Fatalf("got success %v and output %q, want a failure without output", ok, stdout)
+	}
+	if !strings.HasPrefix(stderr, "Error: ") || strings.Count(stderr, "\n") != 1 {
+		t.Errorf("got %q, want the error as one line", stderr)
+	}
+}
+
+func TestFailedRunKeepsOutput(t *testing.T) {
+	dir := writeInput(t)
+	if stdout, stderr, ok := runAgrows(t, dir, "", nil, "--input", "api.go", "server"); !ok {
+		t.Fatalf("agrows failed:\n%s\n%s", stdout, stderr)
+	}
+	output := filepath.Join(dir, "agrows_server_api.go")
+	want, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the unsupported parameter fails after the output was checked
+	broken := apiSource + "\nfunc Watch(ch chan int) {}\n"
+	if err := os.WriteFile(filepath.Join(dir, "api.go"), []byte(broken), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := runAgrows(t, dir, "", nil, "--input", "api.go", "server"); ok {
+		t.Fatal("agrows succeeded with an unsupported parameter")
+	}
+	got, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("the failed run changed the previous output:\n%s", got)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	dir := writeInput(t)
+	writeFile(t, filepath.Join(dir, "agrows.yaml"), "function-format: impl_%s\nprotocol: example.com/fork/protocol\noutput: gen/agrows_{command}_{name}.go\ninputs:\n"+
+		"  - input: api.go\n    command: server\n"+
+		"  - input: api.go\n    command: client\n    output: web/agrows_client.go\n")
+	for _, name := range []string{"gen", "web", "sub"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the config is found in a parent of the working directory
+	if stdout, stderr, ok := runAgrows(t, filepath.Join(dir, "sub"), "", nil); !ok {
+		t.Fatalf("agrows failed:\n%s\n%s", stdout, stderr)
+	}
+	server := readFile(t, filepath.Join(dir, "gen", "agrows_server_api.go"))
+	if !strings.Contains(server, "func impl_Add(") {
+		t.Errorf("the server does not use the function format of the config:\n%s", server)
+	}
+	if !strings.Contains(server, `"example.com/fork/protocol"`) {
+		t.Errorf("the server does not import the protocol of the config:\n%s", server)
+	}
+	if client := readFile(t, filepath.Join(dir, "web", "agrows_client.go")); !strings.Contains(client, "func AddWrapper(") {
+		t.Errorf("the client of the config has no AddWrapper:\n%s", client)
+	}
+
+	// flags given on the command line take precedence
+	if stdout, stderr, ok := runAgrows(t, dir, "", nil, "--input", "api.go", "--function-format", "rpc%s", "server"); !ok {
+		t.Fatalf("agrows failed:\n%s\n%s", stdout, stderr)
+	}
+	if server := readFile(t, filepath.Join(dir, "agrows_server_api.go")); !strings.Contains(server, "func rpcAdd(") {
+		t.Errorf("the flag did not take precedence over the config:\n%s", server)
+	}
+}
+
+func TestConfigNextToInput(t *testing.T) {
+	dir := writeInput(t)
+	writeFile(t, filepath.Join(dir, "agrows.yaml"), "function-format: impl_%s\n")
+	sibling := t.TempDir()
+
+	// the config next to the input is found from another directory
+	if stdout, stderr, ok := runAgrows(t, sibling, "", nil, "--input", filepath.Join(dir, "api.go"), "--output", "server.go", "server"); !ok {
+		t.Fatalf("agrows failed:\n%s\n%s", stdout, stderr)
+	}
+	if server := readFile(t, filepath.Join(sibling, "server.go")); !strings.Contains(server, "func impl_Add(") {
+		t.Errorf("the server does not use the function format of the config next to the input:\n%s", server)
+	}
+
+	stdout, stderr, ok := runAgrows(t, sibling, "", nil, "--input", filepath.Join(dir, "api.go"), "list")
+	if want := "Config: " + filepath.Join(dir, "agrows.yaml") + "\n\nAdd ("; !ok || !strings.HasPrefix(stdout, want) {
+		t.Errorf("got list\n%s\n%s\nwant one starting with\n%s", stdout, stderr, want)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	dir := writeInput(t)
+	writeFile(t, filepath.Join(dir, "agrows.toml"), "compress = true\nwrapper = \"%sJS\"\n")
+	_, stderr, ok := runAgrows(t, dir, "", nil, "--input", "api.go", "server")
+	if want := "unknown key 'wrapper' in config"; ok || !strings.Contains(stderr, want) {
+		t.Errorf("got %s, want an error containing %q", stderr, want)
+	}
+
+	writeFile(t, filepath.Join(dir, "agrows.yaml"), "compress: true\n")
+	_, stderr, ok = runAgrows(t, dir, "", nil, "--input", "api.go", "server")
+	if want := "found several config files"; ok || !strings.Contains(stderr, want) {
+		t.Errorf("got %s, want an error containing %q", stderr, want)
+	}
+}
+
+func TestFailOnBreaking(t *testing.T) {
+	dir := writeInput(t)
+	if stdout, stderr, ok := runAgrows(t, dir, "", nil, "--input", "api.go", "server"); !ok {
+		t.Fatalf("agrows failed:\n%s\n%s", stdout, stderr)
+	}
+	previous := readFile(t, filepath.Join(dir, "agrows_server_api.go"))
+
+	writeFile(t, filepath.Join(dir, "api.go"), strings.Replace(apiSource, "a, b int", "a int, b string", 1))
+	_, stderr, ok := runAgrows(t, dir, "", nil, "--input", "api.go", "--fail-on-breaking", "server")
+	if want := "Add: parameter b changed from int to string"; ok || !strings.Contains(stderr, want) {
+		t.Errorf("got %s, want a failure reporting %q", stderr, want)
+	}
+	if got := readFile(t, filepath.Join(dir, "agrows_server_api.go")); got != previous {
+		t.Errorf("the previous output was replaced despite the breaking change:\n%s", got)
+	}
+}
+
+func TestBoth(t *testing.T) {
+	dir := writeInput(t)
+	if err := os.Mkdir(filepath.Join(dir, "web"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if stdout, stderr, ok := runAgrows(t, dir, "", nil, "--input", "api.go", "--client-output", "web/agrows_client.go", "both"); !ok {
+		t.Fatalf("agrows failed:\n%s\n%s", stdout, stderr)
+	}
+	if server := readFile(t, filepath.Join(dir, "agrows_server_api.go")); !strings.Contains(server, "func AgrowsReceive(") {
+		t.Errorf("the server has no AgrowsReceive:\n%s", server)
+	}
+	if client := readFile(t, filepath.Join(dir, "web", "agrows_client.go")); !strings.Contains(client, "func AddWrapper(") {
+		t.Errorf("the client has no AddWrapper:\n%s", client)
+	}
+
+	_, stderr, ok := runAgrows(t, dir, "", nil, "--input", "api.go", "--output", "out.go", "both")
+	if want := "use --server-output and --client-output"; ok || !strings.Contains(stderr, want) {
+		t.Errorf("got %s, want an error containing %q", stderr, want)
+	}
+}
+
+func TestTestFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "api_test.go"), apiSource)
+	_, stderr, ok := runAgrows(t, dir, "", nil, "--input", "api_test.go", "server")
+	if want := "input file api_test.go is a test file"; ok || !strings.Contains(stderr, want) {
+		t.Errorf("got %s, want an error containing %q", stderr, want)
+	}
+	if stdout, stderr, ok := runAgrows(t, dir, "", nil, "--input", "api_test.go", "--allow-test-files", "server"); !ok {
+		t.Errorf("agrows failed with --allow-test-files:\n%s\n%s", stdout, stderr)
+	}
+}
+
+func TestStdinInput(t *testing.T) {
+	dir := writeInput(t)
+	stdout, stderr, ok := runAgrows(t, dir, apiSource, nil, "--input", "-", "--output", "-", "server")
+	if !ok || !strings.Contains(stdout, "func AgrowsReceive(") || !strings.Contains(stdout, "func agrows_Add(") {
+		t.Fatalf("generating from stdin failed:\n%s\n%s", stdout, stderr)
+	}
+
+	_, stderr, ok = runAgrows(t, dir, "package api\n\nfunc Add(a int {}\n", nil, "--input", "-", "--output", "-", "server")
+	if ok || !strings.Contains(stderr, "stdin:3") {
+		t.Errorf("a syntax error on stdin is not reported at stdin:3:\n%s", stderr)
+	}
+}
+
+func TestStdinInputErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"no output", []string{"server"}, "Error: --output is required when reading the input from stdin"},
+		{"both", []string{"--server-output", "server.go", "both"}, "Error: 'both' requires --server-output and --client-output when reading the input from stdin"},
+		{"verify", []string{"--output", "-", "verify"}, "Error: 'verify' can not read both the input and the output from stdin"},
+		{"several inputs", []string{"--input", "api.go", "--output", "-", "server"}, "Error: --input - can not be combined with other inputs"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := writeInput(t)
+			_, stderr, ok := runAgrows(t, dir, apiSource, nil, append([]string{"--input", "-"}, test.args...)...)
+			if ok || !strings.Contains(stderr, test.want) {
+				t.Fatalf("got %s, want an error containing %q", stderr, test.want)
+			}
+			// nothing is named after the input
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 {
+				t.Errorf("agrows left files behind: %v", entries)
+			}
+		})
+	}
+}
+
+func TestDirectoryInput(t *testing.T) {
+	dir := t.TempDir()
+	pkg := filepath.Join(dir, "functions")
+	writeFile(t, filepath.Join(pkg, "add.go"), apiSource)
+	writeFile(t, filepath.Join(pkg, "sub.go"), "package api\n\nfunc Sub(a, b int) int {\n\treturn Add(a, -b)\n}\n")
+	writeFile(t, filepath.Join(pkg, "add_test.go"), "package api\n\nfunc TestOnly() {}\n")
+	for run := 0; run < 2; run++ {
+		// the second run leaves out the output of the first
+		if stdout, stderr, ok := runAgrows(t, dir, "", nil, "--input", "functions", "server"); !ok {
+			t.Fatalf("agrows failed:\n%s\n%s", stdout, stderr)
+		}
+	}
+	server := readFile(t, filepath.Join(pkg, "agrows_server_functions.go"))
+	for _, want := range []string{"add.go", "sub.go", "func agrows_Sub(", "return agrows_Add(a, -b)"} {
+		if !strings.Contains(server, want) {
+			t.Errorf("the server has no %s:\n%s", want, server)
+		}
+	}
+	if strings.Contains(server, "TestOnly") {
+		t.Errorf("the server includes the test file:\n%s", server)
+	}
+
+	if stdout, stderr, ok := runAgrows(t, pkg, "", nil, "--input", "add.go", "--input", "sub.go", "--output", "out.go", "client"); !ok {
+		t.Fatalf("agrows failed with repeated inputs:\n%s\n%s", stdout, stderr)
+	}
+	if client := readFile(t, filepath.Join(pkg, "out.go")); !strings.Contains(client, "func SubWrapper(") || !strings.Contains(client, "func AddWrapper(") {
+		t.Errorf("the client lacks the functions of a file:\n%s", client)
+	}
+}
+
+func TestPkgInput(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "go.mod"), "module example.com/app\n\ngo 1.21\n")
+	pkg := filepath.Join(dir, "internal", "api")
+	writeFile(t, filepath.Join(pkg, "api.go"), apiSource)
+	// Profile is a struct only to the type checker
+	writeFile(t, filepath.Join(pkg, "profile.go"), "package api\n\ntype User struct {\n\tName string\n}\n\ntype Profile = User\n\nfunc Load() (Profile, error) {\n\treturn Profile{}, nil\n}\n")
+	writeFile(t, filepath.Join(dir, "internal", "other", "other.go"), "package other\n")
+
+	for run := 0; run < 2; run++ {
+		// the second run leaves out the output of the first
+		if stdout, stderr, ok := runAgrows(t, dir, "", nil, "--pkg", "./internal/api", "--output", "internal/api/server.go", "server"); !ok {
+			t.Fatalf("agrows failed:\n%s\n%s", stdout, stderr)
+		}
+	}
+	if server := readFile(t, filepath.Join(pkg, "server.go")); !strings.Contains(server, "func agrows_Add(") || !strings.Contains(server, "func agrows_Load(") {
+		t.Errorf("the server lacks the functions of the package:\n%s", server)
+	}
+
+	if _, stderr, ok := runAgrows(t, dir, "", nil, "--input", "internal/api", "--output", "goclient.go", "goclient"); !ok {
+		t.Fatalf("the Go client of the syntax alone failed:\n%s", stderr)
+	}
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"struct alias", []string{"--pkg", "./internal/api", "--output", "goclient.go", "goclient"}, "struct result Profile of Load"},
+		{"with input", []string{"--pkg", "./internal/api", "--input", "internal/api", "server"}, "--pkg can not be combined with --input"},
+		{"several packages", []string{"--pkg", "./internal/...", "server"}, "matched 2 packages"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stdout, stderr, ok := runAgrows(t, dir, "", nil, test.args...)
+			if ok || !strings.Contains(stdout+stderr, test.want) {
+				t.Errorf("got %t with output\n%s%s\nwant a failure containing %q", ok, stdout, stderr, test.want)
+			}
+		})
+	}
+}
+
+func TestHeaderFile(t *testing.T) {
+	dir := writeInput(t)
+	writeFile(t, filepath.Join(dir, "LICENSE.txt"), "Copyright 2024 The Authors\nLicensed under the GPL.\n")
+	for run := 0; run < 2; run++ {
+		// the second run replaces the output, which it still recognizes
+		if stdout, stderr, ok := runAgrows(t, dir, "", nil, "--input", "api.go", "--header", "LICENSE.txt", "server"); !ok {
+			t.Fatalf("agrows failed:\n%s\n%s", stdout, stderr)
+		}
+	}
+	want := "// Copyright 2024 The Authors\n// Licensed under the GPL.\n\n// Code generated by agrows. DO NOT EDIT.\n\npackage api\n"
+	if server := readFile(t, filepath.Join(dir, "agrows_server_api.go")); !strings.HasPrefix(server, want) {
+		t.Errorf("the server does not start with the header of the file:\n%s", server)
+	}
+}
+
+func TestList(t *testing.T) {
+	dir := writeInput(t)
+	stdout, stderr, ok := runAgrows(t, dir, "", nil, "--input", "api.go", "list")
+	if !ok {
+		t.Fatalf("agrows failed:\n%s\n%s", stdout, stderr)
+	}
+	if want := "Add (api.go:3)\n    params:  a int, b int\n    results: int\n"; stdout != want {
+		t.Errorf("got list\n%s\nwant\n%s", stdout, want)
+	}
+	stdout, stderr, ok = runAgrows(t, dir, "", nil, "--input", "api.go", "--json", "list")
+	if !ok || !strings.Contains(stdout, `"functions"`) {
+		t.Fatalf("agrows printed no JSON:\n%s\n%s", stdout, stderr)
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 1 {
+		t.Errorf("list wrote files: %v, %v", entries, err)
+	}
+}
+
+func TestGoFileInput(t *testing.T) {
+	dir := writeInput(t)
+	env := []string{"GOFILE=api.go", "GOPACKAGE=api"}
+	if stdout, stderr, ok := runAgrows(t, dir, "", env, "client"); !ok {
+		t.Fatalf("generating from GOFILE failed:\n%s\n%s", stdout, stderr)
+	}
+	client, err := os.ReadFile(filepath.Join(dir, "agrows_client_api.go"))
+	if err != nil {
+		t.Fatalf("the output is not named after GOFILE: %v", err)
+	}
+	if !strings.Contains(string(client), "func AddWrapper(") {
+		t.Errorf("the client of GOFILE has no AddWrapper:\n%s", client)
+	}
+
+	// --input takes precedence
+	env = []string{"GOFILE=missing.go", "GOPACKAGE=api"}
+	if stdout, stderr, ok := runAgrows(t, dir, "", env, "--input", "api.go", "server"); !ok {
+		t.Fatalf("--input did not take precedence over GOFILE:\n%s\n%s", stdout, stderr)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "agrows_server_api.go")); err != nil {
+		t.Error(err)
+	}
+
+	_, stderr, ok := runAgrows(t, dir, "", nil, "server")
+	if want := "Error: --input parameter is required"; ok || !strings.Contains(stderr, want) {
+		t.Errorf("got %s, want an error containing %q", stderr, want)
+	}
+}
+
+func TestGoGenerate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("running go generate is skipped with -short")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("running go generate requires the go command")
+	}
+	agrows, err := filepath.Abs(os.Args[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod": "module api\n\ngo 1.22\n",
+		"api.go": "//go:generate " + agrows + " client\n\n" + apiSource,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := exec.Command("go", "generate", "./...")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "AGROWS_TEST_RUN=1")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go generate failed: %v\n%s", err, out)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "agrows_client_api.go")); err != nil {
+		t.Errorf("go generate did not write the client: %v", err)
+	}
+}
+
+// writeFile writes data to path, creating its directory.
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readFile returns the content of path, failing the test if it can not be read.
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"go/types"
+
+	"github.com/codeupdateandmodificationsystem/agrows/gen"
+	log "github.com/dikkadev/dnutlogger"
+	"golang.org/x/tools/go/packages"
+)
+
+// loadPackage loads the package matching pattern, e.g. ./internal/api, with its
+// type information. It returns the Go files of the package without those
+// generated by agrows, along with the type-checked package.
+func loadPackage(pattern string) ([]string, *types.Package, error) {
+	cfg := &packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedImports | packages.NeedDeps | packages.NeedSyntax | packages.NeedTypes | packages.NeedTypesInfo,
+	}
+	pkgs, err := packages.Load(cfg, pattern)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load package %s: %w", pattern, err)
+	}
+	if len(pkgs) != 1 {
+		return nil, nil, fmt.Errorf("package pattern %s matched %d packages, generate them one at a time", pattern, len(pkgs))
+	}
+	pkg := pkgs[0]
+	for _, pkgErr := range pkg.Errors {
+		if pkgErr.Kind == packages.ListError {
+			return nil, nil, fmt.Errorf("failed to load package %s: %w", pattern, pkgErr)
+		}
+		// the outputs of earlier runs declare the types of the package again,
+		// which does not keep its own types from being checked
+		log.Debugf("Ignoring error in package %s: %v", pkg.PkgPath, pkgErr)
+	}
+
+	var files []string
+	for _, file := range pkg.GoFiles {
+		generated, err := gen.IsGenerated(file)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open input file: %w", err)
+		}
+		if !generated {
+			files = append(files, file)
+		}
+	}
+	if len(files) == 0 {
+		return nil, nil, fmt.Errorf("no input files found in package %s", pattern)
+	}
+	log.Debugf("Loaded package %s with %d files", pkg.PkgPath, len(files))
+	return files, pkg.Types, nil
+}